@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"mzt/internal/mocks"
+	"net/http"
+	"os"
+)
+
+// фейковый OpenID Connect провайдер для локальной проверки входа через внешние сервисы
+// в .env приложения: OAUTH_GOOGLE_CLIENT_ID=fake, OAUTH_GOOGLE_CLIENT_SECRET=fake,
+// OAUTH_GOOGLE_AUTH_URL=http://localhost:8091/authorize, OAUTH_GOOGLE_TOKEN_URL=http://localhost:8091/token,
+// OAUTH_GOOGLE_USERINFO_URL=http://localhost:8091/userinfo
+// войти можно под одним пользователем, его задают FAKE_OIDC_SUBJECT, FAKE_OIDC_EMAIL и FAKE_OIDC_NAME
+func main() {
+	addr := getEnvOrDefault("FAKE_OIDC_ADDR", ":8091")
+
+	fake := mocks.NewFakeOIDC(getEnvOrDefault("OAUTH_GOOGLE_CLIENT_ID", "fake"), getEnvOrDefault("OAUTH_GOOGLE_CLIENT_SECRET", "fake"))
+	fake.SetUser(mocks.FakeOIDCUser{
+		Subject:       getEnvOrDefault("FAKE_OIDC_SUBJECT", "fake-user"),
+		Email:         getEnvOrDefault("FAKE_OIDC_EMAIL", "fake@example.com"),
+		EmailVerified: getEnvOrDefault("FAKE_OIDC_EMAIL_VERIFIED", "true") == "true",
+		Name:          getEnvOrDefault("FAKE_OIDC_NAME", "Fake User"),
+	})
+
+	fmt.Printf("Fake OIDC provider listening on %s\n", addr)
+	if err := http.ListenAndServe(addr, fake.Handler()); err != nil {
+		panic(err)
+	}
+}
+
+// Helper function to get environment variable with default value
+func getEnvOrDefault(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"mzt/internal/mocks"
+	"net/http"
+	"os"
+)
+
+// фейковый YooKassa для локальной проверки оплаты от начала до конца
+// в .env приложения: EQUIRING_API_URL=http://localhost:8090/v3, EQUIRING_TRUSTED_NETWORKS=127.0.0.1/32
+// оплатить платеж: curl -X POST http://localhost:8090/fake/payments/<id>/succeeded
+func main() {
+	addr := getEnvOrDefault("FAKE_YOOKASSA_ADDR", ":8090")
+
+	fake := mocks.NewFakeYooKassa(os.Getenv("EQUIRING_STORE_CODE"), os.Getenv("EQUIRING_SECRET_KEY"))
+	fake.SetBaseURL(getEnvOrDefault("FAKE_YOOKASSA_URL", "http://localhost"+addr))
+	fake.WebhookURL = os.Getenv("FAKE_YOOKASSA_WEBHOOK_URL")
+
+	fmt.Printf("Fake YooKassa listening on %s\n", addr)
+	if err := http.ListenAndServe(addr, fake.Handler()); err != nil {
+		panic(err)
+	}
+}
+
+// Helper function to get environment variable with default value
+func getEnvOrDefault(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
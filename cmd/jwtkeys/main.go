@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"mzt/internal/jwtkeys"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// управление ключами подписи access токенов в папке JWT_KEYS_DIR
+//
+// ротация без разлогинивания:
+//  1. go run ./cmd/jwtkeys generate - новый ключ сразу попадает в jwks, но токены им еще не подписываются
+//  2. ждем пока другие сервисы обновят кеш jwks (5 минут)
+//  3. go run ./cmd/jwtkeys rotate - новый ключ становится активным, старые токены проверяются старым ключом
+//  4. когда старые access токены истекут - go run ./cmd/jwtkeys prune
+//
+// при первом запуске достаточно rotate: если нового ключа нет, он создается и сразу становится активным
+func main() {
+	if err := godotenv.Load(); err != nil {
+		fmt.Println("Warning: .env file not found, using environment variables")
+	}
+	dir := getEnvOrDefault("JWT_KEYS_DIR", "keys")
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+	args := os.Args[2:]
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = list(dir)
+	case "generate":
+		err = generate(dir, args)
+	case "rotate":
+		err = rotate(dir, args)
+	case "remove":
+		if len(args) != 1 {
+			usage()
+		}
+		err = jwtkeys.Remove(dir, args[0])
+		if err == nil {
+			fmt.Printf("Removed key %s\n", args[0])
+		}
+	case "prune":
+		err = prune(dir, args)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: jwtkeys <command>
+  list                    show keys, * marks the active one
+  generate [-alg RS256]   create a key and publish it without signing with it
+  rotate [-alg RS256]     make the newest key active, create one if there is no newer key
+  remove <kid>            delete a key that is not active
+  prune [-after 1h]       delete keys older than the active one, once it has been active for -after`)
+	os.Exit(2)
+}
+
+func list(dir string) error {
+	keys, active, err := readDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		mark := " "
+		if key.ID == active {
+			mark = "*"
+		}
+		fmt.Printf("%s %s %s created %s\n", mark, key.ID, key.Alg, key.CreatedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func generate(dir string, args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	alg := flags.String("alg", getEnvOrDefault("JWT_KEY_ALG", jwtkeys.RS256), "RS256 or EdDSA")
+	flags.Parse(args)
+
+	key, err := newKey(dir, *alg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Generated key %s, activate it with rotate once services have refreshed jwks\n", key.ID)
+	return nil
+}
+
+func rotate(dir string, args []string) error {
+	flags := flag.NewFlagSet("rotate", flag.ExitOnError)
+	alg := flags.String("alg", getEnvOrDefault("JWT_KEY_ALG", jwtkeys.RS256), "RS256 or EdDSA, for a new key")
+	flags.Parse(args)
+
+	keys, active, err := readDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// ключи отсортированы от старых к новым, активируем самый новый если он новее активного
+	var next *jwtkeys.Key
+	if len(keys) > 0 && keys[len(keys)-1].ID > active {
+		next = keys[len(keys)-1]
+	}
+	if next == nil {
+		next, err = newKey(dir, *alg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Generated key %s\n", next.ID)
+	}
+
+	if err := jwtkeys.Activate(dir, next.ID); err != nil {
+		return err
+	}
+	fmt.Printf("Active key is now %s\n", next.ID)
+	return nil
+}
+
+func prune(dir string, args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	after := flags.Duration("after", time.Hour, "how long the active key must have been active, longer than the access token lifetime")
+	flags.Parse(args)
+
+	keys, active, err := readDir(dir)
+	if err != nil {
+		return err
+	}
+	if active == "" {
+		return errors.New("no active key")
+	}
+	activatedAt, err := jwtkeys.ActivatedAt(dir)
+	if err != nil {
+		return err
+	}
+	if since := time.Since(activatedAt); since < *after {
+		return fmt.Errorf("key %s has been active for %s only, tokens signed with older keys may still be valid", active, since.Round(time.Second))
+	}
+
+	for _, key := range keys {
+		if key.ID >= active {
+			continue
+		}
+		if err := jwtkeys.Remove(dir, key.ID); err != nil {
+			return err
+		}
+		fmt.Printf("Removed key %s\n", key.ID)
+	}
+	return nil
+}
+
+func newKey(dir, alg string) (*jwtkeys.Key, error) {
+	key, err := jwtkeys.Generate(alg)
+	if err != nil {
+		return nil, err
+	}
+	return key, jwtkeys.Save(dir, key)
+}
+
+// пустая или еще не созданная папка - это просто нет ключей
+func readDir(dir string) ([]*jwtkeys.Key, string, error) {
+	keys, active, err := jwtkeys.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", nil
+	}
+	return keys, active, err
+}
+
+// Helper function to get environment variable with default value
+func getEnvOrDefault(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
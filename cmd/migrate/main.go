@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"mzt/config"
 	"mzt/internal/entity"
+	"mzt/internal/money"
 	"mzt/internal/repository"
 	"os"
 	"time"
@@ -49,18 +51,48 @@ func main() {
 	err := userRepo.DB.AutoMigrate(
 		&entity.User{},
 		&entity.UserData{},
-		&entity.Auth{},
+		&entity.Session{},
+		&entity.SecurityEvent{},
+		&entity.Permission{},
+		&entity.Role{},
+		&entity.PasswordResetToken{},
+		&entity.TwoFactor{},
+		&entity.RecoveryCode{},
+		&entity.Identity{},
+		&entity.OAuthState{},
 		&entity.Payment{},
+		&entity.PaymentEvent{},
+		&entity.Refund{},
+		&entity.PromoCode{},
+		&entity.PromoCodeCourse{},
+		&entity.PromoCodeUsage{},
 		&entity.Course{},
 		&entity.CourseAssignment{},
 		&entity.Lesson{},
 		&entity.Event{},
 		&entity.CoursePrice{},
+		&entity.Bundle{},
+		&entity.BundleCourse{},
+		&entity.PaymentCourse{},
+		&entity.GiftCode{},
+		&entity.Invoice{},
+		&entity.InvoiceEmployee{},
+		&entity.Plan{},
+		&entity.PlanCourse{},
+		&entity.PaymentMethod{},
+		&entity.Subscription{},
+		&entity.InstallmentPlan{},
+		&entity.Installment{},
 	)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to migrate database: %v", err))
 	}
 
+	// встроенные роли и их права
+	if err := userRepo.SyncRoles(entity.Permissions, entity.BuiltinRoles); err != nil {
+		panic(fmt.Sprintf("Failed to sync roles: %v", err))
+	}
+
 	// Create test users
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
 	if err != nil {
@@ -70,13 +102,12 @@ func main() {
 	testUsers := []struct {
 		user     *entity.User
 		userData *entity.UserData
-		auth     *entity.Auth
 	}{
 		{
 			user: &entity.User{
 				ID:         uuid.New(),
 				PasswdHash: string(passwordHash),
-				Role:       1, // Admin
+				Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 			},
 			userData: &entity.UserData{
 				Email:           "admin@example.com",
@@ -91,15 +122,12 @@ func main() {
 				PositionAtWork:  "Senior Developer",
 				MonthIncome:     150000,
 			},
-			auth: &entity.Auth{
-				Key: "admin_key",
-			},
 		},
 		{
 			user: &entity.User{
 				ID:         uuid.New(),
 				PasswdHash: string(passwordHash),
-				Role:       0, // Student
+				Roles:      []entity.Role{{Name: entity.RoleStudent}},
 			},
 			userData: &entity.UserData{
 				Email:           "ivan@example.com",
@@ -114,15 +142,12 @@ func main() {
 				PositionAtWork:  "Frontend Developer",
 				MonthIncome:     80000,
 			},
-			auth: &entity.Auth{
-				Key: "ivan_key",
-			},
 		},
 		{
 			user: &entity.User{
 				ID:         uuid.New(),
 				PasswdHash: string(passwordHash),
-				Role:       0,
+				Roles:      []entity.Role{{Name: entity.RoleStudent}},
 			},
 			userData: &entity.UserData{
 				Email:           "anna@example.com",
@@ -137,15 +162,12 @@ func main() {
 				PositionAtWork:  "CEO",
 				MonthIncome:     250000,
 			},
-			auth: &entity.Auth{
-				Key: "anna_key",
-			},
 		},
 		{
 			user: &entity.User{
 				ID:         uuid.New(),
 				PasswdHash: string(passwordHash),
-				Role:       0,
+				Roles:      []entity.Role{{Name: entity.RoleStudent}},
 			},
 			userData: &entity.UserData{
 				Email:           "maria@example.com",
@@ -160,15 +182,12 @@ func main() {
 				PositionAtWork:  "Intern",
 				MonthIncome:     45000,
 			},
-			auth: &entity.Auth{
-				Key: "maria_key",
-			},
 		},
 		{
 			user: &entity.User{
 				ID:         uuid.New(),
 				PasswdHash: string(passwordHash),
-				Role:       0,
+				Roles:      []entity.Role{{Name: entity.RoleStudent}},
 			},
 			userData: &entity.UserData{
 				Email:           "alex@example.com",
@@ -183,15 +202,12 @@ func main() {
 				PositionAtWork:  "Project Manager",
 				MonthIncome:     180000,
 			},
-			auth: &entity.Auth{
-				Key: "alex_key",
-			},
 		},
 		{
 			user: &entity.User{
 				ID:         uuid.New(),
 				PasswdHash: string(passwordHash),
-				Role:       0,
+				Roles:      []entity.Role{{Name: entity.RoleStudent}},
 			},
 			userData: &entity.UserData{
 				Email:           "elena@example.com",
@@ -206,9 +222,6 @@ func main() {
 				PositionAtWork:  "Founder",
 				MonthIncome:     300000,
 			},
-			auth: &entity.Auth{
-				Key: "elena_key",
-			},
 		},
 	}
 
@@ -219,9 +232,11 @@ func main() {
 		if err != nil {
 			if err.Error() == "record not found" {
 				tu.userData.UserID = tu.user.ID
-				tu.auth.UserID = tu.user.ID
+				// у тестовых аккаунтов почта сразу подтверждена
+				verifiedAt := time.Now()
+				tu.user.EmailVerifiedAt = &verifiedAt
 
-				err = userRepo.CreateUser(tu.user, tu.userData, tu.auth)
+				err = userRepo.CreateUser(tu.user, tu.userData, nil)
 				if err != nil {
 					panic(fmt.Sprintf("Failed to create user %s: %v", tu.userData.Email, err))
 				}
@@ -510,9 +525,8 @@ func main() {
 				}
 
 				coursePrice := &entity.CoursePrice{
-					CourseID:     course.CourseID,
-					Amount:       price,
-					CurrencyCode: "RUB",
+					CourseID: course.CourseID,
+					Amount:   money.New(int64(math.Round(price*100)), money.DefaultCurrency),
 				}
 				err = courseRepo.DB.Create(coursePrice).Error
 				if err != nil {
@@ -2,15 +2,23 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	DB       DB       `mapstructure:"db"`
-	Jwt      Jwt      `mapstructure:"jwt"`
-	Equiring Equiring `mapstructure:"equiring"`
+	DB        DB        `mapstructure:"db"`
+	Jwt       Jwt       `mapstructure:"jwt"`
+	Equiring  Equiring  `mapstructure:"equiring"`
+	Invoice   Invoice   `mapstructure:"invoice"`
+	Mail      Mail      `mapstructure:"mail"`
+	Auth      Auth      `mapstructure:"auth"`
+	RateLimit RateLimit `mapstructure:"rate_limit"`
+	OAuth     OAuth     `mapstructure:"oauth"`
+	Server    Server    `mapstructure:"server"`
 }
 
 type DB struct {
@@ -22,8 +30,14 @@ type DB struct {
 }
 
 type Jwt struct {
-	AccessKey        string        `mapstructure:"access_key"`
-	RefreshKey       string        `mapstructure:"refresh_key"`
+	// refresh токены проверяем только мы, поэтому они подписаны общим секретом
+	RefreshKey string `mapstructure:"refresh_key"`
+	// папка с ключами для подписи access токенов, ключами управляет cmd/jwtkeys
+	KeysDir string `mapstructure:"keys_dir"`
+	// алгоритм новых ключей: RS256 или EdDSA
+	KeyAlg string `mapstructure:"key_alg"`
+	// как часто перечитывать папку с ключами, чтобы подхватить ротацию без перезапуска
+	KeysReload       time.Duration `mapstructure:"keys_reload"`
 	AccessExpiresIn  time.Duration `mapstructure:"access_expires_in"`
 	RefreshExpiresIn time.Duration `mapstructure:"refresh_expires_in"`
 	Domain           string        `mapstructure:"domain"`
@@ -33,6 +47,168 @@ type Equiring struct {
 	StoreCode   string `mapstructure:"store_code"`
 	StoreSecret string `mapstructure:"store_secret"`
 	SecretPath  string `mapstructure:"secret_path"`
+	// имя платежного провайдера: yookassa или fake
+	Gateway string `mapstructure:"gateway"`
+	// куда провайдер вернет пользователя после оплаты
+	ReturnURL string `mapstructure:"return_url"`
+	// адрес api платежного провайдера, в тестах и локально подменяется на фейковый сервер
+	APIURL string `mapstructure:"api_url"`
+	// подсети из которых провайдер шлет уведомления, остальные запросы на вебхук отклоняются
+	TrustedNetworks []string `mapstructure:"trusted_networks"`
+	// через сколько платеж в pending или processing считается зависшим и уходит на сверку
+	StalePaymentAfter time.Duration `mapstructure:"stale_payment_after"`
+	// как часто запускается сверка зависших платежей с провайдером
+	ReconcileInterval time.Duration `mapstructure:"reconcile_interval"`
+
+	// настройки фискального чека по 54-ФЗ
+	// отправлять ли чек вместе с платежом
+	SendReceipts bool `mapstructure:"send_receipts"`
+	// ставка НДС, код из справочника YooKassa: 1 - без НДС, 4 - 20% и т.д.
+	VatCode int `mapstructure:"vat_code"`
+	// система налогообложения, 0 - не передавать (нужно только если в кассе их несколько)
+	TaxSystemCode int `mapstructure:"tax_system_code"`
+	// признак предмета расчета, для курсов - service
+	PaymentSubject string `mapstructure:"payment_subject"`
+	// признак способа расчета, для предоплаты целиком - full_payment
+	PaymentMode string `mapstructure:"payment_mode"`
+
+	// как часто запускаются продления подписок
+	BillingInterval time.Duration `mapstructure:"billing_interval"`
+	// через сколько повторять списание если продление не прошло
+	RenewalRetryInterval time.Duration `mapstructure:"renewal_retry_interval"`
+	// сколько после конца оплаченного периода доступ остается пока пытаемся списать деньги
+	SubscriptionGracePeriod time.Duration `mapstructure:"subscription_grace_period"`
+	// сколько после срока очередной части рассрочки доступ остается без оплаты
+	InstallmentGracePeriod time.Duration `mapstructure:"installment_grace_period"`
+	// сколько действует код из купленного подарка
+	GiftCodeTTL time.Duration `mapstructure:"gift_code_ttl"`
+}
+
+// реквизиты продавца которые печатаются в счетах для юрлиц
+type Invoice struct {
+	SellerName    string `mapstructure:"seller_name"`
+	SellerINN     string `mapstructure:"seller_inn"`
+	SellerKPP     string `mapstructure:"seller_kpp"`
+	SellerAddress string `mapstructure:"seller_address"`
+	BankName      string `mapstructure:"bank_name"`
+	BIK           string `mapstructure:"bik"`
+	BankAccount   string `mapstructure:"bank_account"`
+	CorrAccount   string `mapstructure:"corr_account"`
+	// строка про НДС под итогом, например "Без НДС"
+	VatNote string `mapstructure:"vat_note"`
+	// сколько счет ждет оплаты
+	DueIn time.Duration `mapstructure:"due_in"`
+	// ttf шрифт с кириллицей для pdf
+	FontPath string `mapstructure:"font_path"`
+}
+
+// настройки отправки писем
+type Mail struct {
+	// smtp или file - письма в папку Dir или в лог, для локальной разработки
+	Sender       string `mapstructure:"sender"`
+	From         string `mapstructure:"from"`
+	SMTPHost     string `mapstructure:"smtp_host"`
+	SMTPPort     string `mapstructure:"smtp_port"`
+	SMTPUser     string `mapstructure:"smtp_user"`
+	SMTPPassword string `mapstructure:"smtp_password"`
+	Dir          string `mapstructure:"dir"`
+}
+
+// настройки восстановления доступа к аккаунту
+type Auth struct {
+	// адрес фронтенда, от него строятся ссылки в письмах
+	FrontendURL string `mapstructure:"frontend_url"`
+	// сколько действует ссылка для сброса пароля
+	PasswordResetTTL time.Duration `mapstructure:"password_reset_ttl"`
+	// ключ которым подписываются ссылки для подтверждения почты
+	EmailVerifyKey string `mapstructure:"email_verify_key"`
+	// сколько действует ссылка для подтверждения почты
+	EmailVerifyTTL time.Duration `mapstructure:"email_verify_ttl"`
+	// через сколько можно повторно отправить письмо для подтверждения
+	VerifyResendInterval time.Duration `mapstructure:"verify_resend_interval"`
+
+	// ключ которым шифруются секреты для одноразовых кодов и подписывается токен второго шага входа
+	TwoFactorKey string `mapstructure:"two_factor_key"`
+	// название сервиса в приложении с кодами
+	TwoFactorIssuer string `mapstructure:"two_factor_issuer"`
+	// сколько после пароля есть на ввод кода
+	TwoFactorChallengeTTL time.Duration `mapstructure:"two_factor_challenge_ttl"`
+	// без подключенных кодов пользователи с правами в админке туда не пускаются
+	RequireAdminTwoFactor bool `mapstructure:"require_admin_two_factor"`
+
+	// после скольких неверных паролей подряд вход в аккаунт блокируется
+	MaxLoginAttempts int `mapstructure:"max_login_attempts"`
+	// на сколько блокируется вход в первый раз, каждая следующая блокировка подряд вдвое дольше
+	LoginLockout time.Duration `mapstructure:"login_lockout"`
+	// дольше этого вход не блокируется
+	MaxLoginLockout time.Duration `mapstructure:"max_login_lockout"`
+
+	// токен бота которым телеграм подписывает данные входа, пустой - вход через телеграм выключен
+	TelegramBotToken string `mapstructure:"telegram_bot_token"`
+	// сколько после входа в телеграме данные из виджета принимаются
+	TelegramAuthTTL time.Duration `mapstructure:"telegram_auth_ttl"`
+	// сколько после входа через телеграм или соцсеть аккаунт без пароля может задать пароль или сменить почту
+	ReauthWindow time.Duration `mapstructure:"reauth_window"`
+}
+
+// ограничения частоты запросов к ручкам входа, 0 - без ограничения
+type RateLimit struct {
+	// где хранятся счетчики: memory - в памяти процесса
+	Store string `mapstructure:"store"`
+	// окно в котором считаются запросы
+	Window time.Duration `mapstructure:"window"`
+	// попыток входа за окно с одного адреса
+	SignInPerIP int `mapstructure:"sign_in_per_ip"`
+	// попыток входа за окно на одну почту, с любых адресов
+	SignInPerAccount int `mapstructure:"sign_in_per_account"`
+	// регистраций за окно с одного адреса
+	SignUpPerIP int `mapstructure:"sign_up_per_ip"`
+	// обновлений токена за окно с одного адреса
+	RefreshPerIP int `mapstructure:"refresh_per_ip"`
+	// запросов на сброс пароля и попыток сброса за окно с одного адреса
+	PasswordResetPerIP int `mapstructure:"password_reset_per_ip"`
+	// запросов на сброс пароля за окно на одну почту и попыток сброса по одной ссылке
+	PasswordResetPerAccount int `mapstructure:"password_reset_per_account"`
+	// подтверждений почты по ссылке из письма за окно с одного адреса
+	EmailConfirmPerIP int `mapstructure:"email_confirm_per_ip"`
+}
+
+// вход через внешние сервисы по OAuth2/OpenID Connect
+type OAuth struct {
+	// куда сервис возвращает пользователя после входа, к адресу добавляется /<provider>
+	RedirectURL string `mapstructure:"redirect_url"`
+	// сколько ждем возвращения пользователя от сервиса
+	StateTTL time.Duration `mapstructure:"state_ttl"`
+	Google   OAuthProvider `mapstructure:"google"`
+	Yandex   OAuthProvider `mapstructure:"yandex"`
+	VK       OAuthProvider `mapstructure:"vk"`
+}
+
+// приложение во внешнем сервисе, пустой ClientID - вход через сервис выключен
+// адреса можно переопределить, например на локальный фейковый провайдер
+type OAuthProvider struct {
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	AuthURL      string `mapstructure:"auth_url"`
+	TokenURL     string `mapstructure:"token_url"`
+	UserInfoURL  string `mapstructure:"user_info_url"`
+}
+
+type Server struct {
+	// прокси которым можно доверять заголовок X-Forwarded-For
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// подсети из которых YooKassa отправляет http-уведомления
+// https://yookassa.ru/developers/using-api/webhooks#ip
+var yooKassaNetworks = []string{
+	"185.71.76.0/27",
+	"185.71.77.0/27",
+	"77.75.153.0/25",
+	"77.75.156.11/32",
+	"77.75.156.35/32",
+	"77.75.154.128/25",
+	"2a02:5180::/32",
 }
 
 func NewConfig() *Config {
@@ -42,6 +218,13 @@ func NewConfig() *Config {
 		panic(err)
 	}
 
+	// фейковый провайдер шлет уведомления только с локальной машины
+	gateway := getEnv("EQUIRING_GATEWAY", "yookassa")
+	trustedNetworks := yooKassaNetworks
+	if gateway != "yookassa" {
+		trustedNetworks = []string{"127.0.0.1/32", "::1/128"}
+	}
+
 	return &Config{
 		DB: DB{
 			Host:     os.Getenv("PGHOST"),
@@ -51,16 +234,178 @@ func NewConfig() *Config {
 			Password: os.Getenv("PGPASSWORD"),
 		},
 		Jwt: Jwt{
-			AccessKey:        os.Getenv("JWT_ACCESS_KEY"),
 			RefreshKey:       os.Getenv("JWT_REFRESH_KEY"),
+			KeysDir:          getEnv("JWT_KEYS_DIR", "keys"),
+			KeyAlg:           getEnv("JWT_KEY_ALG", "RS256"),
+			KeysReload:       getEnvMinutes("JWT_KEYS_RELOAD_MINUTES", time.Minute),
 			AccessExpiresIn:  time.Minute * 30,
 			RefreshExpiresIn: time.Hour * 24 * 14,
 			Domain:           os.Getenv("DOMAIN"),
 		},
 		Equiring: Equiring{
-			StoreCode:   os.Getenv("EQUIRING_STORE_CODE"),
-			StoreSecret: os.Getenv("EQUIRING_SECRET_KEY"),
-			SecretPath:  os.Getenv("EQUIRING_WEBHOOK_PATH"),
+			StoreCode:         os.Getenv("EQUIRING_STORE_CODE"),
+			StoreSecret:       os.Getenv("EQUIRING_SECRET_KEY"),
+			SecretPath:        os.Getenv("EQUIRING_WEBHOOK_PATH"),
+			Gateway:           gateway,
+			ReturnURL:         getEnv("EQUIRING_RETURN_URL", "https://mzt-study.ru/"),
+			APIURL:            getEnv("EQUIRING_API_URL", "https://api.yookassa.ru/v3"),
+			TrustedNetworks:   getEnvList("EQUIRING_TRUSTED_NETWORKS", trustedNetworks),
+			StalePaymentAfter: getEnvMinutes("EQUIRING_STALE_PAYMENT_MINUTES", time.Minute*30),
+			ReconcileInterval: getEnvMinutes("EQUIRING_RECONCILE_INTERVAL_MINUTES", time.Minute*5),
+			SendReceipts:      getEnvBool("EQUIRING_SEND_RECEIPTS", true),
+			VatCode:           getEnvInt("EQUIRING_VAT_CODE", 1),
+			TaxSystemCode:     getEnvInt("EQUIRING_TAX_SYSTEM_CODE", 0),
+			PaymentSubject:    getEnv("EQUIRING_PAYMENT_SUBJECT", "service"),
+			PaymentMode:       getEnv("EQUIRING_PAYMENT_MODE", "full_payment"),
+
+			BillingInterval:         getEnvMinutes("EQUIRING_BILLING_INTERVAL_MINUTES", time.Hour),
+			RenewalRetryInterval:    getEnvHours("EQUIRING_RENEWAL_RETRY_HOURS", time.Hour*24),
+			SubscriptionGracePeriod: getEnvHours("EQUIRING_SUBSCRIPTION_GRACE_HOURS", time.Hour*72),
+			InstallmentGracePeriod:  getEnvHours("EQUIRING_INSTALLMENT_GRACE_HOURS", time.Hour*72),
+			GiftCodeTTL:             getEnvHours("EQUIRING_GIFT_CODE_TTL_HOURS", time.Hour*24*365),
+		},
+		Invoice: Invoice{
+			SellerName:    os.Getenv("INVOICE_SELLER_NAME"),
+			SellerINN:     os.Getenv("INVOICE_SELLER_INN"),
+			SellerKPP:     os.Getenv("INVOICE_SELLER_KPP"),
+			SellerAddress: os.Getenv("INVOICE_SELLER_ADDRESS"),
+			BankName:      os.Getenv("INVOICE_BANK_NAME"),
+			BIK:           os.Getenv("INVOICE_BIK"),
+			BankAccount:   os.Getenv("INVOICE_BANK_ACCOUNT"),
+			CorrAccount:   os.Getenv("INVOICE_CORR_ACCOUNT"),
+			VatNote:       getEnv("INVOICE_VAT_NOTE", "Без НДС"),
+			DueIn:         getEnvHours("INVOICE_DUE_HOURS", time.Hour*24*5),
+			FontPath:      getEnv("INVOICE_FONT_PATH", "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"),
+		},
+		Mail: Mail{
+			Sender:       getEnv("MAIL_SENDER", "smtp"),
+			From:         os.Getenv("MAIL_FROM"),
+			SMTPHost:     os.Getenv("SMTP_HOST"),
+			SMTPPort:     getEnv("SMTP_PORT", "587"),
+			SMTPUser:     os.Getenv("SMTP_USER"),
+			SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+			Dir:          os.Getenv("MAIL_DIR"),
+		},
+		Auth: Auth{
+			FrontendURL:          getEnv("FRONTEND_URL", "https://mzt-study.ru"),
+			PasswordResetTTL:     getEnvMinutes("PASSWORD_RESET_TTL_MINUTES", time.Hour),
+			EmailVerifyKey:       os.Getenv("EMAIL_VERIFY_KEY"),
+			EmailVerifyTTL:       getEnvHours("EMAIL_VERIFY_TTL_HOURS", time.Hour*72),
+			VerifyResendInterval: getEnvMinutes("EMAIL_VERIFY_RESEND_MINUTES", time.Minute*2),
+
+			TwoFactorKey:          os.Getenv("TWO_FACTOR_KEY"),
+			TwoFactorIssuer:       getEnv("TWO_FACTOR_ISSUER", "MZT"),
+			TwoFactorChallengeTTL: getEnvMinutes("TWO_FACTOR_CHALLENGE_TTL_MINUTES", time.Minute*5),
+			RequireAdminTwoFactor: getEnvBool("TWO_FACTOR_REQUIRED_FOR_ADMINS", false),
+
+			MaxLoginAttempts: getEnvInt("LOGIN_MAX_ATTEMPTS", 5),
+			LoginLockout:     getEnvMinutes("LOGIN_LOCKOUT_MINUTES", time.Minute*15),
+			MaxLoginLockout:  getEnvHours("LOGIN_MAX_LOCKOUT_HOURS", time.Hour*24),
+
+			TelegramBotToken: os.Getenv("TELEGRAM_BOT_TOKEN"),
+			TelegramAuthTTL:  getEnvHours("TELEGRAM_AUTH_TTL_HOURS", time.Hour*24),
+			ReauthWindow:     getEnvMinutes("REAUTH_WINDOW_MINUTES", time.Minute*10),
+		},
+		RateLimit: RateLimit{
+			Store:                   getEnv("RATE_LIMIT_STORE", "memory"),
+			Window:                  getEnvMinutes("RATE_LIMIT_WINDOW_MINUTES", time.Minute),
+			SignInPerIP:             getEnvInt("RATE_LIMIT_SIGNIN_PER_IP", 20),
+			SignInPerAccount:        getEnvInt("RATE_LIMIT_SIGNIN_PER_ACCOUNT", 10),
+			SignUpPerIP:             getEnvInt("RATE_LIMIT_SIGNUP_PER_IP", 5),
+			RefreshPerIP:            getEnvInt("RATE_LIMIT_REFRESH_PER_IP", 60),
+			PasswordResetPerIP:      getEnvInt("RATE_LIMIT_PASSWORD_RESET_PER_IP", 10),
+			PasswordResetPerAccount: getEnvInt("RATE_LIMIT_PASSWORD_RESET_PER_ACCOUNT", 3),
+			EmailConfirmPerIP:       getEnvInt("RATE_LIMIT_EMAIL_CONFIRM_PER_IP", 20),
+		},
+		OAuth: OAuth{
+			RedirectURL: getEnv("OAUTH_REDIRECT_URL", strings.TrimRight(getEnv("FRONTEND_URL", "https://mzt-study.ru"), "/")+"/oauth/callback"),
+			StateTTL:    getEnvMinutes("OAUTH_STATE_TTL_MINUTES", time.Minute*10),
+			Google: getOAuthProvider("OAUTH_GOOGLE",
+				"https://accounts.google.com/o/oauth2/v2/auth",
+				"https://oauth2.googleapis.com/token",
+				"https://openidconnect.googleapis.com/v1/userinfo"),
+			Yandex: getOAuthProvider("OAUTH_YANDEX",
+				"https://oauth.yandex.ru/authorize",
+				"https://oauth.yandex.ru/token",
+				"https://login.yandex.ru/info"),
+			VK: getOAuthProvider("OAUTH_VK",
+				"https://id.vk.com/authorize",
+				"https://id.vk.com/oauth2/auth",
+				"https://id.vk.com/oauth2/user_info"),
+		},
+		Server: Server{
+			TrustedProxies: getEnvList("TRUSTED_PROXIES", nil),
 		},
 	}
 }
+
+// настройки приложения во внешнем сервисе из переменных с префиксом, адреса по умолчанию - настоящие
+func getOAuthProvider(prefix, authURL, tokenURL, userInfoURL string) OAuthProvider {
+	return OAuthProvider{
+		ClientID:     os.Getenv(prefix + "_CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "_CLIENT_SECRET"),
+		AuthURL:      getEnv(prefix+"_AUTH_URL", authURL),
+		TokenURL:     getEnv(prefix+"_TOKEN_URL", tokenURL),
+		UserInfoURL:  getEnv(prefix+"_USERINFO_URL", userInfoURL),
+	}
+}
+
+// возвращает значение переменной окружения или значение по умолчанию
+func getEnv(key, def string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return def
+}
+
+// возвращает список из переменной окружения, элементы разделены запятыми
+func getEnvList(key string, def []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(value) == "" {
+		return def
+	}
+
+	result := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// возвращает длительность из переменной окружения, значение задается в минутах
+func getEnvMinutes(key string, def time.Duration) time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || minutes <= 0 {
+		return def
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// возвращает длительность из переменной окружения, значение задается в часах
+func getEnvHours(key string, def time.Duration) time.Duration {
+	hours, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || hours <= 0 {
+		return def
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// возвращает целое число из переменной окружения
+func getEnvInt(key string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// возвращает флаг из переменной окружения: true/false, 1/0
+func getEnvBool(key string, def bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return value
+}
@@ -1,39 +1,120 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"mzt/config"
+	"mzt/internal/gateway"
+	"mzt/internal/jwtkeys"
+	"mzt/internal/mailer"
 	"mzt/internal/middleware"
 	"mzt/internal/migration"
+	"mzt/internal/ratelimit"
 	"mzt/internal/repository"
 	"mzt/internal/router"
 	"mzt/internal/service"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
+// ключи подписи и шифрования короче этого можно подобрать перебором
+const minKeyLength = 32
+
 func Run(cfg *config.Config) {
+	// без этих ключей ссылки из писем, refresh токены и токен второго шага входа мог бы подписать кто угодно,
+	// а секреты для одноразовых кодов лежали бы в базе открыто - не запускаемся
+	for _, key := range []struct{ name, value string }{
+		{"JWT_REFRESH_KEY", cfg.Jwt.RefreshKey},
+		{"EMAIL_VERIFY_KEY", cfg.Auth.EmailVerifyKey},
+		{"TWO_FACTOR_KEY", cfg.Auth.TwoFactorKey},
+	} {
+		if err := checkKey(key.name, key.value); err != nil {
+			panic(err)
+		}
+	}
+
 	// создаем репозитории для работы с данными
 	userRepo := repository.NewUserRepo(cfg)
 	courseRepo := repository.NewCourseRepo(cfg)
 	eventRepo := repository.NewEventRepo(cfg)
 	paymentRepo := repository.NewPaymentRepo(cfg)
+	promoCodeRepo := repository.NewPromoCodeRepo(cfg)
+	subscriptionRepo := repository.NewSubscriptionRepo(cfg)
+	installmentRepo := repository.NewInstallmentRepo(cfg)
+	bundleRepo := repository.NewBundleRepo(cfg)
+	giftCodeRepo := repository.NewGiftCodeRepo(cfg)
+	invoiceRepo := repository.NewInvoiceRepo(cfg)
 
 	// запускаем миграции базы данных
-	migration.RunMigrations(cfg)
+	// часть из них удаляет старые колонки и таблицы, на наполовину мигрированной схеме не запускаемся
+	if err := migration.RunMigrations(cfg); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	// выбираем платежного провайдера по имени из конфига
+	paymentGateway, err := gateway.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	// выбираем способ отправки писем по имени из конфига
+	mailSender, err := mailer.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	// ключи для подписи access токенов, ими управляет cmd/jwtkeys
+	keys, err := jwtkeys.Load(cfg)
+	if err != nil {
+		panic(err)
+	}
 
 	// создаем сервисы для бизнес логики
-	authService := service.NewUserService(cfg, userRepo)
+	authService := service.NewUserService(cfg, userRepo, mailSender, keys)
 	courseService := service.NewCourseService(cfg, courseRepo)
-	paymentService := service.NewPaymentService(cfg, courseRepo, paymentRepo)
+	paymentService := service.NewPaymentService(cfg, courseRepo, paymentRepo, promoCodeRepo, userRepo, subscriptionRepo, installmentRepo, bundleRepo, giftCodeRepo, paymentGateway)
 	eventService := service.NewEventService(cfg, eventRepo, courseRepo)
+	promoCodeService := service.NewPromoCodeService(cfg, promoCodeRepo, courseRepo)
+	subscriptionService := service.NewSubscriptionService(cfg, subscriptionRepo, courseRepo, paymentRepo, paymentService)
+	installmentService := service.NewInstallmentService(cfg, installmentRepo, courseRepo, paymentService)
+	bundleService := service.NewBundleService(cfg, bundleRepo, courseRepo, paymentRepo, paymentService)
+	giftService := service.NewGiftService(cfg, giftCodeRepo, courseRepo, paymentService)
+	invoiceService := service.NewInvoiceService(cfg, invoiceRepo, courseRepo, paymentRepo, userRepo)
+	reportService := service.NewReportService(cfg, paymentRepo)
+
+	// фоновые задачи останавливаются когда закрывается stop при завершении приложения
+	stop := make(chan struct{})
+	// в фоне сверяем зависшие платежи с провайдером
+	service.StartPaymentReconciler(paymentService, stop)
+	// в фоне списываем деньги за продление подписок и забираем доступ у истекших
+	service.StartSubscriptionScheduler(subscriptionService, stop)
+	// в фоне приостанавливаем доступ по просроченным рассрочкам
+	service.StartInstallmentScheduler(installmentService, stop)
+
+	// счетчики запросов для ограничения частоты входа
+	limits, err := ratelimit.New(cfg)
+	if err != nil {
+		panic(err)
+	}
 
 	// создаем middleware для обработки запросов
-	middleware := middleware.NewMiddleware(cfg, userRepo, courseRepo)
+	middleware := middleware.NewMiddleware(cfg, userRepo, courseRepo, limits, keys)
 
 	// создаем роутер
 	handler := gin.Default()
 
+	// доверяем X-Forwarded-For только от наших прокси, иначе по нему нельзя проверять адрес провайдера
+	if err := handler.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		panic(err)
+	}
+
 	// настраиваем cors для работы с фронтендом
 	handler.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:8080", "http://127.0.0.1:5173", "http://127.0.0.1:8080", "https://c221-62-60-236-43.ngrok-free.app"},
@@ -45,8 +126,32 @@ func Run(cfg *config.Config) {
 	}))
 
 	// настраиваем все маршруты
-	router.NewRouter(cfg, handler, authService, courseService, paymentService, eventService, middleware)
+	router.NewRouter(cfg, handler, authService, courseService, paymentService, eventService, promoCodeService, subscriptionService, installmentService, bundleService, giftService, invoiceService, reportService, middleware)
 	// запускаем сервер на порту 8080
-	handler.Run(":8080")
-	//TODO server
+	server := &http.Server{Addr: ":8080", Handler: handler}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic(err)
+		}
+	}()
+
+	// по SIGINT или SIGTERM останавливаем фоновые задачи и даем запросам закончиться
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	<-ctx.Done()
+	close(stop)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+}
+
+// проверяет что секрет из окружения задан и достаточно длинный
+func checkKey(name, key string) error {
+	if len(key) < minKeyLength {
+		return fmt.Errorf("%s must be set and at least %d bytes long", name, minKeyLength)
+	}
+	return nil
 }
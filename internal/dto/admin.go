@@ -1,6 +1,7 @@
 package dto
 
 import (
+	"mzt/internal/money"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,18 +20,19 @@ type UserInfoAdminDto struct {
 	IsBusinessOwner   string      `json:"is_business_owner" binding:"required"`
 	PositionAtWork    string      `json:"position_at_work" binding:"required"`
 	MonthIncome       uint        `json:"month_income"`
+	Roles             []string    `json:"roles"`
 	CourseAssignments []CourseDto `json:"course_assignments"`
 }
 type CreateCourseDto struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description" binding:"required"`
-	Price       uint   `json:"price" binding:"required"`
+	Name        string      `json:"name" binding:"required"`
+	Description string      `json:"description" binding:"required"`
+	Price       money.Money `json:"price" binding:"required"`
 }
 
 type UpdateCourseDto struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description" binding:"required"`
-	Price       uint   `json:"price" binding:"required"`
+	Name        string      `json:"name" binding:"required"`
+	Description string      `json:"description" binding:"required"`
+	Price       money.Money `json:"price" binding:"required"`
 }
 
 type CreateLessonDto struct {
@@ -61,3 +63,107 @@ type UpdateUserDto struct {
 	MonthIncome     uint   `json:"month_income"`
 	// MonthIncome     uint      `json:"month_income" binding:"required"`
 }
+
+// данные промокода от админа, используется и для создания и для обновления
+// kind - percent (нужен percent) или fixed (нужен amount), пустой course_ids значит что промокод действует на все курсы
+type CreatePromoCodeDto struct {
+	Code         string       `json:"code" binding:"required"`
+	Kind         string       `json:"kind" binding:"required"`
+	Percent      float64      `json:"percent"`
+	Amount       *money.Money `json:"amount"`
+	ValidFrom    *time.Time   `json:"valid_from"`
+	ValidUntil   *time.Time   `json:"valid_until"`
+	UsageLimit   uint         `json:"usage_limit"`
+	PerUserLimit uint         `json:"per_user_limit"`
+	Active       *bool        `json:"active"`
+	CourseIDs    []uuid.UUID  `json:"course_ids"`
+}
+
+type PromoCodeDto struct {
+	PromoCodeID  uuid.UUID    `json:"promo_code_id"`
+	Code         string       `json:"code"`
+	Kind         string       `json:"kind"`
+	Percent      float64      `json:"percent,omitempty"`
+	Amount       *money.Money `json:"amount,omitempty"`
+	ValidFrom    *time.Time   `json:"valid_from"`
+	ValidUntil   *time.Time   `json:"valid_until"`
+	UsageLimit   uint         `json:"usage_limit"`
+	PerUserLimit uint         `json:"per_user_limit"`
+	Active       bool         `json:"active"`
+	CourseIDs    []uuid.UUID  `json:"course_ids"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+// статистика по оплаченным с промокодом платежам
+// суммы скидок и выручки считаются отдельно по каждой валюте
+type PromoCodeStatsDto struct {
+	PromoCodeID   uuid.UUID     `json:"promo_code_id"`
+	Usages        int64         `json:"usages"`
+	Users         int64         `json:"users"`
+	TotalDiscount []money.Money `json:"total_discount"`
+	Revenue       []money.Money `json:"revenue"`
+}
+
+// данные плана подписки от админа, используется и для создания и для обновления
+// period_months - длина оплачиваемого периода, по умолчанию месяц
+type CreatePlanDto struct {
+	Title        string      `json:"title" binding:"required"`
+	Description  string      `json:"description"`
+	Price        money.Money `json:"price" binding:"required"`
+	PeriodMonths uint        `json:"period_months"`
+	Active       *bool       `json:"active"`
+	CourseIDs    []uuid.UUID `json:"course_ids" binding:"required"`
+}
+
+// данные набора курсов от админа, используется и для создания и для обновления
+// в наборе должно быть хотя бы два курса
+type CreateBundleDto struct {
+	Title       string      `json:"title" binding:"required"`
+	Description string      `json:"description"`
+	Price       money.Money `json:"price" binding:"required"`
+	Active      *bool       `json:"active"`
+	CourseIDs   []uuid.UUID `json:"course_ids" binding:"required"`
+}
+
+// запрос админа на выпуск пачки подарочных кодов без оплаты
+// note - для кого выпущены коды, например название компании
+type CreateGiftCodesDto struct {
+	CourseID  uuid.UUID  `json:"course_id" binding:"required"`
+	Count     uint       `json:"count" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Note      string     `json:"note"`
+}
+
+// отметка админа об оплате счета: кого из сотрудников компании записать на курс
+// сотрудников не может быть больше чем мест в счете
+type MarkInvoicePaidDto struct {
+	UserIDs []uuid.UUID `json:"user_ids" binding:"required"`
+}
+
+// событие безопасности по аккаунту пользователя
+type SecurityEventDto struct {
+	Type      string     `json:"type"`
+	SessionID *uuid.UUID `json:"session_id,omitempty"`
+	UserAgent string     `json:"user_agent"`
+	IP        string     `json:"ip"`
+	Details   string     `json:"details"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// роль и ее права
+type RoleDto struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// роли пользователя и права которые они дают
+type UserRolesDto struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// новые роли пользователя от админа, заменяют текущие
+type SetUserRolesDto struct {
+	Roles []string `json:"roles" binding:"required"`
+}
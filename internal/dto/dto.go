@@ -1,6 +1,7 @@
 package dto
 
 import (
+	"mzt/internal/money"
 	"time"
 
 	"github.com/google/uuid"
@@ -25,14 +26,77 @@ type RegistrationDto struct {
 }
 
 type PaymentDto struct {
-	PaymentID    uuid.UUID `json:"payment_id" binding:"required"`
-	UserID       uuid.UUID `json:"user_id" binding:"required"`
-	CourseID     uuid.UUID `json:"course_id" binding:"required"`
-	Amount       float64   `json:"amount" binding:"required"`
-	CurrencyCode string    `json:"currency_code" binding:"required"`
-	Date         time.Time `json:"date" binding:"required"`
-	Status       string    `json:"status" binding:"required"`
-	PaymentRef   string    `json:"payment_ref"`
+	PaymentID         uuid.UUID   `json:"payment_id" binding:"required"`
+	UserID            uuid.UUID   `json:"user_id" binding:"required"`
+	CourseID          *uuid.UUID  `json:"course_id,omitempty"`
+	SubscriptionID    *uuid.UUID  `json:"subscription_id,omitempty"`
+	BundleID          *uuid.UUID  `json:"bundle_id,omitempty"`
+	InstallmentPlanID *uuid.UUID  `json:"installment_plan_id,omitempty"`
+	Amount            money.Money `json:"amount" binding:"required"`
+	Date              time.Time   `json:"date" binding:"required"`
+	Status            string      `json:"status" binding:"required"`
+	PaymentRef        string      `json:"payment_ref"`
+	PromoCode         string      `json:"promo_code,omitempty"`
+	Discount          money.Money `json:"discount"`
+	// статус регистрации чека, пустой если чек не отправлялся
+	ReceiptStatus string `json:"receipt_status,omitempty"`
+	// курс куплен в подарок
+	Gift bool `json:"gift,omitempty"`
+
+	History []PaymentEventDto `json:"history"`
+	Refunds []RefundDto       `json:"refunds"`
+}
+
+type PaymentEventDto struct {
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Source     string    `json:"source"`
+	Payload    string    `json:"payload,omitempty"`
+	Date       time.Time `json:"date"`
+}
+
+// тело запроса на покупку курса, промокод необязателен
+// installments - на сколько частей разбить оплату, 0 или 1 - оплата целиком
+// gift - купить в подарок: после оплаты покупатель получит код доступа вместо записи на курс
+type CreatePaymentDto struct {
+	PromoCode    string `json:"promo_code"`
+	Installments uint   `json:"installments"`
+	Gift         bool   `json:"gift"`
+}
+
+// тело запроса на активацию подарочного кода
+type RedeemGiftCodeDto struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// реквизиты компании для счета на оплату курса банковским переводом
+// seats - сколько сотрудников будет учиться, kpp не нужен если инн у ИП
+type CreateInvoiceDto struct {
+	Seats        uint   `json:"seats" binding:"required"`
+	CompanyName  string `json:"company_name" binding:"required"`
+	INN          string `json:"inn" binding:"required"`
+	KPP          string `json:"kpp"`
+	LegalAddress string `json:"legal_address" binding:"required"`
+	BankName     string `json:"bank_name"`
+	BIK          string `json:"bik"`
+	BankAccount  string `json:"bank_account"`
+	CorrAccount  string `json:"corr_account"`
+}
+
+// запрос на возврат, если сумма не указана - возвращается весь остаток
+type RefundRequestDto struct {
+	Amount *money.Money `json:"amount"`
+	Reason string       `json:"reason"`
+}
+
+type RefundDto struct {
+	RefundID  uuid.UUID   `json:"refund_id"`
+	PaymentID uuid.UUID   `json:"payment_id"`
+	Amount    money.Money `json:"amount"`
+	Status    string      `json:"status"`
+	RefundRef string      `json:"refund_ref"`
+	Reason    string      `json:"reason,omitempty"`
+	Date      time.Time   `json:"date"`
 }
 
 type UserInfoDto struct {
@@ -49,6 +113,7 @@ type UserInfoDto struct {
 	PositionAtWork  string `json:"position_at_work" binding:"required"`
 	MonthIncome     uint   `json:"month_income"`
 	// MonthIncome     uint   `json:"month_income" binding:"required"`
+	EmailVerified bool `json:"email_verified"`
 }
 
 type LoginDto struct {
@@ -56,6 +121,96 @@ type LoginDto struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// запрос ссылки для сброса пароля
+type ForgotPasswordDto struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// новый пароль и токен из ссылки в письме
+type ResetPasswordDto struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// токен из ссылки для подтверждения почты
+type VerifyEmailDto struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// изменения профиля от самого пользователя, поля которых нет в запросе не меняются
+// почта меняется отдельно через подтверждение новой почты
+type UpdateProfileDto struct {
+	Name            *string    `json:"name"`
+	Birthdate       *time.Time `json:"birthdate"`
+	PhoneNumber     *string    `json:"phone_number"`
+	Telegram        *string    `json:"telegram"`
+	City            *string    `json:"city"`
+	Employment      *string    `json:"employment"`
+	IsBusinessOwner *string    `json:"is_business_owner"`
+	PositionAtWork  *string    `json:"position_at_work"`
+	MonthIncome     *uint      `json:"month_income"`
+}
+
+// смена пароля, нужен текущий пароль
+// current_password можно не передавать если пароля еще нет, например аккаунт создан входом через телеграм
+type ChangePasswordDto struct {
+	CurrentPassword string `json:"current_password"`
+	// код из приложения или код восстановления вместо пароля, если пароля у аккаунта еще нет
+	Code        string `json:"code"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// смена почты, нужен текущий пароль, новая почта начнет работать после подтверждения
+type ChangeEmailDto struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password"`
+	// код из приложения или код восстановления вместо пароля, если пароля у аккаунта нет
+	Code string `json:"code"`
+}
+
+// второй шаг входа: токен из ответа на пароль и код из приложения или код восстановления
+type TwoFactorVerifyDto struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+}
+
+// одноразовый код из приложения
+type TwoFactorCodeDto struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// отключение входа по кодам, нужен пароль и код из приложения или код восстановления
+type DisableTwoFactorDto struct {
+	Password string `json:"password"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// секрет для приложения с кодами, uri показывается QR-кодом
+type TwoFactorSetupDto struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// подключен ли вход по кодам
+// required - без него не пустят в админку
+type TwoFactorStatusDto struct {
+	Enabled           bool  `json:"enabled"`
+	RecoveryCodesLeft int64 `json:"recovery_codes_left"`
+	Required          bool  `json:"required"`
+}
+
+// сессия пользователя на одном устройстве
+// current - сессия из которой пришел запрос
+type SessionDto struct {
+	SessionID  uuid.UUID `json:"session_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
 // TODO summEry
 type LessonDto struct {
 	LessonID uuid.UUID `json:"lesson_id"`
@@ -75,13 +230,12 @@ type UpdateProgressDto struct {
 }
 
 type CourseDto struct {
-	CourseID    uuid.UUID `json:"course_id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Price       struct {
-		Amount       float64 `json:"amount"`
-		CurrencyCode string  `json:"currency_code"`
-	} `json:"price"`
+	CourseID    uuid.UUID   `json:"course_id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Price       money.Money `json:"price"`
+	// доступ к курсу приостановлен до оплаты просроченной части рассрочки
+	Suspended bool `json:"suspended,omitempty"`
 }
 
 type EventDto struct {
@@ -107,3 +261,168 @@ type UpdateEventDto struct {
 	EventDate   time.Time `json:"event_date"`
 	SecretInfo  string    `json:"secret_info"`
 }
+
+type PlanDto struct {
+	PlanID       uuid.UUID   `json:"plan_id"`
+	Title        string      `json:"title"`
+	Description  string      `json:"description"`
+	Price        money.Money `json:"price"`
+	PeriodMonths uint        `json:"period_months"`
+	Active       bool        `json:"active"`
+	CourseIDs    []uuid.UUID `json:"course_ids"`
+	CreatedAt    time.Time   `json:"created_at"`
+}
+
+type BundleDto struct {
+	BundleID    uuid.UUID   `json:"bundle_id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Price       money.Money `json:"price"`
+	Active      bool        `json:"active"`
+	CourseIDs   []uuid.UUID `json:"course_ids"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// подписка пользователя
+// доступ к курсам плана есть до current_period_end, а при неудачном продлении - до grace_until
+type SubscriptionDto struct {
+	SubscriptionID   uuid.UUID  `json:"subscription_id"`
+	Plan             PlanDto    `json:"plan"`
+	Status           string     `json:"status"`
+	CurrentPeriodEnd *time.Time `json:"current_period_end"`
+	NextChargeAt     *time.Time `json:"next_charge_at,omitempty"`
+	GraceUntil       *time.Time `json:"grace_until,omitempty"`
+	CanceledAt       *time.Time `json:"canceled_at,omitempty"`
+	PaymentMethod    string     `json:"payment_method,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// часть рассрочки в графике оплаты
+type InstallmentDto struct {
+	Number    uint        `json:"number"`
+	Amount    money.Money `json:"amount"`
+	DueAt     time.Time   `json:"due_at"`
+	PaidAt    *time.Time  `json:"paid_at"`
+	PaymentID *uuid.UUID  `json:"payment_id,omitempty"`
+	Overdue   bool        `json:"overdue"`
+}
+
+// рассрочка с графиком оплаты
+type InstallmentPlanDto struct {
+	InstallmentPlanID uuid.UUID        `json:"installment_plan_id"`
+	UserID            uuid.UUID        `json:"user_id"`
+	CourseID          uuid.UUID        `json:"course_id"`
+	CourseTitle       string           `json:"course_title"`
+	Amount            money.Money      `json:"amount"`
+	Paid              money.Money      `json:"paid"`
+	Parts             uint             `json:"parts"`
+	Status            string           `json:"status"`
+	NextDueAt         *time.Time       `json:"next_due_at,omitempty"`
+	Installments      []InstallmentDto `json:"installments"`
+	CreatedAt         time.Time        `json:"created_at"`
+}
+
+// подарочный код доступа к курсу
+// status - active, redeemed, expired или revoked
+type GiftCodeDto struct {
+	GiftCodeID      uuid.UUID  `json:"gift_code_id"`
+	Code            string     `json:"code"`
+	CourseID        uuid.UUID  `json:"course_id"`
+	CourseTitle     string     `json:"course_title"`
+	PaymentID       *uuid.UUID `json:"payment_id,omitempty"`
+	IssuedByID      uuid.UUID  `json:"issued_by_id"`
+	BatchID         *uuid.UUID `json:"batch_id,omitempty"`
+	Note            string     `json:"note,omitempty"`
+	Status          string     `json:"status"`
+	ExpiresAt       *time.Time `json:"expires_at"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	RedeemedByID    *uuid.UUID `json:"redeemed_by_id,omitempty"`
+	RedeemedByEmail string     `json:"redeemed_by_email,omitempty"`
+	RedeemedAt      *time.Time `json:"redeemed_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// счет юрлицу, status - статус платежа по счету
+type InvoiceDto struct {
+	InvoiceID    uuid.UUID            `json:"invoice_id"`
+	Number       uint                 `json:"number"`
+	PaymentID    uuid.UUID            `json:"payment_id"`
+	UserID       uuid.UUID            `json:"user_id"`
+	CourseID     uuid.UUID            `json:"course_id"`
+	CourseTitle  string               `json:"course_title"`
+	Seats        uint                 `json:"seats"`
+	UnitPrice    money.Money          `json:"unit_price"`
+	Amount       money.Money          `json:"amount"`
+	Status       string               `json:"status"`
+	CompanyName  string               `json:"company_name"`
+	INN          string               `json:"inn"`
+	KPP          string               `json:"kpp,omitempty"`
+	LegalAddress string               `json:"legal_address"`
+	BankName     string               `json:"bank_name,omitempty"`
+	BIK          string               `json:"bik,omitempty"`
+	BankAccount  string               `json:"bank_account,omitempty"`
+	CorrAccount  string               `json:"corr_account,omitempty"`
+	DueAt        time.Time            `json:"due_at"`
+	PaidAt       *time.Time           `json:"paid_at,omitempty"`
+	Employees    []InvoiceEmployeeDto `json:"employees"`
+	CreatedAt    time.Time            `json:"created_at"`
+}
+
+// сотрудник записанный на курс по счету
+type InvoiceEmployeeDto struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email,omitempty"`
+}
+
+// отчет по выручке, строки сгруппированы по group_by и валюте
+// totals - итоги по каждой валюте, суммы в разных валютах не складываются
+type RevenueReportDto struct {
+	GroupBy  string             `json:"group_by"`
+	From     *time.Time         `json:"from,omitempty"`
+	To       *time.Time         `json:"to,omitempty"`
+	Timezone string             `json:"timezone"`
+	Rows     []RevenueReportRow `json:"rows"`
+	Totals   []RevenueReportRow `json:"totals"`
+}
+
+// строка отчета по выручке
+// payments и amount - все созданные платежи, paid и revenue - оплаченные, в том числе потом возвращенные
+// conversion - доля оплаченных среди созданных, net - выручка за вычетом проведенных возвратов
+type RevenueReportRow struct {
+	Key        string      `json:"key"`
+	Label      string      `json:"label,omitempty"`
+	Currency   string      `json:"currency"`
+	Payments   int         `json:"payments"`
+	Amount     money.Money `json:"amount"`
+	Paid       int         `json:"paid"`
+	Conversion float64     `json:"conversion"`
+	Revenue    money.Money `json:"revenue"`
+	Refunds    int         `json:"refunds"`
+	Refunded   money.Money `json:"refunded"`
+	Net        money.Money `json:"net"`
+}
+
+// данные из Telegram Login Widget, передаются как есть вместе с подписью
+type TelegramAuthDto struct {
+	ID        int64  `json:"id" binding:"required"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+	PhotoURL  string `json:"photo_url"`
+	AuthDate  int64  `json:"auth_date" binding:"required"`
+	Hash      string `json:"hash" binding:"required"`
+}
+
+// то что внешний сервис передал фронтенду при возвращении пользователя
+type OAuthCallbackDto struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+	// только для VK ID
+	DeviceID string `json:"device_id"`
+}
+
+// каких данных профиля не хватает, после входа через внешний сервис их просят заполнить
+type ProfileStatusDto struct {
+	Complete      bool     `json:"complete"`
+	MissingFields []string `json:"missing_fields"`
+}
@@ -1,35 +1,93 @@
 package dto
 
+import "mzt/internal/money"
+
 type PaymentRequest struct {
-	Amount struct {
-		Value    string `json:"value"`
-		Currency string `json:"currency"`
-	} `json:"amount"`
-	Confirmation struct {
-		Type      string `json:"type"`
-		ReturnURL string `json:"return_url"`
-	} `json:"confirmation"`
-	Capture     bool              `json:"capture"`
-	Description string            `json:"description"`
-	Metadata    map[string]string `json:"metadata"`
+	Amount money.Money `json:"amount"`
+	// при списании сохраненным способом оплаты подтверждение не нужно
+	Confirmation *YooConfirmation  `json:"confirmation,omitempty"`
+	Capture      bool              `json:"capture"`
+	Description  string            `json:"description"`
+	Metadata     map[string]string `json:"metadata"`
+	Receipt      *YooReceipt       `json:"receipt,omitempty"`
+	// сохранить способ оплаты для автосписаний
+	SavePaymentMethod bool `json:"save_payment_method,omitempty"`
+	// списать сохраненным способом оплаты без участия пользователя
+	PaymentMethodID string `json:"payment_method_id,omitempty"`
 }
 
-type PaymentResponse struct {
-	ID           string `json:"id"`
-	Confirmation struct {
-		ConfirmationURL string `json:"confirmation_url"`
-	} `json:"confirmation"`
+type YooConfirmation struct {
+	Type      string `json:"type"`
+	ReturnURL string `json:"return_url"`
+}
+
+// способ оплаты в ответе YooKassa
+type YooPaymentMethod struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Saved bool   `json:"saved"`
+	Title string `json:"title,omitempty"`
+}
+
+// чек по 54-ФЗ https://yookassa.ru/developers/api#create_payment_receipt
+type YooReceipt struct {
+	Customer struct {
+		Email string `json:"email,omitempty"`
+		Phone string `json:"phone,omitempty"`
+	} `json:"customer"`
+	Items         []YooReceiptItem `json:"items"`
+	TaxSystemCode int              `json:"tax_system_code,omitempty"`
+}
+
+type YooReceiptItem struct {
+	Description    string      `json:"description"`
+	Quantity       string      `json:"quantity"`
+	Amount         money.Money `json:"amount"`
+	VatCode        int         `json:"vat_code"`
+	PaymentSubject string      `json:"payment_subject"`
+	PaymentMode    string      `json:"payment_mode"`
 }
 
 type YooWebhook struct {
 	Event  string `json:"event"`
 	Object struct {
-		ID       string `json:"id"`
-		Status   string `json:"status"`
-		Metadata struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+		// заполнен только для событий возврата, там object - это сам возврат
+		PaymentID string `json:"payment_id"`
+		Metadata  struct {
 			UserID    string `json:"user_id"`
 			CourseID  string `json:"course_id"`
 			PaymentID string `json:"payment_id"`
 		} `json:"metadata"`
 	} `json:"object"`
 }
+
+// платеж в том виде, в котором его возвращает GET /v3/payments/{id}
+type YooPayment struct {
+	ID           string      `json:"id"`
+	Status       string      `json:"status"`
+	Paid         bool        `json:"paid"`
+	Amount       money.Money `json:"amount"`
+	Confirmation struct {
+		Type            string `json:"type,omitempty"`
+		ConfirmationURL string `json:"confirmation_url,omitempty"`
+	} `json:"confirmation"`
+	Metadata      map[string]string `json:"metadata"`
+	PaymentMethod *YooPaymentMethod `json:"payment_method,omitempty"`
+	// статус регистрации чека: pending, succeeded или canceled
+	ReceiptRegistration string `json:"receipt_registration,omitempty"`
+}
+
+type YooRefundRequest struct {
+	PaymentID   string      `json:"payment_id"`
+	Amount      money.Money `json:"amount"`
+	Description string      `json:"description,omitempty"`
+}
+
+type YooRefund struct {
+	ID        string      `json:"id"`
+	PaymentID string      `json:"payment_id"`
+	Status    string      `json:"status"`
+	Amount    money.Money `json:"amount"`
+}
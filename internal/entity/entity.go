@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"mzt/internal/money"
 	"time"
 
 	"github.com/google/uuid"
@@ -25,18 +26,269 @@ type UserData struct {
 type User struct {
 	ID         uuid.UUID `gorm:"type:uuid;primaryKey"`
 	PasswdHash string
-	//TODO make role an entity
-	Role              int
-	Auth              *Auth              `gorm:"constraint:OnDelete:CASCADE;"`
+	// когда пользователь подтвердил почту, до этого покупать курсы нельзя
+	EmailVerifiedAt *time.Time
+	// когда последний раз отправляли письмо для подтверждения почты
+	VerificationSentAt *time.Time
+	// неверные пароли подряд, после LOGIN_MAX_ATTEMPTS вход блокируется
+	FailedLogins int `gorm:"not null;default:0"`
+	// сколько раз подряд блокировали вход, каждая следующая блокировка дольше
+	LoginLockouts int `gorm:"not null;default:0"`
+	// до какого момента вход по паролю заблокирован
+	LockedUntil *time.Time
+
+	Roles             []Role             `gorm:"many2many:user_roles;constraint:OnDelete:CASCADE;"`
+	Sessions          []Session          `gorm:"constraint:OnDelete:CASCADE;"`
+	Identities        []Identity         `gorm:"constraint:OnDelete:CASCADE;"`
 	UserData          *UserData          `gorm:"constraint:OnDelete:CASCADE;"`
 	CourseAssignments []CourseAssignment `gorm:"constraint:OnDelete:CASCADE;"`
 	Payments          []Payment          `gorm:"constraint:OnDelete:CASCADE;"`
 }
 
-type Auth struct {
-	ID     uint      `gorm:"primaryKey"`
-	UserID uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_userid_auth"`
-	Key    string    `gorm:"type:varchar(255);not null"`
+// IsLocked заблокирован ли вход по паролю
+func (u *User) IsLocked(at time.Time) bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(at)
+}
+
+// NextLockout на сколько заблокировать вход после очередной серии неверных паролей
+// первая блокировка длится base, каждая следующая подряд вдвое дольше, но не больше max
+func (u *User) NextLockout(base, max time.Duration) time.Duration {
+	lockout := base
+	for i := 0; i < u.LoginLockouts && lockout < max; i++ {
+		lockout *= 2
+	}
+	if lockout > max {
+		return max
+	}
+	return lockout
+}
+
+// Session вход пользователя с одного устройства
+// у каждой сессии свой refresh токен, поэтому вход с телефона не завершает сессию на ноутбуке
+// сессия это и есть семейство refresh токенов: при каждом обновлении токен меняется,
+// а id сессии (sid в токене) остается прежним
+type Session struct {
+	SessionID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index:idx_user_session"`
+	// sha256 от текущего refresh токена сессии, сам токен не храним
+	TokenHash string `gorm:"type:varchar(64);not null;uniqueIndex:idx_session_token"`
+	// jti текущего refresh токена, нужен чтобы разбирать инциденты
+	TokenID   string `gorm:"type:varchar(36)"`
+	UserAgent string
+	IP        string    `gorm:"type:varchar(64)"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	// когда сессией последний раз обновляли токены
+	LastUsedAt time.Time
+	// когда истекает refresh токен, после этого сессию можно только начать заново
+	ExpiresAt time.Time `gorm:"index:idx_session_expires"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// SecurityEventType что произошло с аккаунтом
+type SecurityEventType string
+
+const (
+	// предъявили refresh токен который уже обменяли на новый, семейство токенов отозвано
+	SecurityRefreshTokenReuse SecurityEventType = "refresh_token_reuse"
+	// пароль сброшен по ссылке из письма, все сессии завершены
+	SecurityPasswordReset SecurityEventType = "password_reset"
+	// пользователь сменил пароль, сессии на других устройствах завершены
+	SecurityPasswordChanged SecurityEventType = "password_changed"
+	// пользователь подтвердил новую почту, все сессии завершены
+	SecurityEmailChanged SecurityEventType = "email_changed"
+	// админ поменял роли пользователя
+	SecurityRolesChanged SecurityEventType = "roles_changed"
+	// пользователь подключил вход по одноразовым кодам
+	SecurityTwoFactorEnabled SecurityEventType = "two_factor_enabled"
+	// пользователь отключил вход по одноразовым кодам
+	SecurityTwoFactorDisabled SecurityEventType = "two_factor_disabled"
+	// вошли по коду восстановления вместо кода из приложения
+	SecurityRecoveryCodeUsed SecurityEventType = "recovery_code_used"
+	// несколько раз подряд ввели неверный пароль, вход временно заблокирован
+	SecurityAccountLocked SecurityEventType = "account_locked"
+	// к аккаунту привязали вход через внешний сервис
+	SecurityIdentityLinked SecurityEventType = "identity_linked"
+	// от аккаунта отвязали вход через внешний сервис
+	SecurityIdentityUnlinked SecurityEventType = "identity_unlinked"
+)
+
+// SecurityEvent событие безопасности по аккаунту пользователя
+type SecurityEvent struct {
+	ID        uint              `gorm:"primaryKey;autoIncrement"`
+	UserID    uuid.UUID         `gorm:"type:uuid;not null;index:idx_security_event_user"`
+	Type      SecurityEventType `gorm:"type:varchar(64);not null"`
+	SessionID *uuid.UUID        `gorm:"type:uuid"`
+	UserAgent string
+	IP        string `gorm:"type:varchar(64)"`
+	// подробности в свободной форме
+	Details   string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Permission право на группу действий в админке, код вида "courses:write"
+type Permission struct {
+	Code        string `gorm:"type:varchar(64);primaryKey"`
+	Description string
+}
+
+// Role именованный набор прав, у пользователя может быть несколько ролей
+// права пользователя - объединение прав всех его ролей
+type Role struct {
+	Name        string `gorm:"type:varchar(64);primaryKey"`
+	Description string
+
+	Permissions []Permission `gorm:"many2many:role_permissions;constraint:OnDelete:CASCADE;"`
+}
+
+// коды прав, их проверяет middleware RequirePermission
+const (
+	// смотреть пользователей и их историю
+	PermUsersRead = "users:read"
+	// редактировать и удалять пользователей
+	PermUsersWrite = "users:write"
+	// назначать роли пользователям
+	PermRolesWrite = "roles:write"
+	// создавать и менять курсы, уроки и мероприятия
+	PermCoursesWrite = "courses:write"
+	// смотреть кто записан на курс
+	PermEnrollmentsRead = "enrollments:read"
+	// убирать пользователей с курса
+	PermEnrollmentsWrite = "enrollments:write"
+	// смотреть платежи и просроченные рассрочки
+	PermPaymentsRead = "payments:read"
+	// делать возвраты
+	PermPaymentsWrite = "payments:write"
+	// смотреть отчеты по выручке
+	PermReportsRead = "reports:read"
+	// выпускать промокоды и подарочные коды
+	PermPromoWrite = "promo:write"
+	// выставлять счета юрлицам и отмечать их оплату
+	PermInvoicesWrite = "invoices:write"
+	// менять тарифы подписки и наборы курсов
+	PermCatalogWrite = "catalog:write"
+)
+
+// встроенные роли
+const (
+	RoleStudent       = "student"
+	RoleCurator       = "curator"
+	RoleContentEditor = "content_editor"
+	RoleFinance       = "finance"
+	RoleSuperadmin    = "superadmin"
+)
+
+// Permissions все права которые знает приложение
+var Permissions = []Permission{
+	{Code: PermUsersRead, Description: "Просмотр пользователей"},
+	{Code: PermUsersWrite, Description: "Редактирование и удаление пользователей"},
+	{Code: PermRolesWrite, Description: "Назначение ролей"},
+	{Code: PermCoursesWrite, Description: "Редактирование курсов, уроков и мероприятий"},
+	{Code: PermEnrollmentsRead, Description: "Просмотр записанных на курс"},
+	{Code: PermEnrollmentsWrite, Description: "Управление записью на курс"},
+	{Code: PermPaymentsRead, Description: "Просмотр платежей"},
+	{Code: PermPaymentsWrite, Description: "Возвраты"},
+	{Code: PermReportsRead, Description: "Отчеты по выручке"},
+	{Code: PermPromoWrite, Description: "Промокоды и подарочные коды"},
+	{Code: PermInvoicesWrite, Description: "Счета для юрлиц"},
+	{Code: PermCatalogWrite, Description: "Тарифы подписки и наборы курсов"},
+}
+
+// BuiltinRoles роли которые создает миграция
+// их права каждый раз приводятся к тому что написано здесь
+var BuiltinRoles = []Role{
+	{Name: RoleStudent, Description: "Студент"},
+	{Name: RoleCurator, Description: "Куратор", Permissions: permissions(
+		PermUsersRead, PermEnrollmentsRead, PermEnrollmentsWrite,
+	)},
+	{Name: RoleContentEditor, Description: "Редактор контента", Permissions: permissions(
+		PermCoursesWrite,
+	)},
+	{Name: RoleFinance, Description: "Финансы", Permissions: permissions(
+		PermUsersRead, PermPaymentsRead, PermPaymentsWrite, PermReportsRead,
+		PermPromoWrite, PermInvoicesWrite, PermCatalogWrite,
+	)},
+	{Name: RoleSuperadmin, Description: "Суперадмин", Permissions: Permissions},
+}
+
+// права по кодам
+func permissions(codes ...string) []Permission {
+	result := make([]Permission, 0, len(codes))
+	for _, code := range codes {
+		for _, permission := range Permissions {
+			if permission.Code == code {
+				result = append(result, permission)
+			}
+		}
+	}
+	return result
+}
+
+// HasRole есть ли у пользователя роль, роли должны быть загружены
+func (u *User) HasRole(name string) bool {
+	for _, role := range u.Roles {
+		if role.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission дает ли хотя бы одна из ролей право
+func HasPermission(roles []Role, code string) bool {
+	for _, role := range roles {
+		for _, permission := range role.Permissions {
+			if permission.Code == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TwoFactor вход по одноразовым кодам из приложения (TOTP)
+// запись появляется когда пользователь начал подключение, включенной считается после подтверждения кодом
+type TwoFactor struct {
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// секрет для генерации кодов, зашифрован ключом из конфига
+	Secret string `gorm:"not null"`
+	// когда подключение подтвердили кодом, nil - еще не подключено
+	EnabledAt *time.Time
+	// номер 30-секундного шага последнего принятого кода, один и тот же код дважды не принимаем
+	LastCounter int64
+	// сколько раз подряд ввели неверный код
+	FailedAttempts int
+	// до какого момента коды не проверяем после серии неверных
+	LockedUntil *time.Time
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// RecoveryCode одноразовый код на случай потери телефона с приложением
+// сами коды показываются один раз при выпуске, в базе лежит их sha256
+type RecoveryCode struct {
+	CodeHash  string    `gorm:"type:varchar(64);primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index:idx_recovery_code_user"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// PasswordResetToken одноразовая ссылка для сброса пароля
+// сам токен уходит только в письмо, в базе лежит его sha256
+type PasswordResetToken struct {
+	TokenHash string    `gorm:"type:varchar(64);primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index:idx_password_reset_user"`
+	ExpiresAt time.Time `gorm:"not null"`
+	// когда ссылкой воспользовались, второй раз она не сработает
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;"`
 }
 
 type Course struct {
@@ -51,13 +303,19 @@ type Course struct {
 	Price    *CoursePrice       `gorm:"constraint:OnDelete:CASCADE;"`
 }
 
-// TODO index on entries, refund if error
+// TODO index on entries
 // TODO also create payment repository
 type CourseAssignment struct {
 	CaID     uuid.UUID `gorm:"type:uuid;primaryKey"`
 	UserID   uuid.UUID `gorm:"type:uuid;not null;index:,unique,composite:idx_user_course"`
 	CourseID uuid.UUID `gorm:"type:uuid;not null;index:,unique,composite:idx_user_course"`
 	Progress uint
+	// доступ выдан подпиской и отзывается вместе с ней, у купленных курсов пусто
+	SubscriptionID *uuid.UUID `gorm:"type:uuid;index:idx_assignment_subscription"`
+	// доступ выдан покупкой набора и отзывается при полном возврате денег за него
+	BundleID *uuid.UUID `gorm:"type:uuid;index:idx_assignment_bundle"`
+	// доступ приостановлен из-за просроченной части рассрочки, прогресс сохраняется
+	Suspended bool `gorm:"not null;default:false"`
 
 	User   User
 	Course Course
@@ -86,24 +344,435 @@ type Event struct {
 }
 
 type Payment struct {
-	PaymentID    uuid.UUID `gorm:"type:uuid;primaryKey"`
-	UserID       uuid.UUID `gorm:"type:uuid;not null;index:idx_user_payment"`
-	CourseID     uuid.UUID `gorm:"type:uuid;not null;index:idx_course_payment"`
-	Amount       float64   `gorm:"not null"`
-	CurrencyCode string    `gorm:"not null;default:'RUB'"`
+	PaymentID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index:idx_user_payment"`
+	// платеж либо за курс, либо за период подписки, либо за набор курсов
+	CourseID       *uuid.UUID `gorm:"type:uuid;index:idx_course_payment"`
+	SubscriptionID *uuid.UUID `gorm:"type:uuid;index:idx_subscription_payment"`
+	// платеж за набор курсов
+	BundleID *uuid.UUID `gorm:"type:uuid;index:idx_bundle_payment"`
+	// платеж за часть рассрочки, CourseID при этом тоже заполнен
+	InstallmentPlanID *uuid.UUID    `gorm:"type:uuid;index:idx_installment_payment"`
+	Amount            money.Money   `gorm:"embedded;embeddedPrefix:amount_"`
+	CreatedAt         time.Time     `gorm:"autoCreateTime"`
+	Status            PaymentStatus `gorm:"type:varchar(32);not null;default:'pending'"`
+	PaymentRef        string        `gorm:"type:varchar(255);uniqueIndex:idx_payment_ref,where:payment_ref <> ''"`
+	// промокод которым оплачен платеж, Amount уже со скидкой
+	PromoCodeID *uuid.UUID  `gorm:"type:uuid;index:idx_payment_promo_code"`
+	Discount    money.Money `gorm:"embedded;embeddedPrefix:discount_"`
+	// статус регистрации фискального чека у провайдера, пустой если чек не отправлялся
+	ReceiptStatus ReceiptStatus `gorm:"type:varchar(32);not null;default:''"`
+	// курс покупается в подарок: после оплаты выпускается код доступа, а плательщик на курс не записывается
+	Gift bool `gorm:"not null;default:false"`
+	// когда пользователь получил оплаченное: курс, период подписки или код подарка
+	// succeeded без этой отметки значит выдача сорвалась, ее повторят уведомление или сверка
+	FulfilledAt *time.Time
+
+	User            User
+	Course          *Course
+	Subscription    *Subscription    `gorm:"constraint:OnDelete:SET NULL;"`
+	Bundle          *Bundle          `gorm:"constraint:OnDelete:SET NULL;"`
+	InstallmentPlan *InstallmentPlan `gorm:"constraint:OnDelete:SET NULL;"`
+	Events          []PaymentEvent   `gorm:"constraint:OnDelete:CASCADE;"`
+	Refunds         []Refund         `gorm:"constraint:OnDelete:CASCADE;"`
+	PromoCode       *PromoCode       `gorm:"constraint:OnDelete:SET NULL;"`
+	// курсы набора за которые посчитана цена платежа
+	BundleCourses []PaymentCourse `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// PaymentCourse курс набора на момент создания платежа
+// состав набора могут поменять пока платеж ждет оплаты, записываем пользователя на то за что он заплатил
+type PaymentCourse struct {
+	PaymentID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	CourseID  uuid.UUID `gorm:"type:uuid;primaryKey"`
+
+	Course Course `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// PaymentStatus статус платежа
+// меняется только по разрешенным переходам, см. CanTransitionTo
+type PaymentStatus string
+
+const (
+	PaymentPending    PaymentStatus = "pending"
+	PaymentProcessing PaymentStatus = "processing"
+	PaymentSucceeded  PaymentStatus = "succeeded"
+	PaymentCanceled   PaymentStatus = "canceled"
+	PaymentRefunded   PaymentStatus = "refunded"
+	// вернули часть суммы, доступ к курсу остается
+	PaymentPartiallyRefunded PaymentStatus = "partially_refunded"
+	// платеж завис и так и не был оплачен, выставляет сверка с провайдером
+	PaymentExpired PaymentStatus = "expired"
+	// выставлен счет юрлицу, ждем банковский перевод, оплату отмечает админ
+	PaymentAwaitingTransfer PaymentStatus = "awaiting_transfer"
+)
+
+// разрешенные переходы между статусами платежа
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentPending:    {PaymentProcessing, PaymentAwaitingTransfer, PaymentCanceled, PaymentExpired},
+	PaymentProcessing: {PaymentSucceeded, PaymentCanceled, PaymentExpired},
+	PaymentSucceeded:  {PaymentRefunded, PaymentPartiallyRefunded},
+	// каждый следующий частичный возврат тоже попадает в историю
+	PaymentPartiallyRefunded: {PaymentPartiallyRefunded, PaymentRefunded},
+	// провайдер может подтвердить оплату уже после того как мы пометили платеж истекшим
+	PaymentExpired: {PaymentSucceeded, PaymentCanceled},
+	// перевод по счету провайдер не видит, оплату отмечает админ и вместе с ней записывает сотрудников на курс
+	PaymentAwaitingTransfer: {PaymentSucceeded, PaymentCanceled},
+}
+
+// CanTransitionTo проверяет можно ли перевести платеж из текущего статуса в новый
+func (s PaymentStatus) CanTransitionTo(to PaymentStatus) bool {
+	for _, allowed := range paymentTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ReceiptStatus статус регистрации чека по 54-ФЗ
+type ReceiptStatus string
+
+const (
+	ReceiptPending   ReceiptStatus = "pending"
+	ReceiptSucceeded ReceiptStatus = "succeeded"
+	ReceiptCanceled  ReceiptStatus = "canceled"
+)
+
+// PaymentEventSource кто поменял статус платежа
+type PaymentEventSource string
+
+const (
+	PaymentSourceCheckout   PaymentEventSource = "checkout"
+	PaymentSourceWebhook    PaymentEventSource = "webhook"
+	PaymentSourceAdmin      PaymentEventSource = "admin"
+	PaymentSourceReconciler PaymentEventSource = "reconciler"
+	// списание за продление подписки
+	PaymentSourceScheduler PaymentEventSource = "scheduler"
+)
+
+// PaymentEvent запись в истории статусов платежа
+type PaymentEvent struct {
+	ID         uint               `gorm:"primaryKey;autoIncrement"`
+	PaymentID  uuid.UUID          `gorm:"type:uuid;not null;index:idx_payment_event"`
+	FromStatus PaymentStatus      `gorm:"type:varchar(32)"`
+	ToStatus   PaymentStatus      `gorm:"type:varchar(32);not null"`
+	Source     PaymentEventSource `gorm:"type:varchar(32);not null"`
+	// тело уведомления или ответа провайдера как есть
+	Payload   string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// RefundStatus статус возврата у провайдера
+type RefundStatus string
+
+const (
+	RefundPending   RefundStatus = "pending"
+	RefundSucceeded RefundStatus = "succeeded"
+	RefundCanceled  RefundStatus = "canceled"
+)
+
+// Refund возврат денег по платежу, полный или частичный
+type Refund struct {
+	RefundID  uuid.UUID    `gorm:"type:uuid;primaryKey"`
+	PaymentID uuid.UUID    `gorm:"type:uuid;not null;index:idx_payment_refund"`
+	Amount    money.Money  `gorm:"embedded;embeddedPrefix:amount_"`
+	Status    RefundStatus `gorm:"type:varchar(32);not null;default:'pending'"`
+	// id возврата у провайдера
+	RefundRef string    `gorm:"type:varchar(255);uniqueIndex:idx_refund_ref,where:refund_ref <> ''"`
+	Reason    string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// PromoCodeKind как считается скидка по промокоду
+type PromoCodeKind string
+
+const (
+	// Percent - процент от цены курса
+	PromoCodePercent PromoCodeKind = "percent"
+	// Amount - сумма которая вычитается из цены курса, действует только для курсов в той же валюте
+	PromoCodeFixed PromoCodeKind = "fixed"
+)
+
+// PromoCode промокод на скидку при оплате курса
+// нулевые лимиты и пустые даты значат что ограничения нет
+type PromoCode struct {
+	PromoCodeID  uuid.UUID     `gorm:"type:uuid;primaryKey"`
+	Code         string        `gorm:"type:varchar(64);not null;uniqueIndex:idx_promo_code"`
+	Kind         PromoCodeKind `gorm:"type:varchar(16);not null"`
+	Percent      float64       `gorm:"not null;default:0"`
+	Amount       money.Money   `gorm:"embedded;embeddedPrefix:amount_"`
+	ValidFrom    *time.Time
+	ValidUntil   *time.Time
+	UsageLimit   uint      `gorm:"not null;default:0"`
+	PerUserLimit uint      `gorm:"not null;default:0"`
+	Active       bool      `gorm:"not null;default:true"`
 	CreatedAt    time.Time `gorm:"autoCreateTime"`
-	Status       string    `gorm:"not null;default:'pending'"`
-	PaymentRef   string    `gorm:"type:varchar(255)"`
 
-	User   User
-	Course Course
+	// курсы на которые действует промокод, если пусто - на все
+	Courses []PromoCodeCourse `gorm:"constraint:OnDelete:CASCADE;"`
+	Usages  []PromoCodeUsage  `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+type PromoCodeCourse struct {
+	PromoCodeID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	CourseID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+
+	Course Course `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// PromoCodeUsage использование промокода, создается только после успешной оплаты
+type PromoCodeUsage struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement"`
+	PromoCodeID uuid.UUID `gorm:"type:uuid;not null;index:idx_promo_code_usage"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;index:idx_promo_code_usage"`
+	PaymentID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_promo_code_usage_payment"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
 }
 
 type CoursePrice struct {
-	ID           uint      `gorm:"primaryKey;autoIncrement"`
-	CourseID     uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_course_price"`
-	Amount       float64   `gorm:"not null"`
-	CurrencyCode string    `gorm:"not null;default:'RUB'"`
+	ID       uint        `gorm:"primaryKey;autoIncrement"`
+	CourseID uuid.UUID   `gorm:"type:uuid;not null;uniqueIndex:idx_course_price"`
+	Amount   money.Money `gorm:"embedded;embeddedPrefix:amount_"`
 
 	Course Course
 }
+
+// GiftCode одноразовый код доступа к курсу
+// выпускается после оплаты подарка или админом пачкой для корпоративных клиентов
+type GiftCode struct {
+	GiftCodeID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// код хранится в верхнем регистре в виде XXXX-XXXX-XXXX
+	Code     string    `gorm:"type:varchar(32);not null;uniqueIndex:idx_gift_code"`
+	CourseID uuid.UUID `gorm:"type:uuid;not null;index:idx_gift_code_course"`
+	// оплаченный подарок, у кодов выпущенных админом пусто
+	PaymentID *uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_gift_code_payment"`
+	// покупатель подарка или админ который выпустил код
+	IssuedByID uuid.UUID `gorm:"type:uuid;not null;index:idx_gift_code_issuer"`
+	// пачка кодов выпущенных админом за один раз и для кого она, например название компании
+	BatchID   *uuid.UUID `gorm:"type:uuid;index:idx_gift_code_batch"`
+	Note      string
+	ExpiresAt *time.Time
+	// код отозван, например после возврата денег за подарок
+	RevokedAt *time.Time
+	// кто и когда активировал код
+	RedeemedByID *uuid.UUID `gorm:"type:uuid;index:idx_gift_code_redeemer"`
+	RedeemedAt   *time.Time
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+
+	Course     Course   `gorm:"constraint:OnDelete:CASCADE;"`
+	Payment    *Payment `gorm:"constraint:OnDelete:SET NULL;"`
+	RedeemedBy *User    `gorm:"constraint:OnDelete:SET NULL;"`
+}
+
+// Invoice счет юрлицу на оплату курса банковским переводом
+// платеж по счету висит в awaiting_transfer пока админ не отметит оплату и не запишет сотрудников
+type Invoice struct {
+	InvoiceID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// номер счета который печатается в документе и указывается в назначении платежа
+	Number    uint      `gorm:"autoIncrement;not null;uniqueIndex:idx_invoice_number"`
+	PaymentID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_invoice_payment"`
+	// пользователь который оформил счет от имени компании
+	UserID   uuid.UUID `gorm:"type:uuid;not null;index:idx_user_invoice"`
+	CourseID uuid.UUID `gorm:"type:uuid;not null"`
+	// сколько сотрудников можно записать на курс по счету
+	Seats     uint        `gorm:"not null"`
+	UnitPrice money.Money `gorm:"embedded;embeddedPrefix:unit_price_"`
+
+	// реквизиты покупателя, КПП пустой у ИП
+	CompanyName  string `gorm:"not null"`
+	INN          string `gorm:"type:varchar(12);not null"`
+	KPP          string `gorm:"type:varchar(9)"`
+	LegalAddress string
+	BankName     string
+	BIK          string `gorm:"type:varchar(9)"`
+	BankAccount  string `gorm:"type:varchar(20)"`
+	CorrAccount  string `gorm:"type:varchar(20)"`
+
+	// до какого дня нужно оплатить счет
+	DueAt     time.Time
+	PaidAt    *time.Time
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+
+	Payment   Payment           `gorm:"constraint:OnDelete:CASCADE;"`
+	Course    Course            `gorm:"constraint:OnDelete:CASCADE;"`
+	Employees []InvoiceEmployee `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// InvoiceEmployee сотрудник которого записали на курс по оплаченному счету
+type InvoiceEmployee struct {
+	InvoiceID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Bundle набор курсов который продается как один товар по своей цене
+type Bundle struct {
+	BundleID    uuid.UUID   `gorm:"type:uuid;primaryKey"`
+	Title       string      `gorm:"not null"`
+	Description string      `gorm:"type:text"`
+	Amount      money.Money `gorm:"embedded;embeddedPrefix:amount_"`
+	Active      bool        `gorm:"not null;default:true"`
+	CreatedAt   time.Time   `gorm:"autoCreateTime"`
+
+	Courses []BundleCourse `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+type BundleCourse struct {
+	BundleID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	CourseID uuid.UUID `gorm:"type:uuid;primaryKey"`
+
+	Course Course `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Plan тарифный план: доступ к набору курсов на период с автопродлением
+type Plan struct {
+	PlanID      uuid.UUID   `gorm:"type:uuid;primaryKey"`
+	Title       string      `gorm:"not null"`
+	Description string      `gorm:"type:text"`
+	Amount      money.Money `gorm:"embedded;embeddedPrefix:amount_"`
+	// длина оплаченного периода в месяцах
+	PeriodMonths uint      `gorm:"not null;default:1"`
+	Active       bool      `gorm:"not null;default:true"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+
+	Courses []PlanCourse `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+type PlanCourse struct {
+	PlanID   uuid.UUID `gorm:"type:uuid;primaryKey"`
+	CourseID uuid.UUID `gorm:"type:uuid;primaryKey"`
+
+	Course Course `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// SubscriptionStatus статус подписки
+type SubscriptionStatus string
+
+const (
+	// подписка создана и ждет первой оплаты
+	SubscriptionPending SubscriptionStatus = "pending"
+	SubscriptionActive  SubscriptionStatus = "active"
+	// продление не прошло, доступ остается до конца льготного периода
+	SubscriptionPastDue SubscriptionStatus = "past_due"
+	// пользователь отменил продление, доступ остается до конца оплаченного периода
+	SubscriptionCanceled SubscriptionStatus = "canceled"
+	// доступ к курсам отозван
+	SubscriptionExpired SubscriptionStatus = "expired"
+)
+
+// Subscription подписка пользователя на план
+type Subscription struct {
+	SubscriptionID  uuid.UUID          `gorm:"type:uuid;primaryKey"`
+	UserID          uuid.UUID          `gorm:"type:uuid;not null;index:idx_user_subscription"`
+	PlanID          uuid.UUID          `gorm:"type:uuid;not null;index:idx_plan_subscription"`
+	Status          SubscriptionStatus `gorm:"type:varchar(32);not null;default:'pending'"`
+	PaymentMethodID *uuid.UUID         `gorm:"type:uuid"`
+	// до какого момента оплачен доступ
+	CurrentPeriodEnd *time.Time
+	// когда планировщик попробует списать деньги за следующий период
+	NextChargeAt *time.Time `gorm:"index:idx_subscription_next_charge"`
+	// до какого момента держим доступ если продление не прошло
+	GraceUntil *time.Time
+	CanceledAt *time.Time
+	// платеж которым оплачен текущий период, повторная обработка того же платежа период не продлевает
+	LastPaymentID *uuid.UUID `gorm:"type:uuid"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime"`
+
+	User          User           `gorm:"constraint:OnDelete:CASCADE;"`
+	Plan          Plan           `gorm:"constraint:OnDelete:CASCADE;"`
+	PaymentMethod *PaymentMethod `gorm:"constraint:OnDelete:SET NULL;"`
+}
+
+// PaymentMethod сохраненный у провайдера способ оплаты для автосписаний
+type PaymentMethod struct {
+	PaymentMethodID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID          uuid.UUID `gorm:"type:uuid;not null;index:idx_user_payment_method"`
+	// id способа оплаты у провайдера
+	Ref string `gorm:"type:varchar(255);not null;uniqueIndex:idx_payment_method_ref"`
+	// описание для пользователя, например "Bank card *4444"
+	Title     string
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// InstallmentPlanStatus статус рассрочки
+type InstallmentPlanStatus string
+
+const (
+	// рассрочка оформлена и ждет оплаты первой части
+	InstallmentPlanPending InstallmentPlanStatus = "pending"
+	InstallmentPlanActive  InstallmentPlanStatus = "active"
+	// очередная часть не оплачена дольше льготного периода, доступ к курсу приостановлен
+	InstallmentPlanOverdue InstallmentPlanStatus = "overdue"
+	// все части оплачены, курс остается у пользователя
+	InstallmentPlanCompleted InstallmentPlanStatus = "completed"
+	// первую часть так и не оплатили или деньги вернули
+	InstallmentPlanCanceled InstallmentPlanStatus = "canceled"
+)
+
+// InstallmentPlan рассрочка оплаты курса
+// цена курса делится на части с ежемесячными сроками оплаты, каждая часть оплачивается отдельным платежом
+type InstallmentPlan struct {
+	InstallmentPlanID uuid.UUID             `gorm:"type:uuid;primaryKey"`
+	UserID            uuid.UUID             `gorm:"type:uuid;not null;index:idx_user_installment_plan"`
+	CourseID          uuid.UUID             `gorm:"type:uuid;not null"`
+	Amount            money.Money           `gorm:"embedded;embeddedPrefix:amount_"`
+	Parts             uint                  `gorm:"not null"`
+	Status            InstallmentPlanStatus `gorm:"type:varchar(32);not null;default:'pending';index:idx_installment_plan_status"`
+	CreatedAt         time.Time             `gorm:"autoCreateTime"`
+	UpdatedAt         time.Time             `gorm:"autoUpdateTime"`
+
+	User         User          `gorm:"constraint:OnDelete:CASCADE;"`
+	Course       Course        `gorm:"constraint:OnDelete:CASCADE;"`
+	Installments []Installment `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Installment часть рассрочки
+type Installment struct {
+	InstallmentID     uuid.UUID   `gorm:"type:uuid;primaryKey"`
+	InstallmentPlanID uuid.UUID   `gorm:"type:uuid;not null;uniqueIndex:idx_installment_number"`
+	Number            uint        `gorm:"not null;uniqueIndex:idx_installment_number"`
+	Amount            money.Money `gorm:"embedded;embeddedPrefix:amount_"`
+	DueAt             time.Time   `gorm:"not null;index:idx_installment_due"`
+	// последний платеж которым пытались оплатить часть
+	PaymentID *uuid.UUID `gorm:"type:uuid"`
+	PaidAt    *time.Time
+}
+
+// внешние сервисы через которые можно войти
+const (
+	ProviderTelegram = "telegram"
+	ProviderGoogle   = "google"
+	ProviderYandex   = "yandex"
+	ProviderVK       = "vk"
+)
+
+// Identity аккаунт во внешнем сервисе через который пользователь входит без пароля
+// у пользователя не больше одного аккаунта каждого сервиса
+type Identity struct {
+	Provider string `gorm:"type:varchar(32);primaryKey;uniqueIndex:idx_identity_user_provider,priority:2"`
+	// id пользователя в сервисе, для телеграма - числовой id, он не меняется в отличие от username
+	Subject string    `gorm:"type:varchar(255);primaryKey"`
+	UserID  uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_identity_user_provider,priority:1"`
+	// имя пользователя в сервисе на момент привязки
+	Username  string
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// OAuthState начатый вход через внешний сервис, живет пока пользователь на стороне сервиса
+// по state из адреса возврата находим verifier для PKCE, сам state храним только хешем
+type OAuthState struct {
+	StateHash string `gorm:"type:varchar(64);primaryKey"`
+	Provider  string `gorm:"type:varchar(32);not null"`
+	// code_verifier, уходит сервису только вместе с кодом
+	Verifier string `gorm:"not null"`
+	// кому привязать аккаунт сервиса, nil - это вход
+	UserID    *uuid.UUID `gorm:"type:uuid"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
@@ -0,0 +1,193 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Fake провайдер который живет в памяти процесса
+// нужен для тестов и стенда, деньги никуда не уходят
+// статус платежа меняется через SetStatus, уведомление можно прислать на обычный вебхук:
+// {"event": "payment.succeeded", "payment_ref": "...", "payment_id": "..."}
+type Fake struct {
+	// статус с которым проходят списания сохраненным способом оплаты, по умолчанию succeeded
+	RecurringStatus string
+
+	mu       sync.Mutex
+	payments map[string]*Payment
+	refunds  map[string]*Refund
+	methods  map[string]*PaymentMethod
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		payments: make(map[string]*Payment),
+		refunds:  make(map[string]*Refund),
+		methods:  make(map[string]*PaymentMethod),
+	}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+// CreatePayment сохраняет платеж в статусе pending
+// ссылка на оплату ведет сразу на return url
+// списание сохраненным способом оплаты сразу получает RecurringStatus
+func (f *Fake) CreatePayment(req *CreatePaymentRequest) (*Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment := &Payment{
+		Ref:             uuid.New().String(),
+		Status:          StatusPending,
+		Amount:          req.Amount,
+		ConfirmationURL: req.ReturnURL,
+		Metadata:        req.Metadata,
+	}
+	if req.Receipt != nil {
+		payment.ReceiptStatus = StatusPending
+	}
+	if req.SavePaymentMethod {
+		payment.PaymentMethod = &PaymentMethod{Ref: uuid.New().String(), Title: "Bank card *4444"}
+	}
+	if req.PaymentMethodRef != "" {
+		method, ok := f.methods[req.PaymentMethodRef]
+		if !ok {
+			return nil, errors.New("payment method is not saved")
+		}
+		status := f.RecurringStatus
+		if status == "" {
+			status = StatusSucceeded
+		}
+		saved := *method
+		payment.PaymentMethod = &saved
+		payment.ConfirmationURL = ""
+		payment.Status = status
+		payment.Paid = status == StatusSucceeded
+		if payment.Paid && payment.ReceiptStatus != "" {
+			payment.ReceiptStatus = StatusSucceeded
+		}
+	}
+	f.payments[payment.Ref] = payment
+
+	result := *payment
+	return &result, nil
+}
+
+func (f *Fake) GetPayment(ref string) (*Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.payments[ref]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *payment
+	return &result, nil
+}
+
+// Refund сразу проводит возврат
+func (f *Fake) Refund(req *RefundRequest) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.payments[req.PaymentRef]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if payment.Status != StatusSucceeded {
+		return nil, errors.New("payment is not succeeded")
+	}
+
+	refund := &Refund{
+		Ref:        uuid.New().String(),
+		PaymentRef: req.PaymentRef,
+		Status:     StatusSucceeded,
+		Amount:     req.Amount,
+	}
+	f.refunds[refund.Ref] = refund
+
+	result := *refund
+	return &result, nil
+}
+
+func (f *Fake) GetRefund(ref string) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	refund, ok := f.refunds[ref]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *refund
+	return &result, nil
+}
+
+// ParseWebhook разбирает уведомление в простом формате фейкового провайдера
+func (f *Fake) ParseWebhook(body []byte) (*WebhookEvent, error) {
+	var webhook struct {
+		Event      string `json:"event"`
+		PaymentRef string `json:"payment_ref"`
+		RefundRef  string `json:"refund_ref"`
+		PaymentID  string `json:"payment_id"`
+	}
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, err
+	}
+	if webhook.Event == "" || webhook.PaymentRef == "" {
+		return nil, errors.New("invalid webhook")
+	}
+
+	return &WebhookEvent{
+		Event:      webhook.Event,
+		PaymentRef: webhook.PaymentRef,
+		RefundRef:  webhook.RefundRef,
+		PaymentID:  webhook.PaymentID,
+		Raw:        body,
+	}, nil
+}
+
+// SetStatus меняет статус платежа, для succeeded платеж считается оплаченным
+func (f *Fake) SetStatus(ref, status string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.payments[ref]
+	if !ok {
+		return false
+	}
+	payment.Status = status
+	payment.Paid = status == StatusSucceeded
+	// чек регистрируется вместе с оплатой
+	if payment.ReceiptStatus != "" && payment.Paid {
+		payment.ReceiptStatus = StatusSucceeded
+	}
+	// способ оплаты сохраняется только после успешной оплаты
+	if payment.PaymentMethod != nil && payment.Paid {
+		payment.PaymentMethod.Saved = true
+		saved := *payment.PaymentMethod
+		f.methods[saved.Ref] = &saved
+	}
+	return true
+}
+
+// Webhook собирает уведомление о текущем статусе платежа
+func (f *Fake) Webhook(ref string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.payments[ref]
+	if !ok {
+		return nil
+	}
+	body, _ := json.Marshal(map[string]string{
+		"event":       "payment." + payment.Status,
+		"payment_ref": payment.Ref,
+		"payment_id":  payment.Metadata["payment_id"],
+	})
+	return body
+}
@@ -0,0 +1,145 @@
+// пакет для работы с платежными провайдерами
+// сервис платежей работает только с интерфейсом PaymentGateway и не знает про конкретного провайдера
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"mzt/config"
+	"mzt/internal/money"
+
+	"github.com/google/uuid"
+)
+
+// статусы платежа у провайдера приведенные к общему виду
+const (
+	StatusPending   = "pending"
+	StatusWaiting   = "waiting_for_capture"
+	StatusSucceeded = "succeeded"
+	StatusCanceled  = "canceled"
+)
+
+// события из уведомлений провайдера
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentCanceled  = "payment.canceled"
+	EventRefundSucceeded  = "refund.succeeded"
+)
+
+// ErrNotFound провайдер не знает такой платеж или возврат
+var ErrNotFound = errors.New("not found at payment provider")
+
+// PaymentGateway общий интерфейс платежного провайдера
+type PaymentGateway interface {
+	// имя провайдера, совпадает со значением EQUIRING_GATEWAY
+	Name() string
+	// создает платеж и возвращает ссылку на оплату
+	CreatePayment(req *CreatePaymentRequest) (*Payment, error)
+	// получает актуальное состояние платежа
+	GetPayment(ref string) (*Payment, error)
+	// возвращает деньги по платежу полностью или частично
+	Refund(req *RefundRequest) (*Refund, error)
+	// получает актуальное состояние возврата
+	GetRefund(ref string) (*Refund, error)
+	// разбирает тело уведомления от провайдера
+	ParseWebhook(body []byte) (*WebhookEvent, error)
+}
+
+// данные для создания платежа
+type CreatePaymentRequest struct {
+	PaymentID   uuid.UUID
+	Amount      money.Money
+	Description string
+	ReturnURL   string
+	Metadata    map[string]string
+	// фискальный чек, nil если чек не нужен
+	Receipt *Receipt
+	// сохранить способ оплаты чтобы потом списывать без пользователя
+	SavePaymentMethod bool
+	// списать сохраненным способом оплаты, ссылка на оплату тогда не нужна
+	PaymentMethodRef string
+}
+
+// способ оплаты на стороне провайдера
+type PaymentMethod struct {
+	Ref   string
+	Title string
+	// сохранен для повторных списаний
+	Saved bool
+}
+
+// чек по 54-ФЗ, отправляется вместе с платежом
+type Receipt struct {
+	Email string
+	Phone string
+	// система налогообложения, 0 - не передается
+	TaxSystemCode int
+	Items         []ReceiptItem
+}
+
+// позиция в чеке
+type ReceiptItem struct {
+	Description string
+	Quantity    int
+	// цена за единицу
+	Amount         money.Money
+	VatCode        int
+	PaymentSubject string
+	PaymentMode    string
+}
+
+// платеж на стороне провайдера
+type Payment struct {
+	Ref             string
+	Status          string
+	Paid            bool
+	Amount          money.Money
+	ConfirmationURL string
+	Metadata        map[string]string
+	// статус регистрации чека, пустой если чек не отправлялся
+	ReceiptStatus string
+	PaymentMethod *PaymentMethod
+	// ответ провайдера как есть, сохраняется в истории платежа
+	Raw []byte
+}
+
+// данные для возврата
+type RefundRequest struct {
+	RefundID   uuid.UUID
+	PaymentRef string
+	Amount     money.Money
+	Reason     string
+}
+
+// возврат на стороне провайдера
+type Refund struct {
+	Ref        string
+	PaymentRef string
+	Status     string
+	Amount     money.Money
+	Raw        []byte
+}
+
+// уведомление от провайдера
+// уведомлению нельзя доверять, это только повод перепроверить платеж через GetPayment
+type WebhookEvent struct {
+	Event      string
+	PaymentRef string
+	RefundRef  string
+	// наш id платежа из метаданных
+	PaymentID string
+	Raw       []byte
+}
+
+// New создает провайдера по имени из конфига
+// fake живет в памяти процесса, а чтобы гонять настоящий клиент YooKassa без денег, EQUIRING_API_URL направляется на cmd/fakeyookassa
+func New(cfg *config.Config) (PaymentGateway, error) {
+	switch cfg.Equiring.Gateway {
+	case "", "yookassa":
+		return NewYooKassa(cfg), nil
+	case "fake":
+		return NewFake(), nil
+	default:
+		return nil, fmt.Errorf("unknown payment gateway %q", cfg.Equiring.Gateway)
+	}
+}
@@ -0,0 +1,222 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mzt/config"
+	"mzt/internal/dto"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// YooKassa провайдер https://yookassa.ru/developers/api
+type YooKassa struct {
+	apiURL    string
+	shopID    string
+	secretKey string
+	client    *http.Client
+}
+
+func NewYooKassa(cfg *config.Config) *YooKassa {
+	return &YooKassa{
+		apiURL:    cfg.Equiring.APIURL,
+		shopID:    cfg.Equiring.StoreCode,
+		secretKey: cfg.Equiring.StoreSecret,
+		client:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (y *YooKassa) Name() string {
+	return "yookassa"
+}
+
+// CreatePayment создает платеж с подтверждением через редирект
+// если передан сохраненный способ оплаты - деньги списываются сразу без подтверждения
+func (y *YooKassa) CreatePayment(req *CreatePaymentRequest) (*Payment, error) {
+	reqData := &dto.PaymentRequest{
+		Amount:            req.Amount,
+		Capture:           true,
+		Description:       req.Description,
+		Metadata:          req.Metadata,
+		Receipt:           yooReceipt(req.Receipt),
+		SavePaymentMethod: req.SavePaymentMethod,
+		PaymentMethodID:   req.PaymentMethodRef,
+	}
+	if req.PaymentMethodRef == "" {
+		reqData.Confirmation = &dto.YooConfirmation{Type: "redirect", ReturnURL: req.ReturnURL}
+	}
+
+	// ключ идемпотентности привязан к нашему платежу, повтор запроса не создаст второй платеж
+	var result dto.YooPayment
+	raw, err := y.do("POST", "/payments", req.PaymentID.String(), reqData, &result)
+	if err != nil {
+		return nil, err
+	}
+	return yooPayment(&result, raw), nil
+}
+
+// GetPayment получает платеж по id YooKassa
+func (y *YooKassa) GetPayment(ref string) (*Payment, error) {
+	var result dto.YooPayment
+	raw, err := y.do("GET", "/payments/"+ref, "", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return yooPayment(&result, raw), nil
+}
+
+// Refund создает возврат по платежу
+func (y *YooKassa) Refund(req *RefundRequest) (*Refund, error) {
+	reqData := &dto.YooRefundRequest{
+		PaymentID:   req.PaymentRef,
+		Amount:      req.Amount,
+		Description: req.Reason,
+	}
+
+	var result dto.YooRefund
+	raw, err := y.do("POST", "/refunds", req.RefundID.String(), reqData, &result)
+	if err != nil {
+		return nil, err
+	}
+	return yooRefund(&result, raw), nil
+}
+
+// GetRefund получает возврат по id YooKassa
+func (y *YooKassa) GetRefund(ref string) (*Refund, error) {
+	var result dto.YooRefund
+	raw, err := y.do("GET", "/refunds/"+ref, "", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return yooRefund(&result, raw), nil
+}
+
+// ParseWebhook разбирает уведомление YooKassa
+func (y *YooKassa) ParseWebhook(body []byte) (*WebhookEvent, error) {
+	var webhook dto.YooWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, err
+	}
+	if webhook.Event == "" || webhook.Object.ID == "" {
+		return nil, errors.New("invalid webhook")
+	}
+
+	event := &WebhookEvent{
+		Event:      webhook.Event,
+		PaymentRef: webhook.Object.ID,
+		PaymentID:  webhook.Object.Metadata.PaymentID,
+		Raw:        body,
+	}
+	// в событиях возврата object - это возврат, а платеж лежит в payment_id
+	if strings.HasPrefix(webhook.Event, "refund.") {
+		event.RefundRef = webhook.Object.ID
+		event.PaymentRef = webhook.Object.PaymentID
+	}
+	return event, nil
+}
+
+// отправляет запрос в api YooKassa и разбирает ответ в result
+// возвращает тело ответа как есть
+func (y *YooKassa) do(method, path, idempotenceKey string, body interface{}, result interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, y.apiURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(y.shopID, y.secretKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotenceKey != "" {
+		req.Header.Set("Idempotence-Key", idempotenceKey)
+	}
+
+	resp, err := y.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("yookassa returned %d: %s", resp.StatusCode, string(data))
+	}
+
+	return data, json.Unmarshal(data, result)
+}
+
+// приводит платеж YooKassa к общему виду
+func yooPayment(p *dto.YooPayment, raw []byte) *Payment {
+	var method *PaymentMethod
+	if p.PaymentMethod != nil {
+		method = &PaymentMethod{Ref: p.PaymentMethod.ID, Title: p.PaymentMethod.Title, Saved: p.PaymentMethod.Saved}
+	}
+	return &Payment{
+		Ref:             p.ID,
+		Status:          p.Status,
+		Paid:            p.Paid,
+		Amount:          p.Amount,
+		ConfirmationURL: p.Confirmation.ConfirmationURL,
+		Metadata:        p.Metadata,
+		ReceiptStatus:   p.ReceiptRegistration,
+		PaymentMethod:   method,
+		Raw:             raw,
+	}
+}
+
+// собирает чек в формате YooKassa
+// описание позиции у YooKassa не длиннее 128 символов
+func yooReceipt(receipt *Receipt) *dto.YooReceipt {
+	if receipt == nil {
+		return nil
+	}
+
+	result := &dto.YooReceipt{TaxSystemCode: receipt.TaxSystemCode}
+	result.Customer.Email = receipt.Email
+	result.Customer.Phone = receipt.Phone
+	for _, item := range receipt.Items {
+		description := []rune(item.Description)
+		if len(description) > 128 {
+			description = description[:128]
+		}
+		result.Items = append(result.Items, dto.YooReceiptItem{
+			Description:    string(description),
+			Quantity:       fmt.Sprintf("%d.00", item.Quantity),
+			Amount:         item.Amount,
+			VatCode:        item.VatCode,
+			PaymentSubject: item.PaymentSubject,
+			PaymentMode:    item.PaymentMode,
+		})
+	}
+	return result
+}
+
+// приводит возврат YooKassa к общему виду
+func yooRefund(result *dto.YooRefund, raw []byte) *Refund {
+	return &Refund{
+		Ref:        result.ID,
+		PaymentRef: result.PaymentID,
+		Status:     result.Status,
+		Amount:     result.Amount,
+		Raw:        raw,
+	}
+}
@@ -0,0 +1,110 @@
+package jwtkeys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// в папке с ключами каждый ключ лежит в <kid>.pem, а kid активного ключа - в файле active
+const activeFile = "active"
+
+var (
+	// ключа с таким kid нет в папке
+	ErrKeyNotFound = errors.New("key not found")
+	// активный ключ нельзя удалить, сначала нужна ротация
+	ErrKeyActive = errors.New("key is active")
+)
+
+// ReadDir читает все ключи из папки, от старых к новым, и kid активного
+func ReadDir(dir string) ([]*Key, string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys := make([]*Key, 0)
+	for _, entry := range entries {
+		kid, ok := strings.CutSuffix(entry.Name(), ".pem")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, "", err
+		}
+		key, err := ParsePEM(kid, data)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	active, err := os.ReadFile(filepath.Join(dir, activeFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, "", err
+	}
+	return keys, strings.TrimSpace(string(active)), nil
+}
+
+// Save кладет новый ключ в папку, активным он не становится
+func Save(dir string, key *Key) error {
+	data, err := key.MarshalPEM()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, key.ID+".pem"), data)
+}
+
+// Activate делает ключ активным, новые токены будут подписаны им
+func Activate(dir, kid string) error {
+	if _, err := os.Stat(filepath.Join(dir, kid+".pem")); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrKeyNotFound
+		}
+		return err
+	}
+	return writeFile(filepath.Join(dir, activeFile), []byte(kid+"\n"))
+}
+
+// ActivatedAt когда последний раз менялся активный ключ
+func ActivatedAt(dir string) (time.Time, error) {
+	info, err := os.Stat(filepath.Join(dir, activeFile))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Remove удаляет ключ, токены подписанные им перестанут проходить проверку
+func Remove(dir, kid string) error {
+	_, active, err := ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if kid == active {
+		return ErrKeyActive
+	}
+	err = os.Remove(filepath.Join(dir, kid+".pem"))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+// пишет через временный файл, чтобы приложение не прочитало файл наполовину
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,123 @@
+// пакет с ключами для подписи access токенов
+// токены подписываются закрытым ключом, а открытые ключи публикуются в /.well-known/jwks.json,
+// поэтому другие сервисы проверяют наши токены без общего секрета
+// ключей может быть несколько: подписываем активным, проверяем любым, так ротация никого не разлогинивает
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// алгоритмы подписи
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// формат времени в начале kid, по нему ключи сортируются от старых к новым
+// с микросекундами, чтобы ключи созданные в одну секунду тоже шли по порядку
+const kidTimeFormat = "20060102T150405.000000Z"
+
+// Key ключ подписи, kid уходит в заголовок токена и говорит каким ключом его проверять
+type Key struct {
+	ID        string
+	Alg       string
+	Private   crypto.Signer
+	CreatedAt time.Time
+}
+
+// Generate создает новый ключ, kid начинается со времени создания
+func Generate(alg string) (*Key, error) {
+	var private crypto.Signer
+	switch alg {
+	case RS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	case EdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	default:
+		return nil, fmt.Errorf("unknown key algorithm %q", alg)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	return &Key{
+		ID:        now.Format(kidTimeFormat) + "-" + hex.EncodeToString(suffix),
+		Alg:       alg,
+		Private:   private,
+		CreatedAt: now,
+	}, nil
+}
+
+// Public открытая часть ключа
+func (k *Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// Method метод подписи jwt для этого ключа
+func (k *Key) Method() jwt.SigningMethod {
+	if k.Alg == EdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// MarshalPEM закрытый ключ в PKCS8 PEM, в таком виде ключ лежит в файле
+func (k *Key) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParsePEM читает ключ из файла, алгоритм определяется по типу ключа
+func ParsePEM(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("key is not a PKCS8 PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: kid}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Alg, key.Private = RS256, private
+	case ed25519.PrivateKey:
+		key.Alg, key.Private = EdDSA, private
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	created, _, _ := strings.Cut(kid, "-")
+	key.CreatedAt, err = time.Parse(kidTimeFormat, created)
+	if err != nil {
+		return nil, fmt.Errorf("key id %q does not start with creation time", kid)
+	}
+	return key, nil
+}
@@ -0,0 +1,158 @@
+package jwtkeys
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mzt/config"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// не чаще этого перечитываем папку из-за токена с незнакомым kid, иначе мусорные токены заставят читать диск на каждый запрос
+const unknownKidReload = time.Second * 10
+
+// Keyring ключи которыми подписываются и проверяются access токены
+// если ключи лежат в папке, она перечитывается раз в reload и при незнакомом kid,
+// так ротация на одном экземпляре приложения подхватывается остальными без перезапуска
+type Keyring struct {
+	dir    string
+	reload time.Duration
+
+	mu       sync.RWMutex
+	keys     map[string]*Key
+	active   *Key
+	loadedAt time.Time
+}
+
+// NewKeyring ключи в памяти, active - kid ключа для подписи
+func NewKeyring(keys []*Key, active string) (*Keyring, error) {
+	r := &Keyring{}
+	if err := r.set(keys, active); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Load читает ключи из папки JWT_KEYS_DIR
+// без активного ключа приложение не запустится: сначала go run ./cmd/jwtkeys rotate
+func Load(cfg *config.Config) (*Keyring, error) {
+	keys, active, err := ReadDir(cfg.Jwt.KeysDir)
+	if err != nil {
+		return nil, fmt.Errorf("read jwt keys: %w", err)
+	}
+	r := &Keyring{dir: cfg.Jwt.KeysDir, reload: cfg.Jwt.KeysReload}
+	if r.reload <= 0 {
+		r.reload = time.Minute
+	}
+	if err := r.set(keys, active); err != nil {
+		return nil, fmt.Errorf("jwt keys in %s: %w", cfg.Jwt.KeysDir, err)
+	}
+	return r, nil
+}
+
+// Sign подписывает токен активным ключом и пишет его kid в заголовок
+func (r *Keyring) Sign(claims jwt.Claims) (string, error) {
+	r.refresh(false)
+
+	r.mu.RLock()
+	key := r.active
+	r.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc для jwt.Parse: находит ключ по kid и проверяет что токен подписан его алгоритмом
+func (r *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid")
+	}
+
+	key := r.key(kid)
+	if key == nil {
+		// ключ могли только что создать на другом экземпляре
+		r.refresh(true)
+		key = r.key(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public(), nil
+}
+
+// JWKS открытые части всех ключей, и активного, и еще не активированных, и старых
+func (r *Keyring) JWKS() JWKS {
+	r.refresh(false)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set := JWKS{Keys: make([]JWK, 0, len(r.keys))}
+	for _, key := range r.keys {
+		set.Keys = append(set.Keys, key.JWK())
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+func (r *Keyring) key(kid string) *Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keys[kid]
+}
+
+func (r *Keyring) set(keys []*Key, active string) error {
+	byID := make(map[string]*Key, len(keys))
+	for _, key := range keys {
+		byID[key.ID] = key
+	}
+	if active == "" {
+		return errors.New("no active key")
+	}
+	if byID[active] == nil {
+		return fmt.Errorf("active key %q not found", active)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = byID
+	r.active = byID[active]
+	r.loadedAt = time.Now()
+	return nil
+}
+
+// перечитывает папку если прошло время, unknown - из-за незнакомого kid
+// при ошибке продолжаем работать со старыми ключами
+func (r *Keyring) refresh(unknown bool) {
+	if r.dir == "" {
+		return
+	}
+	interval := r.reload
+	if unknown {
+		interval = unknownKidReload
+	}
+	// отмечаем попытку сразу, чтобы папку читал один запрос, а не все одновременно
+	r.mu.Lock()
+	if time.Since(r.loadedAt) < interval {
+		r.mu.Unlock()
+		return
+	}
+	r.loadedAt = time.Now()
+	r.mu.Unlock()
+
+	keys, active, err := ReadDir(r.dir)
+	if err == nil {
+		err = r.set(keys, active)
+	}
+	if err != nil {
+		log.Printf("Failed to reload jwt keys: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"mzt/config"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "test@example.com", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestKeyring_SignAndVerify(t *testing.T) {
+	for _, alg := range []string{RS256, EdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := Generate(alg)
+			require.NoError(t, err)
+			keys, err := NewKeyring([]*Key{key}, key.ID)
+			require.NoError(t, err)
+
+			signed, err := keys.Sign(claims())
+			require.NoError(t, err)
+			token, err := jwt.Parse(signed, keys.Keyfunc)
+			require.NoError(t, err)
+			assert.True(t, token.Valid)
+			assert.Equal(t, key.ID, token.Header["kid"])
+			assert.Equal(t, alg, token.Header["alg"])
+
+			// ключ переживает запись в файл
+			data, err := key.MarshalPEM()
+			require.NoError(t, err)
+			parsed, err := ParsePEM(key.ID, data)
+			require.NoError(t, err)
+			assert.Equal(t, alg, parsed.Alg)
+			assert.Equal(t, key.CreatedAt, parsed.CreatedAt)
+		})
+	}
+}
+
+func TestKeyring_RejectsForeignTokens(t *testing.T) {
+	key, err := Generate(EdDSA)
+	require.NoError(t, err)
+	keys, err := NewKeyring([]*Key{key}, key.ID)
+	require.NoError(t, err)
+
+	// токен на общем секрете с нашим kid не принимается
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	hmac.Header["kid"] = key.ID
+	signed, err := hmac.SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = jwt.Parse(signed, keys.Keyfunc)
+	require.Error(t, err)
+
+	// без kid и с чужим ключом тоже
+	other, err := Generate(EdDSA)
+	require.NoError(t, err)
+	signed, err = jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims()).SignedString(other.Private)
+	require.NoError(t, err)
+	_, err = jwt.Parse(signed, keys.Keyfunc)
+	require.Error(t, err)
+	foreign, err := NewKeyring([]*Key{other}, other.ID)
+	require.NoError(t, err)
+	signed, err = foreign.Sign(claims())
+	require.NoError(t, err)
+	_, err = jwt.Parse(signed, keys.Keyfunc)
+	require.Error(t, err)
+
+	_, err = NewKeyring([]*Key{key}, "")
+	require.Error(t, err)
+	_, err = NewKeyring([]*Key{key}, other.ID)
+	require.Error(t, err)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	old, err := Generate(RS256)
+	require.NoError(t, err)
+	require.NoError(t, Save(dir, old))
+	require.NoError(t, Activate(dir, old.ID))
+
+	keys, err := Load(&config.Config{Jwt: config.Jwt{KeysDir: dir, KeysReload: time.Hour}})
+	require.NoError(t, err)
+	oldToken, err := keys.Sign(claims())
+	require.NoError(t, err)
+
+	// ротация на другом экземпляре: новый ключ в папке и он активен
+	next, err := Generate(EdDSA)
+	require.NoError(t, err)
+	require.NoError(t, Save(dir, next))
+	require.NoError(t, Activate(dir, next.ID))
+	other, err := Load(&config.Config{Jwt: config.Jwt{KeysDir: dir}})
+	require.NoError(t, err)
+	newToken, err := other.Sign(claims())
+	require.NoError(t, err)
+
+	// незнакомый kid заставляет перечитать папку, старые токены продолжают проходить
+	keys.loadedAt = time.Now().Add(-unknownKidReload)
+	_, err = jwt.Parse(newToken, keys.Keyfunc)
+	require.NoError(t, err)
+	_, err = jwt.Parse(oldToken, keys.Keyfunc)
+	require.NoError(t, err)
+	signed, err := keys.Sign(claims())
+	require.NoError(t, err)
+	token, _, err := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, next.ID, token.Header["kid"])
+
+	set := keys.JWKS()
+	require.Len(t, set.Keys, 2)
+	assert.Equal(t, old.ID, set.Keys[0].Kid)
+	assert.Equal(t, "RSA", set.Keys[0].Kty)
+	assert.Equal(t, "OKP", set.Keys[1].Kty)
+
+	// активный ключ не удалить, старый можно - и его токены перестают проходить
+	require.ErrorIs(t, Remove(dir, next.ID), ErrKeyActive)
+	require.NoError(t, Remove(dir, old.ID))
+	require.ErrorIs(t, Remove(dir, old.ID), ErrKeyNotFound)
+	keys.loadedAt = time.Time{}
+	assert.Len(t, keys.JWKS().Keys, 1)
+	_, err = jwt.Parse(oldToken, keys.Keyfunc)
+	require.Error(t, err)
+}
+
+func TestKey_JWK(t *testing.T) {
+	key, err := Generate(RS256)
+	require.NoError(t, err)
+	jwk := key.JWK()
+	assert.Equal(t, "sig", jwk.Use)
+	assert.Equal(t, RS256, jwk.Alg)
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	require.NoError(t, err)
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	require.NoError(t, err)
+	public := key.Public().(*rsa.PublicKey)
+	assert.Equal(t, 0, public.N.Cmp(new(big.Int).SetBytes(n)))
+	assert.Equal(t, int64(public.E), new(big.Int).SetBytes(e).Int64())
+
+	key, err = Generate(EdDSA)
+	require.NoError(t, err)
+	jwk = key.JWK()
+	assert.Equal(t, "Ed25519", jwk.Crv)
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(key.Public().(ed25519.PublicKey)), x)
+}
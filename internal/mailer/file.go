@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File складывает письма в папку вместо отправки, нужен для локальной разработки
+// если папка не задана - письмо просто пишется в лог, ссылку из него можно открыть руками
+type File struct {
+	dir string
+}
+
+func NewFile(dir string) *File {
+	return &File{dir: dir}
+}
+
+func (f *File) Name() string {
+	return "file"
+}
+
+func (f *File) Send(msg *Message) error {
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.Text)
+	if f.dir == "" {
+		log.Printf("mail to %s:\n%s", msg.To, content)
+		return nil
+	}
+
+	if err := os.MkdirAll(f.dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000"), strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To))
+	return os.WriteFile(filepath.Join(f.dir, name), []byte(content), 0o644)
+}
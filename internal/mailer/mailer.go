@@ -0,0 +1,36 @@
+// пакет для отправки писем пользователям
+// сервисы работают только с интерфейсом Sender и не знают как письмо уходит на самом деле
+package mailer
+
+import (
+	"fmt"
+	"mzt/config"
+)
+
+// Message письмо одному получателю, тело - простой текст
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Sender общий интерфейс отправки писем
+type Sender interface {
+	// имя отправителя, совпадает со значением MAIL_SENDER
+	Name() string
+	// отправляет письмо, ошибка значит что письмо точно не ушло
+	Send(msg *Message) error
+}
+
+// New выбирает способ отправки писем по имени из конфига
+// smtp - настоящий почтовый сервер, file - письма складываются в папку для локальной разработки
+func New(cfg *config.Config) (Sender, error) {
+	switch cfg.Mail.Sender {
+	case "", "smtp":
+		return NewSMTP(cfg), nil
+	case "file":
+		return NewFile(cfg.Mail.Dir), nil
+	default:
+		return nil, fmt.Errorf("unknown mail sender %q", cfg.Mail.Sender)
+	}
+}
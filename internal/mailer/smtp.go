@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mzt/config"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SMTP отправляет письма через почтовый сервер
+// на 465 порту соединение сразу шифрованное, на остальных сервер сам предлагает STARTTLS
+type SMTP struct {
+	host     string
+	port     string
+	user     string
+	password string
+	from     string
+}
+
+func NewSMTP(cfg *config.Config) *SMTP {
+	return &SMTP{
+		host:     cfg.Mail.SMTPHost,
+		port:     cfg.Mail.SMTPPort,
+		user:     cfg.Mail.SMTPUser,
+		password: cfg.Mail.SMTPPassword,
+		from:     cfg.Mail.From,
+	}
+}
+
+func (s *SMTP) Name() string {
+	return "smtp"
+}
+
+// Send отправляет письмо в utf-8, тема кодируется по RFC 2047 чтобы кириллица не ломалась
+func (s *SMTP) Send(msg *Message) error {
+	if s.host == "" {
+		return fmt.Errorf("smtp host is not configured")
+	}
+
+	var auth smtp.Auth
+	if s.user != "" {
+		auth = smtp.PlainAuth("", s.user, s.password, s.host)
+	}
+	addr := net.JoinHostPort(s.host, s.port)
+	if s.port == "465" {
+		return sendImplicitTLS(addr, s.host, auth, s.from, msg.To, buildMessage(s.from, msg))
+	}
+	return smtp.SendMail(addr, auth, s.from, []string{msg.To}, buildMessage(s.from, msg))
+}
+
+// отправка через порт 465, где TLS поднимается до начала SMTP диалога
+// smtp.SendMail так не умеет, поэтому повторяет его шаги на своем соединении
+func sendImplicitTLS(addr, host string, auth smtp.Auth, from, to string, body []byte) error {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// собирает письмо с заголовками, тело в base64 чтобы не думать о длине строк
+func buildMessage(from string, msg *Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.BEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@mzt>\r\n", uuid.NewString())
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(msg.Text))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes()
+}
@@ -1,13 +1,24 @@
 package middleware
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
 	"mzt/config"
 	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/jwtkeys"
+	"mzt/internal/ratelimit"
 	"mzt/internal/repository"
-	"mzt/internal/service"
 	"mzt/internal/validator"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -16,15 +27,19 @@ import (
 type Middleware struct {
 	config     *config.Config
 	repo       *repository.UserRepo
-	courseRepo *repository.CourseRepo
+	courseRepo repository.CourseRepository
+	limits     ratelimit.Store
+	keys       *jwtkeys.Keyring
 	validator  *validator.Validator
 }
 
-func NewMiddleware(config *config.Config, repo *repository.UserRepo, courseRepo *repository.CourseRepo) *Middleware {
+func NewMiddleware(config *config.Config, repo *repository.UserRepo, courseRepo repository.CourseRepository, limits ratelimit.Store, keys *jwtkeys.Keyring) *Middleware {
 	return &Middleware{
 		config:     config,
 		repo:       repo,
 		courseRepo: courseRepo,
+		limits:     limits,
+		keys:       keys,
 		validator:  validator.NewValidator(),
 	}
 }
@@ -39,7 +54,7 @@ func (m *Middleware) AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := fields[1]
-		token, err := m.validator.ValidateToken(tokenString, m.config.Jwt.AccessKey)
+		token, err := m.validator.ValidateAccessToken(tokenString, m.keys)
 
 		if err != nil || token == nil || !token.Valid {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -58,6 +73,18 @@ func (m *Middleware) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// токен действует пока жива сессия, которой он выдан
+		sessionId, err := m.validator.SessionID(token)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+		session, err := m.repo.GetSession(sessionId)
+		if err != nil || session.UserID != user.ID || session.ExpiresAt.Before(time.Now()) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+			return
+		}
+
 		userWithData, err := m.repo.GetUserWithDataById(user.ID)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Can't get user data"})
@@ -76,32 +103,50 @@ func (m *Middleware) AuthMiddleware() gin.HandlerFunc {
 			IsBusinessOwner: userWithData.UserData.IsBusinessOwner,
 			PositionAtWork:  userWithData.UserData.PositionAtWork,
 			MonthIncome:     userWithData.UserData.MonthIncome,
+			EmailVerified:   user.EmailVerifiedAt != nil,
 		}
 
 		c.Set("user", userInfo)
 		c.Set("self", user.ID)
+		c.Set("session", session.SessionID)
 
 		c.Next()
 		return
 	}
 }
 
-func (m *Middleware) AdminVerificationMiddleware() gin.HandlerFunc {
+// RequirePermission пропускает только пользователей, у которых одна из ролей дает право
+// идет после AuthMiddleware, права смотрим в базе на каждый запрос чтобы снятая роль действовала сразу
+func (m *Middleware) RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userContext, ok := c.Get("user")
-		userDto := userContext.(*dto.UserInfoDto)
+		self, ok := c.Get("self")
 		if !ok {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User is not specified"})
 			return
 		}
 
-		user, err := m.repo.GetUserByEmail(userDto.Email)
+		roles, err := m.repo.GetUserRoles(self.(uuid.UUID))
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Can't get user roles"})
+			return
 		}
 
-		if user.Role != int(service.Admin) || user.Role < 0 {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "No admin privilegies"})
+		if !entity.HasPermission(roles, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing permission " + permission})
+			return
+		}
+
+		// с включенной настройкой админские права работают только с подключенными кодами
+		if m.config.Auth.RequireAdminTwoFactor {
+			twoFactor, err := m.repo.GetTwoFactor(self.(uuid.UUID))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Can't get two-factor status"})
+				return
+			}
+			if twoFactor == nil || twoFactor.EnabledAt == nil {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required"})
+				return
+			}
 		}
 
 		c.Next()
@@ -137,9 +182,28 @@ func (m *Middleware) AdminVerificationMiddleware() gin.HandlerFunc {
 // 	}
 // }
 
+// EmailVerifiedMiddleware пропускает только пользователей с подтвержденной почтой
+// ставится на покупки, идет после AuthMiddleware
+func (m *Middleware) EmailVerifiedMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userContext, ok := c.Get("user")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User is not specified"})
+			return
+		}
+
+		if !userContext.(*dto.UserInfoDto).EmailVerified {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Email is not verified"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
 func (m *Middleware) CourseEnrollmentMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		courseIDStr := c.Param("courseId")
+		courseIDStr := c.Param("course_id")
 		if courseIDStr == "" {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Course ID is required"})
 			return
@@ -158,13 +222,123 @@ func (m *Middleware) CourseEnrollmentMiddleware() gin.HandlerFunc {
 		}
 
 		assignment, err := m.courseRepo.GetCourseAssignment(courseID, userID.(uuid.UUID))
-		if err != nil {
+		if err != nil || assignment == nil {
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "User is not enrolled in this course"})
 			return
 		}
+		// доступ приостановлен до оплаты просроченной части рассрочки
+		if assignment.Suspended {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Course access is suspended"})
+			return
+		}
 
 		c.Set("courseAssignment", assignment)
 
 		c.Next()
 	}
 }
+
+// TrustedNetworksMiddleware пропускает запросы только из указанных подсетей
+// используется для вебхуков платежного провайдера
+func (m *Middleware) TrustedNetworksMiddleware(cidrs []string) gin.HandlerFunc {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		// одиночный адрес без маски считаем подсетью из одного адреса
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic("invalid trusted network " + cidr + ": " + err.Error())
+		}
+		networks = append(networks, network)
+	}
+
+	return func(c *gin.Context) {
+		ip := net.ParseIP(c.ClientIP())
+		if ip == nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+
+		for _, network := range networks {
+			if network.Contains(ip) {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+	}
+}
+
+// RateLimit ограничивает частоту запросов к ручке: perIP с одного адреса и perAccount на один аккаунт из тела запроса -
+// на почту, а если почты нет, на токен из ссылки в письме
+// 0 - без ограничения, счетчики разных ручек не пересекаются за счет scope
+// если хранилище счетчиков недоступно, запрос пропускаем: вход важнее
+func (m *Middleware) RateLimit(scope string, perIP, perAccount int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if perIP > 0 && !m.allow(c, scope+":ip:"+c.ClientIP(), perIP) {
+			return
+		}
+
+		if perAccount > 0 {
+			if account := requestAccount(c); account != "" && !m.allow(c, scope+":account:"+account, perAccount) {
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// засчитывает запрос, при превышении лимита отвечает 429 и возвращает false
+// ответ одинаковый для любого ключа, чтобы по нему нельзя было понять существует ли аккаунт
+func (m *Middleware) allow(c *gin.Context, key string, limit int) bool {
+	count, resetAt, err := m.limits.Hit(key, m.config.RateLimit.Window)
+	if err != nil {
+		log.Printf("rate limit store %s: %v", m.limits.Name(), err)
+		return true
+	}
+	if count <= limit {
+		return true
+	}
+
+	retryAfter := int(time.Until(resetAt).Seconds()) + 1
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, try again later"})
+	return false
+}
+
+// достает почту или токен из json тела запроса и возвращает тело на место для обработчика
+// токен в счетчиках хранится только хешем
+func requestAccount(c *gin.Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var payload struct {
+		Email string `json:"email"`
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+	if email := strings.ToLower(strings.TrimSpace(payload.Email)); email != "" {
+		return email
+	}
+	if payload.Token != "" {
+		hash := sha256.Sum256([]byte(payload.Token))
+		return "token:" + hex.EncodeToString(hash[:])
+	}
+	return ""
+}
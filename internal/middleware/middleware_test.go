@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"mzt/internal/mocks"
+	"mzt/internal/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCourseEnrollmentMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	courseRepo := mocks.NewMockCourseRepository().(*mocks.MockCourseRepository)
+	m := NewMiddleware(&config.Config{}, nil, courseRepo, nil, nil)
+
+	userID := uuid.New()
+	courseID := uuid.New()
+	assignment := &entity.CourseAssignment{CaID: uuid.New(), UserID: userID, CourseID: courseID}
+	courseRepo.Assignments[courseID] = map[uuid.UUID]*entity.CourseAssignment{userID: assignment}
+
+	// маршруты с теми же параметрами что и в роутере, вместо AuthMiddleware просто кладем пользователя
+	handler := gin.New()
+	handler.Use(func(c *gin.Context) { c.Set("self", userID) })
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	handler.GET("/api/v1/courses/:course_id/progress/", m.CourseEnrollmentMiddleware(), ok)
+	handler.GET("/api/v1/courses/:course_id/events/:event_id/secrets", m.CourseEnrollmentMiddleware(), ok)
+
+	request := func(path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	progress := "/api/v1/courses/" + courseID.String() + "/progress/"
+	secrets := "/api/v1/courses/" + courseID.String() + "/events/" + uuid.NewString() + "/secrets"
+
+	assert.Equal(t, http.StatusOK, request(progress))
+	assert.Equal(t, http.StatusOK, request(secrets))
+
+	// доступ приостановлен из-за просроченной рассрочки
+	assignment.Suspended = true
+	assert.Equal(t, http.StatusForbidden, request(progress))
+	assert.Equal(t, http.StatusForbidden, request(secrets))
+
+	// на чужой курс не пускаем
+	assert.Equal(t, http.StatusForbidden, request("/api/v1/courses/"+uuid.NewString()+"/progress/"))
+	assert.Equal(t, http.StatusBadRequest, request("/api/v1/courses/not-a-uuid/progress/"))
+}
+
+func TestRateLimit_PerAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{RateLimit: config.RateLimit{Window: time.Minute}}
+	m := NewMiddleware(cfg, nil, nil, ratelimit.NewMemory(), nil)
+
+	handler := gin.New()
+	handler.POST("/password/forgot", m.RateLimit("password_forgot", 0, 2), func(c *gin.Context) { c.Status(http.StatusOK) })
+	handler.POST("/password/reset", m.RateLimit("password_reset", 0, 2), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(path, body string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return w.Code
+	}
+
+	// почта считается без учета регистра
+	assert.Equal(t, http.StatusOK, request("/password/forgot", `{"email":"user@example.com"}`))
+	assert.Equal(t, http.StatusOK, request("/password/forgot", `{"email":"User@Example.com"}`))
+	assert.Equal(t, http.StatusTooManyRequests, request("/password/forgot", `{"email":"user@example.com"}`))
+	assert.Equal(t, http.StatusOK, request("/password/forgot", `{"email":"other@example.com"}`))
+
+	// у сброса пароля почты нет, считаем попытки по ссылке
+	assert.Equal(t, http.StatusOK, request("/password/reset", `{"token":"link","password":"1"}`))
+	assert.Equal(t, http.StatusOK, request("/password/reset", `{"token":"link","password":"2"}`))
+	assert.Equal(t, http.StatusTooManyRequests, request("/password/reset", `{"token":"link","password":"3"}`))
+	assert.Equal(t, http.StatusOK, request("/password/reset", `{"token":"other","password":"1"}`))
+}
@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"mzt/config"
 	"mzt/internal/entity"
+	"mzt/internal/money"
 	"mzt/internal/repository"
 	"time"
 
@@ -27,21 +28,89 @@ func RunMigrations(cfg *config.Config) error {
 		return fmt.Errorf("failed to enable UUID extension: %v", err)
 	}
 
+	// колонки подтверждения почты еще нет - значит это первый запуск после ее появления
+	verifyExistingEmails := userRepo.DB.Migrator().HasTable(&entity.User{}) &&
+		!userRepo.DB.Migrator().HasColumn(&entity.User{}, "EmailVerifiedAt")
+	// отметки о выдаче оплаченного еще нет - все проведенные раньше платежи уже выданы
+	markExistingFulfilled := userRepo.DB.Migrator().HasTable(&entity.Payment{}) &&
+		!userRepo.DB.Migrator().HasColumn(&entity.Payment{}, "FulfilledAt")
+	// старая колонка role с числом вместо ролей
+	legacyRoles := userRepo.DB.Migrator().HasColumn(&entity.User{}, "role")
+
 	err := userRepo.DB.AutoMigrate(
 		&entity.User{},
 		&entity.UserData{},
-		&entity.Auth{},
+		&entity.Session{},
+		&entity.SecurityEvent{},
+		&entity.Permission{},
+		&entity.Role{},
+		&entity.PasswordResetToken{},
+		&entity.TwoFactor{},
+		&entity.RecoveryCode{},
+		&entity.Identity{},
+		&entity.OAuthState{},
 		&entity.Payment{},
+		&entity.PaymentEvent{},
+		&entity.Refund{},
+		&entity.PromoCode{},
+		&entity.PromoCodeCourse{},
+		&entity.PromoCodeUsage{},
 		&entity.Course{},
 		&entity.CourseAssignment{},
 		&entity.Lesson{},
 		&entity.Event{},
 		&entity.CoursePrice{},
+		&entity.Bundle{},
+		&entity.BundleCourse{},
+		&entity.PaymentCourse{},
+		&entity.GiftCode{},
+		&entity.Invoice{},
+		&entity.InvoiceEmployee{},
+		&entity.Plan{},
+		&entity.PlanCourse{},
+		&entity.PaymentMethod{},
+		&entity.Subscription{},
+		&entity.InstallmentPlan{},
+		&entity.Installment{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to migrate database schema: %v", err)
 	}
 
+	if err := migratePaymentStatuses(userRepo.DB); err != nil {
+		return fmt.Errorf("failed to migrate payment statuses: %v", err)
+	}
+
+	if err := migrateMoneyColumns(userRepo.DB); err != nil {
+		return fmt.Errorf("failed to migrate money columns: %v", err)
+	}
+
+	if verifyExistingEmails {
+		if err := markEmailsVerified(userRepo.DB); err != nil {
+			return fmt.Errorf("failed to mark existing emails verified: %v", err)
+		}
+	}
+
+	if markExistingFulfilled {
+		if err := markPaymentsFulfilled(userRepo.DB); err != nil {
+			return fmt.Errorf("failed to mark existing payments fulfilled: %v", err)
+		}
+	}
+
+	if err := dropLegacyAuth(userRepo.DB); err != nil {
+		return fmt.Errorf("failed to drop legacy auth table: %v", err)
+	}
+
+	if err := userRepo.SyncRoles(entity.Permissions, entity.BuiltinRoles); err != nil {
+		return fmt.Errorf("failed to sync roles: %v", err)
+	}
+
+	if legacyRoles {
+		if err := migrateLegacyRoles(userRepo.DB); err != nil {
+			return fmt.Errorf("failed to migrate legacy roles: %v", err)
+		}
+	}
+
 	if err := seedUsers(userRepo); err != nil {
 		log.Printf("Warning: Failed to seed users: %v", err)
 	}
@@ -86,6 +155,104 @@ func RunMigrations(cfg *config.Config) error {
 	return nil
 }
 
+// переводит старые строковые статусы платежей в статусы из entity.PaymentStatus
+func migratePaymentStatuses(db *gorm.DB) error {
+	legacy := map[string]entity.PaymentStatus{
+		"completed": entity.PaymentSucceeded,
+		"failed":    entity.PaymentCanceled,
+	}
+	for from, to := range legacy {
+		if err := db.Model(&entity.Payment{}).Where("status = ?", from).Update("status", to).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// считает почту подтвержденной у всех кто зарегистрировался до появления подтверждения
+// иначе они не смогут покупать курсы
+func markEmailsVerified(db *gorm.DB) error {
+	return db.Model(&entity.User{}).Where("email_verified_at IS NULL").Update("email_verified_at", time.Now()).Error
+}
+
+// отмечает выданными платежи проведенные до появления отметки, иначе сверка выдаст их второй раз
+func markPaymentsFulfilled(db *gorm.DB) error {
+	statuses := []entity.PaymentStatus{entity.PaymentSucceeded, entity.PaymentPartiallyRefunded, entity.PaymentRefunded}
+	return db.Model(&entity.Payment{}).Where("status IN ? AND fulfilled_at IS NULL", statuses).Update("fulfilled_at", time.Now()).Error
+}
+
+// удаляет старую таблицу auths с одним refresh токеном на пользователя
+// ее заменили сессии, после обновления всем нужно войти заново
+func dropLegacyAuth(db *gorm.DB) error {
+	if !db.Migrator().HasTable("auths") {
+		return nil
+	}
+	return db.Migrator().DropTable("auths")
+}
+
+// переносит старую числовую роль в новые роли и удаляет колонку
+// 1 был админом и становится суперадмином, остальные - студенты
+func migrateLegacyRoles(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("INSERT INTO user_roles (user_id, role_name) "+
+			"SELECT id, CASE WHEN role = 1 THEN ? ELSE ? END FROM users ON CONFLICT DO NOTHING",
+			entity.RoleSuperadmin, entity.RoleStudent).Error
+		if err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&entity.User{}, "role")
+	})
+}
+
+// переносит суммы из старых float колонок в копейки
+// до появления money все суммы были в рублях с двумя знаками после точки
+// после переноса старые колонки удаляются, повторный запуск ничего не делает
+func migrateMoneyColumns(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		migrator := tx.Migrator()
+
+		for _, table := range []string{"payments", "refunds", "course_prices"} {
+			if !migrator.HasColumn(table, "amount") {
+				continue
+			}
+			err := tx.Exec("UPDATE " + table + " SET amount_minor = ROUND(amount * 100), amount_currency = COALESCE(NULLIF(currency_code, ''), 'RUB')").Error
+			if err != nil {
+				return err
+			}
+			if err := migrator.DropColumn(table, "amount"); err != nil {
+				return err
+			}
+			if err := migrator.DropColumn(table, "currency_code"); err != nil {
+				return err
+			}
+		}
+
+		if migrator.HasColumn("payments", "discount") {
+			err := tx.Exec("UPDATE payments SET discount_minor = ROUND(discount * 100), discount_currency = amount_currency").Error
+			if err != nil {
+				return err
+			}
+			if err := migrator.DropColumn("payments", "discount"); err != nil {
+				return err
+			}
+		}
+
+		// у промокодов value был процентом или суммой в рублях в зависимости от вида
+		if migrator.HasColumn("promo_codes", "value") {
+			if err := tx.Exec("UPDATE promo_codes SET percent = value WHERE kind = ?", entity.PromoCodePercent).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("UPDATE promo_codes SET amount_minor = ROUND(value * 100), amount_currency = 'RUB' WHERE kind = ?", entity.PromoCodeFixed).Error; err != nil {
+				return err
+			}
+			if err := migrator.DropColumn("promo_codes", "value"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func seedUsers(userRepo *repository.UserRepo) error {
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
 	if err != nil {
@@ -104,13 +271,12 @@ func seedUsers(userRepo *repository.UserRepo) error {
 	testUsers := []struct {
 		user     *entity.User
 		userData *entity.UserData
-		auth     *entity.Auth
 	}{
 		{
 			user: &entity.User{
 				ID:         uuid.New(),
 				PasswdHash: string(passwordHash),
-				Role:       1,
+				Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 			},
 			userData: &entity.UserData{
 				Email:           "admin@example.com",
@@ -124,15 +290,12 @@ func seedUsers(userRepo *repository.UserRepo) error {
 				PositionAtWork:  "Senior Developer",
 				MonthIncome:     150000,
 			},
-			auth: &entity.Auth{
-				Key: "admin_key",
-			},
 		},
 		{
 			user: &entity.User{
 				ID:         uuid.New(),
 				PasswdHash: string(passwordHash),
-				Role:       0,
+				Roles:      []entity.Role{{Name: entity.RoleStudent}},
 			},
 			userData: &entity.UserData{
 				Email:           "ivan@example.com",
@@ -146,15 +309,12 @@ func seedUsers(userRepo *repository.UserRepo) error {
 				PositionAtWork:  "CEO",
 				MonthIncome:     250000,
 			},
-			auth: &entity.Auth{
-				Key: "ivan_key",
-			},
 		},
 		{
 			user: &entity.User{
 				ID:         uuid.New(),
 				PasswdHash: string(passwordHash),
-				Role:       0,
+				Roles:      []entity.Role{{Name: entity.RoleStudent}},
 			},
 			userData: &entity.UserData{
 				Email:           "alex@example.com",
@@ -168,15 +328,12 @@ func seedUsers(userRepo *repository.UserRepo) error {
 				PositionAtWork:  "Founder",
 				MonthIncome:     300000,
 			},
-			auth: &entity.Auth{
-				Key: "alex_key",
-			},
 		},
 		{
 			user: &entity.User{
 				ID:         uuid.New(),
 				PasswdHash: string(passwordHash),
-				Role:       0,
+				Roles:      []entity.Role{{Name: entity.RoleStudent}},
 			},
 			userData: &entity.UserData{
 				Email:           "dmitry@example.com",
@@ -190,15 +347,12 @@ func seedUsers(userRepo *repository.UserRepo) error {
 				PositionAtWork:  "Managing Director",
 				MonthIncome:     280000,
 			},
-			auth: &entity.Auth{
-				Key: "dmitry_key",
-			},
 		},
 		{
 			user: &entity.User{
 				ID:         uuid.New(),
 				PasswdHash: string(passwordHash),
-				Role:       0,
+				Roles:      []entity.Role{{Name: entity.RoleStudent}},
 			},
 			userData: &entity.UserData{
 				Email:           "sergey@example.com",
@@ -212,9 +366,6 @@ func seedUsers(userRepo *repository.UserRepo) error {
 				PositionAtWork:  "Executive Director",
 				MonthIncome:     320000,
 			},
-			auth: &entity.Auth{
-				Key: "sergey_key",
-			},
 		},
 	}
 
@@ -227,7 +378,9 @@ func seedUsers(userRepo *repository.UserRepo) error {
 			if err != nil {
 				if errors.Is(err, gorm.ErrRecordNotFound) {
 					tu.userData.UserID = tu.user.ID
-					tu.auth.UserID = tu.user.ID
+					// у тестовых аккаунтов почта сразу подтверждена
+					verifiedAt := time.Now()
+					tu.user.EmailVerifiedAt = &verifiedAt
 
 					if err := tx.Create(tu.user).Error; err != nil {
 						return fmt.Errorf("failed to create user: %v", err)
@@ -235,9 +388,6 @@ func seedUsers(userRepo *repository.UserRepo) error {
 					if err := tx.Create(tu.userData).Error; err != nil {
 						return fmt.Errorf("failed to create user data: %v", err)
 					}
-					if err := tx.Create(tu.auth).Error; err != nil {
-						return fmt.Errorf("failed to create auth: %v", err)
-					}
 					log.Printf("Created user: %s (age: %d)", tu.userData.Email, tu.userData.Age)
 				} else {
 					return fmt.Errorf("failed to check existing user: %v", err)
@@ -826,49 +976,50 @@ func seedCoursePrices(courseRepo *repository.CourseRepo) error {
 		courseMap[course.Title] = course.CourseID
 	}
 
+	// цены в копейках
 	prices := []struct {
 		CourseTitle string
-		Amount      float64
+		Amount      int64
 	}{
 		{
 			CourseTitle: "Мужское лидерство и харизма",
-			Amount:      29900,
+			Amount:      2990000,
 		},
 		{
 			CourseTitle: "Бизнес-сообщество и нетворкинг",
-			Amount:      24900,
+			Amount:      2490000,
 		},
 		{
 			CourseTitle: "Мужская психология и эмоциональный интеллект",
-			Amount:      27900,
+			Amount:      2790000,
 		},
 		{
 			CourseTitle: "Стратегическое мышление и принятие решений",
-			Amount:      29900,
+			Amount:      2990000,
 		},
 		{
 			CourseTitle: "Мужская эффективность и тайм-менеджмент",
-			Amount:      24900,
+			Amount:      2490000,
 		},
 		{
 			CourseTitle: "Бизнес-переговоры и убеждение",
-			Amount:      27900,
+			Amount:      2790000,
 		},
 		{
 			CourseTitle: "Мужское сообщество и менторство",
-			Amount:      32900,
+			Amount:      3290000,
 		},
 		{
 			CourseTitle: "Личный бренд и репутация",
-			Amount:      29900,
+			Amount:      2990000,
 		},
 		{
 			CourseTitle: "Мужское здоровье и энергия",
-			Amount:      24900,
+			Amount:      2490000,
 		},
 		{
 			CourseTitle: "Бизнес-этика и ценности",
-			Amount:      27900,
+			Amount:      2790000,
 		},
 	}
 
@@ -880,9 +1031,8 @@ func seedCoursePrices(courseRepo *repository.CourseRepo) error {
 		}
 
 		newPrice := &entity.CoursePrice{
-			CourseID:     courseID,
-			Amount:       price.Amount,
-			CurrencyCode: "RUB",
+			CourseID: courseID,
+			Amount:   money.New(price.Amount, money.DefaultCurrency),
 		}
 
 		err := courseRepo.DB.Transaction(func(tx *gorm.DB) error {
@@ -914,7 +1064,7 @@ func seedCoursePrices(courseRepo *repository.CourseRepo) error {
 
 func seedUserTransactions(userRepo *repository.UserRepo, courseRepo *repository.CourseRepo) error {
 	var users []entity.User
-	if err := userRepo.DB.Find(&users).Error; err != nil {
+	if err := userRepo.DB.Preload("Roles").Find(&users).Error; err != nil {
 		return fmt.Errorf("failed to get users for transactions: %v", err)
 	}
 
@@ -926,7 +1076,7 @@ func seedUserTransactions(userRepo *repository.UserRepo, courseRepo *repository.
 	// Создаем тестовые транзакции для каждого пользователя
 	for _, user := range users {
 		// Пропускаем админа
-		if user.Role == 1 {
+		if user.HasRole(entity.RoleSuperadmin) {
 			continue
 		}
 
@@ -942,28 +1092,27 @@ func seedUserTransactions(userRepo *repository.UserRepo, courseRepo *repository.
 				continue
 			}
 
-			var status string
-			var amount float64
+			var status entity.PaymentStatus
+			amount := money.New(0, coursePrice.Amount.Currency)
 
 			switch i {
 			case 0:
-				status = "completed"
+				status = entity.PaymentSucceeded
 			case 1:
-				status = "pending"
+				status = entity.PaymentPending
 				amount = coursePrice.Amount
 			default:
-				status = "failed"
+				status = entity.PaymentCanceled
 				amount = coursePrice.Amount
 			}
 
 			transaction := &entity.Payment{
-				PaymentID:    uuid.New(),
-				UserID:       user.ID,
-				CourseID:     course.CourseID,
-				Amount:       amount,
-				CurrencyCode: coursePrice.CurrencyCode,
-				Status:       status,
-				CreatedAt:    time.Now().Add(-time.Duration(i*24) * time.Hour),
+				PaymentID: uuid.New(),
+				UserID:    user.ID,
+				CourseID:  &course.CourseID,
+				Amount:    amount,
+				Status:    status,
+				CreatedAt: time.Now().Add(-time.Duration(i*24) * time.Hour),
 			}
 
 			err := userRepo.DB.Transaction(func(tx *gorm.DB) error {
@@ -1006,10 +1155,10 @@ func seedCourseAssignments(userRepo *repository.UserRepo, courseRepo *repository
 
 	// Assign courses to users
 	for _, user := range users {
-		log.Printf("Processing user %s with role %d", user.ID, user.Role)
+		log.Printf("Processing user %s (superadmin: %t)", user.ID, user.HasRole(entity.RoleSuperadmin))
 
 		// Admin gets all courses
-		if user.Role == 1 {
+		if user.HasRole(entity.RoleSuperadmin) {
 			log.Printf("User %s is admin, assigning all courses", user.ID)
 			for _, course := range courses {
 				// Check if assignment already exists
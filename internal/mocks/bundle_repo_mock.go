@@ -0,0 +1,54 @@
+package mocks
+
+import (
+	"errors"
+	"mzt/internal/entity"
+	"mzt/internal/repository"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MockBundleRepository struct {
+	Bundles map[uuid.UUID]*entity.Bundle
+}
+
+func NewMockBundleRepository() repository.BundleRepository {
+	return &MockBundleRepository{
+		Bundles: make(map[uuid.UUID]*entity.Bundle),
+	}
+}
+
+func (m *MockBundleRepository) CreateBundle(bundle *entity.Bundle) error {
+	if bundle.CreatedAt.IsZero() {
+		bundle.CreatedAt = time.Now()
+	}
+	m.Bundles[bundle.BundleID] = bundle
+	return nil
+}
+
+func (m *MockBundleRepository) GetBundles(onlyActive bool) ([]entity.Bundle, error) {
+	bundles := make([]entity.Bundle, 0, len(m.Bundles))
+	for _, bundle := range m.Bundles {
+		if onlyActive && !bundle.Active {
+			continue
+		}
+		bundles = append(bundles, *bundle)
+	}
+	return bundles, nil
+}
+
+func (m *MockBundleRepository) GetBundleByID(bundleID uuid.UUID) (*entity.Bundle, error) {
+	if bundle, exists := m.Bundles[bundleID]; exists {
+		return bundle, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockBundleRepository) UpdateBundle(bundle *entity.Bundle) error {
+	if _, exists := m.Bundles[bundle.BundleID]; !exists {
+		return errors.New("record not found")
+	}
+	m.Bundles[bundle.BundleID] = bundle
+	return nil
+}
@@ -12,6 +12,8 @@ type MockCourseRepository struct {
 	Courses     map[uuid.UUID]*entity.Course
 	Lessons     map[uuid.UUID]*entity.Lesson
 	Assignments map[uuid.UUID]map[uuid.UUID]*entity.CourseAssignment
+	// если задана, запись на курс возвращает эту ошибку, так проверяется сбой выдачи после оплаты
+	AssignmentErr error
 }
 
 func NewMockCourseRepository() repository.CourseRepository {
@@ -100,6 +102,9 @@ func (m *MockCourseRepository) RemoveLesson(lessonId uuid.UUID) error {
 }
 
 func (m *MockCourseRepository) CreateCourseAssignment(assignment *entity.CourseAssignment) error {
+	if m.AssignmentErr != nil {
+		return m.AssignmentErr
+	}
 	if _, exists := m.Assignments[assignment.CourseID]; !exists {
 		m.Assignments[assignment.CourseID] = make(map[uuid.UUID]*entity.CourseAssignment)
 	}
@@ -153,9 +158,50 @@ func (m *MockCourseRepository) UpdateCourseAssignment(assignment *entity.CourseA
 	return nil
 }
 
+func (m *MockCourseRepository) DeleteSubscriptionAssignments(subscriptionId uuid.UUID) error {
+	for _, courseAssignments := range m.Assignments {
+		for userId, assignment := range courseAssignments {
+			if assignment.SubscriptionID != nil && *assignment.SubscriptionID == subscriptionId {
+				delete(courseAssignments, userId)
+			}
+		}
+	}
+	return nil
+}
+
 func (m *MockCourseRepository) DeleteCourseAssignment(courseId uuid.UUID, userId uuid.UUID) error {
 	if courseAssignments, exists := m.Assignments[courseId]; exists {
 		delete(courseAssignments, userId)
 	}
 	return nil
 }
+
+func (m *MockCourseRepository) EnrollInBundle(userId, bundleId uuid.UUID, courseIds []uuid.UUID) error {
+	for _, courseId := range courseIds {
+		if _, exists := m.Assignments[courseId]; !exists {
+			m.Assignments[courseId] = make(map[uuid.UUID]*entity.CourseAssignment)
+		}
+		assignment, exists := m.Assignments[courseId][userId]
+		if !exists {
+			bundleId := bundleId
+			m.Assignments[courseId][userId] = &entity.CourseAssignment{CaID: uuid.New(), UserID: userId, CourseID: courseId, BundleID: &bundleId}
+			continue
+		}
+		if assignment.SubscriptionID != nil || assignment.Suspended {
+			bundleId := bundleId
+			assignment.SubscriptionID = nil
+			assignment.Suspended = false
+			assignment.BundleID = &bundleId
+		}
+	}
+	return nil
+}
+
+func (m *MockCourseRepository) DeleteBundleAssignments(bundleId, userId uuid.UUID) error {
+	for _, courseAssignments := range m.Assignments {
+		if assignment, exists := courseAssignments[userId]; exists && assignment.BundleID != nil && *assignment.BundleID == bundleId {
+			delete(courseAssignments, userId)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package mocks
+
+import (
+	"errors"
+	"mzt/internal/entity"
+	"mzt/internal/repository"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MockGiftCodeRepository struct {
+	Codes map[uuid.UUID]*entity.GiftCode
+	// активация кода записывает пользователя на курс в этом репозитории
+	Courses *MockCourseRepository
+}
+
+func NewMockGiftCodeRepository(courseRepo repository.CourseRepository) repository.GiftCodeRepository {
+	return &MockGiftCodeRepository{
+		Codes:   make(map[uuid.UUID]*entity.GiftCode),
+		Courses: courseRepo.(*MockCourseRepository),
+	}
+}
+
+func (m *MockGiftCodeRepository) CreateGiftCodes(codes []entity.GiftCode) error {
+	for i := range codes {
+		code := codes[i]
+		if code.CreatedAt.IsZero() {
+			code.CreatedAt = time.Now()
+		}
+		m.Codes[code.GiftCodeID] = &code
+	}
+	return nil
+}
+
+func (m *MockGiftCodeRepository) GetGiftCodes(batchID, courseID *uuid.UUID) ([]entity.GiftCode, error) {
+	codes := make([]entity.GiftCode, 0)
+	for _, code := range m.Codes {
+		if batchID != nil && (code.BatchID == nil || *code.BatchID != *batchID) {
+			continue
+		}
+		if courseID != nil && code.CourseID != *courseID {
+			continue
+		}
+		codes = append(codes, *code)
+	}
+	return codes, nil
+}
+
+func (m *MockGiftCodeRepository) GetGiftCodeByCode(value string) (*entity.GiftCode, error) {
+	for _, code := range m.Codes {
+		if code.Code == value {
+			return code, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockGiftCodeRepository) GetGiftCodeByPaymentID(paymentID uuid.UUID) (*entity.GiftCode, error) {
+	for _, code := range m.Codes {
+		if code.PaymentID != nil && *code.PaymentID == paymentID {
+			return code, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockGiftCodeRepository) GetPurchasedGiftCodes(userID uuid.UUID) ([]entity.GiftCode, error) {
+	codes := make([]entity.GiftCode, 0)
+	for _, code := range m.Codes {
+		if code.IssuedByID == userID && code.PaymentID != nil {
+			codes = append(codes, *code)
+		}
+	}
+	return codes, nil
+}
+
+func (m *MockGiftCodeRepository) RedeemGiftCode(giftCodeID, userID uuid.UUID, at time.Time) error {
+	code, exists := m.Codes[giftCodeID]
+	if !exists || code.RedeemedByID != nil || code.RevokedAt != nil || (code.ExpiresAt != nil && !code.ExpiresAt.After(at)) {
+		return repository.ErrGiftCodeUnavailable
+	}
+	code.RedeemedByID = &userID
+	code.RedeemedAt = &at
+
+	assignment, _ := m.Courses.GetCourseAssignment(code.CourseID, userID)
+	if assignment == nil {
+		return m.Courses.CreateCourseAssignment(&entity.CourseAssignment{CaID: uuid.New(), UserID: userID, CourseID: code.CourseID})
+	}
+	assignment.SubscriptionID = nil
+	assignment.BundleID = nil
+	assignment.Suspended = false
+	return nil
+}
+
+func (m *MockGiftCodeRepository) RevokeGiftCode(giftCodeID uuid.UUID, at time.Time) error {
+	if code, exists := m.Codes[giftCodeID]; exists && code.RevokedAt == nil {
+		code.RevokedAt = &at
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package mocks
+
+import (
+	"errors"
+	"mzt/internal/entity"
+	"mzt/internal/repository"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MockInstallmentRepository struct {
+	Plans map[uuid.UUID]*entity.InstallmentPlan
+}
+
+func NewMockInstallmentRepository() repository.InstallmentRepository {
+	return &MockInstallmentRepository{
+		Plans: make(map[uuid.UUID]*entity.InstallmentPlan),
+	}
+}
+
+func (m *MockInstallmentRepository) CreateInstallmentPlan(plan *entity.InstallmentPlan) error {
+	if plan.CreatedAt.IsZero() {
+		plan.CreatedAt = time.Now()
+	}
+	m.Plans[plan.InstallmentPlanID] = plan
+	return nil
+}
+
+func (m *MockInstallmentRepository) GetInstallmentPlanByID(planID uuid.UUID) (*entity.InstallmentPlan, error) {
+	if plan, exists := m.Plans[planID]; exists {
+		return plan, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockInstallmentRepository) GetInstallmentPlansByUserID(userID uuid.UUID) ([]entity.InstallmentPlan, error) {
+	plans := make([]entity.InstallmentPlan, 0)
+	for _, plan := range m.Plans {
+		if plan.UserID == userID {
+			plans = append(plans, *plan)
+		}
+	}
+	return plans, nil
+}
+
+func (m *MockInstallmentRepository) GetOverdueInstallmentPlans(now time.Time) ([]*entity.InstallmentPlan, error) {
+	plans := make([]*entity.InstallmentPlan, 0)
+	for _, plan := range m.Plans {
+		if plan.Status != entity.InstallmentPlanActive && plan.Status != entity.InstallmentPlanOverdue {
+			continue
+		}
+		for _, installment := range plan.Installments {
+			if installment.PaidAt == nil && !installment.DueAt.After(now) {
+				plans = append(plans, plan)
+				break
+			}
+		}
+	}
+	return plans, nil
+}
+
+func (m *MockInstallmentRepository) UpdateInstallmentPlanStatus(planID uuid.UUID, status entity.InstallmentPlanStatus) error {
+	plan, exists := m.Plans[planID]
+	if !exists {
+		return errors.New("record not found")
+	}
+	plan.Status = status
+	return nil
+}
+
+func (m *MockInstallmentRepository) UpdateInstallment(installment *entity.Installment) error {
+	plan, exists := m.Plans[installment.InstallmentPlanID]
+	if !exists {
+		return errors.New("record not found")
+	}
+	for i := range plan.Installments {
+		if plan.Installments[i].InstallmentID == installment.InstallmentID {
+			plan.Installments[i] = *installment
+			return nil
+		}
+	}
+	return errors.New("record not found")
+}
@@ -0,0 +1,113 @@
+package mocks
+
+import (
+	"errors"
+	"mzt/internal/entity"
+	"mzt/internal/repository"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MockInvoiceRepository struct {
+	Invoices map[uuid.UUID]*entity.Invoice
+	// отметка об оплате меняет статус платежа и записывает сотрудников в этих репозиториях
+	Payments *MockPaymentRepository
+	Courses  *MockCourseRepository
+	number   uint
+}
+
+func NewMockInvoiceRepository(paymentRepo repository.PaymentRepository, courseRepo repository.CourseRepository) repository.InvoiceRepository {
+	return &MockInvoiceRepository{
+		Invoices: make(map[uuid.UUID]*entity.Invoice),
+		Payments: paymentRepo.(*MockPaymentRepository),
+		Courses:  courseRepo.(*MockCourseRepository),
+	}
+}
+
+func (m *MockInvoiceRepository) CreateInvoice(invoice *entity.Invoice) error {
+	m.number++
+	invoice.Number = m.number
+	if invoice.CreatedAt.IsZero() {
+		invoice.CreatedAt = time.Now()
+	}
+	m.Invoices[invoice.InvoiceID] = invoice
+	return nil
+}
+
+func (m *MockInvoiceRepository) GetInvoiceByID(invoiceID uuid.UUID) (*entity.Invoice, error) {
+	invoice, exists := m.Invoices[invoiceID]
+	if !exists {
+		return nil, errors.New("record not found")
+	}
+	return m.fill(invoice), nil
+}
+
+func (m *MockInvoiceRepository) GetInvoicesByUserID(userID uuid.UUID) ([]entity.Invoice, error) {
+	invoices := make([]entity.Invoice, 0)
+	for _, invoice := range m.Invoices {
+		if invoice.UserID == userID {
+			invoices = append(invoices, *m.fill(invoice))
+		}
+	}
+	sortInvoices(invoices)
+	return invoices, nil
+}
+
+func (m *MockInvoiceRepository) GetInvoices(status *entity.PaymentStatus) ([]entity.Invoice, error) {
+	invoices := make([]entity.Invoice, 0)
+	for _, invoice := range m.Invoices {
+		filled := m.fill(invoice)
+		if status != nil && filled.Payment.Status != *status {
+			continue
+		}
+		invoices = append(invoices, *filled)
+	}
+	sortInvoices(invoices)
+	return invoices, nil
+}
+
+func (m *MockInvoiceRepository) MarkInvoicePaid(invoiceID uuid.UUID, userIDs []uuid.UUID, at time.Time) error {
+	invoice, exists := m.Invoices[invoiceID]
+	if !exists {
+		return errors.New("record not found")
+	}
+	if err := m.Payments.TransitionPaymentStatus(invoice.PaymentID, entity.PaymentSucceeded, entity.PaymentSourceAdmin, ""); err != nil {
+		return err
+	}
+	m.Payments.Payments[invoice.PaymentID].FulfilledAt = &at
+
+	for _, userID := range userIDs {
+		assignment, _ := m.Courses.GetCourseAssignment(invoice.CourseID, userID)
+		if assignment == nil {
+			if err := m.Courses.CreateCourseAssignment(&entity.CourseAssignment{CaID: uuid.New(), UserID: userID, CourseID: invoice.CourseID}); err != nil {
+				return err
+			}
+		} else {
+			assignment.SubscriptionID = nil
+			assignment.BundleID = nil
+			assignment.Suspended = false
+		}
+		invoice.Employees = append(invoice.Employees, entity.InvoiceEmployee{InvoiceID: invoiceID, UserID: userID})
+	}
+	invoice.PaidAt = &at
+	return nil
+}
+
+// подставляет платеж и курс как это делает Preload
+func (m *MockInvoiceRepository) fill(invoice *entity.Invoice) *entity.Invoice {
+	if payment, exists := m.Payments.Payments[invoice.PaymentID]; exists {
+		invoice.Payment = *payment
+	}
+	if course, exists := m.Courses.Courses[invoice.CourseID]; exists {
+		invoice.Course = *course
+	}
+	return invoice
+}
+
+func sortInvoices(invoices []entity.Invoice) {
+	sort.Slice(invoices, func(i, j int) bool {
+		return invoices[i].Number > invoices[j].Number
+	})
+}
@@ -0,0 +1,21 @@
+package mocks
+
+import "mzt/internal/mailer"
+
+// MockMailSender запоминает письма вместо отправки
+type MockMailSender struct {
+	Messages []mailer.Message
+}
+
+func NewMockMailSender() *MockMailSender {
+	return &MockMailSender{}
+}
+
+func (m *MockMailSender) Name() string {
+	return "mock"
+}
+
+func (m *MockMailSender) Send(msg *mailer.Message) error {
+	m.Messages = append(m.Messages, *msg)
+	return nil
+}
@@ -0,0 +1,170 @@
+package mocks
+
+import (
+	"mzt/internal/oauth"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// FakeOIDCUser пользователь, которым входят через фейковый провайдер
+type FakeOIDCUser struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// FakeOIDC локальный OpenID Connect провайдер с authorization code и PKCE
+// страница входа ничего не спрашивает и сразу возвращает пользователя с кодом, будто он вошел как текущий User
+// подходит и для тестов через httptest, и для ручной проверки через cmd/fakeoidc
+type FakeOIDC struct {
+	ClientID     string
+	ClientSecret string
+
+	mu     sync.Mutex
+	user   FakeOIDCUser
+	codes  map[string]fakeAuthRequest
+	tokens map[string]FakeOIDCUser
+}
+
+// запрос входа, который ждет обмена кода на токен
+type fakeAuthRequest struct {
+	redirectURI string
+	challenge   string
+	user        FakeOIDCUser
+}
+
+func NewFakeOIDC(clientID, clientSecret string) *FakeOIDC {
+	return &FakeOIDC{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		user:         FakeOIDCUser{Subject: "fake-user", Email: "fake@example.com", EmailVerified: true, Name: "Fake User"},
+		codes:        make(map[string]fakeAuthRequest),
+		tokens:       make(map[string]FakeOIDCUser),
+	}
+}
+
+// NewFakeOIDCServer запускает фейковый провайдер на случайном порту
+// адреса для config.OAuthProvider - server.URL + /authorize, /token и /userinfo
+func NewFakeOIDCServer(clientID, clientSecret string) (*FakeOIDC, *httptest.Server) {
+	fake := NewFakeOIDC(clientID, clientSecret)
+	server := httptest.NewServer(fake.Handler())
+	return fake, server
+}
+
+// Handler отдает http обработчик провайдера
+func (f *FakeOIDC) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /authorize", f.authorize)
+	mux.HandleFunc("POST /token", f.token)
+	mux.HandleFunc("GET /userinfo", f.userInfo)
+	return mux
+}
+
+// SetUser меняет пользователя, которым войдут следующие запросы
+func (f *FakeOIDC) SetUser(user FakeOIDCUser) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.user = user
+}
+
+// Authorize проходит страницу входа и возвращает code и state, которые провайдер передал бы в redirect_uri
+func (f *FakeOIDC) Authorize(authURL string) (string, string, error) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+func (f *FakeOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != f.ClientID || query.Get("response_type") != "code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "pkce required"})
+		return
+	}
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "invalid redirect_uri"})
+		return
+	}
+
+	f.mu.Lock()
+	code := uuid.NewString()
+	f.codes[code] = fakeAuthRequest{redirectURI: redirect.String(), challenge: query.Get("code_challenge"), user: f.user}
+	f.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (f *FakeOIDC) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if r.PostForm.Get("client_id") != f.ClientID || r.PostForm.Get("client_secret") != f.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// код одноразовый, даже если обмен не удался
+	code := r.PostForm.Get("code")
+	request, ok := f.codes[code]
+	delete(f.codes, code)
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != request.redirectURI {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if oauth.Challenge(r.PostForm.Get("code_verifier")) != request.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "pkce verification failed"})
+		return
+	}
+
+	token := uuid.NewString()
+	f.tokens[token] = request.user
+	writeJSON(w, http.StatusOK, map[string]interface{}{"access_token": token, "token_type": "Bearer", "expires_in": 3600})
+}
+
+func (f *FakeOIDC) userInfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	f.mu.Lock()
+	user, exists := f.tokens[token]
+	f.mu.Unlock()
+	if !ok || !exists {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sub":            user.Subject,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"name":           user.Name,
+	})
+}
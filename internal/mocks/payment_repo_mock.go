@@ -0,0 +1,275 @@
+package mocks
+
+import (
+	"errors"
+	"mzt/internal/entity"
+	"mzt/internal/money"
+	"mzt/internal/repository"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MockPaymentRepository struct {
+	Payments map[uuid.UUID]*entity.Payment
+	Prices   map[uuid.UUID]*entity.CoursePrice
+	Events   map[uuid.UUID][]entity.PaymentEvent
+	Refunds  map[uuid.UUID]*entity.Refund
+}
+
+func NewMockPaymentRepository() repository.PaymentRepository {
+	return &MockPaymentRepository{
+		Payments: make(map[uuid.UUID]*entity.Payment),
+		Prices:   make(map[uuid.UUID]*entity.CoursePrice),
+		Events:   make(map[uuid.UUID][]entity.PaymentEvent),
+		Refunds:  make(map[uuid.UUID]*entity.Refund),
+	}
+}
+
+func (m *MockPaymentRepository) CreatePayment(payment *entity.Payment) error {
+	if payment.Status == "" {
+		payment.Status = entity.PaymentPending
+	}
+	if payment.CreatedAt.IsZero() {
+		payment.CreatedAt = time.Now()
+	}
+	m.Payments[payment.PaymentID] = payment
+	m.Events[payment.PaymentID] = append(m.Events[payment.PaymentID], entity.PaymentEvent{
+		PaymentID: payment.PaymentID,
+		ToStatus:  payment.Status,
+		Source:    entity.PaymentSourceCheckout,
+	})
+	return nil
+}
+
+func (m *MockPaymentRepository) GetPaymentByID(paymentID uuid.UUID) (*entity.Payment, error) {
+	if payment, exists := m.Payments[paymentID]; exists {
+		return payment, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockPaymentRepository) GetPaymentByRef(ref string) (*entity.Payment, error) {
+	for _, payment := range m.Payments {
+		if ref != "" && payment.PaymentRef == ref {
+			return payment, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockPaymentRepository) GetPaymentsByUserID(userID uuid.UUID) ([]*entity.Payment, error) {
+	payments := make([]*entity.Payment, 0)
+	for _, payment := range m.Payments {
+		if payment.UserID == userID {
+			// как Preload в настоящем репозитории
+			result := m.withRefunds(payment)
+			result.Events = m.Events[payment.PaymentID]
+			payments = append(payments, result)
+		}
+	}
+	return payments, nil
+}
+
+func (m *MockPaymentRepository) GetPaymentsByCourseID(courseID uuid.UUID) ([]*entity.Payment, error) {
+	payments := make([]*entity.Payment, 0)
+	for _, payment := range m.Payments {
+		if payment.CourseID != nil && *payment.CourseID == courseID {
+			payments = append(payments, m.withRefunds(payment))
+		}
+	}
+	return payments, nil
+}
+
+func (m *MockPaymentRepository) GetPaymentsCreatedBetween(from, to *time.Time) ([]*entity.Payment, error) {
+	payments := make([]*entity.Payment, 0)
+	for _, payment := range m.Payments {
+		if from != nil && payment.CreatedAt.Before(*from) {
+			continue
+		}
+		if to != nil && !payment.CreatedAt.Before(*to) {
+			continue
+		}
+		payments = append(payments, m.withRefunds(payment))
+	}
+	return payments, nil
+}
+
+// копия платежа с возвратами как после Preload("Refunds")
+func (m *MockPaymentRepository) withRefunds(payment *entity.Payment) *entity.Payment {
+	result := *payment
+	result.Refunds = nil
+	for _, refund := range m.Refunds {
+		if refund.PaymentID == payment.PaymentID {
+			result.Refunds = append(result.Refunds, *refund)
+		}
+	}
+	return &result
+}
+
+func (m *MockPaymentRepository) GetPaymentsBySubscriptionID(subscriptionID uuid.UUID) ([]*entity.Payment, error) {
+	payments := make([]*entity.Payment, 0)
+	for _, payment := range m.Payments {
+		if payment.SubscriptionID != nil && *payment.SubscriptionID == subscriptionID {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+func (m *MockPaymentRepository) TransitionPaymentStatus(paymentID uuid.UUID, to entity.PaymentStatus, source entity.PaymentEventSource, payload string) error {
+	payment, exists := m.Payments[paymentID]
+	if !exists {
+		return errors.New("record not found")
+	}
+	if !payment.Status.CanTransitionTo(to) {
+		return repository.ErrIllegalTransition
+	}
+	m.Events[paymentID] = append(m.Events[paymentID], entity.PaymentEvent{
+		PaymentID:  paymentID,
+		FromStatus: payment.Status,
+		ToStatus:   to,
+		Source:     source,
+		Payload:    payload,
+	})
+	payment.Status = to
+	return nil
+}
+
+func (m *MockPaymentRepository) GetPaymentEvents(paymentID uuid.UUID) ([]entity.PaymentEvent, error) {
+	return m.Events[paymentID], nil
+}
+
+func (m *MockPaymentRepository) UpdatePaymentRef(paymentID uuid.UUID, ref string) error {
+	payment, exists := m.Payments[paymentID]
+	if !exists {
+		return errors.New("record not found")
+	}
+	payment.PaymentRef = ref
+	return nil
+}
+
+func (m *MockPaymentRepository) UpdateReceiptStatus(paymentID uuid.UUID, status entity.ReceiptStatus) error {
+	if payment, exists := m.Payments[paymentID]; exists {
+		payment.ReceiptStatus = status
+	}
+	return nil
+}
+
+func (m *MockPaymentRepository) GetStalePayments(statuses []entity.PaymentStatus, before time.Time) ([]*entity.Payment, error) {
+	payments := make([]*entity.Payment, 0)
+	for _, payment := range m.Payments {
+		if !payment.CreatedAt.Before(before) {
+			continue
+		}
+		for _, status := range statuses {
+			if payment.Status == status {
+				payments = append(payments, payment)
+				break
+			}
+		}
+	}
+	return payments, nil
+}
+
+func (m *MockPaymentRepository) MarkPaymentFulfilled(paymentID uuid.UUID) error {
+	payment, exists := m.Payments[paymentID]
+	if !exists {
+		return errors.New("record not found")
+	}
+	if payment.FulfilledAt == nil {
+		now := time.Now()
+		payment.FulfilledAt = &now
+	}
+	return nil
+}
+
+func (m *MockPaymentRepository) GetUnfulfilledPayments(before time.Time) ([]*entity.Payment, error) {
+	payments := make([]*entity.Payment, 0)
+	for _, payment := range m.Payments {
+		if payment.Status == entity.PaymentSucceeded && payment.FulfilledAt == nil && payment.CreatedAt.Before(before) {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+func (m *MockPaymentRepository) GetPaymentCourseIDs(paymentID uuid.UUID) ([]uuid.UUID, error) {
+	courseIDs := make([]uuid.UUID, 0)
+	if payment, exists := m.Payments[paymentID]; exists {
+		for _, course := range payment.BundleCourses {
+			courseIDs = append(courseIDs, course.CourseID)
+		}
+	}
+	return courseIDs, nil
+}
+
+func (m *MockPaymentRepository) CreateRefund(refund *entity.Refund) error {
+	if refund.Status == "" {
+		refund.Status = entity.RefundPending
+	}
+	if payment, exists := m.Payments[refund.PaymentID]; exists {
+		remaining := payment.Amount.Minor
+		for _, existing := range m.Refunds {
+			if existing.PaymentID == refund.PaymentID && existing.Status != entity.RefundCanceled {
+				remaining -= existing.Amount.Minor
+			}
+		}
+		if refund.Amount.Minor > remaining {
+			return repository.ErrRefundExceedsPayment
+		}
+	}
+	if refund.CreatedAt.IsZero() {
+		refund.CreatedAt = time.Now()
+	}
+	m.Refunds[refund.RefundID] = refund
+	return nil
+}
+
+func (m *MockPaymentRepository) UpdateRefund(refund *entity.Refund) error {
+	existing, exists := m.Refunds[refund.RefundID]
+	if !exists {
+		return errors.New("record not found")
+	}
+	existing.Status = refund.Status
+	existing.RefundRef = refund.RefundRef
+	return nil
+}
+
+func (m *MockPaymentRepository) GetRefundByRef(ref string) (*entity.Refund, error) {
+	for _, refund := range m.Refunds {
+		if ref != "" && refund.RefundRef == ref {
+			return refund, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockPaymentRepository) GetRefundsByPaymentID(paymentID uuid.UUID) ([]entity.Refund, error) {
+	refunds := make([]entity.Refund, 0)
+	for _, refund := range m.Refunds {
+		if refund.PaymentID == paymentID {
+			refunds = append(refunds, *refund)
+		}
+	}
+	return refunds, nil
+}
+
+func (m *MockPaymentRepository) GetCoursePrice(courseID uuid.UUID) (*entity.CoursePrice, error) {
+	if price, exists := m.Prices[courseID]; exists {
+		return price, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockPaymentRepository) SetCoursePrice(price *entity.CoursePrice) error {
+	m.Prices[price.CourseID] = price
+	return nil
+}
+
+func (m *MockPaymentRepository) UpdateCoursePrice(courseID uuid.UUID, amount money.Money) error {
+	if price, exists := m.Prices[courseID]; exists {
+		price.Amount = amount
+	}
+	return nil
+}
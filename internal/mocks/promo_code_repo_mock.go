@@ -0,0 +1,107 @@
+package mocks
+
+import (
+	"errors"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/repository"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type MockPromoCodeRepository struct {
+	PromoCodes map[uuid.UUID]*entity.PromoCode
+	Usages     []entity.PromoCodeUsage
+}
+
+func NewMockPromoCodeRepository() repository.PromoCodeRepository {
+	return &MockPromoCodeRepository{
+		PromoCodes: make(map[uuid.UUID]*entity.PromoCode),
+	}
+}
+
+func (m *MockPromoCodeRepository) CreatePromoCode(promo *entity.PromoCode) error {
+	m.PromoCodes[promo.PromoCodeID] = promo
+	return nil
+}
+
+func (m *MockPromoCodeRepository) GetPromoCodes() ([]entity.PromoCode, error) {
+	promos := make([]entity.PromoCode, 0, len(m.PromoCodes))
+	for _, promo := range m.PromoCodes {
+		promos = append(promos, *promo)
+	}
+	return promos, nil
+}
+
+func (m *MockPromoCodeRepository) GetPromoCodeByID(promoCodeID uuid.UUID) (*entity.PromoCode, error) {
+	if promo, exists := m.PromoCodes[promoCodeID]; exists {
+		return promo, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockPromoCodeRepository) GetPromoCodeByCode(code string) (*entity.PromoCode, error) {
+	for _, promo := range m.PromoCodes {
+		if strings.EqualFold(promo.Code, code) {
+			return promo, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockPromoCodeRepository) UpdatePromoCode(promo *entity.PromoCode) error {
+	if _, exists := m.PromoCodes[promo.PromoCodeID]; !exists {
+		return errors.New("record not found")
+	}
+	m.PromoCodes[promo.PromoCodeID] = promo
+	return nil
+}
+
+func (m *MockPromoCodeRepository) DeletePromoCode(promoCodeID uuid.UUID) error {
+	delete(m.PromoCodes, promoCodeID)
+	return nil
+}
+
+func (m *MockPromoCodeRepository) RecordPromoCodeUsage(usage *entity.PromoCodeUsage) error {
+	for _, existing := range m.Usages {
+		if existing.PaymentID == usage.PaymentID {
+			return nil
+		}
+	}
+	m.Usages = append(m.Usages, *usage)
+	return nil
+}
+
+func (m *MockPromoCodeRepository) CountPromoCodeUsages(promoCodeID uuid.UUID) (int64, error) {
+	var count int64
+	for _, usage := range m.Usages {
+		if usage.PromoCodeID == promoCodeID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockPromoCodeRepository) CountPromoCodeUsagesByUser(promoCodeID, userID uuid.UUID) (int64, error) {
+	var count int64
+	for _, usage := range m.Usages {
+		if usage.PromoCodeID == promoCodeID && usage.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockPromoCodeRepository) GetPromoCodeStats(promoCodeID uuid.UUID) (*dto.PromoCodeStatsDto, error) {
+	stats := &dto.PromoCodeStatsDto{PromoCodeID: promoCodeID}
+	users := make(map[uuid.UUID]bool)
+	for _, usage := range m.Usages {
+		if usage.PromoCodeID == promoCodeID {
+			stats.Usages++
+			users[usage.UserID] = true
+		}
+	}
+	stats.Users = int64(len(users))
+	return stats, nil
+}
@@ -0,0 +1,153 @@
+package mocks
+
+import (
+	"errors"
+	"mzt/internal/entity"
+	"mzt/internal/repository"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MockSubscriptionRepository struct {
+	Plans          map[uuid.UUID]*entity.Plan
+	Subscriptions  map[uuid.UUID]*entity.Subscription
+	PaymentMethods map[uuid.UUID]*entity.PaymentMethod
+}
+
+func NewMockSubscriptionRepository() repository.SubscriptionRepository {
+	return &MockSubscriptionRepository{
+		Plans:          make(map[uuid.UUID]*entity.Plan),
+		Subscriptions:  make(map[uuid.UUID]*entity.Subscription),
+		PaymentMethods: make(map[uuid.UUID]*entity.PaymentMethod),
+	}
+}
+
+func (m *MockSubscriptionRepository) CreatePlan(plan *entity.Plan) error {
+	m.Plans[plan.PlanID] = plan
+	return nil
+}
+
+func (m *MockSubscriptionRepository) GetPlans(onlyActive bool) ([]entity.Plan, error) {
+	plans := make([]entity.Plan, 0, len(m.Plans))
+	for _, plan := range m.Plans {
+		if onlyActive && !plan.Active {
+			continue
+		}
+		plans = append(plans, *plan)
+	}
+	return plans, nil
+}
+
+func (m *MockSubscriptionRepository) GetPlanByID(planID uuid.UUID) (*entity.Plan, error) {
+	if plan, exists := m.Plans[planID]; exists {
+		return plan, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockSubscriptionRepository) UpdatePlan(plan *entity.Plan) error {
+	if _, exists := m.Plans[plan.PlanID]; !exists {
+		return errors.New("record not found")
+	}
+	m.Plans[plan.PlanID] = plan
+	return nil
+}
+
+func (m *MockSubscriptionRepository) CreateSubscription(subscription *entity.Subscription) error {
+	if subscription.CreatedAt.IsZero() {
+		subscription.CreatedAt = time.Now()
+	}
+	m.Subscriptions[subscription.SubscriptionID] = subscription
+	return nil
+}
+
+// заполняет связи так же как Preload в настоящем репозитории
+func (m *MockSubscriptionRepository) preload(subscription *entity.Subscription) *entity.Subscription {
+	if plan, exists := m.Plans[subscription.PlanID]; exists {
+		subscription.Plan = *plan
+	}
+	subscription.PaymentMethod = nil
+	if subscription.PaymentMethodID != nil {
+		subscription.PaymentMethod = m.PaymentMethods[*subscription.PaymentMethodID]
+	}
+	return subscription
+}
+
+func (m *MockSubscriptionRepository) GetSubscriptionByID(subscriptionID uuid.UUID) (*entity.Subscription, error) {
+	if subscription, exists := m.Subscriptions[subscriptionID]; exists {
+		return m.preload(subscription), nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockSubscriptionRepository) GetSubscriptionsByUserID(userID uuid.UUID) ([]entity.Subscription, error) {
+	subscriptions := make([]entity.Subscription, 0)
+	for _, subscription := range m.Subscriptions {
+		if subscription.UserID == userID {
+			subscriptions = append(subscriptions, *m.preload(subscription))
+		}
+	}
+	return subscriptions, nil
+}
+
+func (m *MockSubscriptionRepository) UpdateSubscription(subscription *entity.Subscription) error {
+	if _, exists := m.Subscriptions[subscription.SubscriptionID]; !exists {
+		return errors.New("record not found")
+	}
+	m.Subscriptions[subscription.SubscriptionID] = subscription
+	return nil
+}
+
+func (m *MockSubscriptionRepository) GetDueSubscriptions(now time.Time) ([]*entity.Subscription, error) {
+	subscriptions := make([]*entity.Subscription, 0)
+	for _, subscription := range m.Subscriptions {
+		if subscription.Status != entity.SubscriptionActive && subscription.Status != entity.SubscriptionPastDue {
+			continue
+		}
+		if subscription.NextChargeAt != nil && !subscription.NextChargeAt.After(now) {
+			subscriptions = append(subscriptions, m.preload(subscription))
+		}
+	}
+	return subscriptions, nil
+}
+
+func (m *MockSubscriptionRepository) ClaimDueSubscription(subscriptionID uuid.UUID, now, until time.Time) (bool, error) {
+	subscription, exists := m.Subscriptions[subscriptionID]
+	if !exists || (subscription.Status != entity.SubscriptionActive && subscription.Status != entity.SubscriptionPastDue) {
+		return false, nil
+	}
+	if subscription.NextChargeAt == nil || subscription.NextChargeAt.After(now) {
+		return false, nil
+	}
+	subscription.NextChargeAt = &until
+	return true, nil
+}
+
+func (m *MockSubscriptionRepository) GetLapsedSubscriptions(now time.Time) ([]*entity.Subscription, error) {
+	subscriptions := make([]*entity.Subscription, 0)
+	for _, subscription := range m.Subscriptions {
+		switch {
+		case subscription.Status == entity.SubscriptionPastDue && subscription.GraceUntil != nil && !subscription.GraceUntil.After(now):
+		case subscription.Status == entity.SubscriptionCanceled && subscription.CurrentPeriodEnd != nil && !subscription.CurrentPeriodEnd.After(now):
+		default:
+			continue
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+func (m *MockSubscriptionRepository) CreatePaymentMethod(method *entity.PaymentMethod) error {
+	m.PaymentMethods[method.PaymentMethodID] = method
+	return nil
+}
+
+func (m *MockSubscriptionRepository) GetPaymentMethodByRef(ref string) (*entity.PaymentMethod, error) {
+	for _, method := range m.PaymentMethods {
+		if method.Ref == ref {
+			return method, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
@@ -1,8 +1,12 @@
 package mocks
 
 import (
+	"errors"
 	"mzt/internal/entity"
 	"mzt/internal/repository"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -10,16 +14,34 @@ import (
 type MockUserRepository struct {
 	Users     map[uuid.UUID]*entity.User
 	UserData  map[uuid.UUID]*entity.UserData
-	UserAuth  map[uuid.UUID]*entity.Auth
+	Sessions  map[uuid.UUID]*entity.Session
+	Events    []entity.SecurityEvent
+	Resets    map[string]*entity.PasswordResetToken
 	UserEmail map[string]uuid.UUID
+	Roles     map[string]entity.Role
+	TwoFactor map[uuid.UUID]*entity.TwoFactor
+	Recovery  map[string]*entity.RecoveryCode
+	// привязанные сервисы по ключу provider:subject
+	Identities map[string]*entity.Identity
+	OAuth      map[string]*entity.OAuthState
 }
 
 func NewMockUserRepository() repository.UserRepository {
+	roles := make(map[string]entity.Role)
+	for _, role := range entity.BuiltinRoles {
+		roles[role.Name] = role
+	}
 	return &MockUserRepository{
-		Users:     make(map[uuid.UUID]*entity.User),
-		UserData:  make(map[uuid.UUID]*entity.UserData),
-		UserAuth:  make(map[uuid.UUID]*entity.Auth),
-		UserEmail: make(map[string]uuid.UUID),
+		Users:      make(map[uuid.UUID]*entity.User),
+		UserData:   make(map[uuid.UUID]*entity.UserData),
+		Sessions:   make(map[uuid.UUID]*entity.Session),
+		Resets:     make(map[string]*entity.PasswordResetToken),
+		UserEmail:  make(map[string]uuid.UUID),
+		Roles:      roles,
+		TwoFactor:  make(map[uuid.UUID]*entity.TwoFactor),
+		Recovery:   make(map[string]*entity.RecoveryCode),
+		Identities: make(map[string]*entity.Identity),
+		OAuth:      make(map[string]*entity.OAuthState),
 	}
 }
 
@@ -40,28 +62,17 @@ func (m *MockUserRepository) GetUserWithDataById(id uuid.UUID) (*entity.User, er
 	return nil, nil
 }
 
-func (m *MockUserRepository) GetUserWithRefreshById(id uuid.UUID) (*entity.User, error) {
-	if user, exists := m.Users[id]; exists {
-		if auth, exists := m.UserAuth[id]; exists {
-			user.Auth = auth
-			return user, nil
-		}
-	}
-	return nil, nil
-}
-
-func (m *MockUserRepository) CreateUser(user *entity.User, userData *entity.UserData, auth *entity.Auth) error {
+func (m *MockUserRepository) CreateUser(user *entity.User, userData *entity.UserData, session *entity.Session) error {
 	m.Users[user.ID] = user
 	m.UserData[user.ID] = userData
-	m.UserAuth[user.ID] = auth
+	if session != nil {
+		m.Sessions[session.SessionID] = session
+	}
 	m.UserEmail[userData.Email] = user.ID
-	return nil
-}
-
-func (m *MockUserRepository) UpdateToken(userId uuid.UUID, token string) error {
-	if auth, exists := m.UserAuth[userId]; exists {
-		auth.Key = token
-		return nil
+	for i := range user.Identities {
+		identity := user.Identities[i]
+		identity.UserID = user.ID
+		m.Identities[identity.Provider+":"+identity.Subject] = &identity
 	}
 	return nil
 }
@@ -89,7 +100,11 @@ func (m *MockUserRepository) DeleteUser(userId uuid.UUID) error {
 	if _, exists := m.Users[userId]; exists {
 		delete(m.Users, userId)
 		delete(m.UserData, userId)
-		delete(m.UserAuth, userId)
+		for id, session := range m.Sessions {
+			if session.UserID == userId {
+				delete(m.Sessions, id)
+			}
+		}
 		for email, id := range m.UserEmail {
 			if id == userId {
 				delete(m.UserEmail, email)
@@ -107,3 +122,431 @@ func (m *MockUserRepository) GetUserById(userId uuid.UUID) (*entity.User, error)
 	}
 	return nil, nil
 }
+
+func (m *MockUserRepository) CreateSession(session *entity.Session) error {
+	for id, existing := range m.Sessions {
+		if existing.UserID == session.UserID && existing.ExpiresAt.Before(time.Now()) {
+			delete(m.Sessions, id)
+		}
+	}
+	m.Sessions[session.SessionID] = session
+	return nil
+}
+
+func (m *MockUserRepository) GetSession(sessionId uuid.UUID) (*entity.Session, error) {
+	if session, exists := m.Sessions[sessionId]; exists {
+		copied := *session
+		return &copied, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockUserRepository) GetSessionsByUserID(userId uuid.UUID) ([]entity.Session, error) {
+	sessions := make([]entity.Session, 0)
+	for _, session := range m.Sessions {
+		if session.UserID == userId && session.ExpiresAt.After(time.Now()) {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+func (m *MockUserRepository) RotateSessionToken(session *entity.Session, previousHash string) error {
+	stored, exists := m.Sessions[session.SessionID]
+	if !exists || stored.TokenHash != previousHash {
+		return repository.ErrTokenReused
+	}
+	copied := *session
+	m.Sessions[session.SessionID] = &copied
+	return nil
+}
+
+func (m *MockUserRepository) DeleteSession(userId uuid.UUID, sessionId uuid.UUID) error {
+	if session, exists := m.Sessions[sessionId]; exists && session.UserID == userId {
+		delete(m.Sessions, sessionId)
+	}
+	return nil
+}
+
+func (m *MockUserRepository) DeleteOtherSessions(userId uuid.UUID, keepSessionId uuid.UUID) error {
+	for id, session := range m.Sessions {
+		if session.UserID == userId && id != keepSessionId {
+			delete(m.Sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *MockUserRepository) RevokeSessionFamily(sessionId uuid.UUID, event *entity.SecurityEvent) error {
+	delete(m.Sessions, sessionId)
+	m.Events = append(m.Events, *event)
+	return nil
+}
+
+func (m *MockUserRepository) GetSecurityEvents(userId uuid.UUID) ([]entity.SecurityEvent, error) {
+	events := make([]entity.SecurityEvent, 0)
+	for i := len(m.Events) - 1; i >= 0; i-- {
+		if m.Events[i].UserID == userId {
+			events = append(events, m.Events[i])
+		}
+	}
+	return events, nil
+}
+
+func (m *MockUserRepository) CreatePasswordResetToken(token *entity.PasswordResetToken) error {
+	m.Resets[token.TokenHash] = token
+	return nil
+}
+
+func (m *MockUserRepository) ResetPassword(tokenHash string, passwdHash string, event *entity.SecurityEvent) (uuid.UUID, error) {
+	now := time.Now()
+	token, exists := m.Resets[tokenHash]
+	if !exists || token.UsedAt != nil || !token.ExpiresAt.After(now) {
+		return uuid.Nil, repository.ErrResetTokenInvalid
+	}
+	for _, other := range m.Resets {
+		if other.UserID == token.UserID && other.UsedAt == nil {
+			other.UsedAt = &now
+		}
+	}
+	if user, exists := m.Users[token.UserID]; exists {
+		user.PasswdHash = passwdHash
+	}
+	for id, session := range m.Sessions {
+		if session.UserID == token.UserID {
+			delete(m.Sessions, id)
+		}
+	}
+	event.UserID = token.UserID
+	m.Events = append(m.Events, *event)
+	return token.UserID, nil
+}
+
+func (m *MockUserRepository) MarkEmailVerified(userId uuid.UUID, at time.Time) error {
+	if user, exists := m.Users[userId]; exists {
+		user.EmailVerifiedAt = &at
+	}
+	return nil
+}
+
+func (m *MockUserRepository) MarkVerificationSent(userId uuid.UUID, at time.Time, since time.Time) (bool, error) {
+	user, exists := m.Users[userId]
+	if !exists {
+		return false, nil
+	}
+	if user.VerificationSentAt != nil && user.VerificationSentAt.After(since) {
+		return false, nil
+	}
+	user.VerificationSentAt = &at
+	return true, nil
+}
+
+func (m *MockUserRepository) ChangePassword(userId uuid.UUID, passwdHash string, keepSessionId uuid.UUID, event *entity.SecurityEvent) error {
+	if user, exists := m.Users[userId]; exists {
+		user.PasswdHash = passwdHash
+	}
+	for id, session := range m.Sessions {
+		if session.UserID == userId && id != keepSessionId {
+			delete(m.Sessions, id)
+		}
+	}
+	m.Events = append(m.Events, *event)
+	return nil
+}
+
+func (m *MockUserRepository) ChangeEmail(userId uuid.UUID, email string, verifiedAt time.Time, event *entity.SecurityEvent) error {
+	if _, taken := m.UserEmail[email]; taken {
+		return errors.New("duplicate key value violates unique constraint \"idx_email\"")
+	}
+	if data, exists := m.UserData[userId]; exists {
+		delete(m.UserEmail, data.Email)
+		data.Email = email
+		m.UserEmail[email] = userId
+	}
+	if user, exists := m.Users[userId]; exists {
+		user.EmailVerifiedAt = &verifiedAt
+	}
+	for id, session := range m.Sessions {
+		if session.UserID == userId {
+			delete(m.Sessions, id)
+		}
+	}
+	m.Events = append(m.Events, *event)
+	return nil
+}
+
+func (m *MockUserRepository) GetRoles() ([]entity.Role, error) {
+	roles := make([]entity.Role, 0, len(m.Roles))
+	for _, role := range m.Roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (m *MockUserRepository) GetRolesByNames(names []string) ([]entity.Role, error) {
+	roles := make([]entity.Role, 0, len(names))
+	for _, name := range names {
+		if role, exists := m.Roles[name]; exists {
+			roles = append(roles, role)
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (m *MockUserRepository) GetUserRoles(userId uuid.UUID) ([]entity.Role, error) {
+	user, exists := m.Users[userId]
+	if !exists {
+		return []entity.Role{}, nil
+	}
+	names := make([]string, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		names = append(names, role.Name)
+	}
+	return m.GetRolesByNames(names)
+}
+
+func (m *MockUserRepository) SetUserRoles(userId uuid.UUID, roles []entity.Role, event *entity.SecurityEvent) error {
+	user, exists := m.Users[userId]
+	if !exists {
+		return errors.New("user not found")
+	}
+	user.Roles = roles
+	m.Events = append(m.Events, *event)
+	return nil
+}
+
+func (m *MockUserRepository) CountUsersWithRole(name string) (int64, error) {
+	var count int64
+	for _, user := range m.Users {
+		if user.HasRole(name) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockUserRepository) GetTwoFactor(userId uuid.UUID) (*entity.TwoFactor, error) {
+	if twoFactor, exists := m.TwoFactor[userId]; exists {
+		copied := *twoFactor
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (m *MockUserRepository) SaveTwoFactor(twoFactor *entity.TwoFactor) error {
+	copied := *twoFactor
+	m.TwoFactor[twoFactor.UserID] = &copied
+	return nil
+}
+
+func (m *MockUserRepository) EnableTwoFactor(userId uuid.UUID, at time.Time, counter int64, codes []entity.RecoveryCode, keepSessionId uuid.UUID, event *entity.SecurityEvent) error {
+	twoFactor, exists := m.TwoFactor[userId]
+	if !exists || twoFactor.EnabledAt != nil {
+		return errors.New("record not found")
+	}
+	twoFactor.EnabledAt = &at
+	twoFactor.LastCounter = counter
+	twoFactor.FailedAttempts = 0
+	_ = m.ReplaceRecoveryCodes(userId, codes)
+	for id, session := range m.Sessions {
+		if session.UserID == userId && id != keepSessionId {
+			delete(m.Sessions, id)
+		}
+	}
+	m.Events = append(m.Events, *event)
+	return nil
+}
+
+func (m *MockUserRepository) DisableTwoFactor(userId uuid.UUID, event *entity.SecurityEvent) error {
+	delete(m.TwoFactor, userId)
+	_ = m.ReplaceRecoveryCodes(userId, nil)
+	m.Events = append(m.Events, *event)
+	return nil
+}
+
+func (m *MockUserRepository) UseTwoFactorCounter(userId uuid.UUID, counter int64) (bool, error) {
+	twoFactor, exists := m.TwoFactor[userId]
+	if !exists || twoFactor.LastCounter >= counter {
+		return false, nil
+	}
+	twoFactor.LastCounter = counter
+	twoFactor.FailedAttempts = 0
+	twoFactor.LockedUntil = nil
+	return true, nil
+}
+
+func (m *MockUserRepository) LoginFailed(userId uuid.UUID, maxAttempts int, lockout, maxLockout time.Duration, event *entity.SecurityEvent) (*time.Time, error) {
+	user, exists := m.Users[userId]
+	if !exists {
+		return nil, errors.New("user not found")
+	}
+	user.FailedLogins++
+	if user.FailedLogins < maxAttempts {
+		return nil, nil
+	}
+	until := time.Now().Add(user.NextLockout(lockout, maxLockout))
+	user.FailedLogins = 0
+	user.LoginLockouts++
+	user.LockedUntil = &until
+	m.Events = append(m.Events, *event)
+	return &until, nil
+}
+
+func (m *MockUserRepository) LoginSucceeded(userId uuid.UUID) error {
+	if user, exists := m.Users[userId]; exists {
+		user.FailedLogins = 0
+		user.LoginLockouts = 0
+		user.LockedUntil = nil
+	}
+	return nil
+}
+
+func (m *MockUserRepository) GetIdentity(provider, subject string) (*entity.Identity, error) {
+	if identity, exists := m.Identities[provider+":"+subject]; exists {
+		copied := *identity
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (m *MockUserRepository) GetUserIdentity(userId uuid.UUID, provider string) (*entity.Identity, error) {
+	for _, identity := range m.Identities {
+		if identity.UserID == userId && identity.Provider == provider {
+			copied := *identity
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockUserRepository) CountUserIdentities(userId uuid.UUID) (int64, error) {
+	var count int64
+	for _, identity := range m.Identities {
+		if identity.UserID == userId {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockUserRepository) LinkIdentity(identity *entity.Identity, event *entity.SecurityEvent) error {
+	key := identity.Provider + ":" + identity.Subject
+	if _, exists := m.Identities[key]; exists {
+		return errors.New("identity already linked")
+	}
+	for _, existing := range m.Identities {
+		if existing.UserID == identity.UserID && existing.Provider == identity.Provider {
+			return errors.New("provider already linked")
+		}
+	}
+	copied := *identity
+	m.Identities[key] = &copied
+	m.Events = append(m.Events, *event)
+	return nil
+}
+
+func (m *MockUserRepository) UnlinkIdentity(userId uuid.UUID, provider string, event *entity.SecurityEvent) error {
+	for key, identity := range m.Identities {
+		if identity.UserID == userId && identity.Provider == provider {
+			delete(m.Identities, key)
+			m.Events = append(m.Events, *event)
+			return nil
+		}
+	}
+	return errors.New("identity not found")
+}
+
+func (m *MockUserRepository) GetUserByTelegram(telegram string) (*entity.User, error) {
+	var found *entity.User
+	for id, data := range m.UserData {
+		if strings.EqualFold(data.Telegram, telegram) {
+			if found != nil {
+				return nil, nil
+			}
+			found = m.Users[id]
+		}
+	}
+	return found, nil
+}
+
+func (m *MockUserRepository) GetUsersByEmailFold(email string) ([]*entity.User, error) {
+	users := make([]*entity.User, 0)
+	for id, data := range m.UserData {
+		if strings.EqualFold(data.Email, email) {
+			users = append(users, m.Users[id])
+		}
+	}
+	return users, nil
+}
+
+func (m *MockUserRepository) CreateOAuthState(state *entity.OAuthState) error {
+	copied := *state
+	m.OAuth[state.StateHash] = &copied
+	return nil
+}
+
+func (m *MockUserRepository) ConsumeOAuthState(stateHash string) (*entity.OAuthState, error) {
+	state, exists := m.OAuth[stateHash]
+	delete(m.OAuth, stateHash)
+	if !exists || !state.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return state, nil
+}
+
+func (m *MockUserRepository) TwoFactorFailed(userId uuid.UUID, maxAttempts int, lockUntil time.Time) (bool, error) {
+	twoFactor, exists := m.TwoFactor[userId]
+	if !exists {
+		return false, nil
+	}
+	twoFactor.FailedAttempts++
+	if twoFactor.FailedAttempts >= maxAttempts {
+		twoFactor.FailedAttempts = 0
+		twoFactor.LockedUntil = &lockUntil
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *MockUserRepository) UseRecoveryCode(userId uuid.UUID, codeHash string, event *entity.SecurityEvent) (bool, error) {
+	code, exists := m.Recovery[codeHash]
+	if !exists || code.UserID != userId || code.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	code.UsedAt = &now
+	if twoFactor, exists := m.TwoFactor[userId]; exists {
+		twoFactor.FailedAttempts = 0
+		twoFactor.LockedUntil = nil
+	}
+	m.Events = append(m.Events, *event)
+	return true, nil
+}
+
+func (m *MockUserRepository) ReplaceRecoveryCodes(userId uuid.UUID, codes []entity.RecoveryCode) error {
+	for hash, code := range m.Recovery {
+		if code.UserID == userId {
+			delete(m.Recovery, hash)
+		}
+	}
+	for i := range codes {
+		code := codes[i]
+		m.Recovery[code.CodeHash] = &code
+	}
+	return nil
+}
+
+func (m *MockUserRepository) CountRecoveryCodes(userId uuid.UUID) (int64, error) {
+	var count int64
+	for _, code := range m.Recovery {
+		if code.UserID == userId && code.UsedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
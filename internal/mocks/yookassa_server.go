@@ -0,0 +1,345 @@
+package mocks
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"mzt/internal/dto"
+	"mzt/internal/money"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// FakeYooKassa локальный заменитель api YooKassa
+// умеет создавать и отдавать платежи, менять их статус и слать уведомления
+// подходит и для тестов через httptest, и для ручной проверки через cmd/fakeyookassa
+type FakeYooKassa struct {
+	ShopID     string
+	SecretKey  string
+	WebhookURL string
+	// статус с которым создаются возвраты, по умолчанию succeeded
+	RefundStatus string
+	// статус с которым проходят списания сохраненным способом оплаты, по умолчанию succeeded
+	RecurringStatus string
+
+	mu       sync.Mutex
+	payments map[string]*dto.YooPayment
+	refunds  map[string]*dto.YooRefund
+	receipts map[string]*dto.YooReceipt
+	methods  map[string]*dto.YooPaymentMethod
+	baseURL  string
+}
+
+func NewFakeYooKassa(shopID, secretKey string) *FakeYooKassa {
+	return &FakeYooKassa{
+		ShopID:    shopID,
+		SecretKey: secretKey,
+		payments:  make(map[string]*dto.YooPayment),
+		refunds:   make(map[string]*dto.YooRefund),
+		receipts:  make(map[string]*dto.YooReceipt),
+		methods:   make(map[string]*dto.YooPaymentMethod),
+	}
+}
+
+// NewFakeYooKassaServer запускает фейковый провайдер на случайном порту
+// адрес api для config.Equiring.APIURL - server.URL + "/v3"
+func NewFakeYooKassaServer(shopID, secretKey string) (*FakeYooKassa, *httptest.Server) {
+	fake := NewFakeYooKassa(shopID, secretKey)
+	server := httptest.NewServer(fake.Handler())
+	fake.baseURL = server.URL
+	return fake, server
+}
+
+// Handler отдает http обработчик с теми же путями что у настоящего api
+func (f *FakeYooKassa) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v3/payments", f.auth(f.createPayment))
+	mux.HandleFunc("GET /v3/payments/{id}", f.auth(f.getPayment))
+	mux.HandleFunc("POST /v3/refunds", f.auth(f.createRefund))
+	mux.HandleFunc("GET /v3/refunds/{id}", f.auth(f.getRefund))
+	// служебный путь: имитирует оплату или отмену и отправляет уведомление
+	mux.HandleFunc("POST /fake/payments/{id}/{status}", f.changeStatus)
+	return mux
+}
+
+// SetBaseURL задает адрес, который попадет в ссылку на оплату
+func (f *FakeYooKassa) SetBaseURL(url string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.baseURL = url
+}
+
+// Payment возвращает копию платежа
+func (f *FakeYooKassa) Payment(id string) (dto.YooPayment, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payment, ok := f.payments[id]
+	if !ok {
+		return dto.YooPayment{}, false
+	}
+	return *payment, true
+}
+
+// SetStatus меняет статус платежа, для succeeded платеж считается оплаченным
+func (f *FakeYooKassa) SetStatus(id, status string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payment, ok := f.payments[id]
+	if !ok {
+		return false
+	}
+	payment.Status = status
+	payment.Paid = status == "succeeded"
+	// чек регистрируется вместе с оплатой
+	if payment.ReceiptRegistration != "" && payment.Paid {
+		payment.ReceiptRegistration = "succeeded"
+	}
+	// способ оплаты сохраняется только после успешной оплаты
+	if payment.PaymentMethod != nil && payment.Paid {
+		payment.PaymentMethod.Saved = true
+		saved := *payment.PaymentMethod
+		f.methods[saved.ID] = &saved
+	}
+	return true
+}
+
+// Receipt возвращает чек, который пришел вместе с платежом
+func (f *FakeYooKassa) Receipt(id string) (*dto.YooReceipt, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	receipt, ok := f.receipts[id]
+	return receipt, ok
+}
+
+// Webhook собирает уведомление о платеже в формате YooKassa
+func (f *FakeYooKassa) Webhook(id string) dto.YooWebhook {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var webhook dto.YooWebhook
+	payment, ok := f.payments[id]
+	if !ok {
+		return webhook
+	}
+	webhook.Event = "payment." + payment.Status
+	webhook.Object.ID = payment.ID
+	webhook.Object.Status = payment.Status
+	webhook.Object.Metadata.UserID = payment.Metadata["user_id"]
+	webhook.Object.Metadata.CourseID = payment.Metadata["course_id"]
+	webhook.Object.Metadata.PaymentID = payment.Metadata["payment_id"]
+	return webhook
+}
+
+// Refund возвращает копию возврата
+func (f *FakeYooKassa) Refund(id string) (dto.YooRefund, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	refund, ok := f.refunds[id]
+	if !ok {
+		return dto.YooRefund{}, false
+	}
+	return *refund, true
+}
+
+// SetRefundStatus меняет статус возврата
+func (f *FakeYooKassa) SetRefundStatus(id, status string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	refund, ok := f.refunds[id]
+	if !ok {
+		return false
+	}
+	refund.Status = status
+	return true
+}
+
+// RefundWebhook собирает уведомление о возврате в формате YooKassa
+func (f *FakeYooKassa) RefundWebhook(id string) dto.YooWebhook {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var webhook dto.YooWebhook
+	refund, ok := f.refunds[id]
+	if !ok {
+		return webhook
+	}
+	webhook.Event = "refund." + refund.Status
+	webhook.Object.ID = refund.ID
+	webhook.Object.Status = refund.Status
+	webhook.Object.PaymentID = refund.PaymentID
+	return webhook
+}
+
+// проверяет basic auth магазина
+func (f *FakeYooKassa) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != f.ShopID || pass != f.SecretKey {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"type": "error", "code": "invalid_credentials"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (f *FakeYooKassa) createPayment(w http.ResponseWriter, r *http.Request) {
+	var req dto.PaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"type": "error", "code": "invalid_request"})
+		return
+	}
+	if req.Receipt != nil && !validReceipt(req.Receipt, req.Amount) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"type": "error", "code": "invalid_request", "parameter": "receipt"})
+		return
+	}
+
+	f.mu.Lock()
+	payment := &dto.YooPayment{
+		ID:       uuid.New().String(),
+		Status:   "pending",
+		Amount:   req.Amount,
+		Metadata: req.Metadata,
+	}
+	if req.Receipt != nil {
+		payment.ReceiptRegistration = "pending"
+		f.receipts[payment.ID] = req.Receipt
+	}
+	if req.SavePaymentMethod {
+		payment.PaymentMethod = &dto.YooPaymentMethod{Type: "bank_card", ID: uuid.New().String(), Title: "Bank card *4444"}
+	}
+
+	if req.PaymentMethodID != "" {
+		// автосписание: подтверждение не нужно, результат известен сразу
+		method, ok := f.methods[req.PaymentMethodID]
+		if !ok {
+			f.mu.Unlock()
+			writeJSON(w, http.StatusBadRequest, map[string]string{"type": "error", "code": "invalid_request", "parameter": "payment_method_id"})
+			return
+		}
+		saved := *method
+		payment.PaymentMethod = &saved
+		payment.Status = f.RecurringStatus
+		if payment.Status == "" {
+			payment.Status = "succeeded"
+		}
+		payment.Paid = payment.Status == "succeeded"
+		if payment.Paid && payment.ReceiptRegistration != "" {
+			payment.ReceiptRegistration = "succeeded"
+		}
+	} else {
+		payment.Confirmation.Type = "redirect"
+		payment.Confirmation.ConfirmationURL = f.baseURL + "/fake/checkout/" + payment.ID
+	}
+	f.payments[payment.ID] = payment
+	result := *payment
+	f.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (f *FakeYooKassa) getPayment(w http.ResponseWriter, r *http.Request) {
+	payment, ok := f.Payment(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"type": "error", "code": "not_found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, payment)
+}
+
+// createRefund проводит возврат только по оплаченному платежу и не больше его суммы
+func (f *FakeYooKassa) createRefund(w http.ResponseWriter, r *http.Request) {
+	var req dto.YooRefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"type": "error", "code": "invalid_request"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.payments[req.PaymentID]
+	if !ok || payment.Status != "succeeded" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"type": "error", "code": "invalid_request"})
+		return
+	}
+	if !req.Amount.SameCurrency(payment.Amount) || !req.Amount.IsPositive() || req.Amount.Minor > payment.Amount.Minor {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"type": "error", "code": "invalid_request"})
+		return
+	}
+
+	status := f.RefundStatus
+	if status == "" {
+		status = "succeeded"
+	}
+	refund := &dto.YooRefund{
+		ID:        uuid.New().String(),
+		PaymentID: payment.ID,
+		Status:    status,
+		Amount:    req.Amount,
+	}
+	f.refunds[refund.ID] = refund
+
+	writeJSON(w, http.StatusOK, *refund)
+}
+
+func (f *FakeYooKassa) getRefund(w http.ResponseWriter, r *http.Request) {
+	refund, ok := f.Refund(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"type": "error", "code": "not_found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, refund)
+}
+
+func (f *FakeYooKassa) changeStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !f.SetStatus(id, r.PathValue("status")) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"type": "error", "code": "not_found"})
+		return
+	}
+
+	// если задан адрес приложения - отправляем уведомление как настоящий провайдер
+	if f.WebhookURL != "" {
+		body, _ := json.Marshal(f.Webhook(id))
+		resp, err := http.Post(f.WebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		resp.Body.Close()
+		writeJSON(w, http.StatusOK, map[string]int{"webhook_status": resp.StatusCode})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// validReceipt проверяет чек так же как YooKassa: нужен контакт покупателя,
+// а сумма позиций должна совпадать с суммой платежа
+func validReceipt(receipt *dto.YooReceipt, amount money.Money) bool {
+	if receipt.Customer.Email == "" && receipt.Customer.Phone == "" || len(receipt.Items) == 0 {
+		return false
+	}
+
+	total := money.New(0, amount.Currency)
+	for _, item := range receipt.Items {
+		quantity, err := strconv.ParseFloat(item.Quantity, 64)
+		if err != nil || quantity <= 0 || item.VatCode == 0 || item.PaymentSubject == "" || item.PaymentMode == "" {
+			return false
+		}
+		if total, err = total.Add(money.New(int64(math.Round(float64(item.Amount.Minor)*quantity)), item.Amount.Currency)); err != nil {
+			return false
+		}
+	}
+	return total == amount
+}
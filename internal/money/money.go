@@ -0,0 +1,240 @@
+// пакет для работы с деньгами
+// суммы хранятся целым числом в минимальных единицах валюты (копейках, центах) вместе с кодом валюты ISO 4217
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// валюта по умолчанию, в ней были все суммы до появления мультивалютности
+const DefaultCurrency = "RUB"
+
+var (
+	// валюта не из списка поддерживаемых
+	ErrUnknownCurrency = errors.New("unknown currency")
+	// сумму не удалось разобрать или у нее больше знаков после точки чем у валюты
+	ErrInvalidAmount = errors.New("invalid amount")
+	// операция над суммами в разных валютах
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// количество знаков после точки для поддерживаемых валют ISO 4217
+var exponents = map[string]int{
+	"RUB": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CNY": 2,
+	"KZT": 2,
+	"BYN": 2,
+	"UAH": 2,
+	"UZS": 2,
+	"AMD": 2,
+	"GEL": 2,
+	"AZN": 2,
+	"KGS": 2,
+	"TRY": 2,
+	"AED": 2,
+	"JPY": 0,
+	"KRW": 0,
+}
+
+// Money сумма в минимальных единицах валюты
+// в сущностях встраивается через gorm:"embedded;embeddedPrefix:..." и дает колонки <prefix>minor и <prefix>currency
+type Money struct {
+	Minor    int64  `gorm:"column:minor;not null;default:0"`
+	Currency string `gorm:"column:currency;type:char(3);not null;default:'RUB'"`
+}
+
+// New создает сумму из минимальных единиц
+func New(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: strings.ToUpper(currency)}
+}
+
+// Parse разбирает десятичную запись суммы, например "299.90"
+// разбор идет без float, лишние знаки после точки - ошибка
+func Parse(value, currency string) (Money, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	exp, ok := exponents[currency]
+	if !ok {
+		return Money{}, ErrUnknownCurrency
+	}
+
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+
+	whole, frac, _ := strings.Cut(value, ".")
+	if whole == "" || len(frac) > exp || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, ErrInvalidAmount
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// ValidCurrency проверяет что валюта поддерживается
+func ValidCurrency(currency string) bool {
+	_, ok := exponents[strings.ToUpper(currency)]
+	return ok
+}
+
+// Exponent количество знаков после точки у валюты
+func Exponent(currency string) int {
+	if exp, ok := exponents[strings.ToUpper(currency)]; ok {
+		return exp
+	}
+	return 2
+}
+
+// String десятичная запись суммы без валюты, так ее ждут платежные провайдеры
+func (m Money) String() string {
+	exp := Exponent(m.Currency)
+	if exp == 0 {
+		return strconv.FormatInt(m.Minor, 10)
+	}
+
+	sign := ""
+	minor := m.Minor
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	scale := int64(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/scale, exp, minor%scale)
+}
+
+// IsZero сумма равна нулю
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// IsPositive сумма больше нуля
+func (m Money) IsPositive() bool {
+	return m.Minor > 0
+}
+
+// Valid сумма неотрицательная и в поддерживаемой валюте
+func (m Money) Valid() bool {
+	return m.Minor >= 0 && ValidCurrency(m.Currency)
+}
+
+// SameCurrency суммы в одной валюте
+func (m Money) SameCurrency(other Money) bool {
+	return m.Currency == other.Currency
+}
+
+// Add складывает суммы в одной валюте
+func (m Money) Add(other Money) (Money, error) {
+	if !m.SameCurrency(other) {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Minor: m.Minor + other.Minor, Currency: m.Currency}, nil
+}
+
+// Sub вычитает сумму в той же валюте
+func (m Money) Sub(other Money) (Money, error) {
+	if !m.SameCurrency(other) {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Minor: m.Minor - other.Minor, Currency: m.Currency}, nil
+}
+
+// Percent процент от суммы, округляется до минимальной единицы
+func (m Money) Percent(percent float64) Money {
+	return Money{Minor: int64(math.Round(float64(m.Minor) * percent / 100)), Currency: m.Currency}
+}
+
+// Mul сумма за count одинаковых позиций
+func (m Money) Mul(count int64) Money {
+	return Money{Minor: m.Minor * count, Currency: m.Currency}
+}
+
+// Prorate доля part/whole от суммы, округляется до минимальной единицы
+// считается без переполнения, даже если произведение не влезает в int64
+func (m Money) Prorate(part, whole int64) Money {
+	if whole <= 0 {
+		return Money{Currency: m.Currency}
+	}
+	n := new(big.Int).Mul(big.NewInt(m.Minor), big.NewInt(part))
+	n.Add(n, big.NewInt(whole/2))
+	n.Quo(n, big.NewInt(whole))
+	return Money{Minor: n.Int64(), Currency: m.Currency}
+}
+
+// Split делит сумму на parts равных частей без потери копеек
+// остаток от деления раскладывается по одной минимальной единице на первые части
+func (m Money) Split(parts int) []Money {
+	if parts <= 0 {
+		return nil
+	}
+	base := m.Minor / int64(parts)
+	rest := m.Minor % int64(parts)
+	result := make([]Money, parts)
+	for i := range result {
+		result[i] = Money{Minor: base, Currency: m.Currency}
+		if int64(i) < rest {
+			result[i].Minor++
+		}
+	}
+	return result
+}
+
+// сумма в json такая же как у YooKassa: {"value": "299.90", "currency": "RUB"}
+type jsonMoney struct {
+	Value    json.RawMessage `json:"value"`
+	Currency string          `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Value    string `json:"value"`
+		Currency string `json:"currency"`
+	}{Value: m.String(), Currency: m.Currency})
+}
+
+// UnmarshalJSON принимает value строкой или числом
+// если валюта не указана - берется валюта по умолчанию
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw jsonMoney
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Currency == "" {
+		raw.Currency = DefaultCurrency
+	}
+
+	value := strings.Trim(string(raw.Value), `"`)
+	if value == "" || value == "null" {
+		return ErrInvalidAmount
+	}
+
+	parsed, err := Parse(value, raw.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	m, err := Parse("299.9", "rub")
+	require.NoError(t, err)
+	assert.Equal(t, New(29990, "RUB"), m)
+	assert.Equal(t, "299.90", m.String())
+
+	m, err = Parse("1500", "JPY")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1500), m.Minor)
+	assert.Equal(t, "1500", m.String())
+
+	_, err = Parse("0.001", "USD")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+	_, err = Parse("1.5", "JPY")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+	_, err = Parse("abc", "RUB")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+	_, err = Parse("10", "XXX")
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+}
+
+func TestArithmetic(t *testing.T) {
+	price := New(29900, "RUB")
+
+	discount := price.Percent(10)
+	assert.Equal(t, New(2990, "RUB"), discount)
+
+	total, err := price.Sub(discount)
+	require.NoError(t, err)
+	assert.Equal(t, "269.10", total.String())
+
+	_, err = price.Add(New(100, "USD"))
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+	assert.Equal(t, "-1.05", New(-105, "RUB").String())
+
+	parts := New(10000, "RUB").Split(3)
+	assert.Equal(t, []Money{New(3334, "RUB"), New(3333, "RUB"), New(3333, "RUB")}, parts)
+	assert.Nil(t, price.Split(0))
+
+	assert.Equal(t, New(19933, "RUB"), price.Prorate(2, 3))
+	assert.Equal(t, New(0, "RUB"), price.Prorate(1, 0))
+
+	assert.Equal(t, New(89700, "RUB"), price.Mul(3))
+}
+
+func TestJSON(t *testing.T) {
+	data, err := json.Marshal(New(29990, "EUR"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"value":"299.90","currency":"EUR"}`, string(data))
+
+	var m Money
+	require.NoError(t, json.Unmarshal([]byte(`{"value":"299.90","currency":"EUR"}`), &m))
+	assert.Equal(t, New(29990, "EUR"), m)
+
+	// число без валюты - рубли
+	require.NoError(t, json.Unmarshal([]byte(`{"value":150}`), &m))
+	assert.Equal(t, New(15000, "RUB"), m)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"value":"1.234","currency":"RUB"}`), &m))
+	assert.Error(t, json.Unmarshal([]byte(`{"currency":"RUB"}`), &m))
+}
@@ -0,0 +1,223 @@
+// пакет для входа через внешние сервисы по OAuth2 authorization code с PKCE
+// сервис пользователей работает только с Provider и Profile и не знает чем сервисы отличаются
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mzt/config"
+	"mzt/internal/entity"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// имена сервисов, совпадают с provider в адресах и в таблице identities
+const (
+	Google = entity.ProviderGoogle
+	Yandex = entity.ProviderYandex
+	VK     = entity.ProviderVK
+)
+
+// как сервис отдает данные пользователя
+const (
+	// стандартный OpenID Connect userinfo
+	KindOIDC = "oidc"
+	// login.yandex.ru/info
+	KindYandex = "yandex"
+	// VK ID user_info
+	KindVK = "vk"
+)
+
+// ErrExchange сервис не отдал токен или данные пользователя
+var ErrExchange = errors.New("oauth provider rejected the request")
+
+var httpClient = &http.Client{Timeout: time.Second * 10}
+
+// Profile пользователь сервиса приведенный к общему виду
+type Profile struct {
+	// id пользователя в сервисе, не меняется
+	Subject string
+	Email   string
+	// сервис подтвердил что почта принадлежит пользователю
+	EmailVerified bool
+	Name          string
+	Username      string
+}
+
+// Callback то что сервис передал при возвращении пользователя
+type Callback struct {
+	Code  string
+	State string
+	// VK ID присылает id устройства, без него код не обменять
+	DeviceID string
+}
+
+// Provider приложение в одном внешнем сервисе
+type Provider struct {
+	Name         string
+	Kind         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	Scopes       []string
+	// куда сервис вернет пользователя с кодом
+	RedirectURL string
+}
+
+// Providers включенные в конфиге сервисы по имени
+func Providers(cfg *config.Config) map[string]*Provider {
+	providers := make(map[string]*Provider)
+	add := func(name, kind string, settings config.OAuthProvider, scopes ...string) {
+		if settings.ClientID == "" {
+			return
+		}
+		providers[name] = &Provider{
+			Name:         name,
+			Kind:         kind,
+			ClientID:     settings.ClientID,
+			ClientSecret: settings.ClientSecret,
+			AuthURL:      settings.AuthURL,
+			TokenURL:     settings.TokenURL,
+			UserInfoURL:  settings.UserInfoURL,
+			Scopes:       scopes,
+			RedirectURL:  strings.TrimRight(cfg.OAuth.RedirectURL, "/") + "/" + name,
+		}
+	}
+	add(Google, KindOIDC, cfg.OAuth.Google, "openid", "email", "profile")
+	add(Yandex, KindYandex, cfg.OAuth.Yandex, "login:email", "login:info")
+	add(VK, KindVK, cfg.OAuth.VK, "email")
+	return providers
+}
+
+// Names имена сервисов по алфавиту
+func Names(providers map[string]*Provider) []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewVerifier случайный code_verifier для PKCE
+func NewVerifier() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Challenge code_challenge для code_verifier по методу S256
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL адрес страницы входа в сервисе
+func (p *Provider) AuthCodeURL(state, verifier string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		separator = "&"
+	}
+	return p.AuthURL + separator + params.Encode()
+}
+
+// Exchange меняет код на access токен и по нему получает данные пользователя
+func (p *Provider) Exchange(ctx context.Context, callback *Callback, verifier string) (*Profile, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {callback.Code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	if p.Kind == KindVK {
+		form.Set("device_id", callback.DeviceID)
+		form.Set("state", callback.State)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := p.post(ctx, p.TokenURL, form, "", &token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("%w: empty access token", ErrExchange)
+	}
+
+	switch p.Kind {
+	case KindYandex:
+		return p.yandexProfile(ctx, token.AccessToken)
+	case KindVK:
+		return p.vkProfile(ctx, token.AccessToken)
+	default:
+		return p.oidcProfile(ctx, token.AccessToken)
+	}
+}
+
+// отправляет форму и разбирает json ответ
+func (p *Provider) post(ctx context.Context, endpoint string, form url.Values, authorization string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return p.do(req, out)
+}
+
+// запрашивает json по GET
+func (p *Provider) get(ctx context.Context, endpoint string, authorization string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	return p.do(req, out)
+}
+
+func (p *Provider) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s %s returned %d: %s", ErrExchange, p.Name, req.URL.Path, resp.StatusCode, body)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrExchange, p.Name, err)
+	}
+	return nil
+}
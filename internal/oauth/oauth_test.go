@@ -0,0 +1,116 @@
+package oauth
+
+import (
+	"context"
+	"mzt/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChallenge(t *testing.T) {
+	verifier, err := NewVerifier()
+	require.NoError(t, err)
+	assert.Len(t, verifier, 43)
+
+	// base64url без паддинга от sha256
+	challenge := Challenge(verifier)
+	assert.Len(t, challenge, 43)
+	assert.NotContains(t, challenge, "=")
+	assert.Equal(t, challenge, Challenge(verifier))
+	assert.NotEqual(t, challenge, Challenge(verifier+"x"))
+}
+
+func TestProviders(t *testing.T) {
+	cfg := &config.Config{OAuth: config.OAuth{
+		RedirectURL: "https://mzt-study.ru/oauth/callback/",
+		Google:      config.OAuthProvider{ClientID: "google-id", AuthURL: "https://accounts.example.com/auth"},
+		Yandex:      config.OAuthProvider{ClientID: "yandex-id", AuthURL: "https://oauth.example.com/authorize?force_confirm=yes"},
+	}}
+	providers := Providers(cfg)
+	// без ClientID сервис выключен
+	assert.Equal(t, []string{Google, Yandex}, Names(providers))
+
+	link, err := url.Parse(providers[Google].AuthCodeURL("state-1", "verifier"))
+	require.NoError(t, err)
+	query := link.Query()
+	assert.Equal(t, "google-id", query.Get("client_id"))
+	assert.Equal(t, "https://mzt-study.ru/oauth/callback/google", query.Get("redirect_uri"))
+	assert.Equal(t, "openid email profile", query.Get("scope"))
+	assert.Equal(t, "state-1", query.Get("state"))
+	assert.Equal(t, Challenge("verifier"), query.Get("code_challenge"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	link, err = url.Parse(providers[Yandex].AuthCodeURL("state-2", "verifier"))
+	require.NoError(t, err)
+	assert.Equal(t, "yes", link.Query().Get("force_confirm"))
+	assert.Equal(t, "state-2", link.Query().Get("state"))
+}
+
+// сервис который отдает токен и данные пользователя в формате нужного провайдера
+func providerServer(t *testing.T, kind string) (*Provider, *httptest.Server) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "code-1", r.PostForm.Get("code"))
+		assert.Equal(t, "verifier", r.PostForm.Get("code_verifier"))
+		if kind == KindVK {
+			assert.Equal(t, "device-1", r.PostForm.Get("device_id"))
+		}
+		w.Write([]byte(`{"access_token":"token-1","token_type":"Bearer"}`))
+	})
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		switch kind {
+		case KindYandex:
+			assert.Equal(t, "OAuth token-1", r.Header.Get("Authorization"))
+			assert.Equal(t, "json", r.URL.Query().Get("format"))
+			w.Write([]byte(`{"id":"1000","login":"ivan.petrov","default_email":"Ivan@yandex.ru","real_name":"Иван Петров"}`))
+		case KindVK:
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "token-1", r.PostForm.Get("access_token"))
+			w.Write([]byte(`{"user":{"user_id":2000,"first_name":"Иван","last_name":"Петров","email":"ivan@mail.ru"}}`))
+		default:
+			assert.Equal(t, "Bearer token-1", r.Header.Get("Authorization"))
+			w.Write([]byte(`{"sub":"3000","email":"ivan@gmail.com","email_verified":"true","name":"Ivan Petrov"}`))
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return &Provider{
+		Name:        kind,
+		Kind:        kind,
+		ClientID:    "client",
+		TokenURL:    server.URL + "/token",
+		UserInfoURL: server.URL + "/info",
+	}, server
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	callback := &Callback{Code: "code-1", State: "state-1", DeviceID: "device-1"}
+
+	provider, _ := providerServer(t, KindOIDC)
+	profile, err := provider.Exchange(context.Background(), callback, "verifier")
+	require.NoError(t, err)
+	assert.Equal(t, &Profile{Subject: "3000", Email: "ivan@gmail.com", EmailVerified: true, Name: "Ivan Petrov"}, profile)
+
+	provider, _ = providerServer(t, KindYandex)
+	profile, err = provider.Exchange(context.Background(), callback, "verifier")
+	require.NoError(t, err)
+	assert.Equal(t, &Profile{Subject: "1000", Email: "ivan@yandex.ru", EmailVerified: true, Name: "Иван Петров", Username: "ivan.petrov"}, profile)
+
+	// почте из VK не доверяем
+	provider, _ = providerServer(t, KindVK)
+	profile, err = provider.Exchange(context.Background(), callback, "verifier")
+	require.NoError(t, err)
+	assert.Equal(t, &Profile{Subject: "2000", Email: "ivan@mail.ru", Name: "Иван Петров"}, profile)
+
+	// сервис отказал
+	provider.TokenURL = provider.UserInfoURL + "/missing"
+	_, err = provider.Exchange(context.Background(), callback, "verifier")
+	assert.ErrorIs(t, err, ErrExchange)
+}
@@ -0,0 +1,96 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// данные пользователя по OpenID Connect, так отдают Google и фейковый провайдер
+// id_token не разбираем: userinfo по https с access токеном дает те же данные
+func (p *Provider) oidcProfile(ctx context.Context, accessToken string) (*Profile, error) {
+	var info struct {
+		Subject           string          `json:"sub"`
+		Email             string          `json:"email"`
+		EmailVerified     json.RawMessage `json:"email_verified"`
+		Name              string          `json:"name"`
+		PreferredUsername string          `json:"preferred_username"`
+	}
+	if err := p.get(ctx, p.UserInfoURL, "Bearer "+accessToken, &info); err != nil {
+		return nil, err
+	}
+	if info.Subject == "" {
+		return nil, fmt.Errorf("%w: %s: empty subject", ErrExchange, p.Name)
+	}
+
+	// некоторые провайдеры отдают email_verified строкой
+	verified := strings.Trim(string(info.EmailVerified), `"`) == "true"
+	return &Profile{
+		Subject:       info.Subject,
+		Email:         strings.ToLower(info.Email),
+		EmailVerified: info.Email != "" && verified,
+		Name:          info.Name,
+		Username:      info.PreferredUsername,
+	}, nil
+}
+
+// данные пользователя Яндекс ID
+// почта по умолчанию - это ящик на Яндексе или адрес, который Яндекс подтвердил
+func (p *Provider) yandexProfile(ctx context.Context, accessToken string) (*Profile, error) {
+	var info struct {
+		ID           string `json:"id"`
+		Login        string `json:"login"`
+		DefaultEmail string `json:"default_email"`
+		RealName     string `json:"real_name"`
+		DisplayName  string `json:"display_name"`
+	}
+	endpoint := p.UserInfoURL + "?" + url.Values{"format": {"json"}}.Encode()
+	if err := p.get(ctx, endpoint, "OAuth "+accessToken, &info); err != nil {
+		return nil, err
+	}
+	if info.ID == "" {
+		return nil, fmt.Errorf("%w: %s: empty subject", ErrExchange, p.Name)
+	}
+
+	name := info.RealName
+	if name == "" {
+		name = info.DisplayName
+	}
+	return &Profile{
+		Subject:       info.ID,
+		Email:         strings.ToLower(info.DefaultEmail),
+		EmailVerified: info.DefaultEmail != "",
+		Name:          name,
+		Username:      info.Login,
+	}, nil
+}
+
+// данные пользователя VK ID
+// что почта подтверждена VK не гарантирует, поэтому по ней аккаунты не связываем
+func (p *Provider) vkProfile(ctx context.Context, accessToken string) (*Profile, error) {
+	var info struct {
+		User struct {
+			UserID    json.Number `json:"user_id"`
+			FirstName string      `json:"first_name"`
+			LastName  string      `json:"last_name"`
+			Email     string      `json:"email"`
+		} `json:"user"`
+	}
+	form := url.Values{"client_id": {p.ClientID}, "access_token": {accessToken}}
+	if err := p.post(ctx, p.UserInfoURL, form, "", &info); err != nil {
+		return nil, err
+	}
+	subject := info.User.UserID.String()
+	if _, err := strconv.ParseInt(subject, 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: %s: invalid user id %q", ErrExchange, p.Name, subject)
+	}
+
+	return &Profile{
+		Subject: subject,
+		Email:   strings.ToLower(info.User.Email),
+		Name:    strings.TrimSpace(info.User.FirstName + " " + info.User.LastName),
+	}, nil
+}
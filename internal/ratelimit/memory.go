@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory счетчики в памяти процесса
+// при перезапуске счетчики обнуляются, истекшие окна периодически вычищаются
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	count   int
+	resetAt time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (m *Memory) Name() string {
+	return "memory"
+}
+
+func (m *Memory) Hit(key string, window time.Duration) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now, window)
+
+	b, exists := m.buckets[key]
+	if !exists || !now.Before(b.resetAt) {
+		b = &bucket{resetAt: now.Add(window)}
+		m.buckets[key] = b
+	}
+	b.count++
+	return b.count, b.resetAt, nil
+}
+
+// удаляет истекшие окна не чаще раза в окно, чтобы карта не росла от разовых адресов
+func (m *Memory) sweep(now time.Time, window time.Duration) {
+	if now.Before(m.nextSweep) {
+		return
+	}
+	for key, b := range m.buckets {
+		if !now.Before(b.resetAt) {
+			delete(m.buckets, key)
+		}
+	}
+	m.nextSweep = now.Add(window)
+}
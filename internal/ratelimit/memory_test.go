@@ -0,0 +1,35 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_Hit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemory()
+	store.now = func() time.Time { return now }
+
+	for i := 1; i <= 3; i++ {
+		count, resetAt, err := store.Hit("signin:ip:127.0.0.1", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, i, count)
+		assert.Equal(t, now.Add(time.Minute), resetAt)
+	}
+
+	// у другого ключа свой счетчик
+	count, _, err := store.Hit("signin:ip:10.0.0.1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// после окна счет начинается заново, истекшие окна вычищаются
+	now = now.Add(time.Minute)
+	count, resetAt, err := store.Hit("signin:ip:127.0.0.1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, now.Add(time.Minute), resetAt)
+	assert.Len(t, store.buckets, 1)
+}
@@ -0,0 +1,30 @@
+// пакет для ограничения частоты запросов
+// middleware работает только с интерфейсом Store и не знает где лежат счетчики
+package ratelimit
+
+import (
+	"fmt"
+	"mzt/config"
+	"time"
+)
+
+// Store хранилище счетчиков запросов
+// счетчик живет одно окно: первый запрос открывает окно, по его окончании счет начинается заново
+// для нескольких экземпляров приложения нужна общая реализация, например в Redis через INCR и PEXPIRE
+type Store interface {
+	// имя хранилища, совпадает со значением RATE_LIMIT_STORE
+	Name() string
+	// засчитывает запрос по ключу, возвращает сколько запросов было в текущем окне и когда окно закончится
+	Hit(key string, window time.Duration) (int, time.Time, error)
+}
+
+// New выбирает хранилище счетчиков по имени из конфига
+// memory - в памяти процесса, подходит пока приложение запущено в одном экземпляре
+func New(cfg *config.Config) (Store, error) {
+	switch cfg.RateLimit.Store {
+	case "", "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", cfg.RateLimit.Store)
+	}
+}
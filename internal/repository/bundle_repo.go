@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// интерфейс для работы с наборами курсов
+// определяет все методы которые нужны для работы с наборами в базе
+type BundleRepository interface {
+	CreateBundle(bundle *entity.Bundle) error
+	GetBundles(onlyActive bool) ([]entity.Bundle, error)
+	GetBundleByID(bundleID uuid.UUID) (*entity.Bundle, error)
+	UpdateBundle(bundle *entity.Bundle) error
+}
+
+// репозиторий для работы с наборами курсов
+// реализует интерфейс BundleRepository
+type BundleRepo struct {
+	config *config.Config
+	DB     *gorm.DB
+}
+
+func NewBundleRepo(cfg *config.Config) *BundleRepo {
+	return &BundleRepo{
+		config: cfg,
+		DB:     connectDB(cfg),
+	}
+}
+
+// CreateBundle создает набор вместе со списком курсов
+func (r *BundleRepo) CreateBundle(bundle *entity.Bundle) error {
+	return r.DB.Create(bundle).Error
+}
+
+// GetBundles получает наборы, новые сначала
+// если onlyActive - только те которые сейчас продаются
+func (r *BundleRepo) GetBundles(onlyActive bool) ([]entity.Bundle, error) {
+	var bundles []entity.Bundle
+	query := r.DB.Preload("Courses").Order("created_at DESC")
+	if onlyActive {
+		query = query.Where("active = ?", true)
+	}
+	if err := query.Find(&bundles).Error; err != nil {
+		return nil, err
+	}
+	return bundles, nil
+}
+
+// GetBundleByID получает набор по id
+func (r *BundleRepo) GetBundleByID(bundleID uuid.UUID) (*entity.Bundle, error) {
+	var bundle entity.Bundle
+	if err := r.DB.Preload("Courses").Where("bundle_id = ?", bundleID).First(&bundle).Error; err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+// UpdateBundle обновляет набор
+// список курсов заменяется целиком, уже выданный покупками доступ не меняется
+func (r *BundleRepo) UpdateBundle(bundle *entity.Bundle) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&entity.Bundle{}).Where("bundle_id = ?", bundle.BundleID).Updates(map[string]interface{}{
+			"title":           bundle.Title,
+			"description":     bundle.Description,
+			"amount_minor":    bundle.Amount.Minor,
+			"amount_currency": bundle.Amount.Currency,
+			"active":          bundle.Active,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("bundle_id = ?", bundle.BundleID).Delete(&entity.BundleCourse{}).Error; err != nil {
+			return err
+		}
+		if len(bundle.Courses) == 0 {
+			return nil
+		}
+		return tx.Create(&bundle.Courses).Error
+	})
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"mzt/internal/money"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleRepository(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := &config.Config{DB: config.DB{
+		Host:     "localhost",
+		Port:     "5433",
+		User:     "postgres",
+		Password: "postgres",
+		Name:     "mzt_test",
+	}}
+	repo := NewBundleRepo(cfg)
+	repo.DB = db
+	courseRepo := NewCourseRepo(cfg)
+	courseRepo.DB = db
+
+	courses := []*entity.Course{
+		{CourseID: uuid.New(), Title: "First Course"},
+		{CourseID: uuid.New(), Title: "Second Course"},
+	}
+	for _, course := range courses {
+		require.NoError(t, db.Create(course).Error)
+	}
+	user := &entity.User{ID: uuid.New(), PasswdHash: "test_hash"}
+	require.NoError(t, db.Create(user).Error)
+
+	bundle := &entity.Bundle{
+		BundleID: uuid.New(),
+		Title:    "2 курса",
+		Amount:   money.New(3990000, "RUB"),
+		Active:   true,
+	}
+	bundle.Courses = []entity.BundleCourse{
+		{BundleID: bundle.BundleID, CourseID: courses[0].CourseID},
+		{BundleID: bundle.BundleID, CourseID: courses[1].CourseID},
+	}
+
+	t.Run("Create And Get Bundle", func(t *testing.T) {
+		require.NoError(t, repo.CreateBundle(bundle))
+
+		got, err := repo.GetBundleByID(bundle.BundleID)
+		require.NoError(t, err)
+		assert.Equal(t, bundle.Amount, got.Amount)
+		require.Len(t, got.Courses, 2)
+
+		bundle.Courses = bundle.Courses[:1]
+		require.NoError(t, repo.UpdateBundle(bundle))
+		got, err = repo.GetBundleByID(bundle.BundleID)
+		require.NoError(t, err)
+		assert.Len(t, got.Courses, 1)
+	})
+
+	t.Run("Enroll In Bundle", func(t *testing.T) {
+		// купленный отдельно курс не становится курсом из набора
+		require.NoError(t, courseRepo.CreateCourseAssignment(&entity.CourseAssignment{CaID: uuid.New(), UserID: user.ID, CourseID: courses[0].CourseID}))
+
+		courseIDs := []uuid.UUID{courses[0].CourseID, courses[1].CourseID}
+		require.NoError(t, courseRepo.EnrollInBundle(user.ID, bundle.BundleID, courseIDs))
+
+		owned, err := courseRepo.GetCourseAssignment(courses[0].CourseID, user.ID)
+		require.NoError(t, err)
+		assert.Nil(t, owned.BundleID)
+		fromBundle, err := courseRepo.GetCourseAssignment(courses[1].CourseID, user.ID)
+		require.NoError(t, err)
+		require.NotNil(t, fromBundle.BundleID)
+		assert.Equal(t, bundle.BundleID, *fromBundle.BundleID)
+
+		require.NoError(t, courseRepo.DeleteBundleAssignments(bundle.BundleID, user.ID))
+		_, err = courseRepo.GetCourseAssignment(courses[1].CourseID, user.ID)
+		assert.Error(t, err)
+		_, err = courseRepo.GetCourseAssignment(courses[0].CourseID, user.ID)
+		assert.NoError(t, err)
+	})
+}
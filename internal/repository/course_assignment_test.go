@@ -33,7 +33,7 @@ func TestCourseAssignmentRepository(t *testing.T) {
 		user := &entity.User{
 			ID:         uuid.New(),
 			PasswdHash: "test_hash",
-			Role:       1,
+			Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 		}
 		err = db.Create(user).Error
 		require.NoError(t, err)
@@ -68,7 +68,7 @@ func TestCourseAssignmentRepository(t *testing.T) {
 		user := &entity.User{
 			ID:         uuid.New(),
 			PasswdHash: "test_hash",
-			Role:       1,
+			Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 		}
 		err = db.Create(user).Error
 		require.NoError(t, err)
@@ -104,7 +104,7 @@ func TestCourseAssignmentRepository(t *testing.T) {
 		user := &entity.User{
 			ID:         uuid.New(),
 			PasswdHash: "test_hash",
-			Role:       1,
+			Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 		}
 		err = db.Create(user).Error
 		require.NoError(t, err)
@@ -139,12 +139,12 @@ func TestCourseAssignmentRepository(t *testing.T) {
 			{
 				ID:         uuid.New(),
 				PasswdHash: "test_hash1",
-				Role:       1,
+				Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 			},
 			{
 				ID:         uuid.New(),
 				PasswdHash: "test_hash2",
-				Role:       1,
+				Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 			},
 		}
 
@@ -1,12 +1,14 @@
 package repository
 
 import (
+	"errors"
 	"mzt/config"
 	"mzt/internal/dto"
 	"mzt/internal/entity"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // интерфейс для работы с курсами
@@ -28,6 +30,9 @@ type CourseRepository interface {
 	GetCourseAssignment(courseId, userId uuid.UUID) (*entity.CourseAssignment, error)
 	UpdateCourseAssignment(assignment *entity.CourseAssignment) error
 	DeleteCourseAssignment(courseId uuid.UUID, userId uuid.UUID) error
+	DeleteSubscriptionAssignments(subscriptionId uuid.UUID) error
+	EnrollInBundle(userId, bundleId uuid.UUID, courseIds []uuid.UUID) error
+	DeleteBundleAssignments(bundleId, userId uuid.UUID) error
 }
 
 // репозиторий для работы с курсами
@@ -88,9 +93,8 @@ func (r *CourseRepo) UpdateCourse(courseId uuid.UUID, updated *dto.UpdateCourseD
 		if err == gorm.ErrRecordNotFound {
 			// если цены нет - создаем новую
 			price = entity.CoursePrice{
-				CourseID:     courseId,
-				Amount:       float64(updated.Price),
-				CurrencyCode: "RUB",
+				CourseID: courseId,
+				Amount:   updated.Price,
 			}
 			if err := tx.Create(&price).Error; err != nil {
 				tx.Rollback()
@@ -102,7 +106,7 @@ func (r *CourseRepo) UpdateCourse(courseId uuid.UUID, updated *dto.UpdateCourseD
 		}
 	} else {
 		// если цена есть - обновляем
-		price.Amount = float64(updated.Price)
+		price.Amount = updated.Price
 		if err := tx.Save(&price).Error; err != nil {
 			tx.Rollback()
 			return err
@@ -171,8 +175,7 @@ func (r *CourseRepo) GetCourse(courseId uuid.UUID) (*dto.CourseDto, error) {
 	}
 	// если у курса есть цена добавляем ее в response
 	if course.Price != nil {
-		result.Price.Amount = course.Price.Amount
-		result.Price.CurrencyCode = course.Price.CurrencyCode
+		result.Price = course.Price.Amount
 	}
 	return result, nil
 }
@@ -206,8 +209,7 @@ func (r *CourseRepo) GetCourses() ([]dto.CourseDto, error) {
 		}
 		// если у курса есть цена добавляем ее в response
 		if course.Price != nil {
-			courseDto.Price.Amount = course.Price.Amount
-			courseDto.Price.CurrencyCode = course.Price.CurrencyCode
+			courseDto.Price = course.Price.Amount
 		}
 		result = append(result, courseDto)
 	}
@@ -241,7 +243,7 @@ func (r *CourseRepo) GetCourseAssignment(courseId, userId uuid.UUID) (*entity.Co
 }
 
 // UpdateCourseAssignment обновляет прогресс пользователя по курсу
-// меняет значение прогресса в базе
+// меняет значение прогресса, подписку или набор которыми выдан доступ и приостановку доступа
 func (r *CourseRepo) UpdateCourseAssignment(assignment *entity.CourseAssignment) error {
 	// начинаем транзакцию чтобы все изменения сохранились вместе
 	tx := r.DB.Begin()
@@ -258,6 +260,9 @@ func (r *CourseRepo) UpdateCourseAssignment(assignment *entity.CourseAssignment)
 
 	// обновляем прогресс
 	existingAssignment.Progress = assignment.Progress
+	existingAssignment.SubscriptionID = assignment.SubscriptionID
+	existingAssignment.BundleID = assignment.BundleID
+	existingAssignment.Suspended = assignment.Suspended
 
 	// сохраняем изменения
 	if err := tx.Save(&existingAssignment).Error; err != nil {
@@ -275,6 +280,12 @@ func (r *CourseRepo) DeleteCourseAssignment(courseId, userId uuid.UUID) error {
 	return r.DB.Where("course_id = ? AND user_id = ?", courseId, userId).Delete(&entity.CourseAssignment{}).Error
 }
 
+// DeleteSubscriptionAssignments удаляет все записи на курсы выданные подпиской
+// купленные курсы не трогаются
+func (r *CourseRepo) DeleteSubscriptionAssignments(subscriptionId uuid.UUID) error {
+	return r.DB.Where("subscription_id = ?", subscriptionId).Delete(&entity.CourseAssignment{}).Error
+}
+
 // GetCourseAssignmentsByCourseId получает список всех записей на курс
 // ищет все записи в базе по id курса
 func (r *CourseRepo) GetCourseAssignmentsByCourseId(courseId uuid.UUID) ([]entity.CourseAssignment, error) {
@@ -314,3 +325,72 @@ func (r *CourseRepo) GetUserWithDataById(userId uuid.UUID) (*entity.User, error)
 	}
 	return &user, nil
 }
+
+// EnrollInBundle записывает пользователя на все курсы набора в одной транзакции
+// курсы из подписки или с приостановленным доступом становятся купленными, уже купленные не меняются
+func (r *CourseRepo) EnrollInBundle(userId, bundleId uuid.UUID, courseIds []uuid.UUID) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		for _, courseId := range courseIds {
+			var assignment entity.CourseAssignment
+			err := tx.Where("course_id = ? AND user_id = ?", courseId, userId).First(&assignment).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				assignment = entity.CourseAssignment{
+					CaID:     uuid.New(),
+					UserID:   userId,
+					CourseID: courseId,
+					BundleID: &bundleId,
+				}
+				if err := tx.Omit(clause.Associations).Create(&assignment).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if assignment.SubscriptionID == nil && !assignment.Suspended {
+				continue
+			}
+			assignment.SubscriptionID = nil
+			assignment.Suspended = false
+			assignment.BundleID = &bundleId
+			if err := tx.Omit(clause.Associations).Save(&assignment).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// записывает пользователя на курс как на купленный внутри уже открытой транзакции
+// курс из подписки, набора или с приостановленным доступом становится купленным
+func assignPurchasedCourse(tx *gorm.DB, courseId, userId uuid.UUID) error {
+	var assignment entity.CourseAssignment
+	err := tx.Where("course_id = ? AND user_id = ?", courseId, userId).First(&assignment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		assignment = entity.CourseAssignment{
+			CaID:     uuid.New(),
+			UserID:   userId,
+			CourseID: courseId,
+		}
+		return tx.Omit(clause.Associations).Create(&assignment).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	if assignment.SubscriptionID == nil && assignment.BundleID == nil && !assignment.Suspended {
+		return nil
+	}
+	assignment.SubscriptionID = nil
+	assignment.BundleID = nil
+	assignment.Suspended = false
+	return tx.Omit(clause.Associations).Save(&assignment).Error
+}
+
+// DeleteBundleAssignments удаляет записи на курсы выданные пользователю покупкой набора
+// курсы купленные отдельно не трогаются
+func (r *CourseRepo) DeleteBundleAssignments(bundleId, userId uuid.UUID) error {
+	return r.DB.Where("bundle_id = ? AND user_id = ?", bundleId, userId).Delete(&entity.CourseAssignment{}).Error
+}
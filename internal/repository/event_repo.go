@@ -172,7 +172,8 @@ func (r *EventRepo) GetEventsByCourseId(courseId uuid.UUID) ([]dto.EventDto, err
 func (r *EventRepo) GetEventsWithSecretsByUserId(userId uuid.UUID) ([]dto.EventDto, error) {
 	// получаем все курсы пользователя
 	var courseAssignments []entity.CourseAssignment
-	if err := r.DB.Where("user_id = ?", userId).Find(&courseAssignments).Error; err != nil {
+	// курсы с приостановленным доступом не учитываем
+	if err := r.DB.Where("user_id = ? AND suspended = ?", userId, false).Find(&courseAssignments).Error; err != nil {
 		return nil, err
 	}
 
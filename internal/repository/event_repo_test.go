@@ -184,7 +184,7 @@ func TestEventRepository(t *testing.T) {
 		user := &entity.User{
 			ID:         userID,
 			PasswdHash: "test_hash",
-			Role:       0,
+			Roles:      []entity.Role{{Name: entity.RoleStudent}},
 		}
 		err := db.Create(user).Error
 		require.NoError(t, err)
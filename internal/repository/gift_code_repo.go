@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"errors"
+	"mzt/config"
+	"mzt/internal/entity"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrGiftCodeUnavailable код уже активирован, отозван или истек
+var ErrGiftCodeUnavailable = errors.New("gift code is no longer available")
+
+// интерфейс для работы с подарочными кодами
+// определяет все методы которые нужны для выпуска и активации кодов доступа к курсам
+type GiftCodeRepository interface {
+	CreateGiftCodes(codes []entity.GiftCode) error
+	GetGiftCodes(batchID, courseID *uuid.UUID) ([]entity.GiftCode, error)
+	GetGiftCodeByCode(code string) (*entity.GiftCode, error)
+	GetGiftCodeByPaymentID(paymentID uuid.UUID) (*entity.GiftCode, error)
+	GetPurchasedGiftCodes(userID uuid.UUID) ([]entity.GiftCode, error)
+	RedeemGiftCode(giftCodeID, userID uuid.UUID, at time.Time) error
+	RevokeGiftCode(giftCodeID uuid.UUID, at time.Time) error
+}
+
+// репозиторий для работы с подарочными кодами
+// реализует интерфейс GiftCodeRepository
+type GiftCodeRepo struct {
+	config *config.Config
+	DB     *gorm.DB
+}
+
+func NewGiftCodeRepo(cfg *config.Config) *GiftCodeRepo {
+	return &GiftCodeRepo{
+		config: cfg,
+		DB:     connectDB(cfg),
+	}
+}
+
+// CreateGiftCodes сохраняет выпущенные коды одной пачкой
+func (r *GiftCodeRepo) CreateGiftCodes(codes []entity.GiftCode) error {
+	if len(codes) == 0 {
+		return nil
+	}
+	return r.DB.Omit(clause.Associations).Create(&codes).Error
+}
+
+// GetGiftCodes получает коды вместе с теми кто их активировал, новые сначала
+// если передан batchID или courseID - только коды из этой пачки или на этот курс
+func (r *GiftCodeRepo) GetGiftCodes(batchID, courseID *uuid.UUID) ([]entity.GiftCode, error) {
+	var codes []entity.GiftCode
+	query := r.DB.Preload("Course").Preload("RedeemedBy.UserData").Order("created_at DESC, code")
+	if batchID != nil {
+		query = query.Where("batch_id = ?", *batchID)
+	}
+	if courseID != nil {
+		query = query.Where("course_id = ?", *courseID)
+	}
+	if err := query.Find(&codes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// GetGiftCodeByCode получает код по его значению
+func (r *GiftCodeRepo) GetGiftCodeByCode(code string) (*entity.GiftCode, error) {
+	var giftCode entity.GiftCode
+	if err := r.DB.Preload("Course").Where("code = ?", code).First(&giftCode).Error; err != nil {
+		return nil, err
+	}
+	return &giftCode, nil
+}
+
+// GetGiftCodeByPaymentID получает код выпущенный по оплаченному подарку
+func (r *GiftCodeRepo) GetGiftCodeByPaymentID(paymentID uuid.UUID) (*entity.GiftCode, error) {
+	var giftCode entity.GiftCode
+	if err := r.DB.Where("payment_id = ?", paymentID).First(&giftCode).Error; err != nil {
+		return nil, err
+	}
+	return &giftCode, nil
+}
+
+// GetPurchasedGiftCodes получает коды из подарков которые купил пользователь, новые сначала
+func (r *GiftCodeRepo) GetPurchasedGiftCodes(userID uuid.UUID) ([]entity.GiftCode, error) {
+	var codes []entity.GiftCode
+	err := r.DB.Preload("Course").Preload("RedeemedBy.UserData").
+		Where("issued_by_id = ? AND payment_id IS NOT NULL", userID).
+		Order("created_at DESC").Find(&codes).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RedeemGiftCode активирует код и записывает пользователя на курс в одной транзакции
+// курс из подписки, набора или с приостановленным доступом становится купленным
+func (r *GiftCodeRepo) RedeemGiftCode(giftCodeID, userID uuid.UUID, at time.Time) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		// код забирает только первый кто успел, даже если активируют одновременно
+		result := tx.Model(&entity.GiftCode{}).
+			Where("gift_code_id = ? AND redeemed_by_id IS NULL AND revoked_at IS NULL", giftCodeID).
+			Where("expires_at IS NULL OR expires_at > ?", at).
+			Updates(map[string]interface{}{"redeemed_by_id": userID, "redeemed_at": at})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrGiftCodeUnavailable
+		}
+
+		var giftCode entity.GiftCode
+		if err := tx.Where("gift_code_id = ?", giftCodeID).First(&giftCode).Error; err != nil {
+			return err
+		}
+
+		return assignPurchasedCourse(tx, giftCode.CourseID, userID)
+	})
+}
+
+// RevokeGiftCode отзывает код, после этого его нельзя активировать
+func (r *GiftCodeRepo) RevokeGiftCode(giftCodeID uuid.UUID, at time.Time) error {
+	return r.DB.Model(&entity.GiftCode{}).
+		Where("gift_code_id = ? AND revoked_at IS NULL", giftCodeID).
+		Update("revoked_at", at).Error
+}
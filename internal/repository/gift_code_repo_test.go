@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGiftCodeRepository(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := &config.Config{DB: config.DB{
+		Host:     "localhost",
+		Port:     "5433",
+		User:     "postgres",
+		Password: "postgres",
+		Name:     "mzt_test",
+	}}
+	repo := NewGiftCodeRepo(cfg)
+	repo.DB = db
+	courseRepo := NewCourseRepo(cfg)
+	courseRepo.DB = db
+
+	course := &entity.Course{CourseID: uuid.New(), Title: "Test Course"}
+	require.NoError(t, db.Create(course).Error)
+	admin := &entity.User{ID: uuid.New(), PasswdHash: "test_hash"}
+	require.NoError(t, db.Create(admin).Error)
+	user := &entity.User{ID: uuid.New(), PasswdHash: "test_hash"}
+	require.NoError(t, db.Create(user).Error)
+
+	batchID := uuid.New()
+	expiresAt := time.Now().Add(time.Hour)
+	codes := []entity.GiftCode{
+		{GiftCodeID: uuid.New(), Code: "AAAA-AAAA-AAAA", CourseID: course.CourseID, IssuedByID: admin.ID, BatchID: &batchID, ExpiresAt: &expiresAt},
+		{GiftCodeID: uuid.New(), Code: "BBBB-BBBB-BBBB", CourseID: course.CourseID, IssuedByID: admin.ID, BatchID: &batchID, ExpiresAt: &expiresAt},
+	}
+
+	t.Run("Create And Get Codes", func(t *testing.T) {
+		require.NoError(t, repo.CreateGiftCodes(codes))
+
+		got, err := repo.GetGiftCodes(&batchID, nil)
+		require.NoError(t, err)
+		assert.Len(t, got, 2)
+
+		code, err := repo.GetGiftCodeByCode("AAAA-AAAA-AAAA")
+		require.NoError(t, err)
+		assert.Equal(t, codes[0].GiftCodeID, code.GiftCodeID)
+	})
+
+	t.Run("Redeem Code", func(t *testing.T) {
+		require.NoError(t, repo.RedeemGiftCode(codes[0].GiftCodeID, user.ID, time.Now()))
+
+		assignment, err := courseRepo.GetCourseAssignment(course.CourseID, user.ID)
+		require.NoError(t, err)
+		assert.Nil(t, assignment.BundleID)
+
+		// код одноразовый
+		err = repo.RedeemGiftCode(codes[0].GiftCodeID, admin.ID, time.Now())
+		assert.ErrorIs(t, err, ErrGiftCodeUnavailable)
+
+		got, err := repo.GetGiftCodes(&batchID, nil)
+		require.NoError(t, err)
+		for _, code := range got {
+			if code.GiftCodeID == codes[0].GiftCodeID {
+				require.NotNil(t, code.RedeemedByID)
+				assert.Equal(t, user.ID, *code.RedeemedByID)
+			}
+		}
+	})
+
+	t.Run("Revoked And Expired Codes", func(t *testing.T) {
+		require.NoError(t, repo.RevokeGiftCode(codes[1].GiftCodeID, time.Now()))
+		err := repo.RedeemGiftCode(codes[1].GiftCodeID, admin.ID, time.Now())
+		assert.ErrorIs(t, err, ErrGiftCodeUnavailable)
+
+		expired := entity.GiftCode{GiftCodeID: uuid.New(), Code: "CCCC-CCCC-CCCC", CourseID: course.CourseID, IssuedByID: admin.ID, ExpiresAt: &expiresAt}
+		require.NoError(t, repo.CreateGiftCodes([]entity.GiftCode{expired}))
+		err = repo.RedeemGiftCode(expired.GiftCodeID, admin.ID, expiresAt.Add(time.Minute))
+		assert.ErrorIs(t, err, ErrGiftCodeUnavailable)
+	})
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// интерфейс для работы с рассрочками
+// определяет все методы которые нужны для работы с рассрочками и графиком их оплаты
+type InstallmentRepository interface {
+	CreateInstallmentPlan(plan *entity.InstallmentPlan) error
+	GetInstallmentPlanByID(planID uuid.UUID) (*entity.InstallmentPlan, error)
+	GetInstallmentPlansByUserID(userID uuid.UUID) ([]entity.InstallmentPlan, error)
+	GetOverdueInstallmentPlans(now time.Time) ([]*entity.InstallmentPlan, error)
+	UpdateInstallmentPlanStatus(planID uuid.UUID, status entity.InstallmentPlanStatus) error
+	UpdateInstallment(installment *entity.Installment) error
+}
+
+// репозиторий для работы с рассрочками
+// реализует интерфейс InstallmentRepository
+type InstallmentRepo struct {
+	config *config.Config
+	DB     *gorm.DB
+}
+
+func NewInstallmentRepo(cfg *config.Config) *InstallmentRepo {
+	return &InstallmentRepo{
+		config: cfg,
+		DB:     connectDB(cfg),
+	}
+}
+
+// части рассрочки всегда загружаются по порядку
+func orderedInstallments(db *gorm.DB) *gorm.DB {
+	return db.Order("number")
+}
+
+// CreateInstallmentPlan создает рассрочку вместе с графиком оплаты
+func (r *InstallmentRepo) CreateInstallmentPlan(plan *entity.InstallmentPlan) error {
+	return r.DB.Omit("User", "Course").Create(plan).Error
+}
+
+// GetInstallmentPlanByID получает рассрочку с курсом и графиком оплаты
+func (r *InstallmentRepo) GetInstallmentPlanByID(planID uuid.UUID) (*entity.InstallmentPlan, error) {
+	var plan entity.InstallmentPlan
+	err := r.DB.Preload("Installments", orderedInstallments).Preload("Course").
+		Where("installment_plan_id = ?", planID).First(&plan).Error
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// GetInstallmentPlansByUserID получает все рассрочки пользователя, новые сначала
+func (r *InstallmentRepo) GetInstallmentPlansByUserID(userID uuid.UUID) ([]entity.InstallmentPlan, error) {
+	var plans []entity.InstallmentPlan
+	err := r.DB.Preload("Installments", orderedInstallments).Preload("Course").
+		Where("user_id = ?", userID).Order("created_at DESC").Find(&plans).Error
+	if err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// GetOverdueInstallmentPlans получает действующие рассрочки в которых есть неоплаченная часть со сроком до now
+func (r *InstallmentRepo) GetOverdueInstallmentPlans(now time.Time) ([]*entity.InstallmentPlan, error) {
+	var plans []*entity.InstallmentPlan
+	overdue := r.DB.Model(&entity.Installment{}).Select("installment_plan_id").
+		Where("paid_at IS NULL AND due_at <= ?", now)
+	err := r.DB.Preload("Installments", orderedInstallments).Preload("Course").
+		Where("status IN ? AND installment_plan_id IN (?)",
+			[]entity.InstallmentPlanStatus{entity.InstallmentPlanActive, entity.InstallmentPlanOverdue}, overdue).
+		Order("created_at").Find(&plans).Error
+	if err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// UpdateInstallmentPlanStatus меняет статус рассрочки
+func (r *InstallmentRepo) UpdateInstallmentPlanStatus(planID uuid.UUID, status entity.InstallmentPlanStatus) error {
+	return r.DB.Model(&entity.InstallmentPlan{}).Where("installment_plan_id = ?", planID).Update("status", status).Error
+}
+
+// UpdateInstallment сохраняет платеж и дату оплаты части
+func (r *InstallmentRepo) UpdateInstallment(installment *entity.Installment) error {
+	return r.DB.Omit(clause.Associations).Save(installment).Error
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"mzt/internal/money"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallmentRepository(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := &config.Config{DB: config.DB{
+		Host:     "localhost",
+		Port:     "5433",
+		User:     "postgres",
+		Password: "postgres",
+		Name:     "mzt_test",
+	}}
+	repo := NewInstallmentRepo(cfg)
+	repo.DB = db
+
+	course := &entity.Course{CourseID: uuid.New(), Title: "Test Course"}
+	require.NoError(t, db.Create(course).Error)
+	user := &entity.User{ID: uuid.New(), PasswdHash: "test_hash"}
+	require.NoError(t, db.Create(user).Error)
+
+	now := time.Now()
+	plan := &entity.InstallmentPlan{
+		InstallmentPlanID: uuid.New(),
+		UserID:            user.ID,
+		CourseID:          course.CourseID,
+		Amount:            money.New(2990000, "RUB"),
+		Parts:             2,
+		Status:            entity.InstallmentPlanActive,
+	}
+	for i, amount := range plan.Amount.Split(2) {
+		plan.Installments = append(plan.Installments, entity.Installment{
+			InstallmentID:     uuid.New(),
+			InstallmentPlanID: plan.InstallmentPlanID,
+			Number:            uint(i + 1),
+			Amount:            amount,
+			DueAt:             now.AddDate(0, i, 0).Add(-time.Hour),
+		})
+	}
+
+	t.Run("Create And Get", func(t *testing.T) {
+		require.NoError(t, repo.CreateInstallmentPlan(plan))
+
+		got, err := repo.GetInstallmentPlanByID(plan.InstallmentPlanID)
+		require.NoError(t, err)
+		assert.Equal(t, "Test Course", got.Course.Title)
+		require.Len(t, got.Installments, 2)
+		assert.Equal(t, uint(1), got.Installments[0].Number)
+	})
+
+	t.Run("Overdue Plans", func(t *testing.T) {
+		overdue, err := repo.GetOverdueInstallmentPlans(now)
+		require.NoError(t, err)
+		require.Len(t, overdue, 1)
+
+		paidAt := now
+		plan.Installments[0].PaidAt = &paidAt
+		require.NoError(t, repo.UpdateInstallment(&plan.Installments[0]))
+
+		overdue, err = repo.GetOverdueInstallmentPlans(now)
+		require.NoError(t, err)
+		assert.Empty(t, overdue)
+	})
+
+	t.Run("Update Status", func(t *testing.T) {
+		require.NoError(t, repo.UpdateInstallmentPlanStatus(plan.InstallmentPlanID, entity.InstallmentPlanCompleted))
+
+		plans, err := repo.GetInstallmentPlansByUserID(user.ID)
+		require.NoError(t, err)
+		require.Len(t, plans, 1)
+		assert.Equal(t, entity.InstallmentPlanCompleted, plans[0].Status)
+	})
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// интерфейс для работы со счетами юрлицам
+// определяет все методы которые нужны для выставления счетов и отметки об оплате
+type InvoiceRepository interface {
+	CreateInvoice(invoice *entity.Invoice) error
+	GetInvoiceByID(invoiceID uuid.UUID) (*entity.Invoice, error)
+	GetInvoicesByUserID(userID uuid.UUID) ([]entity.Invoice, error)
+	GetInvoices(status *entity.PaymentStatus) ([]entity.Invoice, error)
+	MarkInvoicePaid(invoiceID uuid.UUID, userIDs []uuid.UUID, at time.Time) error
+}
+
+// репозиторий для работы со счетами
+// реализует интерфейс InvoiceRepository
+type InvoiceRepo struct {
+	config *config.Config
+	DB     *gorm.DB
+}
+
+func NewInvoiceRepo(cfg *config.Config) *InvoiceRepo {
+	return &InvoiceRepo{
+		config: cfg,
+		DB:     connectDB(cfg),
+	}
+}
+
+// CreateInvoice сохраняет счет, платеж по нему уже должен быть создан
+// номер счета выдает база
+func (r *InvoiceRepo) CreateInvoice(invoice *entity.Invoice) error {
+	return r.DB.Omit(clause.Associations).Create(invoice).Error
+}
+
+// GetInvoiceByID получает счет вместе с платежом, курсом и записанными сотрудниками
+func (r *InvoiceRepo) GetInvoiceByID(invoiceID uuid.UUID) (*entity.Invoice, error) {
+	var invoice entity.Invoice
+	err := r.DB.Preload("Payment").Preload("Course").Preload("Employees.User.UserData").
+		Where("invoice_id = ?", invoiceID).First(&invoice).Error
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// GetInvoicesByUserID получает счета которые оформил пользователь, новые сначала
+func (r *InvoiceRepo) GetInvoicesByUserID(userID uuid.UUID) ([]entity.Invoice, error) {
+	var invoices []entity.Invoice
+	err := r.DB.Preload("Payment").Preload("Course").Preload("Employees.User.UserData").
+		Where("user_id = ?", userID).Order("number DESC").Find(&invoices).Error
+	if err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// GetInvoices получает все счета, новые сначала
+// если передан status - только счета с платежом в этом статусе
+func (r *InvoiceRepo) GetInvoices(status *entity.PaymentStatus) ([]entity.Invoice, error) {
+	var invoices []entity.Invoice
+	query := r.DB.Preload("Payment").Preload("Course").Preload("Employees.User.UserData").Order("number DESC")
+	if status != nil {
+		query = query.Where("payment_id IN (?)", r.DB.Model(&entity.Payment{}).Select("payment_id").Where("status = ?", *status))
+	}
+	if err := query.Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// MarkInvoicePaid отмечает счет оплаченным и записывает сотрудников на курс в одной транзакции
+// платеж переводится в succeeded и сразу отмечается выданным, повторная отметка упирается в ErrIllegalTransition
+func (r *InvoiceRepo) MarkInvoicePaid(invoiceID uuid.UUID, userIDs []uuid.UUID, at time.Time) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		var invoice entity.Invoice
+		if err := tx.Where("invoice_id = ?", invoiceID).First(&invoice).Error; err != nil {
+			return err
+		}
+
+		if err := transitionPaymentStatus(tx, invoice.PaymentID, entity.PaymentSucceeded, entity.PaymentSourceAdmin, ""); err != nil {
+			return err
+		}
+		// иначе сверка примет платеж за сорвавшуюся выдачу
+		if err := tx.Model(&entity.Payment{}).Where("payment_id = ?", invoice.PaymentID).Update("fulfilled_at", at).Error; err != nil {
+			return err
+		}
+
+		for _, userID := range userIDs {
+			if err := assignPurchasedCourse(tx, invoice.CourseID, userID); err != nil {
+				return err
+			}
+			employee := entity.InvoiceEmployee{InvoiceID: invoiceID, UserID: userID}
+			if err := tx.Omit(clause.Associations).Create(&employee).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&entity.Invoice{}).Where("invoice_id = ?", invoiceID).Update("paid_at", at).Error
+	})
+}
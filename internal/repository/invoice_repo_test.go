@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"mzt/internal/money"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvoiceRepository(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := &config.Config{DB: config.DB{
+		Host:     "localhost",
+		Port:     "5433",
+		User:     "postgres",
+		Password: "postgres",
+		Name:     "mzt_test",
+	}}
+	repo := NewInvoiceRepo(cfg)
+	repo.DB = db
+	paymentRepo := NewPaymentRepo(cfg)
+	paymentRepo.DB = db
+	courseRepo := NewCourseRepo(cfg)
+	courseRepo.DB = db
+
+	course := &entity.Course{CourseID: uuid.New(), Title: "Test Course"}
+	require.NoError(t, db.Create(course).Error)
+	buyer := &entity.User{ID: uuid.New(), PasswdHash: "test_hash"}
+	require.NoError(t, db.Create(buyer).Error)
+	employee := &entity.User{ID: uuid.New(), PasswdHash: "test_hash"}
+	require.NoError(t, db.Create(employee).Error)
+
+	payment := &entity.Payment{
+		PaymentID: uuid.New(),
+		UserID:    buyer.ID,
+		CourseID:  &course.CourseID,
+		Amount:    money.New(200000, "RUB"),
+	}
+	require.NoError(t, paymentRepo.CreatePayment(payment))
+	require.NoError(t, paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentAwaitingTransfer, entity.PaymentSourceCheckout, ""))
+
+	invoice := &entity.Invoice{
+		InvoiceID:    uuid.New(),
+		PaymentID:    payment.PaymentID,
+		UserID:       buyer.ID,
+		CourseID:     course.CourseID,
+		Seats:        2,
+		UnitPrice:    money.New(100000, "RUB"),
+		CompanyName:  "ООО Ромашка",
+		INN:          "7707083893",
+		KPP:          "773601001",
+		LegalAddress: "г. Москва",
+		DueAt:        time.Now().AddDate(0, 0, 5),
+	}
+
+	t.Run("Create Invoice", func(t *testing.T) {
+		require.NoError(t, repo.CreateInvoice(invoice))
+		assert.NotZero(t, invoice.Number)
+
+		status := entity.PaymentAwaitingTransfer
+		invoices, err := repo.GetInvoices(&status)
+		require.NoError(t, err)
+		require.Len(t, invoices, 1)
+		assert.Equal(t, "Test Course", invoices[0].Course.Title)
+	})
+
+	t.Run("Mark Paid", func(t *testing.T) {
+		require.NoError(t, repo.MarkInvoicePaid(invoice.InvoiceID, []uuid.UUID{employee.ID}, time.Now()))
+
+		got, err := repo.GetInvoiceByID(invoice.InvoiceID)
+		require.NoError(t, err)
+		assert.Equal(t, entity.PaymentSucceeded, got.Payment.Status)
+		assert.NotNil(t, got.Payment.FulfilledAt)
+		assert.NotNil(t, got.PaidAt)
+		require.Len(t, got.Employees, 1)
+
+		assignment, err := courseRepo.GetCourseAssignment(course.CourseID, employee.ID)
+		require.NoError(t, err)
+		assert.Equal(t, employee.ID, assignment.UserID)
+
+		// вторая отметка откатывается целиком
+		err = repo.MarkInvoicePaid(invoice.InvoiceID, []uuid.UUID{buyer.ID}, time.Now())
+		assert.ErrorIs(t, err, ErrIllegalTransition)
+		_, err = courseRepo.GetCourseAssignment(course.CourseID, buyer.ID)
+		assert.Error(t, err)
+
+		invoices, err := repo.GetInvoicesByUserID(buyer.ID)
+		require.NoError(t, err)
+		assert.Len(t, invoices, 1)
+	})
+}
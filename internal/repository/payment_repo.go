@@ -1,25 +1,50 @@
 package repository
 
 import (
+	"errors"
 	"mzt/config"
 	"mzt/internal/entity"
+	"mzt/internal/money"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrIllegalTransition переход между статусами платежа запрещен
+var ErrIllegalTransition = errors.New("illegal payment status transition")
+
+// ErrRefundExceedsPayment вместе с прошлыми возвратами вернули бы больше чем заплачено
+var ErrRefundExceedsPayment = errors.New("refund exceeds remaining payment amount")
+
 // интерфейс для работы с платежами
 // определяет все методы которые нужны для работы с платежами и ценами курсов в базе
 type PaymentRepository interface {
 	CreatePayment(payment *entity.Payment) error
 	GetPaymentByID(paymentID uuid.UUID) (*entity.Payment, error)
+	GetPaymentByRef(ref string) (*entity.Payment, error)
 	GetPaymentsByUserID(userID uuid.UUID) ([]*entity.Payment, error)
 	GetPaymentsByCourseID(courseID uuid.UUID) ([]*entity.Payment, error)
-	UpdatePaymentStatus(paymentID uuid.UUID, status string) error
+	GetPaymentsCreatedBetween(from, to *time.Time) ([]*entity.Payment, error)
+	GetPaymentsBySubscriptionID(subscriptionID uuid.UUID) ([]*entity.Payment, error)
+	TransitionPaymentStatus(paymentID uuid.UUID, to entity.PaymentStatus, source entity.PaymentEventSource, payload string) error
+	GetPaymentEvents(paymentID uuid.UUID) ([]entity.PaymentEvent, error)
+	UpdatePaymentRef(paymentID uuid.UUID, ref string) error
+	UpdateReceiptStatus(paymentID uuid.UUID, status entity.ReceiptStatus) error
+	GetStalePayments(statuses []entity.PaymentStatus, before time.Time) ([]*entity.Payment, error)
+	MarkPaymentFulfilled(paymentID uuid.UUID) error
+	GetUnfulfilledPayments(before time.Time) ([]*entity.Payment, error)
+	GetPaymentCourseIDs(paymentID uuid.UUID) ([]uuid.UUID, error)
+
+	CreateRefund(refund *entity.Refund) error
+	UpdateRefund(refund *entity.Refund) error
+	GetRefundByRef(ref string) (*entity.Refund, error)
+	GetRefundsByPaymentID(paymentID uuid.UUID) ([]entity.Refund, error)
 
 	GetCoursePrice(courseID uuid.UUID) (*entity.CoursePrice, error)
 	SetCoursePrice(price *entity.CoursePrice) error
-	UpdateCoursePrice(courseID uuid.UUID, amount float64) error
+	UpdateCoursePrice(courseID uuid.UUID, amount money.Money) error
 }
 
 // репозиторий для работы с платежами
@@ -37,9 +62,22 @@ func NewPaymentRepo(cfg *config.Config) *PaymentRepo {
 }
 
 // CreatePayment создает новый платеж
-// просто создает новую запись в таблице payments
+// создает запись в таблице payments и первую запись в истории статусов
 func (r *PaymentRepo) CreatePayment(payment *entity.Payment) error {
-	return r.DB.Create(payment).Error
+	if payment.Status == "" {
+		payment.Status = entity.PaymentPending
+	}
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Events", "Refunds", "PromoCode").Create(payment).Error; err != nil {
+			return err
+		}
+		return tx.Create(&entity.PaymentEvent{
+			PaymentID: payment.PaymentID,
+			ToStatus:  payment.Status,
+			Source:    entity.PaymentSourceCheckout,
+		}).Error
+	})
 }
 
 // GetPaymentByID получает информацию о платеже
@@ -53,11 +91,30 @@ func (r *PaymentRepo) GetPaymentByID(paymentID uuid.UUID) (*entity.Payment, erro
 	return &payment, nil
 }
 
+// GetPaymentByRef получает платеж по id у провайдера
+// нужен чтобы сопоставить запись провайдера с нашей
+func (r *PaymentRepo) GetPaymentByRef(ref string) (*entity.Payment, error) {
+	if ref == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var payment entity.Payment
+	err := r.DB.Where("payment_ref = ?", ref).First(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
 // GetPaymentsByUserID получает список платежей пользователя
-// берет все платежи пользователя из базы
+// берет все платежи пользователя из базы вместе с историей статусов и возвратами
 func (r *PaymentRepo) GetPaymentsByUserID(userID uuid.UUID) ([]*entity.Payment, error) {
 	var payments []*entity.Payment
-	err := r.DB.Where("user_id = ?", userID).Find(&payments).Error
+	err := r.DB.Preload("Events", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at, id")
+	}).Preload("Refunds", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).Preload("PromoCode").Where("user_id = ?", userID).Order("created_at DESC").Find(&payments).Error
 	if err != nil {
 		return nil, err
 	}
@@ -65,20 +122,217 @@ func (r *PaymentRepo) GetPaymentsByUserID(userID uuid.UUID) ([]*entity.Payment,
 }
 
 // GetPaymentsByCourseID получает список платежей по курсу
-// берет все платежи для конкретного курса из базы
+// берет все платежи для конкретного курса из базы вместе с курсом и возвратами
 func (r *PaymentRepo) GetPaymentsByCourseID(courseID uuid.UUID) ([]*entity.Payment, error) {
 	var payments []*entity.Payment
-	err := r.DB.Where("course_id = ?", courseID).Find(&payments).Error
+	err := r.DB.Preload("Refunds").Preload("Course").
+		Where("course_id = ?", courseID).Order("created_at").Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// GetPaymentsCreatedBetween получает платежи созданные в промежутке [from, to) для отчетов
+// пустая граница значит что ограничения с этой стороны нет
+// вместе с платежом подгружаются возвраты и то за что платили: курс, набор или план подписки
+func (r *PaymentRepo) GetPaymentsCreatedBetween(from, to *time.Time) ([]*entity.Payment, error) {
+	var payments []*entity.Payment
+	query := r.DB.Preload("Refunds").Preload("Course").Preload("Bundle").Preload("Subscription.Plan").Order("created_at")
+	if from != nil {
+		query = query.Where("created_at >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("created_at < ?", *to)
+	}
+	if err := query.Find(&payments).Error; err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// GetPaymentsBySubscriptionID получает платежи за периоды подписки, новые сначала
+func (r *PaymentRepo) GetPaymentsBySubscriptionID(subscriptionID uuid.UUID) ([]*entity.Payment, error) {
+	var payments []*entity.Payment
+	err := r.DB.Where("subscription_id = ?", subscriptionID).Order("created_at DESC").Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// TransitionPaymentStatus переводит платеж в новый статус
+// проверяет что переход разрешен и записывает его в историю, все в одной транзакции
+func (r *PaymentRepo) TransitionPaymentStatus(paymentID uuid.UUID, to entity.PaymentStatus, source entity.PaymentEventSource, payload string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		return transitionPaymentStatus(tx, paymentID, to, source, payload)
+	})
+}
+
+// меняет статус платежа и пишет событие в историю внутри уже открытой транзакции
+func transitionPaymentStatus(tx *gorm.DB, paymentID uuid.UUID, to entity.PaymentStatus, source entity.PaymentEventSource, payload string) error {
+	// блокируем строку чтобы параллельные уведомления не перескочили друг через друга
+	var payment entity.Payment
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("payment_id = ?", paymentID).First(&payment).Error
+	if err != nil {
+		return err
+	}
+
+	if !payment.Status.CanTransitionTo(to) {
+		return ErrIllegalTransition
+	}
+
+	if err := tx.Model(&entity.Payment{}).Where("payment_id = ?", paymentID).Update("status", to).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&entity.PaymentEvent{
+		PaymentID:  paymentID,
+		FromStatus: payment.Status,
+		ToStatus:   to,
+		Source:     source,
+		Payload:    payload,
+	}).Error
+}
+
+// GetPaymentEvents получает историю статусов платежа
+// записи отсортированы от старых к новым
+func (r *PaymentRepo) GetPaymentEvents(paymentID uuid.UUID) ([]entity.PaymentEvent, error) {
+	var events []entity.PaymentEvent
+	err := r.DB.Where("payment_id = ?", paymentID).Order("created_at, id").Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// UpdatePaymentRef сохраняет id платежа у провайдера
+// по нему потом сверяем платеж с данными провайдера
+func (r *PaymentRepo) UpdatePaymentRef(paymentID uuid.UUID, ref string) error {
+	result := r.DB.Model(&entity.Payment{}).Where("payment_id = ?", paymentID).Update("payment_ref", ref)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateReceiptStatus сохраняет статус регистрации чека, который вернул провайдер
+func (r *PaymentRepo) UpdateReceiptStatus(paymentID uuid.UUID, status entity.ReceiptStatus) error {
+	return r.DB.Model(&entity.Payment{}).Where("payment_id = ?", paymentID).Update("receipt_status", status).Error
+}
+
+// GetStalePayments получает платежи которые висят в одном из статусов с момента до before
+// сначала самые старые
+func (r *PaymentRepo) GetStalePayments(statuses []entity.PaymentStatus, before time.Time) ([]*entity.Payment, error) {
+	var payments []*entity.Payment
+	err := r.DB.Where("status IN ? AND created_at < ?", statuses, before).Order("created_at").Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// MarkPaymentFulfilled отмечает что оплаченное выдано
+func (r *PaymentRepo) MarkPaymentFulfilled(paymentID uuid.UUID) error {
+	return r.DB.Model(&entity.Payment{}).
+		Where("payment_id = ? AND fulfilled_at IS NULL", paymentID).
+		Update("fulfilled_at", time.Now()).Error
+}
+
+// GetUnfulfilledPayments получает оплаченные до before платежи, по которым так и не выдали оплаченное
+func (r *PaymentRepo) GetUnfulfilledPayments(before time.Time) ([]*entity.Payment, error) {
+	var payments []*entity.Payment
+	err := r.DB.Where("status = ? AND fulfilled_at IS NULL AND created_at < ?", entity.PaymentSucceeded, before).
+		Order("created_at").Find(&payments).Error
 	if err != nil {
 		return nil, err
 	}
 	return payments, nil
 }
 
-// UpdatePaymentStatus обновляет статус платежа
-// меняет статус платежа в базе на новый
-func (r *PaymentRepo) UpdatePaymentStatus(paymentID uuid.UUID, status string) error {
-	return r.DB.Model(&entity.Payment{}).Where("payment_id = ?", paymentID).Update("status", status).Error
+// GetPaymentCourseIDs получает курсы набора записанные в платеж при его создании
+func (r *PaymentRepo) GetPaymentCourseIDs(paymentID uuid.UUID) ([]uuid.UUID, error) {
+	var courses []entity.PaymentCourse
+	if err := r.DB.Where("payment_id = ?", paymentID).Find(&courses).Error; err != nil {
+		return nil, err
+	}
+	courseIDs := make([]uuid.UUID, 0, len(courses))
+	for _, course := range courses {
+		courseIDs = append(courseIDs, course.CourseID)
+	}
+	return courseIDs, nil
+}
+
+// CreateRefund создает запись о возврате
+// запись создается до запроса к провайдеру, ее id уходит провайдеру ключом идемпотентности
+// остаток считается под блокировкой платежа, поэтому параллельные возвраты не вернут больше чем заплачено
+func (r *PaymentRepo) CreateRefund(refund *entity.Refund) error {
+	if refund.Status == "" {
+		refund.Status = entity.RefundPending
+	}
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		var payment entity.Payment
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("payment_id = ?", refund.PaymentID).First(&payment).Error
+		if err != nil {
+			return err
+		}
+
+		// незавершенные возвраты тоже занимают сумму
+		var refunds []entity.Refund
+		err = tx.Where("payment_id = ? AND status <> ?", refund.PaymentID, entity.RefundCanceled).Find(&refunds).Error
+		if err != nil {
+			return err
+		}
+		remaining := payment.Amount
+		for _, existing := range refunds {
+			if remaining, err = remaining.Sub(existing.Amount); err != nil {
+				return err
+			}
+		}
+		if !refund.Amount.SameCurrency(remaining) || refund.Amount.Minor > remaining.Minor {
+			return ErrRefundExceedsPayment
+		}
+
+		return tx.Create(refund).Error
+	})
+}
+
+// UpdateRefund сохраняет статус возврата и его id у провайдера
+func (r *PaymentRepo) UpdateRefund(refund *entity.Refund) error {
+	return r.DB.Model(&entity.Refund{}).Where("refund_id = ?", refund.RefundID).Updates(map[string]interface{}{
+		"status":     refund.Status,
+		"refund_ref": refund.RefundRef,
+	}).Error
+}
+
+// GetRefundByRef получает возврат по его id у провайдера
+func (r *PaymentRepo) GetRefundByRef(ref string) (*entity.Refund, error) {
+	if ref == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var refund entity.Refund
+	err := r.DB.Where("refund_ref = ?", ref).First(&refund).Error
+	if err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// GetRefundsByPaymentID получает все возвраты по платежу
+// записи отсортированы от старых к новым
+func (r *PaymentRepo) GetRefundsByPaymentID(paymentID uuid.UUID) ([]entity.Refund, error) {
+	var refunds []entity.Refund
+	err := r.DB.Where("payment_id = ?", paymentID).Order("created_at").Find(&refunds).Error
+	if err != nil {
+		return nil, err
+	}
+	return refunds, nil
 }
 
 // GetCoursePrice получает цену курса
@@ -100,6 +354,9 @@ func (r *PaymentRepo) SetCoursePrice(price *entity.CoursePrice) error {
 
 // UpdateCoursePrice обновляет цену курса
 // меняет цену курса в базе на новую
-func (r *PaymentRepo) UpdateCoursePrice(courseID uuid.UUID, amount float64) error {
-	return r.DB.Model(&entity.CoursePrice{}).Where("course_id = ?", courseID).Update("amount", amount).Error
+func (r *PaymentRepo) UpdateCoursePrice(courseID uuid.UUID, amount money.Money) error {
+	return r.DB.Model(&entity.CoursePrice{}).Where("course_id = ?", courseID).Updates(map[string]interface{}{
+		"amount_minor":    amount.Minor,
+		"amount_currency": amount.Currency,
+	}).Error
 }
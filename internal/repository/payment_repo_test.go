@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"mzt/internal/money"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentRepository(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPaymentRepo(&config.Config{DB: config.DB{
+		Host:     "localhost",
+		Port:     "5433",
+		User:     "postgres",
+		Password: "postgres",
+		Name:     "mzt_test",
+	}})
+	repo.DB = db
+
+	// создает пользователя и курс, на которые ссылается платеж
+	createPayment := func(t *testing.T) *entity.Payment {
+		user := &entity.User{ID: uuid.New(), PasswdHash: "test_hash"}
+		require.NoError(t, db.Create(user).Error)
+		course := &entity.Course{CourseID: uuid.New(), Title: "Test Course"}
+		require.NoError(t, db.Create(course).Error)
+
+		payment := &entity.Payment{
+			PaymentID: uuid.New(),
+			UserID:    user.ID,
+			CourseID:  &course.CourseID,
+			Amount:    money.New(2990000, "RUB"),
+		}
+		require.NoError(t, repo.CreatePayment(payment))
+		return payment
+	}
+
+	t.Run("Transition Records History", func(t *testing.T) {
+		payment := createPayment(t)
+
+		err := repo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentProcessing, entity.PaymentSourceCheckout, "")
+		require.NoError(t, err)
+		err = repo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentSucceeded, entity.PaymentSourceWebhook, `{"event":"payment.succeeded"}`)
+		require.NoError(t, err)
+
+		got, err := repo.GetPaymentByID(payment.PaymentID)
+		require.NoError(t, err)
+		assert.Equal(t, entity.PaymentSucceeded, got.Status)
+
+		events, err := repo.GetPaymentEvents(payment.PaymentID)
+		require.NoError(t, err)
+		require.Len(t, events, 3)
+		assert.Equal(t, entity.PaymentPending, events[0].ToStatus)
+		assert.Equal(t, entity.PaymentProcessing, events[1].FromStatus)
+		assert.Equal(t, entity.PaymentSucceeded, events[2].ToStatus)
+		assert.Equal(t, entity.PaymentSourceWebhook, events[2].Source)
+		assert.Equal(t, `{"event":"payment.succeeded"}`, events[2].Payload)
+	})
+
+	t.Run("Illegal Transition Is Rejected", func(t *testing.T) {
+		payment := createPayment(t)
+
+		err := repo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentSucceeded, entity.PaymentSourceWebhook, "")
+		assert.ErrorIs(t, err, ErrIllegalTransition)
+
+		got, err := repo.GetPaymentByID(payment.PaymentID)
+		require.NoError(t, err)
+		assert.Equal(t, entity.PaymentPending, got.Status)
+
+		events, err := repo.GetPaymentEvents(payment.PaymentID)
+		require.NoError(t, err)
+		assert.Len(t, events, 1)
+	})
+
+	t.Run("User Payments Include History", func(t *testing.T) {
+		payment := createPayment(t)
+		require.NoError(t, repo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentCanceled, entity.PaymentSourceAdmin, ""))
+
+		payments, err := repo.GetPaymentsByUserID(payment.UserID)
+		require.NoError(t, err)
+		require.Len(t, payments, 1)
+		require.Len(t, payments[0].Events, 2)
+		assert.Equal(t, entity.PaymentSourceAdmin, payments[0].Events[1].Source)
+	})
+
+	t.Run("Payment Ref Is Stored And Unique", func(t *testing.T) {
+		payment := createPayment(t)
+		ref := uuid.New().String()
+		require.NoError(t, repo.UpdatePaymentRef(payment.PaymentID, ref))
+
+		got, err := repo.GetPaymentByRef(ref)
+		require.NoError(t, err)
+		assert.Equal(t, payment.PaymentID, got.PaymentID)
+
+		// один платеж провайдера не может принадлежать двум нашим
+		other := createPayment(t)
+		assert.Error(t, repo.UpdatePaymentRef(other.PaymentID, ref))
+
+		assert.Error(t, repo.UpdatePaymentRef(uuid.New(), uuid.New().String()))
+	})
+
+	t.Run("Stale Payments", func(t *testing.T) {
+		stale := createPayment(t)
+		require.NoError(t, db.Model(&entity.Payment{}).Where("payment_id = ?", stale.PaymentID).
+			Update("created_at", time.Now().Add(-time.Hour)).Error)
+		fresh := createPayment(t)
+
+		payments, err := repo.GetStalePayments([]entity.PaymentStatus{entity.PaymentPending}, time.Now().Add(-time.Minute*30))
+		require.NoError(t, err)
+
+		ids := make([]uuid.UUID, 0, len(payments))
+		for _, payment := range payments {
+			ids = append(ids, payment.PaymentID)
+		}
+		assert.Contains(t, ids, stale.PaymentID)
+		assert.NotContains(t, ids, fresh.PaymentID)
+	})
+
+	t.Run("Unfulfilled Payments", func(t *testing.T) {
+		payment := createPayment(t)
+		require.NoError(t, repo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentProcessing, entity.PaymentSourceCheckout, ""))
+		require.NoError(t, repo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentSucceeded, entity.PaymentSourceWebhook, ""))
+
+		isUnfulfilled := func() bool {
+			payments, err := repo.GetUnfulfilledPayments(time.Now().Add(time.Minute))
+			require.NoError(t, err)
+			for _, p := range payments {
+				if p.PaymentID == payment.PaymentID {
+					return true
+				}
+			}
+			return false
+		}
+		assert.True(t, isUnfulfilled())
+
+		require.NoError(t, repo.MarkPaymentFulfilled(payment.PaymentID))
+		assert.False(t, isUnfulfilled())
+		got, err := repo.GetPaymentByID(payment.PaymentID)
+		require.NoError(t, err)
+		assert.NotNil(t, got.FulfilledAt)
+	})
+
+	t.Run("Bundle Payment Keeps Quoted Courses", func(t *testing.T) {
+		user := &entity.User{ID: uuid.New(), PasswdHash: "test_hash"}
+		require.NoError(t, db.Create(user).Error)
+		course := &entity.Course{CourseID: uuid.New(), Title: "Test Course"}
+		require.NoError(t, db.Create(course).Error)
+		bundle := &entity.Bundle{BundleID: uuid.New(), Title: "Test Bundle", Amount: money.New(2990000, "RUB")}
+		require.NoError(t, db.Create(bundle).Error)
+
+		payment := &entity.Payment{
+			PaymentID:     uuid.New(),
+			UserID:        user.ID,
+			BundleID:      &bundle.BundleID,
+			Amount:        money.New(2990000, "RUB"),
+			BundleCourses: []entity.PaymentCourse{{CourseID: course.CourseID}},
+		}
+		require.NoError(t, repo.CreatePayment(payment))
+
+		courseIDs, err := repo.GetPaymentCourseIDs(payment.PaymentID)
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{course.CourseID}, courseIDs)
+	})
+
+	t.Run("Payments For Report", func(t *testing.T) {
+		old := createPayment(t)
+		require.NoError(t, db.Model(&entity.Payment{}).Where("payment_id = ?", old.PaymentID).
+			Update("created_at", time.Now().AddDate(0, 0, -2)).Error)
+		recent := createPayment(t)
+
+		from := time.Now().AddDate(0, 0, -1)
+		payments, err := repo.GetPaymentsCreatedBetween(&from, nil)
+		require.NoError(t, err)
+
+		ids := make([]uuid.UUID, 0, len(payments))
+		for _, payment := range payments {
+			ids = append(ids, payment.PaymentID)
+		}
+		assert.Contains(t, ids, recent.PaymentID)
+		assert.NotContains(t, ids, old.PaymentID)
+
+		payments, err = repo.GetPaymentsCreatedBetween(nil, &from)
+		require.NoError(t, err)
+		require.NotEmpty(t, payments)
+		assert.Equal(t, old.PaymentID, payments[0].PaymentID)
+	})
+
+	t.Run("Refunds", func(t *testing.T) {
+		payment := createPayment(t)
+		refund := &entity.Refund{
+			RefundID:  uuid.New(),
+			PaymentID: payment.PaymentID,
+			Amount:    money.New(1000000, "RUB"),
+		}
+		require.NoError(t, repo.CreateRefund(refund))
+		assert.Equal(t, entity.RefundPending, refund.Status)
+
+		refund.RefundRef = uuid.New().String()
+		refund.Status = entity.RefundSucceeded
+		require.NoError(t, repo.UpdateRefund(refund))
+
+		got, err := repo.GetRefundByRef(refund.RefundRef)
+		require.NoError(t, err)
+		assert.Equal(t, refund.RefundID, got.RefundID)
+		assert.Equal(t, entity.RefundSucceeded, got.Status)
+
+		refunds, err := repo.GetRefundsByPaymentID(payment.PaymentID)
+		require.NoError(t, err)
+		assert.Len(t, refunds, 1)
+	})
+
+	t.Run("Concurrent Refunds Do Not Exceed Payment", func(t *testing.T) {
+		payment := createPayment(t)
+
+		// две половины и еще треть суммы одновременно - пройти могут только две
+		amounts := []int64{1495000, 1495000, 1000000}
+		results := make(chan error, len(amounts))
+		for _, minor := range amounts {
+			go func(minor int64) {
+				results <- repo.CreateRefund(&entity.Refund{
+					RefundID:  uuid.New(),
+					PaymentID: payment.PaymentID,
+					Amount:    money.New(minor, "RUB"),
+				})
+			}(minor)
+		}
+		var total int64
+		for range amounts {
+			if err := <-results; err != nil {
+				assert.ErrorIs(t, err, ErrRefundExceedsPayment)
+			}
+		}
+		refunds, err := repo.GetRefundsByPaymentID(payment.PaymentID)
+		require.NoError(t, err)
+		for _, refund := range refunds {
+			total += refund.Amount.Minor
+		}
+		assert.LessOrEqual(t, total, payment.Amount.Minor)
+		assert.Len(t, refunds, 2)
+	})
+}
@@ -36,7 +36,7 @@ func TestPreloadRelationships(t *testing.T) {
 		user := &entity.User{
 			ID:         userId,
 			PasswdHash: "test_hash",
-			Role:       1,
+			Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 		}
 		userData := &entity.UserData{
 			UserID:      userId,
@@ -45,12 +45,8 @@ func TestPreloadRelationships(t *testing.T) {
 			Birthdate:   time.Now(),
 			PhoneNumber: "+1234567890",
 		}
-		auth := &entity.Auth{
-			UserID: userId,
-			Key:    "test_key",
-		}
 
-		err := userRepo.CreateUser(user, userData, auth)
+		err := userRepo.CreateUser(user, userData, nil)
 		require.NoError(t, err)
 
 		gotUser, err := userRepo.GetUserWithDataById(userId)
@@ -60,40 +56,12 @@ func TestPreloadRelationships(t *testing.T) {
 		assert.Equal(t, userData.Name, gotUser.UserData.Name)
 	})
 
-	t.Run("Test User with Auth preload", func(t *testing.T) {
-		userId := uuid.New()
-		user := &entity.User{
-			ID:         userId,
-			PasswdHash: "test_hash",
-			Role:       1,
-		}
-		userData := &entity.UserData{
-			UserID:      userId,
-			Email:       "test2@example.com",
-			Name:        "Test User 2",
-			Birthdate:   time.Now(),
-			PhoneNumber: "+1234567891",
-		}
-		auth := &entity.Auth{
-			UserID: userId,
-			Key:    "test_key_2",
-		}
-
-		err := userRepo.CreateUser(user, userData, auth)
-		require.NoError(t, err)
-
-		gotUser, err := userRepo.GetUserWithRefreshById(userId)
-		require.NoError(t, err)
-		assert.NotNil(t, gotUser.Auth)
-		assert.Equal(t, auth.Key, gotUser.Auth.Key)
-	})
-
 	t.Run("Test User with CourseAssignments preload", func(t *testing.T) {
 		userId := uuid.New()
 		user := &entity.User{
 			ID:         userId,
 			PasswdHash: "test_hash",
-			Role:       1,
+			Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 		}
 		userData := &entity.UserData{
 			UserID:      userId,
@@ -102,12 +70,8 @@ func TestPreloadRelationships(t *testing.T) {
 			Birthdate:   time.Now(),
 			PhoneNumber: "+1234567892",
 		}
-		auth := &entity.Auth{
-			UserID: userId,
-			Key:    "test_key_3",
-		}
 
-		err := userRepo.CreateUser(user, userData, auth)
+		err := userRepo.CreateUser(user, userData, nil)
 		require.NoError(t, err)
 
 		courseId := uuid.New()
@@ -205,12 +169,12 @@ func TestPreloadRelationships(t *testing.T) {
 			{
 				ID:         uuid.New(),
 				PasswdHash: "test_hash1",
-				Role:       1,
+				Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 			},
 			{
 				ID:         uuid.New(),
 				PasswdHash: "test_hash2",
-				Role:       1,
+				Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 			},
 		}
 
@@ -222,11 +186,7 @@ func TestPreloadRelationships(t *testing.T) {
 				Birthdate:   time.Now(),
 				PhoneNumber: "+1234567890",
 			}
-			auth := &entity.Auth{
-				UserID: user.ID,
-				Key:    "test_key_" + user.ID.String(),
-			}
-			err = userRepo.CreateUser(user, userData, auth)
+			err = userRepo.CreateUser(user, userData, nil)
 			require.NoError(t, err)
 		}
 
@@ -258,12 +218,12 @@ func TestPreloadRelationships(t *testing.T) {
 			{
 				ID:         uuid.New(),
 				PasswdHash: "test_hash1",
-				Role:       1,
+				Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 			},
 			{
 				ID:         uuid.New(),
 				PasswdHash: "test_hash2",
-				Role:       1,
+				Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
 			},
 		}
 
@@ -288,11 +248,7 @@ func TestPreloadRelationships(t *testing.T) {
 				Birthdate:   time.Now(),
 				PhoneNumber: "+1234567890",
 			}
-			auth := &entity.Auth{
-				UserID: user.ID,
-				Key:    "test_key_" + user.ID.String(),
-			}
-			err := userRepo.CreateUser(user, userData, auth)
+			err := userRepo.CreateUser(user, userData, nil)
 			require.NoError(t, err)
 		}
 
@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/money"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// интерфейс для работы с промокодами
+// определяет все методы которые нужны для работы с промокодами и их использованием в базе
+type PromoCodeRepository interface {
+	CreatePromoCode(promo *entity.PromoCode) error
+	GetPromoCodes() ([]entity.PromoCode, error)
+	GetPromoCodeByID(promoCodeID uuid.UUID) (*entity.PromoCode, error)
+	GetPromoCodeByCode(code string) (*entity.PromoCode, error)
+	UpdatePromoCode(promo *entity.PromoCode) error
+	DeletePromoCode(promoCodeID uuid.UUID) error
+
+	RecordPromoCodeUsage(usage *entity.PromoCodeUsage) error
+	CountPromoCodeUsages(promoCodeID uuid.UUID) (int64, error)
+	CountPromoCodeUsagesByUser(promoCodeID, userID uuid.UUID) (int64, error)
+	GetPromoCodeStats(promoCodeID uuid.UUID) (*dto.PromoCodeStatsDto, error)
+}
+
+// репозиторий для работы с промокодами
+// реализует интерфейс PromoCodeRepository
+type PromoCodeRepo struct {
+	config *config.Config
+	DB     *gorm.DB
+}
+
+func NewPromoCodeRepo(cfg *config.Config) *PromoCodeRepo {
+	return &PromoCodeRepo{
+		config: cfg,
+		DB:     connectDB(cfg),
+	}
+}
+
+// CreatePromoCode создает промокод вместе со списком курсов на которые он действует
+func (r *PromoCodeRepo) CreatePromoCode(promo *entity.PromoCode) error {
+	return r.DB.Omit("Usages").Create(promo).Error
+}
+
+// GetPromoCodes получает все промокоды, новые сначала
+func (r *PromoCodeRepo) GetPromoCodes() ([]entity.PromoCode, error) {
+	var promos []entity.PromoCode
+	err := r.DB.Preload("Courses").Order("created_at DESC").Find(&promos).Error
+	if err != nil {
+		return nil, err
+	}
+	return promos, nil
+}
+
+// GetPromoCodeByID получает промокод по id
+func (r *PromoCodeRepo) GetPromoCodeByID(promoCodeID uuid.UUID) (*entity.PromoCode, error) {
+	var promo entity.PromoCode
+	err := r.DB.Preload("Courses").Where("promo_code_id = ?", promoCodeID).First(&promo).Error
+	if err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+// GetPromoCodeByCode получает промокод по самому коду
+// коды хранятся в верхнем регистре, поэтому сравниваем без учета регистра
+func (r *PromoCodeRepo) GetPromoCodeByCode(code string) (*entity.PromoCode, error) {
+	var promo entity.PromoCode
+	err := r.DB.Preload("Courses").Where("UPPER(code) = UPPER(?)", code).First(&promo).Error
+	if err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+// UpdatePromoCode обновляет промокод
+// список курсов заменяется целиком
+func (r *PromoCodeRepo) UpdatePromoCode(promo *entity.PromoCode) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&entity.PromoCode{}).Where("promo_code_id = ?", promo.PromoCodeID).Updates(map[string]interface{}{
+			"code":            promo.Code,
+			"kind":            promo.Kind,
+			"percent":         promo.Percent,
+			"amount_minor":    promo.Amount.Minor,
+			"amount_currency": promo.Amount.Currency,
+			"valid_from":      promo.ValidFrom,
+			"valid_until":     promo.ValidUntil,
+			"usage_limit":     promo.UsageLimit,
+			"per_user_limit":  promo.PerUserLimit,
+			"active":          promo.Active,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("promo_code_id = ?", promo.PromoCodeID).Delete(&entity.PromoCodeCourse{}).Error; err != nil {
+			return err
+		}
+		if len(promo.Courses) == 0 {
+			return nil
+		}
+		return tx.Create(&promo.Courses).Error
+	})
+}
+
+// DeletePromoCode удаляет промокод
+// у платежей по нему остается только сумма скидки
+func (r *PromoCodeRepo) DeletePromoCode(promoCodeID uuid.UUID) error {
+	return r.DB.Where("promo_code_id = ?", promoCodeID).Delete(&entity.PromoCode{}).Error
+}
+
+// RecordPromoCodeUsage записывает использование промокода
+// повторная запись по тому же платежу игнорируется
+func (r *PromoCodeRepo) RecordPromoCodeUsage(usage *entity.PromoCodeUsage) error {
+	return r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(usage).Error
+}
+
+// CountPromoCodeUsages считает сколько раз промокод использован всего
+func (r *PromoCodeRepo) CountPromoCodeUsages(promoCodeID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.DB.Model(&entity.PromoCodeUsage{}).Where("promo_code_id = ?", promoCodeID).Count(&count).Error
+	return count, err
+}
+
+// CountPromoCodeUsagesByUser считает сколько раз пользователь использовал промокод
+func (r *PromoCodeRepo) CountPromoCodeUsagesByUser(promoCodeID, userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.DB.Model(&entity.PromoCodeUsage{}).
+		Where("promo_code_id = ? AND user_id = ?", promoCodeID, userID).Count(&count).Error
+	return count, err
+}
+
+// GetPromoCodeStats собирает статистику по промокоду из оплаченных с ним платежей
+// суммы считаются отдельно по каждой валюте
+func (r *PromoCodeRepo) GetPromoCodeStats(promoCodeID uuid.UUID) (*dto.PromoCodeStatsDto, error) {
+	stats := &dto.PromoCodeStatsDto{
+		PromoCodeID:   promoCodeID,
+		TotalDiscount: make([]money.Money, 0),
+		Revenue:       make([]money.Money, 0),
+	}
+	err := r.DB.Model(&entity.PromoCodeUsage{}).
+		Select("COUNT(*) AS usages, COUNT(DISTINCT user_id) AS users").
+		Where("promo_code_id = ?", promoCodeID).
+		Scan(stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var totals []struct {
+		Currency string
+		Discount int64
+		Revenue  int64
+	}
+	err = r.DB.Table("promo_code_usages AS u").
+		Select("p.amount_currency AS currency, COALESCE(SUM(p.discount_minor), 0) AS discount, COALESCE(SUM(p.amount_minor), 0) AS revenue").
+		Joins("JOIN payments p ON p.payment_id = u.payment_id").
+		Where("u.promo_code_id = ?", promoCodeID).
+		Group("p.amount_currency").
+		Order("p.amount_currency").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, total := range totals {
+		stats.TotalDiscount = append(stats.TotalDiscount, money.New(total.Discount, total.Currency))
+		stats.Revenue = append(stats.Revenue, money.New(total.Revenue, total.Currency))
+	}
+	return stats, nil
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"mzt/internal/money"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromoCodeRepository(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := &config.Config{DB: config.DB{
+		Host:     "localhost",
+		Port:     "5433",
+		User:     "postgres",
+		Password: "postgres",
+		Name:     "mzt_test",
+	}}
+	repo := NewPromoCodeRepo(cfg)
+	repo.DB = db
+	paymentRepo := NewPaymentRepo(cfg)
+	paymentRepo.DB = db
+
+	course := &entity.Course{CourseID: uuid.New(), Title: "Test Course"}
+	require.NoError(t, db.Create(course).Error)
+
+	promo := &entity.PromoCode{
+		PromoCodeID: uuid.New(),
+		Code:        "SALE10",
+		Kind:        entity.PromoCodePercent,
+		Percent:     10,
+		Active:      true,
+	}
+	promo.Courses = []entity.PromoCodeCourse{{PromoCodeID: promo.PromoCodeID, CourseID: course.CourseID}}
+
+	t.Run("Create And Find By Code", func(t *testing.T) {
+		require.NoError(t, repo.CreatePromoCode(promo))
+
+		got, err := repo.GetPromoCodeByCode("sale10")
+		require.NoError(t, err)
+		assert.Equal(t, promo.PromoCodeID, got.PromoCodeID)
+		require.Len(t, got.Courses, 1)
+		assert.Equal(t, course.CourseID, got.Courses[0].CourseID)
+	})
+
+	t.Run("Update Replaces Courses", func(t *testing.T) {
+		promo.Percent = 20
+		promo.Courses = nil
+		require.NoError(t, repo.UpdatePromoCode(promo))
+
+		got, err := repo.GetPromoCodeByID(promo.PromoCodeID)
+		require.NoError(t, err)
+		assert.Equal(t, 20.0, got.Percent)
+		assert.Empty(t, got.Courses)
+	})
+
+	t.Run("Usage Stats", func(t *testing.T) {
+		user := &entity.User{ID: uuid.New(), PasswdHash: "test_hash"}
+		require.NoError(t, db.Create(user).Error)
+		payment := &entity.Payment{
+			PaymentID:   uuid.New(),
+			UserID:      user.ID,
+			CourseID:    &course.CourseID,
+			Amount:      money.New(2691000, "RUB"),
+			PromoCodeID: &promo.PromoCodeID,
+			Discount:    money.New(299000, "RUB"),
+		}
+		require.NoError(t, paymentRepo.CreatePayment(payment))
+
+		usage := &entity.PromoCodeUsage{PromoCodeID: promo.PromoCodeID, UserID: user.ID, PaymentID: payment.PaymentID}
+		require.NoError(t, repo.RecordPromoCodeUsage(usage))
+		// повторная запись по тому же платежу не считается
+		require.NoError(t, repo.RecordPromoCodeUsage(&entity.PromoCodeUsage{PromoCodeID: promo.PromoCodeID, UserID: user.ID, PaymentID: payment.PaymentID}))
+
+		count, err := repo.CountPromoCodeUsagesByUser(promo.PromoCodeID, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		stats, err := repo.GetPromoCodeStats(promo.PromoCodeID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stats.Usages)
+		assert.Equal(t, int64(1), stats.Users)
+		assert.Equal(t, []money.Money{money.New(299000, "RUB")}, stats.TotalDiscount)
+		assert.Equal(t, []money.Money{money.New(2691000, "RUB")}, stats.Revenue)
+	})
+}
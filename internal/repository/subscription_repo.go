@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// интерфейс для работы с подписками
+// определяет все методы которые нужны для работы с планами, подписками и сохраненными способами оплаты
+type SubscriptionRepository interface {
+	CreatePlan(plan *entity.Plan) error
+	GetPlans(onlyActive bool) ([]entity.Plan, error)
+	GetPlanByID(planID uuid.UUID) (*entity.Plan, error)
+	UpdatePlan(plan *entity.Plan) error
+
+	CreateSubscription(subscription *entity.Subscription) error
+	GetSubscriptionByID(subscriptionID uuid.UUID) (*entity.Subscription, error)
+	GetSubscriptionsByUserID(userID uuid.UUID) ([]entity.Subscription, error)
+	UpdateSubscription(subscription *entity.Subscription) error
+	GetDueSubscriptions(now time.Time) ([]*entity.Subscription, error)
+	ClaimDueSubscription(subscriptionID uuid.UUID, now, until time.Time) (bool, error)
+	GetLapsedSubscriptions(now time.Time) ([]*entity.Subscription, error)
+
+	CreatePaymentMethod(method *entity.PaymentMethod) error
+	GetPaymentMethodByRef(ref string) (*entity.PaymentMethod, error)
+}
+
+// репозиторий для работы с подписками
+// реализует интерфейс SubscriptionRepository
+type SubscriptionRepo struct {
+	config *config.Config
+	DB     *gorm.DB
+}
+
+func NewSubscriptionRepo(cfg *config.Config) *SubscriptionRepo {
+	return &SubscriptionRepo{
+		config: cfg,
+		DB:     connectDB(cfg),
+	}
+}
+
+// CreatePlan создает план вместе со списком курсов
+func (r *SubscriptionRepo) CreatePlan(plan *entity.Plan) error {
+	return r.DB.Create(plan).Error
+}
+
+// GetPlans получает планы, новые сначала
+// если onlyActive - только те на которые можно подписаться
+func (r *SubscriptionRepo) GetPlans(onlyActive bool) ([]entity.Plan, error) {
+	var plans []entity.Plan
+	query := r.DB.Preload("Courses").Order("created_at DESC")
+	if onlyActive {
+		query = query.Where("active = ?", true)
+	}
+	if err := query.Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// GetPlanByID получает план по id
+func (r *SubscriptionRepo) GetPlanByID(planID uuid.UUID) (*entity.Plan, error) {
+	var plan entity.Plan
+	err := r.DB.Preload("Courses").Where("plan_id = ?", planID).First(&plan).Error
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// UpdatePlan обновляет план
+// список курсов заменяется целиком, уже выданный подписками доступ не меняется
+func (r *SubscriptionRepo) UpdatePlan(plan *entity.Plan) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&entity.Plan{}).Where("plan_id = ?", plan.PlanID).Updates(map[string]interface{}{
+			"title":           plan.Title,
+			"description":     plan.Description,
+			"amount_minor":    plan.Amount.Minor,
+			"amount_currency": plan.Amount.Currency,
+			"period_months":   plan.PeriodMonths,
+			"active":          plan.Active,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("plan_id = ?", plan.PlanID).Delete(&entity.PlanCourse{}).Error; err != nil {
+			return err
+		}
+		if len(plan.Courses) == 0 {
+			return nil
+		}
+		return tx.Create(&plan.Courses).Error
+	})
+}
+
+// CreateSubscription создает подписку
+func (r *SubscriptionRepo) CreateSubscription(subscription *entity.Subscription) error {
+	return r.DB.Omit(clause.Associations).Create(subscription).Error
+}
+
+// GetSubscriptionByID получает подписку вместе с планом и способом оплаты
+func (r *SubscriptionRepo) GetSubscriptionByID(subscriptionID uuid.UUID) (*entity.Subscription, error) {
+	var subscription entity.Subscription
+	err := r.DB.Preload("Plan.Courses").Preload("PaymentMethod").
+		Where("subscription_id = ?", subscriptionID).First(&subscription).Error
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// GetSubscriptionsByUserID получает все подписки пользователя, новые сначала
+func (r *SubscriptionRepo) GetSubscriptionsByUserID(userID uuid.UUID) ([]entity.Subscription, error) {
+	var subscriptions []entity.Subscription
+	err := r.DB.Preload("Plan.Courses").Preload("PaymentMethod").
+		Where("user_id = ?", userID).Order("created_at DESC").Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// UpdateSubscription сохраняет статус и даты подписки
+func (r *SubscriptionRepo) UpdateSubscription(subscription *entity.Subscription) error {
+	return r.DB.Omit(clause.Associations).Save(subscription).Error
+}
+
+// GetDueSubscriptions получает подписки за которые пора списать деньги
+func (r *SubscriptionRepo) GetDueSubscriptions(now time.Time) ([]*entity.Subscription, error) {
+	var subscriptions []*entity.Subscription
+	err := r.DB.Preload("Plan.Courses").Preload("PaymentMethod").
+		Where("status IN ? AND next_charge_at <= ?", []entity.SubscriptionStatus{entity.SubscriptionActive, entity.SubscriptionPastDue}, now).
+		Order("next_charge_at").Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// ClaimDueSubscription забирает продление подписки себе: переносит next_charge_at на until
+// строка блокируется с SKIP LOCKED, так что если списание уже начал другой экземпляр приложения, вернет false
+func (r *SubscriptionRepo) ClaimDueSubscription(subscriptionID uuid.UUID, now, until time.Time) (bool, error) {
+	claimed := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var subscriptions []entity.Subscription
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("subscription_id = ? AND status IN ? AND next_charge_at <= ?", subscriptionID,
+				[]entity.SubscriptionStatus{entity.SubscriptionActive, entity.SubscriptionPastDue}, now).
+			Find(&subscriptions).Error
+		if err != nil || len(subscriptions) == 0 {
+			return err
+		}
+		claimed = true
+		return tx.Model(&entity.Subscription{}).Where("subscription_id = ?", subscriptionID).
+			Update("next_charge_at", until).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return claimed, nil
+}
+
+// GetLapsedSubscriptions получает подписки у которых пора забрать доступ:
+// продление так и не прошло за льготный период или пользователь отменил подписку и период закончился
+func (r *SubscriptionRepo) GetLapsedSubscriptions(now time.Time) ([]*entity.Subscription, error) {
+	var subscriptions []*entity.Subscription
+	err := r.DB.
+		Where("(status = ? AND grace_until <= ?) OR (status = ? AND current_period_end <= ?)",
+			entity.SubscriptionPastDue, now, entity.SubscriptionCanceled, now).
+		Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// CreatePaymentMethod сохраняет способ оплаты
+func (r *SubscriptionRepo) CreatePaymentMethod(method *entity.PaymentMethod) error {
+	return r.DB.Omit(clause.Associations).Create(method).Error
+}
+
+// GetPaymentMethodByRef получает способ оплаты по id у провайдера
+func (r *SubscriptionRepo) GetPaymentMethodByRef(ref string) (*entity.PaymentMethod, error) {
+	var method entity.PaymentMethod
+	if err := r.DB.Where("ref = ?", ref).First(&method).Error; err != nil {
+		return nil, err
+	}
+	return &method, nil
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"mzt/internal/money"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionRepository(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := &config.Config{DB: config.DB{
+		Host:     "localhost",
+		Port:     "5433",
+		User:     "postgres",
+		Password: "postgres",
+		Name:     "mzt_test",
+	}}
+	repo := NewSubscriptionRepo(cfg)
+	repo.DB = db
+	courseRepo := NewCourseRepo(cfg)
+	courseRepo.DB = db
+
+	course := &entity.Course{CourseID: uuid.New(), Title: "Test Course"}
+	require.NoError(t, db.Create(course).Error)
+	user := &entity.User{ID: uuid.New(), PasswdHash: "test_hash"}
+	require.NoError(t, db.Create(user).Error)
+
+	plan := &entity.Plan{
+		PlanID:       uuid.New(),
+		Title:        "Все курсы",
+		Amount:       money.New(99000, "RUB"),
+		PeriodMonths: 1,
+		Active:       true,
+	}
+	plan.Courses = []entity.PlanCourse{{PlanID: plan.PlanID, CourseID: course.CourseID}}
+
+	t.Run("Create And Get Plan", func(t *testing.T) {
+		require.NoError(t, repo.CreatePlan(plan))
+
+		got, err := repo.GetPlanByID(plan.PlanID)
+		require.NoError(t, err)
+		assert.Equal(t, plan.Amount, got.Amount)
+		require.Len(t, got.Courses, 1)
+
+		plan.Active = false
+		require.NoError(t, repo.UpdatePlan(plan))
+		active, err := repo.GetPlans(true)
+		require.NoError(t, err)
+		assert.Empty(t, active)
+	})
+
+	now := time.Now()
+	periodEnd := now.Add(-time.Hour)
+	method := &entity.PaymentMethod{PaymentMethodID: uuid.New(), UserID: user.ID, Ref: "pm_1", Title: "Bank card *4444"}
+	subscription := &entity.Subscription{
+		SubscriptionID:   uuid.New(),
+		UserID:           user.ID,
+		PlanID:           plan.PlanID,
+		Status:           entity.SubscriptionActive,
+		PaymentMethodID:  &method.PaymentMethodID,
+		CurrentPeriodEnd: &periodEnd,
+		NextChargeAt:     &periodEnd,
+	}
+
+	t.Run("Due Subscriptions", func(t *testing.T) {
+		require.NoError(t, repo.CreatePaymentMethod(method))
+		require.NoError(t, repo.CreateSubscription(subscription))
+
+		due, err := repo.GetDueSubscriptions(now)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		require.NotNil(t, due[0].PaymentMethod)
+		assert.Equal(t, "pm_1", due[0].PaymentMethod.Ref)
+		assert.Len(t, due[0].Plan.Courses, 1)
+	})
+
+	t.Run("Claim Due Subscription", func(t *testing.T) {
+		until := now.Add(time.Hour)
+		claimed, err := repo.ClaimDueSubscription(subscription.SubscriptionID, now, until)
+		require.NoError(t, err)
+		assert.True(t, claimed)
+
+		// второй экземпляр уже не заберет то же продление
+		claimed, err = repo.ClaimDueSubscription(subscription.SubscriptionID, now, until)
+		require.NoError(t, err)
+		assert.False(t, claimed)
+		due, err := repo.GetDueSubscriptions(now)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+	})
+
+	t.Run("Lapsed Subscriptions", func(t *testing.T) {
+		subscription.Status = entity.SubscriptionCanceled
+		subscription.NextChargeAt = nil
+		require.NoError(t, repo.UpdateSubscription(subscription))
+
+		due, err := repo.GetDueSubscriptions(now)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+		lapsed, err := repo.GetLapsedSubscriptions(now)
+		require.NoError(t, err)
+		require.Len(t, lapsed, 1)
+		assert.Equal(t, subscription.SubscriptionID, lapsed[0].SubscriptionID)
+	})
+
+	t.Run("Revoke Subscription Assignments", func(t *testing.T) {
+		require.NoError(t, courseRepo.CreateCourseAssignment(&entity.CourseAssignment{
+			CaID:           uuid.New(),
+			UserID:         user.ID,
+			CourseID:       course.CourseID,
+			SubscriptionID: &subscription.SubscriptionID,
+		}))
+		require.NoError(t, courseRepo.DeleteSubscriptionAssignments(subscription.SubscriptionID))
+
+		_, err := courseRepo.GetCourseAssignment(course.CourseID, user.ID)
+		assert.Error(t, err)
+	})
+}
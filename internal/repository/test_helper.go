@@ -26,15 +26,42 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	time.Sleep(time.Second)
 
 	err := db.Migrator().DropTable(
+		"user_roles",
+		"role_permissions",
 		&entity.Course{},
 		&entity.Lesson{},
 		&entity.CourseAssignment{},
 		&entity.User{},
 		&entity.UserData{},
-		&entity.Auth{},
+		&entity.Session{},
+		&entity.SecurityEvent{},
+		&entity.Permission{},
+		&entity.Role{},
+		&entity.PasswordResetToken{},
+		&entity.TwoFactor{},
+		&entity.RecoveryCode{},
+		&entity.Identity{},
+		&entity.OAuthState{},
 		&entity.Payment{},
+		&entity.PaymentEvent{},
+		&entity.Refund{},
+		&entity.PromoCode{},
+		&entity.PromoCodeCourse{},
+		&entity.PromoCodeUsage{},
 		&entity.Event{},
 		&entity.CoursePrice{},
+		&entity.Bundle{},
+		&entity.BundleCourse{},
+		&entity.PaymentCourse{},
+		&entity.GiftCode{},
+		&entity.Invoice{},
+		&entity.InvoiceEmployee{},
+		&entity.Plan{},
+		&entity.PlanCourse{},
+		&entity.PaymentMethod{},
+		&entity.Subscription{},
+		&entity.InstallmentPlan{},
+		&entity.Installment{},
 	)
 	require.NoError(t, err)
 
@@ -44,24 +71,76 @@ func setupTestDB(t *testing.T) *gorm.DB {
 		&entity.CourseAssignment{},
 		&entity.User{},
 		&entity.UserData{},
-		&entity.Auth{},
+		&entity.Session{},
+		&entity.SecurityEvent{},
+		&entity.Permission{},
+		&entity.Role{},
+		&entity.PasswordResetToken{},
+		&entity.TwoFactor{},
+		&entity.RecoveryCode{},
+		&entity.Identity{},
+		&entity.OAuthState{},
 		&entity.Payment{},
+		&entity.PaymentEvent{},
+		&entity.Refund{},
+		&entity.PromoCode{},
+		&entity.PromoCodeCourse{},
+		&entity.PromoCodeUsage{},
 		&entity.Event{},
 		&entity.CoursePrice{},
+		&entity.Bundle{},
+		&entity.BundleCourse{},
+		&entity.PaymentCourse{},
+		&entity.GiftCode{},
+		&entity.Invoice{},
+		&entity.InvoiceEmployee{},
+		&entity.Plan{},
+		&entity.PlanCourse{},
+		&entity.PaymentMethod{},
+		&entity.Subscription{},
+		&entity.InstallmentPlan{},
+		&entity.Installment{},
 	)
 	require.NoError(t, err)
 
 	t.Cleanup(func() {
 		err := db.Migrator().DropTable(
+			"user_roles",
+			"role_permissions",
 			&entity.Course{},
 			&entity.Lesson{},
 			&entity.CourseAssignment{},
 			&entity.User{},
 			&entity.UserData{},
-			&entity.Auth{},
+			&entity.Session{},
+			&entity.SecurityEvent{},
+			&entity.Permission{},
+			&entity.Role{},
+			&entity.PasswordResetToken{},
+			&entity.TwoFactor{},
+			&entity.RecoveryCode{},
+			&entity.Identity{},
+			&entity.OAuthState{},
 			&entity.Payment{},
+			&entity.PaymentEvent{},
+			&entity.Refund{},
+			&entity.PromoCode{},
+			&entity.PromoCodeCourse{},
+			&entity.PromoCodeUsage{},
 			&entity.Event{},
 			&entity.CoursePrice{},
+			&entity.Bundle{},
+			&entity.BundleCourse{},
+			&entity.PaymentCourse{},
+			&entity.GiftCode{},
+			&entity.Invoice{},
+			&entity.InvoiceEmployee{},
+			&entity.Plan{},
+			&entity.PlanCourse{},
+			&entity.PaymentMethod{},
+			&entity.Subscription{},
+			&entity.InstallmentPlan{},
+			&entity.Installment{},
 		)
 		require.NoError(t, err)
 	})
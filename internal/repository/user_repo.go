@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
 	"mzt/config"
 	"mzt/internal/entity"
@@ -9,20 +10,82 @@ import (
 	"github.com/google/uuid"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrTokenReused refresh токен сессии уже обменяли на новый
+var ErrTokenReused = errors.New("refresh token was already rotated")
+
+// ErrResetTokenInvalid ссылки для сброса пароля нет, она истекла или уже использована
+var ErrResetTokenInvalid = errors.New("password reset token is invalid or expired")
+
 // интерфейс для работы с пользователями
 // определяет все методы которые нужны для работы с пользователями в базе
 type UserRepository interface {
 	GetUserByEmail(email string) (*entity.User, error)
 	GetUserWithDataById(id uuid.UUID) (*entity.User, error)
-	GetUserWithRefreshById(id uuid.UUID) (*entity.User, error)
-	CreateUser(user *entity.User, userData *entity.UserData, auth *entity.Auth) error
-	UpdateToken(userId uuid.UUID, token string) error
+	CreateUser(user *entity.User, userData *entity.UserData, session *entity.Session) error
 	DeleteUser(userId uuid.UUID) error
 	UpdateUser(userId uuid.UUID, updated *entity.UserData) error
 	GetUsers() ([]entity.User, error)
 	GetUserById(userId uuid.UUID) (*entity.User, error)
+
+	// неверные пароли и блокировка входа
+	LoginFailed(userId uuid.UUID, maxAttempts int, lockout, maxLockout time.Duration, event *entity.SecurityEvent) (*time.Time, error)
+	LoginSucceeded(userId uuid.UUID) error
+
+	// вход через внешние сервисы
+	GetIdentity(provider, subject string) (*entity.Identity, error)
+	GetUserIdentity(userId uuid.UUID, provider string) (*entity.Identity, error)
+	CountUserIdentities(userId uuid.UUID) (int64, error)
+	LinkIdentity(identity *entity.Identity, event *entity.SecurityEvent) error
+	UnlinkIdentity(userId uuid.UUID, provider string, event *entity.SecurityEvent) error
+	GetUserByTelegram(telegram string) (*entity.User, error)
+	GetUsersByEmailFold(email string) ([]*entity.User, error)
+	CreateOAuthState(state *entity.OAuthState) error
+	ConsumeOAuthState(stateHash string) (*entity.OAuthState, error)
+
+	// сессии пользователя, по одной на каждое устройство
+	CreateSession(session *entity.Session) error
+	GetSession(sessionId uuid.UUID) (*entity.Session, error)
+	GetSessionsByUserID(userId uuid.UUID) ([]entity.Session, error)
+	RotateSessionToken(session *entity.Session, previousHash string) error
+	DeleteSession(userId uuid.UUID, sessionId uuid.UUID) error
+	DeleteOtherSessions(userId uuid.UUID, keepSessionId uuid.UUID) error
+	RevokeSessionFamily(sessionId uuid.UUID, event *entity.SecurityEvent) error
+
+	// события безопасности по аккаунту
+	GetSecurityEvents(userId uuid.UUID) ([]entity.SecurityEvent, error)
+
+	// сброс пароля по ссылке из письма
+	CreatePasswordResetToken(token *entity.PasswordResetToken) error
+	ResetPassword(tokenHash string, passwdHash string, event *entity.SecurityEvent) (uuid.UUID, error)
+
+	// подтверждение почты
+	MarkEmailVerified(userId uuid.UUID, at time.Time) error
+	MarkVerificationSent(userId uuid.UUID, at time.Time, since time.Time) (bool, error)
+
+	// смена пароля и почты самим пользователем
+	ChangePassword(userId uuid.UUID, passwdHash string, keepSessionId uuid.UUID, event *entity.SecurityEvent) error
+	ChangeEmail(userId uuid.UUID, email string, verifiedAt time.Time, event *entity.SecurityEvent) error
+
+	// роли и права
+	GetRoles() ([]entity.Role, error)
+	GetRolesByNames(names []string) ([]entity.Role, error)
+	GetUserRoles(userId uuid.UUID) ([]entity.Role, error)
+	SetUserRoles(userId uuid.UUID, roles []entity.Role, event *entity.SecurityEvent) error
+	CountUsersWithRole(name string) (int64, error)
+
+	// вход по одноразовым кодам
+	GetTwoFactor(userId uuid.UUID) (*entity.TwoFactor, error)
+	SaveTwoFactor(twoFactor *entity.TwoFactor) error
+	EnableTwoFactor(userId uuid.UUID, at time.Time, counter int64, codes []entity.RecoveryCode, keepSessionId uuid.UUID, event *entity.SecurityEvent) error
+	DisableTwoFactor(userId uuid.UUID, event *entity.SecurityEvent) error
+	UseTwoFactorCounter(userId uuid.UUID, counter int64) (bool, error)
+	TwoFactorFailed(userId uuid.UUID, maxAttempts int, lockUntil time.Time) (bool, error)
+	UseRecoveryCode(userId uuid.UUID, codeHash string, event *entity.SecurityEvent) (bool, error)
+	ReplaceRecoveryCodes(userId uuid.UUID, codes []entity.RecoveryCode) error
+	CountRecoveryCodes(userId uuid.UUID) (int64, error)
 }
 
 // репозиторий для работы с пользователями
@@ -44,7 +107,7 @@ func NewUserRepo(cfg *config.Config) *UserRepo {
 // загружает данные пользователей и их курсы
 func (r *UserRepo) GetUsers() ([]entity.User, error) {
 	var users []entity.User
-	err := r.DB.Preload("UserData").Preload("CourseAssignments").Preload("CourseAssignments.Course").Find(&users).Error
+	err := r.DB.Preload("UserData").Preload("Roles").Preload("CourseAssignments").Preload("CourseAssignments.Course").Find(&users).Error
 	if err != nil {
 		return nil, err
 	}
@@ -81,8 +144,8 @@ func (r *UserRepo) GetUserById(userId uuid.UUID) (*entity.User, error) {
 }
 
 // создает нового пользователя
-// создает записи в таблицах users, user_data и auth
-func (r *UserRepo) CreateUser(user *entity.User, userData *entity.UserData, auth *entity.Auth) error {
+// создает записи в таблицах users, user_data и первую сессию если она передана
+func (r *UserRepo) CreateUser(user *entity.User, userData *entity.UserData, session *entity.Session) error {
 	// начинаем транзакцию
 	tx := r.DB.Begin()
 	if tx.Error != nil {
@@ -103,11 +166,13 @@ func (r *UserRepo) CreateUser(user *entity.User, userData *entity.UserData, auth
 		return err
 	}
 
-	// создаем запись для авторизации
-	err = tx.Create(&auth).Error
-	if err != nil {
-		tx.Rollback()
-		return err
+	// создаем сессию устройства с которого зарегистрировались
+	if session != nil {
+		err = tx.Create(session).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
 	// завершаем транзакцию
@@ -164,44 +229,554 @@ func (r *UserRepo) GetUserWithDataById(userId uuid.UUID) (*entity.User, error) {
 	return &user, nil
 }
 
-// получает пользователя с токеном
-// загружает данные пользователя вместе с его токеном
-func (r *UserRepo) GetUserWithRefreshById(userId uuid.UUID) (*entity.User, error) {
-	var user entity.User
-	err := r.DB.Preload("Auth").First(&user, "id = ?", userId).Error
+// создает сессию нового устройства
+// заодно чистит истекшие сессии пользователя чтобы таблица не росла бесконечно
+func (r *UserRepo) CreateSession(session *entity.Session) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND expires_at < ?", session.UserID, time.Now()).Delete(&entity.Session{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(session).Error
+	})
+}
+
+// получает сессию по id
+func (r *UserRepo) GetSession(sessionId uuid.UUID) (*entity.Session, error) {
+	var session entity.Session
+	err := r.DB.Where("session_id = ?", sessionId).First(&session).Error
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+	return &session, nil
 }
 
-// обновляет токен пользователя
-// обновляет запись в таблице auth
-func (r *UserRepo) UpdateToken(userId uuid.UUID, token string) error {
-	// начинаем транзакцию
-	tx := r.DB.Begin()
-	if tx.Error != nil {
-		return tx.Error
+// получает действующие сессии пользователя, недавно использованные первыми
+func (r *UserRepo) GetSessionsByUserID(userId uuid.UUID) ([]entity.Session, error) {
+	var sessions []entity.Session
+	err := r.DB.Where("user_id = ? AND expires_at > ?", userId, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
 	}
+	return sessions, nil
+}
 
-	// получаем текущий токен
-	var auth entity.Auth
-	if err := tx.Where("user_id = ?", userId).First(&auth).Error; err != nil {
-		tx.Rollback()
-		return err
+// сохраняет новый refresh токен сессии
+// обновление проходит только если в базе все еще лежит previousHash,
+// так из двух одновременных обменов одного токена успешным будет только один
+func (r *UserRepo) RotateSessionToken(session *entity.Session, previousHash string) error {
+	result := r.DB.Model(&entity.Session{}).
+		Where("session_id = ? AND token_hash = ?", session.SessionID, previousHash).
+		Updates(map[string]interface{}{
+			"token_hash":   session.TokenHash,
+			"token_id":     session.TokenID,
+			"user_agent":   session.UserAgent,
+			"ip":           session.IP,
+			"last_used_at": session.LastUsedAt,
+			"expires_at":   session.ExpiresAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenReused
 	}
+	return nil
+}
 
-	// обновляем токен
-	auth.Key = token
+// удаляет одну сессию пользователя
+func (r *UserRepo) DeleteSession(userId uuid.UUID, sessionId uuid.UUID) error {
+	return r.DB.Where("user_id = ? AND session_id = ?", userId, sessionId).Delete(&entity.Session{}).Error
+}
 
-	// сохраняем изменения
-	if err := tx.Save(&auth).Error; err != nil {
-		tx.Rollback()
+// удаляет все сессии пользователя кроме указанной
+func (r *UserRepo) DeleteOtherSessions(userId uuid.UUID, keepSessionId uuid.UUID) error {
+	return r.DB.Where("user_id = ? AND session_id <> ?", userId, keepSessionId).Delete(&entity.Session{}).Error
+}
+
+// отзывает все семейство refresh токенов сессии и записывает событие безопасности
+// удаление сессии и запись события в одной транзакции
+func (r *UserRepo) RevokeSessionFamily(sessionId uuid.UUID, event *entity.SecurityEvent) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", sessionId).Delete(&entity.Session{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(event).Error
+	})
+}
+
+// получает события безопасности пользователя, новые первыми
+func (r *UserRepo) GetSecurityEvents(userId uuid.UUID) ([]entity.SecurityEvent, error) {
+	var events []entity.SecurityEvent
+	err := r.DB.Where("user_id = ?", userId).Order("created_at DESC").Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// сохраняет ссылку для сброса пароля
+func (r *UserRepo) CreatePasswordResetToken(token *entity.PasswordResetToken) error {
+	return r.DB.Create(token).Error
+}
+
+// меняет пароль по ссылке из письма и возвращает id пользователя
+// в одной транзакции: гасит ссылку и остальные неиспользованные ссылки пользователя,
+// меняет хеш пароля, завершает все сессии и записывает событие безопасности (user_id заполняется тут)
+func (r *UserRepo) ResetPassword(tokenHash string, passwdHash string, event *entity.SecurityEvent) (uuid.UUID, error) {
+	var userId uuid.UUID
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var token entity.PasswordResetToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+			First(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrResetTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+		userId = token.UserID
+
+		if err := tx.Model(&entity.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", userId).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&entity.User{}).Where("id = ?", userId).Update("passwd_hash", passwdHash).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userId).Delete(&entity.Session{}).Error; err != nil {
+			return err
+		}
+
+		event.UserID = userId
+		return tx.Create(event).Error
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return userId, nil
+}
+
+// отмечает что пользователь подтвердил почту
+func (r *UserRepo) MarkEmailVerified(userId uuid.UUID, at time.Time) error {
+	return r.DB.Model(&entity.User{}).Where("id = ?", userId).Update("email_verified_at", at).Error
+}
+
+// отмечает отправку письма для подтверждения почты
+// отметка ставится только если прошлое письмо ушло раньше since, иначе возвращает false
+// проверка и запись одним запросом, чтобы параллельные запросы не отправили два письма
+func (r *UserRepo) MarkVerificationSent(userId uuid.UUID, at time.Time, since time.Time) (bool, error) {
+	result := r.DB.Model(&entity.User{}).
+		Where("id = ? AND (verification_sent_at IS NULL OR verification_sent_at <= ?)", userId, since).
+		Update("verification_sent_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// меняет хеш пароля и завершает все сессии пользователя кроме текущей
+// вместе с записью события безопасности в одной транзакции
+func (r *UserRepo) ChangePassword(userId uuid.UUID, passwdHash string, keepSessionId uuid.UUID, event *entity.SecurityEvent) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&entity.User{}).Where("id = ?", userId).Update("passwd_hash", passwdHash).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND session_id <> ?", userId, keepSessionId).Delete(&entity.Session{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(event).Error
+	})
+}
+
+// меняет почту на уже подтвержденную новую
+// почта - subject в токенах, поэтому все сессии завершаются и войти нужно заново
+func (r *UserRepo) ChangeEmail(userId uuid.UUID, email string, verifiedAt time.Time, event *entity.SecurityEvent) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&entity.UserData{}).Where("user_id = ?", userId).Update("email", email).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&entity.User{}).Where("id = ?", userId).Update("email_verified_at", verifiedAt).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userId).Delete(&entity.Session{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(event).Error
+	})
+}
+
+// все роли с правами
+func (r *UserRepo) GetRoles() ([]entity.Role, error) {
+	var roles []entity.Role
+	err := r.DB.Preload("Permissions").Order("name").Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// роли по названиям, неизвестные названия пропускаются
+func (r *UserRepo) GetRolesByNames(names []string) ([]entity.Role, error) {
+	var roles []entity.Role
+	err := r.DB.Preload("Permissions").Where("name IN ?", names).Order("name").Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// роли пользователя вместе с их правами
+func (r *UserRepo) GetUserRoles(userId uuid.UUID) ([]entity.Role, error) {
+	var roles []entity.Role
+	err := r.DB.Preload("Permissions").
+		Joins("JOIN user_roles ON user_roles.role_name = roles.name").
+		Where("user_roles.user_id = ?", userId).
+		Order("name").
+		Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// заменяет роли пользователя и записывает событие безопасности в одной транзакции
+func (r *UserRepo) SetUserRoles(userId uuid.UUID, roles []entity.Role, event *entity.SecurityEvent) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		user := &entity.User{ID: userId}
+		if err := tx.Model(user).Omit("Roles.*").Association("Roles").Replace(roles); err != nil {
+			return err
+		}
+		return tx.Create(event).Error
+	})
+}
+
+// сколько пользователей с ролью
+func (r *UserRepo) CountUsersWithRole(name string) (int64, error) {
+	var count int64
+	err := r.DB.Table("user_roles").Where("role_name = ?", name).Count(&count).Error
+	return count, err
+}
+
+// считает неверный пароль, после maxAttempts подряд блокирует вход и пишет событие
+// возвращает до какого момента вход заблокирован, nil - еще не заблокирован
+func (r *UserRepo) LoginFailed(userId uuid.UUID, maxAttempts int, lockout, maxLockout time.Duration, event *entity.SecurityEvent) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var user entity.User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", userId).
+			First(&user).Error
+		if err != nil {
+			return err
+		}
+
+		if user.FailedLogins+1 < maxAttempts {
+			return tx.Model(&user).Update("failed_logins", user.FailedLogins+1).Error
+		}
+
+		until := time.Now().Add(user.NextLockout(lockout, maxLockout))
+		err = tx.Model(&user).Updates(map[string]interface{}{
+			"failed_logins":  0,
+			"login_lockouts": user.LoginLockouts + 1,
+			"locked_until":   until,
+		}).Error
+		if err != nil {
+			return err
+		}
+		lockedUntil = &until
+		return tx.Create(event).Error
+	})
+	return lockedUntil, err
+}
+
+// сбрасывает счетчики неверных паролей после успешного входа
+func (r *UserRepo) LoginSucceeded(userId uuid.UUID) error {
+	return r.DB.Model(&entity.User{}).Where("id = ?", userId).
+		Updates(map[string]interface{}{"failed_logins": 0, "login_lockouts": 0, "locked_until": nil}).Error
+}
+
+// аккаунт внешнего сервиса, nil если его ни к кому не привязывали
+func (r *UserRepo) GetIdentity(provider, subject string) (*entity.Identity, error) {
+	var identity entity.Identity
+	err := r.DB.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// привязанный к пользователю аккаунт сервиса, nil если не привязан
+func (r *UserRepo) GetUserIdentity(userId uuid.UUID, provider string) (*entity.Identity, error) {
+	var identity entity.Identity
+	err := r.DB.Where("user_id = ? AND provider = ?", userId, provider).First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// сколько внешних сервисов привязано к пользователю
+func (r *UserRepo) CountUserIdentities(userId uuid.UUID) (int64, error) {
+	var count int64
+	err := r.DB.Model(&entity.Identity{}).Where("user_id = ?", userId).Count(&count).Error
+	return count, err
+}
+
+// привязывает аккаунт сервиса к пользователю и пишет событие
+func (r *UserRepo) LinkIdentity(identity *entity.Identity, event *entity.SecurityEvent) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(identity).Error; err != nil {
+			return err
+		}
+		return tx.Create(event).Error
+	})
+}
+
+// отвязывает аккаунт сервиса от пользователя и пишет событие
+func (r *UserRepo) UnlinkIdentity(userId uuid.UUID, provider string, event *entity.SecurityEvent) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND provider = ?", userId, provider).Delete(&entity.Identity{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Create(event).Error
+	})
+}
+
+// пользователь у которого в профиле указан этот телеграм, без учета регистра
+// nil если такого нет или профилей с ним несколько и непонятно чей он
+func (r *UserRepo) GetUserByTelegram(telegram string) (*entity.User, error) {
+	var data []entity.UserData
+	err := r.DB.Where("lower(telegram) = lower(?)", telegram).Limit(2).Find(&data).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 1 {
+		return nil, nil
+	}
+	return r.GetUserById(data[0].UserID)
+}
+
+// пользователи с этой почтой без учета регистра
+// почта хранится как ее ввели, поэтому разные написания одного адреса могут оказаться у разных аккаунтов
+func (r *UserRepo) GetUsersByEmailFold(email string) ([]*entity.User, error) {
+	var data []entity.UserData
+	if err := r.DB.Where("lower(email) = lower(?)", email).Find(&data).Error; err != nil {
+		return nil, err
+	}
+	users := make([]*entity.User, 0, len(data))
+	for _, userData := range data {
+		user, err := r.GetUserById(userData.UserID)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// сохраняет начатый вход через внешний сервис
+func (r *UserRepo) CreateOAuthState(state *entity.OAuthState) error {
+	return r.DB.Create(state).Error
+}
+
+// забирает начатый вход по хешу state, второй раз его не получить
+// nil если такого нет или он истек, заодно удаляет истекшие
+func (r *UserRepo) ConsumeOAuthState(stateHash string) (*entity.OAuthState, error) {
+	var found *entity.OAuthState
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var state entity.OAuthState
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("state_hash = ? AND expires_at > ?", stateHash, now).
+			First(&state).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			found = &state
+		}
+		return tx.Where("state_hash = ? OR expires_at <= ?", stateHash, now).Delete(&entity.OAuthState{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// настройки входа по кодам, nil если пользователь их не подключал
+func (r *UserRepo) GetTwoFactor(userId uuid.UUID) (*entity.TwoFactor, error) {
+	var twoFactor entity.TwoFactor
+	err := r.DB.Where("user_id = ?", userId).First(&twoFactor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &twoFactor, nil
+}
+
+// сохраняет новый секрет, пока подключение не подтверждено кодом
+func (r *UserRepo) SaveTwoFactor(twoFactor *entity.TwoFactor) error {
+	return r.DB.Save(twoFactor).Error
+}
+
+// включает вход по кодам после подтверждения первым кодом
+// в одной транзакции выпускает коды восстановления, завершает остальные сессии и пишет событие
+func (r *UserRepo) EnableTwoFactor(userId uuid.UUID, at time.Time, counter int64, codes []entity.RecoveryCode, keepSessionId uuid.UUID, event *entity.SecurityEvent) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&entity.TwoFactor{}).
+			Where("user_id = ? AND enabled_at IS NULL", userId).
+			Updates(map[string]interface{}{"enabled_at": at, "last_counter": counter, "failed_attempts": 0})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := replaceRecoveryCodes(tx, userId, codes); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND session_id <> ?", userId, keepSessionId).Delete(&entity.Session{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(event).Error
+	})
+}
+
+// отключает вход по кодам и удаляет коды восстановления
+func (r *UserRepo) DisableTwoFactor(userId uuid.UUID, event *entity.SecurityEvent) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&entity.TwoFactor{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userId).Delete(&entity.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(event).Error
+	})
+}
+
+// запоминает шаг принятого кода и сбрасывает счетчик ошибок
+// false - код этого или более позднего шага уже принимали
+func (r *UserRepo) UseTwoFactorCounter(userId uuid.UUID, counter int64) (bool, error) {
+	result := r.DB.Model(&entity.TwoFactor{}).
+		Where("user_id = ? AND last_counter < ?", userId, counter).
+		Updates(map[string]interface{}{"last_counter": counter, "failed_attempts": 0, "locked_until": nil})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// считает неверный код, после maxAttempts подряд блокирует проверку до lockUntil
+// true - проверка только что заблокирована
+func (r *UserRepo) TwoFactorFailed(userId uuid.UUID, maxAttempts int, lockUntil time.Time) (bool, error) {
+	locked := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&entity.TwoFactor{}).Where("user_id = ?", userId).
+			Update("failed_attempts", gorm.Expr("failed_attempts + 1")).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&entity.TwoFactor{}).
+			Where("user_id = ? AND failed_attempts >= ?", userId, maxAttempts).
+			Updates(map[string]interface{}{"failed_attempts": 0, "locked_until": lockUntil})
+		locked = result.RowsAffected > 0
+		return result.Error
+	})
+	return locked, err
+}
+
+// гасит код восстановления и пишет событие
+// false - такого неиспользованного кода у пользователя нет
+func (r *UserRepo) UseRecoveryCode(userId uuid.UUID, codeHash string, event *entity.SecurityEvent) (bool, error) {
+	used := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&entity.RecoveryCode{}).
+			Where("code_hash = ? AND user_id = ? AND used_at IS NULL", codeHash, userId).
+			Update("used_at", time.Now())
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		used = true
+		if err := tx.Model(&entity.TwoFactor{}).Where("user_id = ?", userId).
+			Updates(map[string]interface{}{"failed_attempts": 0, "locked_until": nil}).Error; err != nil {
+			return err
+		}
+		return tx.Create(event).Error
+	})
+	return used, err
+}
+
+// заменяет коды восстановления новыми, старые перестают работать
+func (r *UserRepo) ReplaceRecoveryCodes(userId uuid.UUID, codes []entity.RecoveryCode) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userId, codes)
+	})
+}
+
+// сколько неиспользованных кодов восстановления осталось
+func (r *UserRepo) CountRecoveryCodes(userId uuid.UUID) (int64, error) {
+	var count int64
+	err := r.DB.Model(&entity.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userId).Count(&count).Error
+	return count, err
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userId uuid.UUID, codes []entity.RecoveryCode) error {
+	if err := tx.Where("user_id = ?", userId).Delete(&entity.RecoveryCode{}).Error; err != nil {
 		return err
 	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return tx.Create(&codes).Error
+}
 
-	// завершаем транзакцию
-	return tx.Commit().Error
+// SyncRoles создает права и роли и приводит права ролей к переданным
+// вызывается из миграций со встроенными ролями
+func (r *UserRepo) SyncRoles(permissions []entity.Permission, roles []entity.Role) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "code"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description"}),
+		}).Create(&permissions).Error
+		if err != nil {
+			return err
+		}
+
+		for _, role := range roles {
+			role := role
+			rolePermissions := role.Permissions
+			role.Permissions = nil
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "name"}},
+				DoUpdates: clause.AssignmentColumns([]string{"description"}),
+			}).Create(&role).Error
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&role).Omit("Permissions.*").Association("Permissions").Replace(rolePermissions); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // подключение к базе данных
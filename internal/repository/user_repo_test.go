@@ -0,0 +1,390 @@
+package repository
+
+import (
+	"mzt/config"
+	"mzt/internal/entity"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupUserRepo(t *testing.T) *UserRepo {
+	db := setupTestDB(t)
+	userRepo := NewUserRepo(&config.Config{DB: config.DB{
+		Host:     "localhost",
+		Port:     "5433",
+		User:     "postgres",
+		Password: "postgres",
+		Name:     "mzt_test",
+	}})
+	userRepo.DB = db
+	return userRepo
+}
+
+func TestUserRepository_Sessions(t *testing.T) {
+	userRepo := setupUserRepo(t)
+
+	userId := uuid.New()
+	user := &entity.User{
+		ID:         userId,
+		PasswdHash: "test_hash",
+		Roles:      []entity.Role{{Name: entity.RoleSuperadmin}},
+	}
+	userData := &entity.UserData{
+		UserID:      userId,
+		Email:       "test2@example.com",
+		Name:        "Test User 2",
+		Birthdate:   time.Now(),
+		PhoneNumber: "+1234567891",
+	}
+	laptop := &entity.Session{
+		SessionID:  uuid.New(),
+		UserID:     userId,
+		TokenHash:  "laptop_hash",
+		UserAgent:  "laptop",
+		LastUsedAt: time.Now().Add(-time.Hour),
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+
+	err := userRepo.CreateUser(user, userData, laptop)
+	require.NoError(t, err)
+
+	// вход с телефона не трогает сессию ноутбука
+	phone := &entity.Session{
+		SessionID:  uuid.New(),
+		UserID:     userId,
+		TokenHash:  "phone_hash",
+		UserAgent:  "phone",
+		LastUsedAt: time.Now(),
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	require.NoError(t, userRepo.CreateSession(phone))
+
+	sessions, err := userRepo.GetSessionsByUserID(userId)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, phone.SessionID, sessions[0].SessionID)
+
+	got, err := userRepo.GetSession(laptop.SessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "laptop_hash", got.TokenHash)
+
+	// токен меняется только если предъявлен последний выданный
+	got.TokenHash = "laptop_hash_2"
+	require.NoError(t, userRepo.RotateSessionToken(got, "laptop_hash"))
+	got.TokenHash = "laptop_hash_3"
+	assert.ErrorIs(t, userRepo.RotateSessionToken(got, "laptop_hash"), ErrTokenReused)
+
+	sessionId := laptop.SessionID
+	require.NoError(t, userRepo.RevokeSessionFamily(laptop.SessionID, &entity.SecurityEvent{
+		UserID:    userId,
+		Type:      entity.SecurityRefreshTokenReuse,
+		SessionID: &sessionId,
+	}))
+	_, err = userRepo.GetSession(laptop.SessionID)
+	assert.Error(t, err)
+	events, err := userRepo.GetSecurityEvents(userId)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	require.NoError(t, userRepo.DeleteOtherSessions(userId, phone.SessionID))
+	sessions, err = userRepo.GetSessionsByUserID(userId)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, phone.SessionID, sessions[0].SessionID)
+
+	require.NoError(t, userRepo.DeleteSession(userId, phone.SessionID))
+	_, err = userRepo.GetSession(phone.SessionID)
+	assert.Error(t, err)
+}
+
+func TestUserRepository_PasswordReset(t *testing.T) {
+	userRepo := setupUserRepo(t)
+
+	userId := uuid.New()
+	user := &entity.User{ID: userId, PasswdHash: "old_hash"}
+	userData := &entity.UserData{UserID: userId, Email: "reset@example.com", Name: "Reset User", Birthdate: time.Now()}
+	session := &entity.Session{
+		SessionID: uuid.New(),
+		UserID:    userId,
+		TokenHash: "reset_session_hash",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	require.NoError(t, userRepo.CreateUser(user, userData, session))
+
+	require.NoError(t, userRepo.CreatePasswordResetToken(&entity.PasswordResetToken{TokenHash: "expired", UserID: userId, ExpiresAt: time.Now().Add(-time.Minute)}))
+	require.NoError(t, userRepo.CreatePasswordResetToken(&entity.PasswordResetToken{TokenHash: "first", UserID: userId, ExpiresAt: time.Now().Add(time.Hour)}))
+	require.NoError(t, userRepo.CreatePasswordResetToken(&entity.PasswordResetToken{TokenHash: "second", UserID: userId, ExpiresAt: time.Now().Add(time.Hour)}))
+
+	_, err := userRepo.ResetPassword("expired", "new_hash", &entity.SecurityEvent{Type: entity.SecurityPasswordReset})
+	assert.ErrorIs(t, err, ErrResetTokenInvalid)
+
+	got, err := userRepo.ResetPassword("first", "new_hash", &entity.SecurityEvent{Type: entity.SecurityPasswordReset})
+	require.NoError(t, err)
+	assert.Equal(t, userId, got)
+
+	gotUser, err := userRepo.GetUserById(userId)
+	require.NoError(t, err)
+	assert.Equal(t, "new_hash", gotUser.PasswdHash)
+	_, err = userRepo.GetSession(session.SessionID)
+	assert.Error(t, err)
+
+	// остальные ссылки пользователя тоже погашены
+	_, err = userRepo.ResetPassword("second", "other_hash", &entity.SecurityEvent{Type: entity.SecurityPasswordReset})
+	assert.ErrorIs(t, err, ErrResetTokenInvalid)
+}
+
+func TestUserRepository_EmailVerification(t *testing.T) {
+	userRepo := setupUserRepo(t)
+
+	userId := uuid.New()
+	user := &entity.User{ID: userId, PasswdHash: "test_hash"}
+	userData := &entity.UserData{UserID: userId, Email: "verify@example.com", Name: "Verify User", Birthdate: time.Now()}
+	require.NoError(t, userRepo.CreateUser(user, userData, nil))
+
+	now := time.Now()
+	sent, err := userRepo.MarkVerificationSent(userId, now, now.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.True(t, sent)
+	// второе письмо в пределах интервала не отправляется
+	sent, err = userRepo.MarkVerificationSent(userId, now.Add(time.Second), now.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.False(t, sent)
+
+	require.NoError(t, userRepo.MarkEmailVerified(userId, now))
+	gotUser, err := userRepo.GetUserById(userId)
+	require.NoError(t, err)
+	assert.NotNil(t, gotUser.EmailVerifiedAt)
+}
+
+func TestUserRepository_PasswordAndEmailChange(t *testing.T) {
+	userRepo := setupUserRepo(t)
+
+	userId := uuid.New()
+	user := &entity.User{ID: userId, PasswdHash: "old_hash"}
+	userData := &entity.UserData{UserID: userId, Email: "change@example.com", Name: "Change User", Birthdate: time.Now()}
+	current := &entity.Session{SessionID: uuid.New(), UserID: userId, TokenHash: "change_current", ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, userRepo.CreateUser(user, userData, current))
+	other := &entity.Session{SessionID: uuid.New(), UserID: userId, TokenHash: "change_other", ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, userRepo.CreateSession(other))
+
+	require.NoError(t, userRepo.ChangePassword(userId, "new_hash", current.SessionID, &entity.SecurityEvent{UserID: userId, Type: entity.SecurityPasswordChanged}))
+	gotUser, err := userRepo.GetUserById(userId)
+	require.NoError(t, err)
+	assert.Equal(t, "new_hash", gotUser.PasswdHash)
+	sessions, err := userRepo.GetSessionsByUserID(userId)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, current.SessionID, sessions[0].SessionID)
+
+	// занятую почту взять нельзя
+	takenId := uuid.New()
+	require.NoError(t, userRepo.CreateUser(&entity.User{ID: takenId, PasswdHash: "test_hash"}, &entity.UserData{UserID: takenId, Email: "taken@example.com", Name: "Taken User", Birthdate: time.Now()}, nil))
+	err = userRepo.ChangeEmail(userId, "taken@example.com", time.Now(), &entity.SecurityEvent{UserID: userId, Type: entity.SecurityEmailChanged})
+	assert.Error(t, err)
+
+	require.NoError(t, userRepo.ChangeEmail(userId, "changed@example.com", time.Now(), &entity.SecurityEvent{UserID: userId, Type: entity.SecurityEmailChanged}))
+	gotUser, err = userRepo.GetUserByEmail("changed@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, userId, gotUser.ID)
+	assert.NotNil(t, gotUser.EmailVerifiedAt)
+	sessions, err = userRepo.GetSessionsByUserID(userId)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+	events, err := userRepo.GetSecurityEvents(userId)
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+}
+
+func TestUserRepository_Roles(t *testing.T) {
+	userRepo := setupUserRepo(t)
+
+	require.NoError(t, userRepo.SyncRoles(entity.Permissions, entity.BuiltinRoles))
+	// повторная синхронизация ничего не ломает
+	require.NoError(t, userRepo.SyncRoles(entity.Permissions, entity.BuiltinRoles))
+
+	roles, err := userRepo.GetRoles()
+	require.NoError(t, err)
+	require.Len(t, roles, len(entity.BuiltinRoles))
+
+	userId := uuid.New()
+	user := &entity.User{ID: userId, PasswdHash: "test_hash", Roles: []entity.Role{{Name: entity.RoleStudent}}}
+	userData := &entity.UserData{UserID: userId, Email: "roles@example.com", Name: "Roles User", Birthdate: time.Now()}
+	require.NoError(t, userRepo.CreateUser(user, userData, nil))
+
+	got, err := userRepo.GetUserRoles(userId)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.False(t, entity.HasPermission(got, entity.PermCoursesWrite))
+
+	assigned, err := userRepo.GetRolesByNames([]string{entity.RoleContentEditor, entity.RoleCurator, "unknown"})
+	require.NoError(t, err)
+	require.Len(t, assigned, 2)
+	require.NoError(t, userRepo.SetUserRoles(userId, assigned, &entity.SecurityEvent{UserID: userId, Type: entity.SecurityRolesChanged}))
+
+	got, err = userRepo.GetUserRoles(userId)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.True(t, entity.HasPermission(got, entity.PermCoursesWrite))
+	assert.True(t, entity.HasPermission(got, entity.PermEnrollmentsWrite))
+	assert.False(t, entity.HasPermission(got, entity.PermPaymentsWrite))
+
+	count, err := userRepo.CountUsersWithRole(entity.RoleCurator)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// сами роли при замене не меняются
+	roles, err = userRepo.GetRolesByNames([]string{entity.RoleCurator})
+	require.NoError(t, err)
+	assert.Len(t, roles[0].Permissions, 3)
+}
+
+func TestUserRepository_TwoFactor(t *testing.T) {
+	userRepo := setupUserRepo(t)
+
+	userId := uuid.New()
+	user := &entity.User{ID: userId, PasswdHash: "test_hash", Roles: []entity.Role{{Name: entity.RoleStudent}}}
+	userData := &entity.UserData{UserID: userId, Email: "two-factor@example.com", Name: "Two Factor User", Birthdate: time.Now()}
+	require.NoError(t, userRepo.CreateUser(user, userData, nil))
+
+	twoFactor, err := userRepo.GetTwoFactor(userId)
+	require.NoError(t, err)
+	assert.Nil(t, twoFactor)
+
+	require.NoError(t, userRepo.SaveTwoFactor(&entity.TwoFactor{UserID: userId, Secret: "sealed"}))
+	codes := []entity.RecoveryCode{{CodeHash: "hash-1", UserID: userId}, {CodeHash: "hash-2", UserID: userId}}
+	require.NoError(t, userRepo.EnableTwoFactor(userId, time.Now(), 100, codes, uuid.Nil, &entity.SecurityEvent{UserID: userId, Type: entity.SecurityTwoFactorEnabled}))
+	// второй раз не включается
+	require.Error(t, userRepo.EnableTwoFactor(userId, time.Now(), 101, codes, uuid.Nil, &entity.SecurityEvent{UserID: userId, Type: entity.SecurityTwoFactorEnabled}))
+
+	// старый и тот же счетчик не принимаются
+	fresh, err := userRepo.UseTwoFactorCounter(userId, 100)
+	require.NoError(t, err)
+	assert.False(t, fresh)
+	fresh, err = userRepo.UseTwoFactorCounter(userId, 101)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+
+	used, err := userRepo.UseRecoveryCode(userId, "hash-1", &entity.SecurityEvent{UserID: userId, Type: entity.SecurityRecoveryCodeUsed})
+	require.NoError(t, err)
+	assert.True(t, used)
+	used, err = userRepo.UseRecoveryCode(userId, "hash-1", &entity.SecurityEvent{UserID: userId, Type: entity.SecurityRecoveryCodeUsed})
+	require.NoError(t, err)
+	assert.False(t, used)
+	left, err := userRepo.CountRecoveryCodes(userId)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), left)
+
+	locked, err := userRepo.TwoFactorFailed(userId, 2, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, locked)
+	locked, err = userRepo.TwoFactorFailed(userId, 2, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, locked)
+
+	require.NoError(t, userRepo.DisableTwoFactor(userId, &entity.SecurityEvent{UserID: userId, Type: entity.SecurityTwoFactorDisabled}))
+	twoFactor, err = userRepo.GetTwoFactor(userId)
+	require.NoError(t, err)
+	assert.Nil(t, twoFactor)
+	left, err = userRepo.CountRecoveryCodes(userId)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), left)
+}
+
+func TestUserRepository_LoginLockout(t *testing.T) {
+	userRepo := setupUserRepo(t)
+
+	userId := uuid.New()
+	user := &entity.User{ID: userId, PasswdHash: "test_hash", Roles: []entity.Role{{Name: entity.RoleStudent}}}
+	userData := &entity.UserData{UserID: userId, Email: "lockout@example.com", Name: "Lockout User", Birthdate: time.Now()}
+	require.NoError(t, userRepo.CreateUser(user, userData, nil))
+
+	event := &entity.SecurityEvent{UserID: userId, Type: entity.SecurityAccountLocked}
+	until, err := userRepo.LoginFailed(userId, 2, time.Minute, time.Hour, event)
+	require.NoError(t, err)
+	assert.Nil(t, until)
+	until, err = userRepo.LoginFailed(userId, 2, time.Minute, time.Hour, event)
+	require.NoError(t, err)
+	require.NotNil(t, until)
+
+	got, err := userRepo.GetUserById(userId)
+	require.NoError(t, err)
+	assert.True(t, got.IsLocked(time.Now()))
+	assert.Equal(t, 0, got.FailedLogins)
+	assert.Equal(t, 1, got.LoginLockouts)
+
+	events, err := userRepo.GetSecurityEvents(userId)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	require.NoError(t, userRepo.LoginSucceeded(userId))
+	got, err = userRepo.GetUserById(userId)
+	require.NoError(t, err)
+	assert.False(t, got.IsLocked(time.Now()))
+	assert.Equal(t, 0, got.LoginLockouts)
+}
+
+func TestUserRepository_Identities(t *testing.T) {
+	userRepo := setupUserRepo(t)
+
+	userId := uuid.New()
+	user := &entity.User{
+		ID:         userId,
+		Roles:      []entity.Role{{Name: entity.RoleStudent}},
+		Identities: []entity.Identity{{Provider: entity.ProviderTelegram, Subject: "100", Username: "tg_user"}},
+	}
+	userData := &entity.UserData{UserID: userId, Email: "telegram-100@telegram.invalid", Name: "Telegram User", Telegram: "@Tg_User", Birthdate: time.Now()}
+	require.NoError(t, userRepo.CreateUser(user, userData, nil))
+
+	identity, err := userRepo.GetIdentity(entity.ProviderTelegram, "100")
+	require.NoError(t, err)
+	require.NotNil(t, identity)
+	assert.Equal(t, userId, identity.UserID)
+
+	found, err := userRepo.GetUserByTelegram("@tg_user")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, userId, found.ID)
+
+	byEmail, err := userRepo.GetUsersByEmailFold("Telegram-100@Telegram.invalid")
+	require.NoError(t, err)
+	require.Len(t, byEmail, 1)
+	assert.Equal(t, userId, byEmail[0].ID)
+
+	// второй аккаунт того же сервиса к пользователю не привязать
+	err = userRepo.LinkIdentity(&entity.Identity{Provider: entity.ProviderTelegram, Subject: "200", UserID: userId}, &entity.SecurityEvent{UserID: userId, Type: entity.SecurityIdentityLinked})
+	require.Error(t, err)
+
+	require.NoError(t, userRepo.UnlinkIdentity(userId, entity.ProviderTelegram, &entity.SecurityEvent{UserID: userId, Type: entity.SecurityIdentityUnlinked}))
+	count, err := userRepo.CountUserIdentities(userId)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	require.Error(t, userRepo.UnlinkIdentity(userId, entity.ProviderTelegram, &entity.SecurityEvent{UserID: userId, Type: entity.SecurityIdentityUnlinked}))
+}
+
+func TestUserRepository_OAuthState(t *testing.T) {
+	userRepo := setupUserRepo(t)
+
+	userId := uuid.New()
+	require.NoError(t, userRepo.CreateOAuthState(&entity.OAuthState{StateHash: "live", Provider: entity.ProviderGoogle, Verifier: "verifier", UserID: &userId, ExpiresAt: time.Now().Add(time.Minute)}))
+	require.NoError(t, userRepo.CreateOAuthState(&entity.OAuthState{StateHash: "expired", Provider: entity.ProviderGoogle, Verifier: "verifier", ExpiresAt: time.Now().Add(-time.Minute)}))
+
+	expired, err := userRepo.ConsumeOAuthState("expired")
+	require.NoError(t, err)
+	assert.Nil(t, expired)
+
+	state, err := userRepo.ConsumeOAuthState("live")
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, "verifier", state.Verifier)
+	require.NotNil(t, state.UserID)
+	assert.Equal(t, userId, *state.UserID)
+
+	// state одноразовый
+	state, err = userRepo.ConsumeOAuthState("live")
+	require.NoError(t, err)
+	assert.Nil(t, state)
+}
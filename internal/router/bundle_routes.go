@@ -0,0 +1,119 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"mzt/internal/dto"
+	"mzt/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ListBundles получает список наборов курсов которые сейчас продаются
+func (r *Router) ListBundles(c *gin.Context) {
+	bundles, err := r.bundleService.GetBundles(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"bundles": bundles})
+}
+
+// CreateBundle создает новый набор курсов
+// доступно с правом catalog:write
+func (r *Router) CreateBundle(c *gin.Context) {
+	var payload dto.CreateBundleDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bundle, err := r.bundleService.CreateBundle(&payload)
+	if err != nil {
+		bundleError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"bundle": bundle})
+}
+
+// UpdateBundle обновляет набор курсов
+// доступно с правом catalog:write
+func (r *Router) UpdateBundle(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("bundle_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bundle ID"})
+		return
+	}
+
+	var payload dto.CreateBundleDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bundle, err := r.bundleService.UpdateBundle(id, &payload)
+	if err != nil {
+		bundleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"bundle": bundle})
+}
+
+// DeleteBundle снимает набор курсов с продажи
+// купленный доступ остается, доступно с правом catalog:write
+func (r *Router) DeleteBundle(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("bundle_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bundle ID"})
+		return
+	}
+
+	if err := r.bundleService.DeactivateBundle(id); err != nil {
+		bundleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Bundle deactivated successfully"})
+}
+
+// CreateBundlePayment создает платеж за набор курсов и возвращает ссылку на оплату
+// за уже купленные курсы цена набора уменьшается
+func (r *Router) CreateBundlePayment(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("bundle_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bundle ID"})
+		return
+	}
+
+	// достаем id пользователя из контекста
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	url, err := r.bundleService.Checkout(self.(uuid.UUID), id)
+	if err != nil {
+		bundleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Payment initiated successfully",
+		"url":     url,
+	})
+}
+
+// отвечает ошибкой сервиса наборов с подходящим статусом
+func bundleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrBundleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bundle not found"})
+	case errors.Is(err, service.ErrBundleAlreadyOwned):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidBundle), errors.Is(err, service.ErrInvalidPrice), errors.Is(err, service.ErrReceiptContact):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -1,16 +1,18 @@
 package router
 
 import (
+	"errors"
 	"net/http"
 
 	"mzt/internal/dto"
+	"mzt/internal/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 // ListCourses получает список всех курсов
-// просто возвращает все курсы из базы
+// возвращает все курсы из базы и наборы курсов которые сейчас продаются
 func (r *Router) ListCourses(c *gin.Context) {
 	// получаем список курсов из сервиса
 	courses, err := r.courseService.ListCourses()
@@ -19,8 +21,14 @@ func (r *Router) ListCourses(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	// наборы показываются в каталоге рядом с курсами
+	bundles, err := r.bundleService.GetBundles(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	// отправляем список курсов клиенту
-	c.JSON(http.StatusOK, gin.H{"courses": courses})
+	c.JSON(http.StatusOK, gin.H{"courses": courses, "bundles": bundles})
 }
 
 // GetCourse получает информацию о курсе
@@ -47,7 +55,7 @@ func (r *Router) GetCourse(c *gin.Context) {
 }
 
 // CreateCourse создает новый курс
-// доступно только админам
+// доступно с правом courses:write
 func (r *Router) CreateCourse(c *gin.Context) {
 	// парсим данные из тела запроса
 	var payload dto.CreateCourseDto
@@ -60,6 +68,10 @@ func (r *Router) CreateCourse(c *gin.Context) {
 	// создаем курс через сервис
 	err := r.courseService.CreateCourse(&payload)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidPrice) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		// если что-то пошло не так, возвращаем ошибку
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -69,7 +81,7 @@ func (r *Router) CreateCourse(c *gin.Context) {
 }
 
 // UpdateCourse обновляет информацию о курсе
-// доступно только админам
+// доступно с правом courses:write
 func (r *Router) UpdateCourse(c *gin.Context) {
 	// достаем id курса из параметров запроса
 	courseId := c.Param("course_id")
@@ -90,6 +102,10 @@ func (r *Router) UpdateCourse(c *gin.Context) {
 	// обновляем курс через сервис
 	err = r.courseService.UpdateCourse(id, &payload)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidPrice) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		// если что-то пошло не так, возвращаем ошибку
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -99,7 +115,7 @@ func (r *Router) UpdateCourse(c *gin.Context) {
 }
 
 // DeleteCourse удаляет курс
-// доступно только админам
+// доступно с правом courses:write
 func (r *Router) DeleteCourse(c *gin.Context) {
 	// достаем id курса из параметров запроса
 	courseId := c.Param("course_id")
@@ -167,7 +183,7 @@ func (r *Router) GetLesson(c *gin.Context) {
 }
 
 // CreateLesson создает новый урок
-// доступно только админам
+// доступно с правом courses:write
 func (r *Router) CreateLesson(c *gin.Context) {
 	// достаем id курса из параметров запроса
 	courseId := c.Param("course_id")
@@ -192,7 +208,7 @@ func (r *Router) CreateLesson(c *gin.Context) {
 }
 
 // UpdateLesson обновляет информацию об уроке
-// доступно только админам
+// доступно с правом courses:write
 func (r *Router) UpdateLesson(c *gin.Context) {
 	// достаем id урока из параметров запроса
 	lessonId := c.Param("lesson_id")
@@ -217,7 +233,7 @@ func (r *Router) UpdateLesson(c *gin.Context) {
 }
 
 // DeleteLesson удаляет урок
-// доступно только админам
+// доступно с правом courses:write
 func (r *Router) DeleteLesson(c *gin.Context) {
 	// достаем id урока из параметров запроса
 	lessonId := c.Param("lesson_id")
@@ -99,7 +99,7 @@ func (r *Router) GetMyEventsWithSecrets(c *gin.Context) {
 }
 
 // создает новое событие
-// доступно только админам
+// доступно с правом courses:write
 func (r *Router) CreateEvent(c *gin.Context) {
 	// парсим данные из тела запроса
 	var payload dto.CreateEventDto
@@ -120,7 +120,7 @@ func (r *Router) CreateEvent(c *gin.Context) {
 }
 
 // обновляет информацию о событии
-// доступно только админам
+// доступно с правом courses:write
 func (r *Router) UpdateEvent(c *gin.Context) {
 	// достаем id события из параметров запроса
 	eventId := c.Param("event_id")
@@ -148,7 +148,7 @@ func (r *Router) UpdateEvent(c *gin.Context) {
 }
 
 // удаляет событие
-// доступно только админам
+// доступно с правом courses:write
 func (r *Router) DeleteEvent(c *gin.Context) {
 	// достаем id события из параметров запроса
 	eventId := c.Param("event_id")
@@ -0,0 +1,143 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"mzt/internal/dto"
+	"mzt/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RedeemGiftCode активирует подарочный код и записывает текущего пользователя на курс
+func (r *Router) RedeemGiftCode(c *gin.Context) {
+	var payload dto.RedeemGiftCodeDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	course, err := r.giftService.Redeem(self.(uuid.UUID), payload.Code)
+	if err != nil {
+		giftCodeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"course": course})
+}
+
+// MyGifts получает коды из подарков которые купил текущий пользователь
+func (r *Router) MyGifts(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	codes, err := r.giftService.GetPurchasedGiftCodes(self.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"gift_codes": codes})
+}
+
+// ListGiftCodes получает подарочные коды с тем кто их активировал
+// можно отфильтровать по batch_id и course_id, доступно с правом promo:write
+func (r *Router) ListGiftCodes(c *gin.Context) {
+	batchID, courseID, ok := giftCodeFilter(c)
+	if !ok {
+		return
+	}
+
+	codes, err := r.giftService.GetGiftCodes(batchID, courseID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"gift_codes": codes})
+}
+
+// CreateGiftCodes выпускает пачку подарочных кодов без оплаты
+// доступно с правом promo:write
+func (r *Router) CreateGiftCodes(c *gin.Context) {
+	var payload dto.CreateGiftCodesDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	codes, err := r.giftService.CreateBatch(self.(uuid.UUID), &payload)
+	if err != nil {
+		giftCodeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"gift_codes": codes})
+}
+
+// ExportGiftCodes выгружает подарочные коды в csv
+// фильтры те же что и у списка, доступно с правом promo:write
+func (r *Router) ExportGiftCodes(c *gin.Context) {
+	batchID, courseID, ok := giftCodeFilter(c)
+	if !ok {
+		return
+	}
+
+	data, err := r.giftService.ExportGiftCodes(batchID, courseID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="gift-codes.csv"`)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+}
+
+// достает фильтры batch_id и course_id из query
+// если id невалидный - сразу отвечает ошибкой и возвращает false
+func giftCodeFilter(c *gin.Context) (*uuid.UUID, *uuid.UUID, bool) {
+	var batchID, courseID *uuid.UUID
+	if value := c.Query("batch_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch ID"})
+			return nil, nil, false
+		}
+		batchID = &id
+	}
+	if value := c.Query("course_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course ID"})
+			return nil, nil, false
+		}
+		courseID = &id
+	}
+	return batchID, courseID, true
+}
+
+// отвечает ошибкой сервиса подарков с подходящим статусом
+func giftCodeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrGiftCodeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift code not found"})
+	case errors.Is(err, service.ErrGiftCodeUnavailable), errors.Is(err, service.ErrGiftCourseOwned):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidGiftCodes):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
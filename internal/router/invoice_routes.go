@@ -0,0 +1,153 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"mzt/internal/dto"
+	"mzt/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// CreateInvoice выставляет счет юрлицу на оплату курса банковским переводом
+func (r *Router) CreateInvoice(c *gin.Context) {
+	courseID, err := uuid.Parse(c.Param("course_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course ID"})
+		return
+	}
+
+	var payload dto.CreateInvoiceDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	invoice, err := r.invoiceService.CreateInvoice(self.(uuid.UUID), courseID, &payload)
+	if err != nil {
+		invoiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"invoice": invoice})
+}
+
+// MyInvoices получает счета которые оформил текущий пользователь
+func (r *Router) MyInvoices(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	invoices, err := r.invoiceService.GetUserInvoices(self.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invoices": invoices})
+}
+
+// MyInvoicePDF отдает pdf счета текущего пользователя
+func (r *Router) MyInvoicePDF(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+	userID := self.(uuid.UUID)
+	r.invoicePDF(c, &userID)
+}
+
+// ListInvoices получает все счета, можно отфильтровать по статусу платежа
+// доступно с правом invoices:write
+func (r *Router) ListInvoices(c *gin.Context) {
+	invoices, err := r.invoiceService.GetInvoices(c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invoices": invoices})
+}
+
+// InvoicePDF отдает pdf любого счета, доступно с правом invoices:write
+func (r *Router) InvoicePDF(c *gin.Context) {
+	r.invoicePDF(c, nil)
+}
+
+// MarkInvoicePaid отмечает счет оплаченным и записывает сотрудников на курс
+// доступно с правом invoices:write
+func (r *Router) MarkInvoicePaid(c *gin.Context) {
+	invoiceID, err := uuid.Parse(c.Param("invoice_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var payload dto.MarkInvoicePaidDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invoice, err := r.invoiceService.MarkPaid(invoiceID, payload.UserIDs)
+	if err != nil {
+		invoiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invoice": invoice})
+}
+
+// CancelInvoice отменяет неоплаченный счет, доступно с правом invoices:write
+func (r *Router) CancelInvoice(c *gin.Context) {
+	invoiceID, err := uuid.Parse(c.Param("invoice_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	if err := r.invoiceService.CancelInvoice(invoiceID); err != nil {
+		invoiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Invoice canceled"})
+}
+
+// рендерит pdf счета, если передан userID - только счета этого пользователя
+func (r *Router) invoicePDF(c *gin.Context, userID *uuid.UUID) {
+	invoiceID, err := uuid.Parse(c.Param("invoice_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	number, data, err := r.invoiceService.InvoicePDF(invoiceID, userID)
+	if err != nil {
+		invoiceError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="invoice-%d.pdf"`, number))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
+
+// отвечает ошибкой сервиса счетов с подходящим статусом
+func invoiceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvoiceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+	case errors.Is(err, service.ErrInvoiceClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidInvoice), errors.Is(err, service.ErrInvalidPrice):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
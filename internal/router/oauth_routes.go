@@ -0,0 +1,153 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"mzt/internal/dto"
+	"mzt/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// state дублируется в куки, чтобы код из чужой ссылки нельзя было подсунуть в наш браузер
+const oauthStateCookie = "oauth_state"
+
+// OAuthProviders список сервисов через которые можно войти
+func (r *Router) OAuthProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": r.authService.OAuthProviders()})
+}
+
+// StartOAuth возвращает адрес страницы входа внешнего сервиса
+func (r *Router) StartOAuth(c *gin.Context) {
+	r.startOAuth(c, nil)
+}
+
+// OAuthCallback завершает вход через внешний сервис кодом, с которым сервис вернул пользователя
+// если такого пользователя еще нет, аккаунт создается
+func (r *Router) OAuthCallback(c *gin.Context) {
+	payload, ok := r.oauthCallback(c)
+	if !ok {
+		return
+	}
+
+	id, access, refresh, err := r.authService.SignInWithOAuth(c.Param("provider"), payload, requestDevice(c))
+	if twoFactorRequired(c, err) {
+		return
+	}
+	if err != nil {
+		identityError(c, err)
+		return
+	}
+	r.signedIn(c, id, access, refresh)
+}
+
+// StartLinkOAuth начинает привязку аккаунта внешнего сервиса к текущему пользователю
+func (r *Router) StartLinkOAuth(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+	userId := self.(uuid.UUID)
+	r.startOAuth(c, &userId)
+}
+
+// LinkOAuthCallback завершает привязку аккаунта внешнего сервиса
+func (r *Router) LinkOAuthCallback(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	payload, ok := r.oauthCallback(c)
+	if !ok {
+		return
+	}
+
+	if err := r.authService.LinkOAuth(self.(uuid.UUID), c.Param("provider"), payload, requestDevice(c)); err != nil {
+		identityError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account linked"})
+}
+
+// UnlinkOAuth отвязывает аккаунт внешнего сервиса от текущего пользователя
+func (r *Router) UnlinkOAuth(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	if err := r.authService.UnlinkOAuth(self.(uuid.UUID), c.Param("provider"), requestDevice(c)); err != nil {
+		identityError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlinked"})
+}
+
+// ProfileStatus каких полей профиля не хватает текущему пользователю
+func (r *Router) ProfileStatus(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	status, err := r.authService.ProfileStatus(self.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// создает state и отдает адрес сервиса, state кладем в куки
+func (r *Router) startOAuth(c *gin.Context, userId *uuid.UUID) {
+	url, state, err := r.authService.StartOAuth(c.Param("provider"), userId)
+	if err != nil {
+		identityError(c, err)
+		return
+	}
+
+	c.SetCookie(oauthStateCookie, state, int(r.config.OAuth.StateTTL.Seconds()), "/", r.config.Jwt.Domain, false, true)
+	c.JSON(http.StatusOK, gin.H{"url": url})
+}
+
+// читает код и state, state должен совпасть с выданным этому браузеру
+func (r *Router) oauthCallback(c *gin.Context) (*dto.OAuthCallbackDto, bool) {
+	var payload dto.OAuthCallbackDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	cookie, err := c.Cookie(oauthStateCookie)
+	if err != nil || cookie != payload.State {
+		c.JSON(http.StatusBadRequest, gin.H{"error": service.ErrOAuthStateInvalid.Error()})
+		return nil, false
+	}
+	c.SetCookie(oauthStateCookie, "", -1, "/", r.config.Jwt.Domain, false, true)
+	return &payload, true
+}
+
+// отвечает ошибкой входа через внешний сервис с подходящим статусом
+func identityError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrTelegramAuthInvalid), errors.Is(err, service.ErrOAuthFailed):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrOAuthStateInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTelegramDisabled), errors.Is(err, service.ErrOAuthProviderUnknown):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrIdentityTaken), errors.Is(err, service.ErrIdentityAlreadyLinked),
+		errors.Is(err, service.ErrIdentityNotLinked), errors.Is(err, service.ErrLastLoginMethod),
+		errors.Is(err, service.ErrOAuthEmailTaken), errors.Is(err, service.ErrTelegramLinkRequired):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
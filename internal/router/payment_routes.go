@@ -1,65 +1,46 @@
 package router
 
 import (
+	"errors"
+	"io"
+	"log"
 	"net/http"
 
 	"mzt/internal/dto"
+	"mzt/internal/repository"
+	"mzt/internal/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-func (r *Router) YooWebhookHandler(c *gin.Context) {
-	var webhook dto.YooWebhook
-
-	if err := c.BindJSON(&webhook); err != nil {
+// обрабатывает уведомления от платежного провайдера
+// разбор тела зависит от провайдера, сам вебхук ничего не решает - сервис перепроверяет платеж
+func (r *Router) PaymentWebhookHandler(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook"})
 		return
 	}
 
-	if webhook.Event == "payment.succeeded" && webhook.Object.Status == "succeeded" {
-		userID := webhook.Object.Metadata.UserID
-		courseID := webhook.Object.Metadata.CourseID
-		paymentID := webhook.Object.Metadata.PaymentID
-
-		// преобразуем строки в uuid
-		userIDParsed, err := uuid.Parse(userID)
-		if err != nil {
-			//TODO handle this(i think log + notification somewhere)
-			c.JSON(http.StatusOK, gin.H{"status": "ok"})
-			return
-		}
-
-		courseIDParsed, err := uuid.Parse(courseID)
-		if err != nil {
-			//TODO handle this(i think log + notification somewhere)
-			c.JSON(http.StatusOK, gin.H{"status": "ok"})
-			return
-		}
-
-		paymentIDParsed, err := uuid.Parse(paymentID)
-		if err != nil {
-			// Log but continue without payment update
-			// We should still assign the course
-		} else {
-			// обновляем статус платежа на успешный
-			err = r.paymentService.UpdatePaymentStatus(paymentIDParsed, "succeeded")
-			if err != nil {
-				// Log the error but continue with course assignment
-			}
-		}
-
-		// записываем пользователя на курс
-		err = r.courseService.AssignUserToCourse(courseIDParsed, userIDParsed)
-		if err != nil {
-			c.JSON(http.StatusOK, gin.H{"status": "ok"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-	} else {
+	err = r.paymentService.HandleWebhook(body)
+	switch {
+	case err == nil:
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-		return
+	case errors.Is(err, service.ErrInvalidWebhook):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook"})
+	case errors.Is(err, service.ErrPaymentNotFound), errors.Is(err, service.ErrPaymentMismatch):
+		// поддельное или не наше уведомление
+		log.Printf("Rejected payment webhook: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment verification failed"})
+	case errors.Is(err, repository.ErrIllegalTransition):
+		// статус платежа у нас уже не позволяет принять это уведомление
+		log.Printf("Payment webhook conflicts with payment state: %v", err)
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment state conflict"})
+	default:
+		// провайдер повторит уведомление если ответить ошибкой
+		log.Printf("Failed to process payment webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Can't process webhook"})
 	}
 }
 
@@ -78,9 +59,50 @@ func (r *Router) CreateCoursePayment(c *gin.Context) {
 
 	userId := user.(uuid.UUID)
 
+	// тело необязательно, в нем может прийти промокод
+	var payload dto.CreatePaymentDto
+	if err := c.ShouldBindJSON(&payload); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
 	// создаем платеж через сервис
 	// сумма будет получена из базы данных
-	result, err := r.paymentService.CreateYooKassaPayment(userId.String(), courseId, "")
+	var result string
+	var err error
+	if payload.Gift && payload.Installments > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Gift can not be paid in installments"})
+		return
+	}
+	if payload.Gift {
+		// за подарок покупатель получит код доступа, а не запись на курс
+		courseUUID, parseErr := uuid.Parse(courseId)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course ID"})
+			return
+		}
+		result, err = r.giftService.Checkout(userId, courseUUID, payload.PromoCode)
+	} else if payload.Installments > 1 {
+		// оплата частями оформляется как рассрочка, ссылка ведет на оплату первой части
+		courseUUID, parseErr := uuid.Parse(courseId)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course ID"})
+			return
+		}
+		result, err = r.installmentService.Checkout(userId, courseUUID, payload.PromoCode, payload.Installments)
+	} else {
+		result, err = r.paymentService.CreatePayment(userId.String(), courseId, payload.PromoCode)
+	}
+	if errors.Is(err, service.ErrPromoCodeNotFound) || errors.Is(err, service.ErrPromoCodeExpired) ||
+		errors.Is(err, service.ErrPromoCodeNotApplicable) || errors.Is(err, service.ErrPromoCodeLimitReached) ||
+		errors.Is(err, service.ErrReceiptContact) || errors.Is(err, service.ErrInvalidInstallments) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrInstallmentPlanExists) || errors.Is(err, service.ErrInstallmentCourseOwned) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
 		return
@@ -93,7 +115,7 @@ func (r *Router) CreateCoursePayment(c *gin.Context) {
 }
 
 // получает список транзакций пользователя
-// доступно только админам
+// доступно с правом users:read
 func (r *Router) GetUserTransactions(c *gin.Context) {
 	// достаем id пользователя из параметров запроса
 	userID := c.Param("user_id")
@@ -106,7 +128,7 @@ func (r *Router) GetUserTransactions(c *gin.Context) {
 	}
 
 	// получаем список транзакций из сервиса
-	transactions, err := r.paymentService.GetUserTransactions(userIDParsed)
+	transactions, err := r.paymentService.GetUserTransactions(userIDParsed, true)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -115,3 +137,105 @@ func (r *Router) GetUserTransactions(c *gin.Context) {
 	// отправляем список транзакций клиенту
 	c.JSON(http.StatusOK, transactions)
 }
+
+// получает платежи текущего пользователя и график оплаты его рассрочек
+func (r *Router) MyTransactions(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+	userID := self.(uuid.UUID)
+
+	transactions, err := r.paymentService.GetUserTransactions(userID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	plans, err := r.installmentService.GetUserInstallmentPlans(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transactions":      transactions,
+		"installment_plans": plans,
+	})
+}
+
+// создает платеж за следующую часть рассрочки текущего пользователя
+// возвращает ссылку на оплату
+func (r *Router) PayInstallment(c *gin.Context) {
+	planID, err := uuid.Parse(c.Param("installment_plan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid installment plan ID"})
+		return
+	}
+
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	result, err := r.installmentService.PayNextInstallment(self.(uuid.UUID), planID)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Payment initiated successfully",
+			"url":     result,
+		})
+	case errors.Is(err, service.ErrInstallmentPlanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Installment plan not found"})
+	case errors.Is(err, service.ErrInstallmentPlanClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrReceiptContact):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// получает рассрочки с просроченными частями
+// доступно с правом payments:read
+func (r *Router) ListOverdueInstallmentPlans(c *gin.Context) {
+	plans, err := r.installmentService.GetOverduePlans()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"installment_plans": plans})
+}
+
+// возвращает деньги по платежу полностью или частично
+// доступно с правом payments:write, при полном возврате пользователь теряет доступ к курсу
+func (r *Router) RefundPayment(c *gin.Context) {
+	paymentID, err := uuid.Parse(c.Param("payment_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	// тело можно не передавать, тогда возвращается весь остаток
+	var payload dto.RefundRequestDto
+	if err := c.ShouldBindJSON(&payload); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	refund, err := r.paymentService.RefundPayment(paymentID, payload.Amount, payload.Reason)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, refund)
+	case errors.Is(err, service.ErrPaymentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+	case errors.Is(err, service.ErrPaymentNotRefundable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment can not be refunded"})
+	case errors.Is(err, service.ErrInvalidRefundAmount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refund amount"})
+	default:
+		log.Printf("Failed to refund payment %s: %v", paymentID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Can't refund payment"})
+	}
+}
@@ -0,0 +1,145 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"mzt/internal/dto"
+	"mzt/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// UpdateMe меняет профиль текущего пользователя
+// меняются только поля из запроса, проверки те же что и при регистрации
+func (r *Router) UpdateMe(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	var payload dto.UpdateProfileDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// проверяем что имя нормальное
+	if payload.Name != nil && !r.validator.IsValidName(*payload.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid name"})
+		return
+	}
+
+	// проверяем что телефон правильный
+	if payload.PhoneNumber != nil && !r.validator.IsValidPhoneNumber(*payload.PhoneNumber) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+		return
+	}
+
+	// проверяем что телеграм правильный
+	if payload.Telegram != nil && !r.validator.IsValidTelegram(*payload.Telegram) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid telegram"})
+		return
+	}
+
+	user, err := r.authService.UpdateProfile(self.(uuid.UUID), &payload)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Can't update profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Profile updated successfully",
+		"user":    user,
+	})
+}
+
+// ChangeMyPassword меняет пароль текущего пользователя
+// на других устройствах после этого нужно войти заново
+func (r *Router) ChangeMyPassword(c *gin.Context) {
+	userId, sessionId, ok := currentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	var payload dto.ChangePasswordDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// проверяем что пароль достаточно сложный
+	if !r.validator.IsValidPassword(payload.NewPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is weak or contains incorrect symbols"})
+		return
+	}
+
+	err := r.authService.ChangePassword(userId, sessionId, payload.CurrentPassword, payload.Code, payload.NewPassword, requestDevice(c))
+	if err != nil {
+		profileError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+// ChangeMyEmail отправляет на новую почту ссылку для подтверждения смены
+func (r *Router) ChangeMyEmail(c *gin.Context) {
+	userId, sessionId, ok := currentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	var payload dto.ChangeEmailDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := r.authService.RequestEmailChange(userId, sessionId, payload.Email, payload.Password, payload.Code, requestDevice(c)); err != nil {
+		profileError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Confirmation link sent to the new email"})
+}
+
+// ConfirmEmailChange меняет почту по ссылке из письма
+// после смены пользователь выходит на всех устройствах и входит с новой почтой
+func (r *Router) ConfirmEmailChange(c *gin.Context) {
+	var payload dto.VerifyEmailDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := r.authService.ConfirmEmailChange(payload.Token, requestDevice(c)); err != nil {
+		profileError(c, err)
+		return
+	}
+
+	c.SetCookie("refresh_token", "", -1, "/", r.config.Jwt.Domain, false, true)
+	c.JSON(http.StatusOK, gin.H{"message": "Email changed, please sign in again"})
+}
+
+// отвечает ошибкой смены пароля или почты с подходящим статусом
+func profileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidEmail), errors.Is(err, service.ErrVerifyTokenInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrWrongPassword), errors.Is(err, service.ErrReauthRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTwoFactorInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTwoFactorLocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrVerificationTooSoon):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,127 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"mzt/internal/dto"
+	"mzt/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ListPromoCodes получает список всех промокодов
+// доступно с правом promo:write
+func (r *Router) ListPromoCodes(c *gin.Context) {
+	promoCodes, err := r.promoService.GetPromoCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"promo_codes": promoCodes})
+}
+
+// GetPromoCode получает промокод по id
+// доступно с правом promo:write
+func (r *Router) GetPromoCode(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("promo_code_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	promoCode, err := r.promoService.GetPromoCode(id)
+	if err != nil {
+		promoCodeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"promo_code": promoCode})
+}
+
+// CreatePromoCode создает новый промокод
+// доступно с правом promo:write
+func (r *Router) CreatePromoCode(c *gin.Context) {
+	var payload dto.CreatePromoCodeDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promoCode, err := r.promoService.CreatePromoCode(&payload)
+	if err != nil {
+		promoCodeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"promo_code": promoCode})
+}
+
+// UpdatePromoCode обновляет промокод
+// доступно с правом promo:write
+func (r *Router) UpdatePromoCode(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("promo_code_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	var payload dto.CreatePromoCodeDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promoCode, err := r.promoService.UpdatePromoCode(id, &payload)
+	if err != nil {
+		promoCodeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"promo_code": promoCode})
+}
+
+// DeletePromoCode удаляет промокод
+// доступно с правом promo:write
+func (r *Router) DeletePromoCode(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("promo_code_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	if err := r.promoService.DeletePromoCode(id); err != nil {
+		promoCodeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Promo code deleted successfully"})
+}
+
+// GetPromoCodeStats получает статистику использования промокода
+// доступно с правом promo:write
+func (r *Router) GetPromoCodeStats(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("promo_code_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	stats, err := r.promoService.GetPromoCodeStats(id)
+	if err != nil {
+		promoCodeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"stats": stats})
+}
+
+// отвечает ошибкой сервиса промокодов с подходящим статусом
+func promoCodeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrPromoCodeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promo code not found"})
+	case errors.Is(err, service.ErrPromoCodeExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "Promo code already exists"})
+	case errors.Is(err, service.ErrInvalidPromoCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,109 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"mzt/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RevenueReport отчет по выручке с конверсией и возвратами
+// группировка group_by: course, day, week, month, currency или status
+// фильтры from, to, course_id, currency, status, tz - часовой пояс для дат, доступно с правом reports:read
+func (r *Router) RevenueReport(c *gin.Context) {
+	filter, ok := reportFilter(c)
+	if !ok {
+		return
+	}
+
+	report, err := r.reportService.RevenueReport(filter)
+	if err != nil {
+		reportError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
+// ExportRevenueReport выгружает отчет по выручке в csv
+// параметры те же что и у отчета, доступно с правом reports:read
+func (r *Router) ExportRevenueReport(c *gin.Context) {
+	filter, ok := reportFilter(c)
+	if !ok {
+		return
+	}
+
+	data, err := r.reportService.RevenueReportCSV(filter)
+	if err != nil {
+		reportError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="revenue.csv"`)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+}
+
+// достает параметры отчета из query
+// даты принимаются в виде 2006-01-02 или RFC3339, дата без времени в to включается целиком
+// если параметр невалидный - сразу отвечает ошибкой и возвращает false
+func reportFilter(c *gin.Context) (service.ReportFilter, bool) {
+	filter := service.ReportFilter{
+		GroupBy:  c.Query("group_by"),
+		Currency: c.Query("currency"),
+		Status:   c.Query("status"),
+		Location: time.UTC,
+	}
+
+	if value := c.Query("tz"); value != "" {
+		loc, err := time.LoadLocation(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone"})
+			return filter, false
+		}
+		filter.Location = loc
+	}
+	if value := c.Query("course_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course ID"})
+			return filter, false
+		}
+		filter.CourseID = &id
+	}
+
+	for _, param := range []struct {
+		name   string
+		target **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			day, dayErr := time.ParseInLocation("2006-01-02", value, filter.Location)
+			if dayErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name + " date"})
+				return filter, false
+			}
+			t = day
+			if param.name == "to" {
+				t = day.AddDate(0, 0, 1)
+			}
+		}
+		*param.target = &t
+	}
+	return filter, true
+}
+
+// отвечает ошибкой сервиса отчетов с подходящим статусом
+func reportError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidReport):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
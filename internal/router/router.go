@@ -2,6 +2,7 @@ package router
 
 import (
 	"mzt/config"
+	"mzt/internal/entity"
 	"mzt/internal/middleware"
 	"mzt/internal/service"
 	"mzt/internal/validator"
@@ -11,32 +12,59 @@ import (
 
 // структура роутера содержит все сервисы и конфигурацию
 type Router struct {
-	authService    *service.UserService
-	courseService  *service.CourseService
-	paymentService *service.PaymentService
-	eventService   *service.EventService
-	config         *config.Config
-	validator      *validator.Validator
+	authService         *service.UserService
+	courseService       *service.CourseService
+	paymentService      *service.PaymentService
+	eventService        *service.EventService
+	promoService        *service.PromoCodeService
+	subscriptionService *service.SubscriptionService
+	installmentService  *service.InstallmentService
+	bundleService       *service.BundleService
+	giftService         *service.GiftService
+	invoiceService      *service.InvoiceService
+	reportService       *service.ReportService
+	config              *config.Config
+	validator           *validator.Validator
 }
 
 // конструктор роутера
-func NewRouter(config *config.Config, handler *gin.Engine, authService *service.UserService, courseService *service.CourseService, paymentService *service.PaymentService, eventService *service.EventService, MW *middleware.Middleware) *Router {
+func NewRouter(config *config.Config, handler *gin.Engine, authService *service.UserService, courseService *service.CourseService, paymentService *service.PaymentService, eventService *service.EventService, promoService *service.PromoCodeService, subscriptionService *service.SubscriptionService, installmentService *service.InstallmentService, bundleService *service.BundleService, giftService *service.GiftService, invoiceService *service.InvoiceService, reportService *service.ReportService, MW *middleware.Middleware) *Router {
 	r := &Router{
-		authService:    authService,
-		paymentService: paymentService,
-		courseService:  courseService,
-		eventService:   eventService,
-		config:         config,
-		validator:      validator.NewValidator(),
+		authService:         authService,
+		paymentService:      paymentService,
+		courseService:       courseService,
+		eventService:        eventService,
+		promoService:        promoService,
+		subscriptionService: subscriptionService,
+		installmentService:  installmentService,
+		bundleService:       bundleService,
+		giftService:         giftService,
+		invoiceService:      invoiceService,
+		reportService:       reportService,
+		config:              config,
+		validator:           validator.NewValidator(),
 	}
 
+	// открытые ключи для проверки access токенов другими сервисами
+	handler.GET("/.well-known/jwks.json", r.JWKS)
+
 	// Auth routes
 	authHandler := handler.Group("/api/v1/auth")
 	{
-		authHandler.POST("/signin", r.SignIn)
-		authHandler.POST("/signup", r.SignUp)
-		authHandler.POST("/refresh", r.Refresh)
+		authHandler.POST("/signin", MW.RateLimit("signin", config.RateLimit.SignInPerIP, config.RateLimit.SignInPerAccount), r.SignIn)
+		authHandler.POST("/signup", MW.RateLimit("signup", config.RateLimit.SignUpPerIP, 0), r.SignUp)
+		authHandler.POST("/refresh", MW.RateLimit("refresh", config.RateLimit.RefreshPerIP, 0), r.Refresh)
 		authHandler.POST("/logout", MW.AuthMiddleware(), r.Logout)
+		authHandler.POST("/password/forgot", MW.RateLimit("password_forgot", config.RateLimit.PasswordResetPerIP, config.RateLimit.PasswordResetPerAccount), r.ForgotPassword)
+		authHandler.POST("/password/reset", MW.RateLimit("password_reset", config.RateLimit.PasswordResetPerIP, config.RateLimit.PasswordResetPerAccount), r.ResetPassword)
+		authHandler.POST("/verify-email", MW.RateLimit("verify_email", config.RateLimit.EmailConfirmPerIP, 0), r.VerifyEmail)
+		authHandler.POST("/verify-email/resend", MW.AuthMiddleware(), r.ResendVerification)
+		authHandler.POST("/email/confirm", MW.RateLimit("email_confirm", config.RateLimit.EmailConfirmPerIP, 0), r.ConfirmEmailChange)
+		authHandler.POST("/telegram", MW.RateLimit("telegram", config.RateLimit.SignInPerIP, 0), r.SignInTelegram)
+		authHandler.GET("/oauth/providers", r.OAuthProviders)
+		authHandler.POST("/oauth/:provider", MW.RateLimit("oauth_start", config.RateLimit.SignInPerIP, 0), r.StartOAuth)
+		authHandler.POST("/oauth/:provider/callback", MW.RateLimit("oauth", config.RateLimit.SignInPerIP, 0), r.OAuthCallback)
+		authHandler.POST("/2fa/verify", MW.RateLimit("2fa", config.RateLimit.SignInPerIP, 0), r.VerifyTwoFactor)
 	}
 
 	// User routes
@@ -44,22 +72,57 @@ func NewRouter(config *config.Config, handler *gin.Engine, authService *service.
 	usersGroup.Use(MW.AuthMiddleware())
 	{
 		usersGroup.GET("/me", r.Me)
+		usersGroup.PATCH("/me", r.UpdateMe)
+		usersGroup.POST("/me/password", r.ChangeMyPassword)
+		usersGroup.POST("/me/email", r.ChangeMyEmail)
+		usersGroup.POST("/me/telegram", r.LinkTelegram)
+		usersGroup.DELETE("/me/telegram", r.UnlinkTelegram)
+		usersGroup.POST("/me/oauth/:provider", r.StartLinkOAuth)
+		usersGroup.POST("/me/oauth/:provider/callback", r.LinkOAuthCallback)
+		usersGroup.DELETE("/me/oauth/:provider", r.UnlinkOAuth)
+		usersGroup.GET("/me/profile-status", r.ProfileStatus)
+		usersGroup.GET("/me/2fa", r.MyTwoFactor)
+		usersGroup.POST("/me/2fa/setup", r.SetupTwoFactor)
+		usersGroup.POST("/me/2fa/enable", r.EnableTwoFactor)
+		usersGroup.POST("/me/2fa/disable", r.DisableTwoFactor)
+		usersGroup.POST("/me/2fa/recovery-codes", r.RegenerateRecoveryCodes)
 		usersGroup.GET("/me/courses", r.MyCourses)
 		usersGroup.GET("/me/events", r.GetMyEventsWithSecrets)
+		usersGroup.GET("/me/transactions", r.MyTransactions)
+		usersGroup.POST("/me/installments/:installment_plan_id/pay", r.PayInstallment)
+		usersGroup.GET("/me/subscriptions", r.MySubscriptions)
+		usersGroup.POST("/me/subscriptions/:subscription_id/cancel", r.CancelMySubscription)
+		usersGroup.POST("/me/subscriptions/:subscription_id/resume", r.ResumeMySubscription)
+		usersGroup.POST("/me/redeem", r.RedeemGiftCode)
+		usersGroup.GET("/me/gifts", r.MyGifts)
+		usersGroup.GET("/me/invoices", r.MyInvoices)
+		usersGroup.GET("/me/invoices/:invoice_id/pdf", r.MyInvoicePDF)
+		usersGroup.GET("/me/sessions", r.MySessions)
+		usersGroup.DELETE("/me/sessions", r.RevokeOtherSessions)
+		usersGroup.DELETE("/me/sessions/:session_id", r.RevokeMySession)
 
 		// Admin routes
 		adminGroup := usersGroup.Group("")
-		adminGroup.Use(MW.AdminVerificationMiddleware())
+		adminGroup.Use(MW.RequirePermission(entity.PermUsersRead))
 		{
 			adminGroup.GET("/", r.GetUsers)
 			adminGroup.GET("/:user_id", r.Users)
 			adminGroup.GET("/:user_id/transactions", r.GetUserTransactions)
-			adminGroup.PUT("/:user_id", r.Users)
-			adminGroup.DELETE("/:user_id", r.Users)
+			adminGroup.PUT("/:user_id", MW.RequirePermission(entity.PermUsersWrite), r.Users)
+			adminGroup.DELETE("/:user_id", MW.RequirePermission(entity.PermUsersWrite), r.Users)
 			adminGroup.GET("/:user_id/role", r.Role)
+			adminGroup.PUT("/:user_id/roles", MW.RequirePermission(entity.PermRolesWrite), r.SetUserRoles)
+			adminGroup.GET("/:user_id/security-events", r.UserSecurityEvents)
 		}
 	}
 
+	// Role routes
+	rolesGroup := handler.Group("/api/v1/roles")
+	rolesGroup.Use(MW.AuthMiddleware(), MW.RequirePermission(entity.PermUsersRead))
+	{
+		rolesGroup.GET("/", r.ListRoles)
+	}
+
 	// Course routes
 	coursesGroup := handler.Group("/api/v1/courses")
 	openCoursesGroup := coursesGroup.Group("/")
@@ -71,7 +134,7 @@ func NewRouter(config *config.Config, handler *gin.Engine, authService *service.
 
 		coursesGroup.GET("/:course_id", r.GetCourse)
 		coursesGroupAdmin := coursesGroup.Group("")
-		coursesGroupAdmin.Use(MW.AdminVerificationMiddleware())
+		coursesGroupAdmin.Use(MW.RequirePermission(entity.PermCoursesWrite))
 		{
 			coursesGroupAdmin.POST("/", r.CreateCourse)
 			coursesGroupAdmin.PUT("/:course_id", r.UpdateCourse)
@@ -84,7 +147,7 @@ func NewRouter(config *config.Config, handler *gin.Engine, authService *service.
 			lessonsGroup.GET("/:lesson_id", r.GetLesson)
 
 			lessonsGroupAdmin := lessonsGroup.Group("")
-			lessonsGroupAdmin.Use(MW.AdminVerificationMiddleware())
+			lessonsGroupAdmin.Use(MW.RequirePermission(entity.PermCoursesWrite))
 			{
 				lessonsGroupAdmin.POST("/", r.CreateLesson)
 				lessonsGroupAdmin.PUT("/:lesson_id", r.UpdateLesson)
@@ -100,7 +163,7 @@ func NewRouter(config *config.Config, handler *gin.Engine, authService *service.
 			eventsGroup.GET("/:event_id/secrets", MW.CourseEnrollmentMiddleware(), r.GetEventWithSecrets)
 
 			eventsGroupAdmin := eventsGroup.Group("")
-			eventsGroupAdmin.Use(MW.AdminVerificationMiddleware())
+			eventsGroupAdmin.Use(MW.RequirePermission(entity.PermCoursesWrite))
 			{
 				eventsGroupAdmin.POST("/", r.CreateEvent)
 				eventsGroupAdmin.PUT("/:event_id", r.UpdateEvent)
@@ -111,11 +174,14 @@ func NewRouter(config *config.Config, handler *gin.Engine, authService *service.
 		// Course enrollment and progress
 		usersOnCourseGroup := coursesGroup.Group("/:course_id/users")
 		{
-			usersOnCourseGroup.POST("/", r.CreateCoursePayment)
-			usersOnCourseGroup.GET("/", MW.AdminVerificationMiddleware(), r.ListUsersOnCourse)
-			usersOnCourseGroup.DELETE("/:user_id", MW.AdminVerificationMiddleware(), r.RemoveUserFromCourse)
+			usersOnCourseGroup.POST("/", MW.EmailVerifiedMiddleware(), r.CreateCoursePayment)
+			usersOnCourseGroup.GET("/", MW.RequirePermission(entity.PermEnrollmentsRead), r.ListUsersOnCourse)
+			usersOnCourseGroup.DELETE("/:user_id", MW.RequirePermission(entity.PermEnrollmentsWrite), r.RemoveUserFromCourse)
 		}
 
+		// счет на оплату переводом для юрлиц
+		coursesGroup.POST("/:course_id/invoices", MW.EmailVerifiedMiddleware(), r.CreateInvoice)
+
 		progressGroup := coursesGroup.Group("/:course_id/progress")
 		progressGroup.Use(MW.CourseEnrollmentMiddleware())
 		{
@@ -124,10 +190,89 @@ func NewRouter(config *config.Config, handler *gin.Engine, authService *service.
 		}
 	}
 
+	// Payment routes
+	paymentsGroup := handler.Group("/api/v1/payments")
+	paymentsGroup.Use(MW.AuthMiddleware())
+	{
+		paymentsGroup.POST("/:payment_id/refund", MW.RequirePermission(entity.PermPaymentsWrite), r.RefundPayment)
+		paymentsGroup.GET("/installments/overdue", MW.RequirePermission(entity.PermPaymentsRead), r.ListOverdueInstallmentPlans)
+	}
+
+	// Report routes
+	reportsGroup := handler.Group("/api/v1/reports")
+	reportsGroup.Use(MW.AuthMiddleware(), MW.RequirePermission(entity.PermReportsRead))
+	{
+		reportsGroup.GET("/revenue", r.RevenueReport)
+		reportsGroup.GET("/revenue/export", r.ExportRevenueReport)
+	}
+
+	// Promo code routes
+	promoCodesGroup := handler.Group("/api/v1/promo-codes")
+	promoCodesGroup.Use(MW.AuthMiddleware(), MW.RequirePermission(entity.PermPromoWrite))
+	{
+		promoCodesGroup.GET("/", r.ListPromoCodes)
+		promoCodesGroup.POST("/", r.CreatePromoCode)
+		promoCodesGroup.GET("/:promo_code_id", r.GetPromoCode)
+		promoCodesGroup.PUT("/:promo_code_id", r.UpdatePromoCode)
+		promoCodesGroup.DELETE("/:promo_code_id", r.DeletePromoCode)
+		promoCodesGroup.GET("/:promo_code_id/stats", r.GetPromoCodeStats)
+	}
+
+	// Gift code routes
+	giftCodesGroup := handler.Group("/api/v1/gift-codes")
+	giftCodesGroup.Use(MW.AuthMiddleware(), MW.RequirePermission(entity.PermPromoWrite))
+	{
+		giftCodesGroup.GET("/", r.ListGiftCodes)
+		giftCodesGroup.POST("/", r.CreateGiftCodes)
+		giftCodesGroup.GET("/export", r.ExportGiftCodes)
+	}
+
+	// Invoice routes
+	invoicesGroup := handler.Group("/api/v1/invoices")
+	invoicesGroup.Use(MW.AuthMiddleware(), MW.RequirePermission(entity.PermInvoicesWrite))
+	{
+		invoicesGroup.GET("/", r.ListInvoices)
+		invoicesGroup.GET("/:invoice_id/pdf", r.InvoicePDF)
+		invoicesGroup.POST("/:invoice_id/paid", r.MarkInvoicePaid)
+		invoicesGroup.POST("/:invoice_id/cancel", r.CancelInvoice)
+	}
+
+	// Subscription plan routes
+	plansGroup := handler.Group("/api/v1/plans")
+	plansGroup.GET("/", r.ListPlans)
+	plansGroup.Use(MW.AuthMiddleware())
+	{
+		plansGroup.POST("/:plan_id/subscribe", MW.EmailVerifiedMiddleware(), r.Subscribe)
+
+		plansGroupAdmin := plansGroup.Group("")
+		plansGroupAdmin.Use(MW.RequirePermission(entity.PermCatalogWrite))
+		{
+			plansGroupAdmin.POST("/", r.CreatePlan)
+			plansGroupAdmin.PUT("/:plan_id", r.UpdatePlan)
+			plansGroupAdmin.DELETE("/:plan_id", r.DeletePlan)
+		}
+	}
+
+	// Course bundle routes
+	bundlesGroup := handler.Group("/api/v1/bundles")
+	bundlesGroup.GET("/", r.ListBundles)
+	bundlesGroup.Use(MW.AuthMiddleware())
+	{
+		bundlesGroup.POST("/:bundle_id/checkout", MW.EmailVerifiedMiddleware(), r.CreateBundlePayment)
+
+		bundlesGroupAdmin := bundlesGroup.Group("")
+		bundlesGroupAdmin.Use(MW.RequirePermission(entity.PermCatalogWrite))
+		{
+			bundlesGroupAdmin.POST("/", r.CreateBundle)
+			bundlesGroupAdmin.PUT("/:bundle_id", r.UpdateBundle)
+			bundlesGroupAdmin.DELETE("/:bundle_id", r.DeleteBundle)
+		}
+	}
+
 	// Payment webhook
 	webhookGroup := handler.Group("/api/v1/webhook/payments")
 	{
-		webhookGroup.POST(config.Equiring.SecretPath, r.YooWebhookHandler)
+		webhookGroup.POST(config.Equiring.SecretPath, MW.TrustedNetworksMiddleware(config.Equiring.TrustedNetworks), r.PaymentWebhookHandler)
 	}
 
 	return r
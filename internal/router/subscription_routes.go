@@ -0,0 +1,182 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"mzt/internal/dto"
+	"mzt/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ListPlans получает список планов на которые можно подписаться
+func (r *Router) ListPlans(c *gin.Context) {
+	plans, err := r.subscriptionService.GetPlans(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plans": plans})
+}
+
+// CreatePlan создает новый план подписки
+// доступно с правом catalog:write
+func (r *Router) CreatePlan(c *gin.Context) {
+	var payload dto.CreatePlanDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := r.subscriptionService.CreatePlan(&payload)
+	if err != nil {
+		subscriptionError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"plan": plan})
+}
+
+// UpdatePlan обновляет план подписки
+// доступно с правом catalog:write
+func (r *Router) UpdatePlan(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("plan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return
+	}
+
+	var payload dto.CreatePlanDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := r.subscriptionService.UpdatePlan(id, &payload)
+	if err != nil {
+		subscriptionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plan": plan})
+}
+
+// DeletePlan снимает план с продажи
+// действующие подписки продолжают работать, доступно с правом catalog:write
+func (r *Router) DeletePlan(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("plan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return
+	}
+
+	if err := r.subscriptionService.DeactivatePlan(id); err != nil {
+		subscriptionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Plan deactivated successfully"})
+}
+
+// Subscribe оформляет подписку на план и возвращает ссылку на первую оплату
+func (r *Router) Subscribe(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("plan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return
+	}
+
+	// достаем id пользователя из контекста
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	url, err := r.subscriptionService.Subscribe(self.(uuid.UUID), id)
+	if err != nil {
+		subscriptionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Payment initiated successfully",
+		"url":     url,
+	})
+}
+
+// MySubscriptions получает подписки текущего пользователя
+func (r *Router) MySubscriptions(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	subscriptions, err := r.subscriptionService.GetUserSubscriptions(self.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subscriptions})
+}
+
+// CancelMySubscription отменяет продление подписки текущего пользователя
+// доступ к курсам остается до конца оплаченного периода
+func (r *Router) CancelMySubscription(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("subscription_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+		return
+	}
+
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	subscription, err := r.subscriptionService.CancelSubscription(self.(uuid.UUID), id)
+	if err != nil {
+		subscriptionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"subscription": subscription})
+}
+
+// ResumeMySubscription возобновляет отмененную подписку текущего пользователя
+// пока не закончился оплаченный период
+func (r *Router) ResumeMySubscription(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("subscription_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+		return
+	}
+
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	subscription, err := r.subscriptionService.ResumeSubscription(self.(uuid.UUID), id)
+	if err != nil {
+		subscriptionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"subscription": subscription})
+}
+
+// отвечает ошибкой сервиса подписок с подходящим статусом
+func subscriptionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrPlanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+	case errors.Is(err, service.ErrSubscriptionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+	case errors.Is(err, service.ErrAlreadySubscribed), errors.Is(err, service.ErrSubscriptionNotActive), errors.Is(err, service.ErrSubscriptionNotResumable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidPlan), errors.Is(err, service.ErrInvalidPrice), errors.Is(err, service.ErrReceiptContact):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,66 @@
+package router
+
+import (
+	"net/http"
+
+	"mzt/internal/dto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SignInTelegram входит по данным из Telegram Login Widget
+// если такого пользователя еще нет, аккаунт создается
+func (r *Router) SignInTelegram(c *gin.Context) {
+	var payload dto.TelegramAuthDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, access, refresh, err := r.authService.SignInWithTelegram(&payload, requestDevice(c))
+	if twoFactorRequired(c, err) {
+		return
+	}
+	if err != nil {
+		identityError(c, err)
+		return
+	}
+	r.signedIn(c, id, access, refresh)
+}
+
+// LinkTelegram привязывает телеграм к аккаунту текущего пользователя
+func (r *Router) LinkTelegram(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	var payload dto.TelegramAuthDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := r.authService.LinkTelegram(self.(uuid.UUID), &payload, requestDevice(c)); err != nil {
+		identityError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Telegram linked"})
+}
+
+// UnlinkTelegram отвязывает телеграм от аккаунта текущего пользователя
+func (r *Router) UnlinkTelegram(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	if err := r.authService.UnlinkTelegram(self.(uuid.UUID), requestDevice(c)); err != nil {
+		identityError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Telegram unlinked"})
+}
@@ -0,0 +1,147 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"mzt/internal/dto"
+	"mzt/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// VerifyTwoFactor второй шаг входа: challenge из ответа на вход и код из приложения
+// вместо кода можно отправить код восстановления
+func (r *Router) VerifyTwoFactor(c *gin.Context) {
+	var payload dto.TwoFactorVerifyDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, access, refresh, err := r.authService.VerifyTwoFactor(payload.Challenge, payload.Code, requestDevice(c))
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	r.signedIn(c, id, access, refresh)
+}
+
+// MyTwoFactor показывает подключен ли вход по кодам
+func (r *Router) MyTwoFactor(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	status, err := r.authService.TwoFactorStatus(self.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// SetupTwoFactor выдает секрет и ссылку для QR-кода
+// вход по кодам включится после подтверждения первым кодом
+func (r *Router) SetupTwoFactor(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	setup, err := r.authService.SetupTwoFactor(self.(uuid.UUID))
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, setup)
+}
+
+// EnableTwoFactor включает вход по кодам и отдает коды восстановления
+// остальные сессии пользователя завершаются
+func (r *Router) EnableTwoFactor(c *gin.Context) {
+	userId, sessionId, ok := currentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	var payload dto.TwoFactorCodeDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := r.authService.EnableTwoFactor(userId, sessionId, payload.Code, requestDevice(c))
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor отключает вход по кодам, нужен пароль и код
+func (r *Router) DisableTwoFactor(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	var payload dto.DisableTwoFactorDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := r.authService.DisableTwoFactor(self.(uuid.UUID), payload.Password, payload.Code, requestDevice(c)); err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes выдает новые коды восстановления, старые перестают работать
+func (r *Router) RegenerateRecoveryCodes(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	var payload dto.TwoFactorCodeDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := r.authService.RegenerateRecoveryCodes(self.(uuid.UUID), payload.Code)
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// отвечает ошибкой входа по кодам с подходящим статусом
+func twoFactorError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrTwoFactorInvalid), errors.Is(err, service.ErrTwoFactorChallengeInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrWrongPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTwoFactorEnabled), errors.Is(err, service.ErrTwoFactorDisabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTwoFactorLocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
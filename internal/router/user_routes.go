@@ -1,16 +1,20 @@
 package router
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 
 	"mzt/internal/dto"
+	"mzt/internal/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 // получает список всех пользователей
-// доступно только админам
+// доступно с правом users:read
 func (r *Router) GetUsers(c *gin.Context) {
 	// получаем список пользователей из сервиса
 	users, err := r.authService.GetUsers()
@@ -27,8 +31,8 @@ func (r *Router) GetUsers(c *gin.Context) {
 	})
 }
 
-// получает роль пользователя по его id
-// доступно только админам
+// получает роли пользователя по его id и права которые они дают
+// доступно с правом users:read
 func (r *Router) Role(c *gin.Context) {
 	// достаем id пользователя из параметров запроса
 	userId := c.Param("user_id")
@@ -38,21 +42,74 @@ func (r *Router) Role(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
-	// получаем роль пользователя из сервиса
-	role, err := r.authService.Role(id)
-	if err != nil || role == "" {
+	// получаем роли пользователя из сервиса
+	roles, err := r.authService.Roles(id)
+	if err != nil {
 		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Can't get user role"})
 		return
 	}
-	// отправляем роль пользователя клиенту
+	// отправляем роли пользователя клиенту
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Roles of user",
+		"roles":       roles.Roles,
+		"permissions": roles.Permissions,
+	})
+}
+
+// SetUserRoles заменяет роли пользователя
+// доступно с правом roles:write
+func (r *Router) SetUserRoles(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var payload dto.SetUserRolesDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	roles, err := r.authService.SetUserRoles(self.(uuid.UUID), id, payload.Roles, requestDevice(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUnknownRole):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrLastSuperadmin):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Can't set user roles"})
+		}
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Role of user",
-		"role":    role,
+		"message":     "Roles updated",
+		"roles":       roles.Roles,
+		"permissions": roles.Permissions,
 	})
 }
 
+// ListRoles список ролей с их правами
+// доступно с правом users:read
+func (r *Router) ListRoles(c *gin.Context) {
+	roles, err := r.authService.GetRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Can't get roles"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
 // обрабатывает запросы на получение, обновление и удаление пользователя
-// доступно только админам
+// доступно с правом users:read, изменение и удаление - с правом users:write
 func (r *Router) Users(c *gin.Context) {
 	// достаем id пользователя из параметров запроса
 	userId := c.Param("user_id")
@@ -169,10 +226,23 @@ func (r *Router) SignIn(c *gin.Context) {
 	}
 
 	// пытаемся войти и получить токены
-	access, refresh, err := r.authService.SignIn(&payload)
+	access, refresh, err := r.authService.SignIn(&payload, requestDevice(c))
 
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	// пароль верный, но нужен еще код из приложения
+	if twoFactorRequired(c, err) {
+		return
+	}
+	// ответ не говорит что именно не так: почты нет или пароль неверный
+	switch {
+	case errors.Is(err, service.ErrInvalidCredentials):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	case errors.Is(err, service.ErrAccountLocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, try again later"})
+		return
+	case err != nil:
+		log.Printf("Failed to sign in: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Can't sign in"})
 		return
 	}
 
@@ -183,8 +253,8 @@ func (r *Router) SignIn(c *gin.Context) {
 		return
 	}
 
-	// узнаем роль пользователя админ он или нет
-	role, err := r.authService.Role(id)
+	// узнаем роли пользователя и права которые они дают
+	roles, err := r.authService.Roles(id)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
@@ -198,7 +268,8 @@ func (r *Router) SignIn(c *gin.Context) {
 		"message":      "User signed in successfully",
 		"access_token": access,
 		"id":           id,
-		"role":         role,
+		"roles":        roles.Roles,
+		"permissions":  roles.Permissions,
 	})
 }
 
@@ -243,7 +314,7 @@ func (r *Router) SignUp(c *gin.Context) {
 	}
 
 	// создаем пользователя и получаем токены
-	access, refresh, err := r.authService.SignUp(&payload)
+	access, refresh, err := r.authService.SignUp(&payload, requestDevice(c))
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -257,8 +328,8 @@ func (r *Router) SignUp(c *gin.Context) {
 		return
 	}
 
-	// узнаем роль пользователя админ он или нет
-	role, err := r.authService.Role(id)
+	// узнаем роли пользователя и права которые они дают
+	roles, err := r.authService.Roles(id)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
@@ -272,7 +343,8 @@ func (r *Router) SignUp(c *gin.Context) {
 		"message":      "User created successfully",
 		"access_token": access,
 		"id":           id,
-		"role":         role,
+		"roles":        roles.Roles,
+		"permissions":  roles.Permissions,
 	})
 }
 
@@ -287,8 +359,12 @@ func (r *Router) Refresh(c *gin.Context) {
 	}
 
 	// получаем новые токены
-	access, refresh, err := r.authService.RefreshTokens(token)
+	access, refresh, err := r.authService.RefreshTokens(token, requestDevice(c))
 	if err != nil {
+		// сессия отозвана, старый токен в куки больше не нужен
+		if errors.Is(err, service.ErrRefreshTokenReused) || errors.Is(err, service.ErrSessionNotFound) {
+			c.SetCookie("refresh_token", "", -1, "/", r.config.Jwt.Domain, false, true)
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
@@ -314,8 +390,8 @@ func (r *Router) Refresh(c *gin.Context) {
 		return
 	}
 
-	// узнаем роль пользователя админ он или нет
-	role, err := r.authService.Role(id)
+	// узнаем роли пользователя и права которые они дают
+	roles, err := r.authService.Roles(id)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
@@ -329,23 +405,23 @@ func (r *Router) Refresh(c *gin.Context) {
 		"message":      "Tokens refreshed successfully",
 		"access_token": access,
 		"id":           id,
-		"role":         role,
+		"roles":        roles.Roles,
+		"permissions":  roles.Permissions,
 	})
 }
 
 // Logout выходит из аккаунта
-// удаляет refresh токен и очищает куки
+// завершает текущую сессию и очищает куки, остальные устройства остаются в аккаунте
 func (r *Router) Logout(c *gin.Context) {
-	// достаем id пользователя из контекста
-	user, ok := c.Get("self")
+	// достаем id пользователя и сессии из контекста
+	userId, sessionId, ok := currentSession(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
 	}
 
-	// удаляем refresh токен из базы
-	userId := user.(uuid.UUID)
-	err := r.authService.Logout(userId)
+	// удаляем сессию из базы
+	err := r.authService.Logout(userId, sessionId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -357,3 +433,245 @@ func (r *Router) Logout(c *gin.Context) {
 		"message": "Logged out successfully",
 	})
 }
+
+// ForgotPassword отправляет на почту ссылку для сброса пароля
+// отвечает одинаково для известной и неизвестной почты
+func (r *Router) ForgotPassword(c *gin.Context) {
+	var payload dto.ForgotPasswordDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !r.validator.IsValidEmail(payload.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email"})
+		return
+	}
+
+	// ошибку отправки только пишем в лог, иначе по ответу можно понять что почта зарегистрирована
+	if err := r.authService.ForgotPassword(payload.Email); err != nil {
+		log.Printf("Error sending password reset email: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the email is registered, a reset link has been sent"})
+}
+
+// ResetPassword задает новый пароль по ссылке из письма
+// после сброса пользователь выходит на всех устройствах
+func (r *Router) ResetPassword(c *gin.Context) {
+	var payload dto.ResetPasswordDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// проверяем что пароль достаточно сложный
+	if !r.validator.IsValidPassword(payload.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is weak or contains incorrect symbols"})
+		return
+	}
+
+	if err := r.authService.ResetPassword(payload.Token, payload.Password, requestDevice(c)); err != nil {
+		if errors.Is(err, service.ErrResetTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.SetCookie("refresh_token", "", -1, "/", r.config.Jwt.Domain, false, true)
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+// VerifyEmail подтверждает почту по ссылке из письма
+func (r *Router) VerifyEmail(c *gin.Context) {
+	var payload dto.VerifyEmailDto
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := r.authService.VerifyEmail(payload.Token); err != nil {
+		if errors.Is(err, service.ErrVerifyTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ResendVerification еще раз отправляет письмо для подтверждения почты текущему пользователю
+func (r *Router) ResendVerification(c *gin.Context) {
+	self, ok := c.Get("self")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	if err := r.authService.ResendVerification(self.(uuid.UUID)); err != nil {
+		switch {
+		case errors.Is(err, service.ErrEmailAlreadyVerified):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrVerificationTooSoon):
+			c.Header("Retry-After", strconv.Itoa(int(r.config.Auth.VerifyResendInterval.Seconds())))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// MySessions список устройств на которых пользователь вошел в аккаунт
+func (r *Router) MySessions(c *gin.Context) {
+	userId, sessionId, ok := currentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	sessions, err := r.authService.GetSessions(userId, sessionId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeMySession завершает одну сессию пользователя
+// текущую сессию так тоже можно завершить, это то же самое что выход
+func (r *Router) RevokeMySession(c *gin.Context) {
+	userId, _, ok := currentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	sessionId, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := r.authService.RevokeSession(userId, sessionId); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions завершает все сессии пользователя кроме текущей
+func (r *Router) RevokeOtherSessions(c *gin.Context) {
+	userId, sessionId, ok := currentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Can't get user ID"})
+		return
+	}
+
+	if err := r.authService.RevokeOtherSessions(userId, sessionId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked"})
+}
+
+// события безопасности по аккаунту пользователя
+// доступно с правом users:read
+func (r *Router) UserSecurityEvents(c *gin.Context) {
+	userId, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	events, err := r.authService.GetSecurityEvents(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// JWKS отдает открытые ключи, которыми подписаны access токены
+// ответ можно кешировать: ключ из jwtkeys generate появляется здесь раньше, чем им начнут подписывать
+func (r *Router) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, r.authService.JWKS())
+}
+
+// отвечает на успешный вход: refresh токен в куки, access токен, роли и права в теле
+// аккаунты из внешних сервисов заполнены не полностью, поэтому отдаем и каких полей профиля не хватает
+func (r *Router) signedIn(c *gin.Context, id uuid.UUID, access, refresh string) {
+	// узнаем роли пользователя и права которые они дают
+	roles, err := r.authService.Roles(id)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	profile, err := r.authService.ProfileStatus(id)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	// сохраняем refresh токен в куки
+	c.SetCookie("refresh_token", refresh, int(r.config.Jwt.RefreshExpiresIn.Seconds()), "/", r.config.Jwt.Domain, false, true)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "User signed in successfully",
+		"access_token": access,
+		"id":           id,
+		"roles":        roles.Roles,
+		"permissions":  roles.Permissions,
+		"profile":      profile,
+	})
+}
+
+// отвечает что пароль или другой способ входа подтвержден, но нужен еще код из приложения
+// возвращает false если err не про второй шаг
+func twoFactorRequired(c *gin.Context, err error) bool {
+	var challenge *service.TwoFactorChallenge
+	if !errors.As(err, &challenge) {
+		return false
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "Two-factor code required",
+		"two_factor_required": true,
+		"challenge":           challenge.Challenge,
+	})
+	return true
+}
+
+// устройство с которого пришел запрос
+func requestDevice(c *gin.Context) service.Device {
+	return service.Device{
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	}
+}
+
+// достает id пользователя и его текущей сессии, их кладет AuthMiddleware
+func currentSession(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	self, ok := c.Get("self")
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+	session, ok := c.Get("session")
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+	userId, ok := self.(uuid.UUID)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+	sessionId, ok := session.(uuid.UUID)
+	return userId, sessionId, ok
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/gateway"
+	"mzt/internal/repository"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// набор не найден или снят с продажи
+	ErrBundleNotFound = errors.New("bundle not found")
+	// некорректные данные набора от админа
+	ErrInvalidBundle = errors.New("invalid bundle")
+	// все курсы набора у пользователя уже куплены
+	ErrBundleAlreadyOwned = errors.New("all courses of the bundle are already owned")
+)
+
+// сервис для работы с наборами курсов
+// отвечает за наборы в каталоге и их покупку
+type BundleService struct {
+	config      *config.Config
+	bundleRepo  repository.BundleRepository
+	courseRepo  repository.CourseRepository
+	paymentRepo repository.PaymentRepository
+	payments    *PaymentService
+}
+
+// создаем новый сервис для работы с наборами
+// платежи за наборы создаются и проводятся через сервис платежей
+func NewBundleService(cfg *config.Config, bundleRepo repository.BundleRepository, courseRepo repository.CourseRepository, paymentRepo repository.PaymentRepository, payments *PaymentService) *BundleService {
+	return &BundleService{
+		config:      cfg,
+		bundleRepo:  bundleRepo,
+		courseRepo:  courseRepo,
+		paymentRepo: paymentRepo,
+		payments:    payments,
+	}
+}
+
+// получает список наборов
+// если onlyActive - только те которые сейчас продаются
+func (s *BundleService) GetBundles(onlyActive bool) ([]dto.BundleDto, error) {
+	bundles, err := s.bundleRepo.GetBundles(onlyActive)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.BundleDto, 0, len(bundles))
+	for i := range bundles {
+		result = append(result, bundleDto(&bundles[i]))
+	}
+	return result, nil
+}
+
+// создает новый набор
+func (s *BundleService) CreateBundle(payload *dto.CreateBundleDto) (*dto.BundleDto, error) {
+	bundle := &entity.Bundle{BundleID: uuid.New(), Active: true}
+	if err := s.fillBundle(bundle, payload); err != nil {
+		return nil, err
+	}
+	if err := s.bundleRepo.CreateBundle(bundle); err != nil {
+		return nil, err
+	}
+
+	result := bundleDto(bundle)
+	return &result, nil
+}
+
+// обновляет набор
+// новые цена и состав действуют для следующих покупок, уже выданный доступ не меняется
+func (s *BundleService) UpdateBundle(bundleID uuid.UUID, payload *dto.CreateBundleDto) (*dto.BundleDto, error) {
+	bundle, err := s.bundleRepo.GetBundleByID(bundleID)
+	if err != nil || bundle == nil {
+		return nil, ErrBundleNotFound
+	}
+	if err := s.fillBundle(bundle, payload); err != nil {
+		return nil, err
+	}
+	if err := s.bundleRepo.UpdateBundle(bundle); err != nil {
+		return nil, err
+	}
+
+	result := bundleDto(bundle)
+	return &result, nil
+}
+
+// снимает набор с продажи
+// набор не удаляется: на него ссылаются платежи и выданный доступ
+func (s *BundleService) DeactivateBundle(bundleID uuid.UUID) error {
+	bundle, err := s.bundleRepo.GetBundleByID(bundleID)
+	if err != nil || bundle == nil {
+		return ErrBundleNotFound
+	}
+	bundle.Active = false
+	return s.bundleRepo.UpdateBundle(bundle)
+}
+
+// проверяет данные от админа и переносит их в набор
+func (s *BundleService) fillBundle(bundle *entity.Bundle, payload *dto.CreateBundleDto) error {
+	title := strings.TrimSpace(payload.Title)
+	if title == "" {
+		return ErrInvalidBundle
+	}
+	if !payload.Price.Valid() || !payload.Price.IsPositive() {
+		return ErrInvalidPrice
+	}
+
+	// набор из одного курса - это просто курс, все курсы должны существовать
+	courses := make([]entity.BundleCourse, 0, len(payload.CourseIDs))
+	seen := make(map[uuid.UUID]bool, len(payload.CourseIDs))
+	for _, courseID := range payload.CourseIDs {
+		if seen[courseID] {
+			continue
+		}
+		seen[courseID] = true
+		if course, err := s.courseRepo.GetCourse(courseID); err != nil || course == nil {
+			return fmt.Errorf("%w: course %s not found", ErrInvalidBundle, courseID)
+		}
+		courses = append(courses, entity.BundleCourse{BundleID: bundle.BundleID, CourseID: courseID})
+	}
+	if len(courses) < 2 {
+		return fmt.Errorf("%w: bundle must include at least two courses", ErrInvalidBundle)
+	}
+
+	bundle.Title = title
+	bundle.Description = payload.Description
+	bundle.Amount = payload.Price
+	bundle.Courses = courses
+	if payload.Active != nil {
+		bundle.Active = *payload.Active
+	}
+	return nil
+}
+
+// оформляет покупку набора и возвращает ссылку на оплату
+// за уже купленные пользователем курсы цена набора уменьшается, доступ ко всем курсам открывается после оплаты
+func (s *BundleService) Checkout(userID, bundleID uuid.UUID) (string, error) {
+	bundle, err := s.bundleRepo.GetBundleByID(bundleID)
+	if err != nil || bundle == nil || !bundle.Active {
+		return "", ErrBundleNotFound
+	}
+
+	payment, err := s.quoteBundle(userID, bundle)
+	if err != nil {
+		return "", err
+	}
+	result, err := s.payments.checkout(payment, bundle.Title, entity.PaymentSourceCheckout, &gateway.CreatePaymentRequest{
+		Description: "Покупка набора курсов",
+		ReturnURL:   s.config.Equiring.ReturnURL,
+		Metadata: map[string]string{
+			"user_id":    userID.String(),
+			"bundle_id":  bundleID.String(),
+			"payment_id": payment.PaymentID.String(),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.ConfirmationURL, nil
+}
+
+// считает сколько пользователь заплатит за набор и возвращает еще не сохраненный платеж
+// цена набора делится между курсами пропорционально их ценам, доля уже купленных курсов вычитается
+// если у какого-то курса нет цены в валюте набора - делится поровну
+func (s *BundleService) quoteBundle(userID uuid.UUID, bundle *entity.Bundle) (*entity.Payment, error) {
+	var (
+		owned, total           int64
+		ownedCount, totalCount int64
+		byCount                bool
+	)
+	for _, course := range bundle.Courses {
+		weight := int64(0)
+		price, err := s.paymentRepo.GetCoursePrice(course.CourseID)
+		if err != nil || price == nil || !price.Amount.SameCurrency(bundle.Amount) || !price.Amount.IsPositive() {
+			byCount = true
+		} else {
+			weight = price.Amount.Minor
+		}
+
+		total += weight
+		totalCount++
+		// курс из подписки или с приостановленным доступом еще не куплен
+		assignment, err := s.courseRepo.GetCourseAssignment(course.CourseID, userID)
+		if err == nil && assignment != nil && assignment.SubscriptionID == nil && !assignment.Suspended {
+			owned += weight
+			ownedCount++
+		}
+	}
+	if ownedCount == totalCount {
+		return nil, ErrBundleAlreadyOwned
+	}
+	if byCount {
+		owned, total = ownedCount, totalCount
+	}
+
+	amount := bundle.Amount.Prorate(total-owned, total)
+	if !amount.IsPositive() {
+		return nil, ErrInvalidPrice
+	}
+	payment := &entity.Payment{
+		PaymentID:     uuid.New(),
+		UserID:        userID,
+		BundleID:      &bundle.BundleID,
+		Amount:        amount,
+		Status:        entity.PaymentPending,
+		BundleCourses: make([]entity.PaymentCourse, 0, len(bundle.Courses)),
+	}
+	// после оплаты записываем на эти курсы, даже если состав набора успеют поменять
+	for _, course := range bundle.Courses {
+		payment.BundleCourses = append(payment.BundleCourses, entity.PaymentCourse{PaymentID: payment.PaymentID, CourseID: course.CourseID})
+	}
+	return payment, nil
+}
+
+// преобразует набор в формат для response
+func bundleDto(bundle *entity.Bundle) dto.BundleDto {
+	courseIDs := make([]uuid.UUID, 0, len(bundle.Courses))
+	for _, course := range bundle.Courses {
+		courseIDs = append(courseIDs, course.CourseID)
+	}
+	return dto.BundleDto{
+		BundleID:    bundle.BundleID,
+		Title:       bundle.Title,
+		Description: bundle.Description,
+		Price:       bundle.Amount,
+		Active:      bundle.Active,
+		CourseIDs:   courseIDs,
+		CreatedAt:   bundle.CreatedAt,
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/mocks"
+	"mzt/internal/money"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// поднимает сервис наборов поверх тех же моков что и сервис платежей
+func setupBundleService(t *testing.T) (*BundleService, *mocks.FakeYooKassa, *mocks.MockPaymentRepository, *mocks.MockCourseRepository) {
+	paymentService, fake, paymentRepo, courseRepo := setupPaymentService(t)
+
+	bundleService := NewBundleService(paymentService.config, paymentService.bundleRepo, courseRepo, paymentRepo, paymentService)
+	return bundleService, fake, paymentRepo, courseRepo
+}
+
+// создает набор "три курса по цене двух"
+func createTestBundle(t *testing.T, service *BundleService, courseRepo *mocks.MockCourseRepository, paymentRepo *mocks.MockPaymentRepository) *dto.BundleDto {
+	courseIDs := []uuid.UUID{
+		createPricedCourse(courseRepo, paymentRepo),
+		createPricedCourse(courseRepo, paymentRepo),
+		createPricedCourse(courseRepo, paymentRepo),
+	}
+
+	bundle, err := service.CreateBundle(&dto.CreateBundleDto{Title: "3 курса по цене 2", Price: money.New(5980000, "RUB"), CourseIDs: courseIDs})
+	require.NoError(t, err)
+	return bundle
+}
+
+// оформляет покупку набора и возвращает платеж за него
+func checkoutBundle(t *testing.T, service *BundleService, paymentRepo *mocks.MockPaymentRepository, userID, bundleID uuid.UUID) *entity.Payment {
+	url, err := service.Checkout(userID, bundleID)
+	require.NoError(t, err)
+	require.NotEmpty(t, url)
+
+	for _, payment := range paymentRepo.Payments {
+		if payment.UserID == userID && payment.BundleID != nil && payment.Status == entity.PaymentProcessing {
+			return payment
+		}
+	}
+	t.Fatal("bundle payment was not created")
+	return nil
+}
+
+func TestBundleService_WebhookEnrollsAllCourses(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupBundleService(t)
+	bundle := createTestBundle(t, service, courseRepo, paymentRepo)
+	userID := uuid.New()
+
+	payment := checkoutBundle(t, service, paymentRepo, userID, bundle.BundleID)
+	assert.Equal(t, money.New(5980000, "RUB"), payment.Amount)
+	assert.Nil(t, payment.CourseID)
+
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	require.NoError(t, service.payments.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+
+	assert.Equal(t, entity.PaymentSucceeded, payment.Status)
+	for _, courseID := range bundle.CourseIDs {
+		assignment, _ := courseRepo.GetCourseAssignment(courseID, userID)
+		require.NotNil(t, assignment)
+		assert.Equal(t, bundle.BundleID, *assignment.BundleID)
+	}
+
+	// все курсы набора уже куплены
+	_, err := service.Checkout(userID, bundle.BundleID)
+	assert.ErrorIs(t, err, ErrBundleAlreadyOwned)
+}
+
+func TestBundleService_EnrollsQuotedCourses(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupBundleService(t)
+	bundle := createTestBundle(t, service, courseRepo, paymentRepo)
+	userID := uuid.New()
+
+	payment := checkoutBundle(t, service, paymentRepo, userID, bundle.BundleID)
+
+	// пока платеж ждал оплаты, в набор добавили курс
+	added := createPricedCourse(courseRepo, paymentRepo)
+	_, err := service.UpdateBundle(bundle.BundleID, &dto.CreateBundleDto{
+		Title:     bundle.Title,
+		Price:     bundle.Price,
+		CourseIDs: append([]uuid.UUID{added}, bundle.CourseIDs...),
+	})
+	require.NoError(t, err)
+
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	require.NoError(t, service.payments.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+
+	// записываем только на курсы, за которые посчитали цену
+	for _, courseID := range bundle.CourseIDs {
+		assignment, _ := courseRepo.GetCourseAssignment(courseID, userID)
+		assert.NotNil(t, assignment)
+	}
+	assignment, _ := courseRepo.GetCourseAssignment(added, userID)
+	assert.Nil(t, assignment)
+}
+
+func TestBundleService_ProratesOwnedCourses(t *testing.T) {
+	service, _, paymentRepo, courseRepo := setupBundleService(t)
+	bundle := createTestBundle(t, service, courseRepo, paymentRepo)
+	userID := uuid.New()
+
+	// один курс куплен отдельно, второй доступен только по подписке
+	subscriptionID := uuid.New()
+	require.NoError(t, courseRepo.CreateCourseAssignment(&entity.CourseAssignment{CaID: uuid.New(), UserID: userID, CourseID: bundle.CourseIDs[0]}))
+	require.NoError(t, courseRepo.CreateCourseAssignment(&entity.CourseAssignment{CaID: uuid.New(), UserID: userID, CourseID: bundle.CourseIDs[1], SubscriptionID: &subscriptionID}))
+
+	payment := checkoutBundle(t, service, paymentRepo, userID, bundle.BundleID)
+	assert.Equal(t, money.New(3986667, "RUB"), payment.Amount)
+}
+
+func TestBundleService_FullRefundUnenrolls(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupBundleService(t)
+	bundle := createTestBundle(t, service, courseRepo, paymentRepo)
+	userID := uuid.New()
+
+	// купленный отдельно курс не входит в набор и после возврата остается
+	owned := bundle.CourseIDs[0]
+	require.NoError(t, courseRepo.CreateCourseAssignment(&entity.CourseAssignment{CaID: uuid.New(), UserID: userID, CourseID: owned}))
+
+	payment := checkoutBundle(t, service, paymentRepo, userID, bundle.BundleID)
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	require.NoError(t, service.payments.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+
+	_, err := service.payments.RefundPayment(payment.PaymentID, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, entity.PaymentRefunded, payment.Status)
+
+	assignment, _ := courseRepo.GetCourseAssignment(owned, userID)
+	require.NotNil(t, assignment)
+	assert.Nil(t, assignment.BundleID)
+	for _, courseID := range bundle.CourseIDs[1:] {
+		assignment, _ := courseRepo.GetCourseAssignment(courseID, userID)
+		assert.Nil(t, assignment)
+	}
+}
+
+func TestBundleService_InvalidBundle(t *testing.T) {
+	service, _, paymentRepo, courseRepo := setupBundleService(t)
+
+	_, err := service.CreateBundle(&dto.CreateBundleDto{Title: "Один курс", Price: money.New(100000, "RUB"), CourseIDs: []uuid.UUID{createPricedCourse(courseRepo, paymentRepo)}})
+	assert.ErrorIs(t, err, ErrInvalidBundle)
+
+	bundle := createTestBundle(t, service, courseRepo, paymentRepo)
+	require.NoError(t, service.DeactivateBundle(bundle.BundleID))
+	_, err = service.Checkout(uuid.New(), bundle.BundleID)
+	assert.ErrorIs(t, err, ErrBundleNotFound)
+
+	bundles, err := service.GetBundles(true)
+	require.NoError(t, err)
+	assert.Empty(t, bundles)
+}
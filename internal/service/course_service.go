@@ -61,14 +61,16 @@ func (s *CourseService) GetCourse(courseId uuid.UUID) (*dto.CourseDto, error) {
 // CreateCourse создает новый курс
 // создает новый курс в базе с указанными данными
 func (s *CourseService) CreateCourse(course *dto.CreateCourseDto) error {
+	if !course.Price.Valid() || !course.Price.IsPositive() {
+		return ErrInvalidPrice
+	}
 	// создаем новый курс с уникальным id
 	courseEntity := &entity.Course{
 		CourseID: uuid.New(),
 		Title:    course.Name,
 		Desc:     course.Description,
 		Price: &entity.CoursePrice{
-			Amount:       float64(course.Price),
-			CurrencyCode: "RUB",
+			Amount: course.Price,
 		},
 	}
 	return s.repo.AddCourse(courseEntity)
@@ -77,6 +79,9 @@ func (s *CourseService) CreateCourse(course *dto.CreateCourseDto) error {
 // UpdateCourse обновляет информацию о курсе
 // меняет название описание и цену курса
 func (s *CourseService) UpdateCourse(courseId uuid.UUID, updated *dto.UpdateCourseDto) error {
+	if !updated.Price.Valid() || !updated.Price.IsPositive() {
+		return ErrInvalidPrice
+	}
 	return s.repo.UpdateCourse(courseId, updated)
 }
 
@@ -187,6 +192,7 @@ func (s *CourseService) ListUserCourses(userId uuid.UUID) ([]dto.CourseDto, erro
 			CourseID:    assignment.CourseID,
 			Name:        assignment.Course.Title,
 			Description: assignment.Course.Desc,
+			Suspended:   assignment.Suspended,
 		})
 	}
 
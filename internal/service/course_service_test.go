@@ -4,6 +4,7 @@ import (
 	"mzt/config"
 	"mzt/internal/dto"
 	"mzt/internal/mocks"
+	"mzt/internal/money"
 	"testing"
 	"time"
 
@@ -15,7 +16,6 @@ func TestCourseService_CreateCourse(t *testing.T) {
 	mockRepo := mocks.NewMockCourseRepository()
 	cfg := &config.Config{
 		Jwt: config.Jwt{
-			AccessKey:       "test-access-key",
 			RefreshKey:      "test-refresh-key",
 			AccessExpiresIn: time.Minute * 30,
 		},
@@ -24,7 +24,7 @@ func TestCourseService_CreateCourse(t *testing.T) {
 	courseDto := &dto.CreateCourseDto{
 		Name:        "Test Course",
 		Description: "Test Description",
-		Price:       100,
+		Price:       money.New(10000, "RUB"),
 	}
 
 	err := service.CreateCourse(courseDto)
@@ -41,7 +41,6 @@ func TestCourseService_GetCourse(t *testing.T) {
 	mockRepo := mocks.NewMockCourseRepository()
 	cfg := &config.Config{
 		Jwt: config.Jwt{
-			AccessKey:       "test-access-key",
 			RefreshKey:      "test-refresh-key",
 			AccessExpiresIn: time.Minute * 30,
 		},
@@ -50,7 +49,7 @@ func TestCourseService_GetCourse(t *testing.T) {
 	courseDto := &dto.CreateCourseDto{
 		Name:        "Test Course",
 		Description: "Test Description",
-		Price:       100,
+		Price:       money.New(10000, "RUB"),
 	}
 	err := service.CreateCourse(courseDto)
 	assert.NoError(t, err)
@@ -71,7 +70,6 @@ func TestCourseService_UpdateCourse(t *testing.T) {
 	mockRepo := mocks.NewMockCourseRepository()
 	cfg := &config.Config{
 		Jwt: config.Jwt{
-			AccessKey:       "test-access-key",
 			RefreshKey:      "test-refresh-key",
 			AccessExpiresIn: time.Minute * 30,
 		},
@@ -80,7 +78,7 @@ func TestCourseService_UpdateCourse(t *testing.T) {
 	courseDto := &dto.CreateCourseDto{
 		Name:        "Test Course",
 		Description: "Test Description",
-		Price:       100,
+		Price:       money.New(10000, "RUB"),
 	}
 	err := service.CreateCourse(courseDto)
 	assert.NoError(t, err)
@@ -92,7 +90,7 @@ func TestCourseService_UpdateCourse(t *testing.T) {
 	updatedDto := &dto.UpdateCourseDto{
 		Name:        "Updated Course",
 		Description: "Updated Description",
-		Price:       200,
+		Price:       money.New(20000, "RUB"),
 	}
 
 	err = service.UpdateCourse(courseId, updatedDto)
@@ -108,7 +106,6 @@ func TestCourseService_DeleteCourse(t *testing.T) {
 	mockRepo := mocks.NewMockCourseRepository()
 	cfg := &config.Config{
 		Jwt: config.Jwt{
-			AccessKey:       "test-access-key",
 			RefreshKey:      "test-refresh-key",
 			AccessExpiresIn: time.Minute * 30,
 		},
@@ -117,7 +114,7 @@ func TestCourseService_DeleteCourse(t *testing.T) {
 	courseDto := &dto.CreateCourseDto{
 		Name:        "Test Course",
 		Description: "Test Description",
-		Price:       100,
+		Price:       money.New(10000, "RUB"),
 	}
 	err := service.CreateCourse(courseDto)
 	assert.NoError(t, err)
@@ -138,7 +135,6 @@ func TestCourseService_EnrollUserToCourse(t *testing.T) {
 	mockRepo := mocks.NewMockCourseRepository()
 	cfg := &config.Config{
 		Jwt: config.Jwt{
-			AccessKey:       "test-access-key",
 			RefreshKey:      "test-refresh-key",
 			AccessExpiresIn: time.Minute * 30,
 		},
@@ -147,7 +143,7 @@ func TestCourseService_EnrollUserToCourse(t *testing.T) {
 	courseDto := &dto.CreateCourseDto{
 		Name:        "Test Course",
 		Description: "Test Description",
-		Price:       100,
+		Price:       money.New(10000, "RUB"),
 	}
 	err := service.CreateCourse(courseDto)
 	assert.NoError(t, err)
@@ -170,7 +166,6 @@ func TestCourseService_UpdateProgress(t *testing.T) {
 	mockRepo := mocks.NewMockCourseRepository()
 	cfg := &config.Config{
 		Jwt: config.Jwt{
-			AccessKey:       "test-access-key",
 			RefreshKey:      "test-refresh-key",
 			AccessExpiresIn: time.Minute * 30,
 		},
@@ -179,7 +174,7 @@ func TestCourseService_UpdateProgress(t *testing.T) {
 	courseDto := &dto.CreateCourseDto{
 		Name:        "Test Course",
 		Description: "Test Description",
-		Price:       100,
+		Price:       money.New(10000, "RUB"),
 	}
 	err := service.CreateCourse(courseDto)
 	assert.NoError(t, err)
@@ -61,8 +61,8 @@ func (s *EventService) GetEventWithSecrets(eventId uuid.UUID, userId uuid.UUID)
 	}
 
 	// проверяем что пользователь записан на курс
-	_, err = s.courseRepo.GetCourseAssignment(event.CourseID, userId)
-	if err != nil {
+	assignment, err := s.courseRepo.GetCourseAssignment(event.CourseID, userId)
+	if err != nil || assignment == nil || assignment.Suspended {
 		return nil, errors.New("user does not have access to event secrets")
 	}
 
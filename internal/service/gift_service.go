@@ -0,0 +1,337 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math/big"
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/gateway"
+	"mzt/internal/repository"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// сколько кодов админ может выпустить за один раз
+const MaxGiftCodeBatch = 1000
+
+// символы кода без похожих друг на друга 0/O и 1/I
+const giftCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var (
+	// код не найден
+	ErrGiftCodeNotFound = errors.New("gift code not found")
+	// код уже активирован, отозван или истек
+	ErrGiftCodeUnavailable = errors.New("gift code is no longer available")
+	// курс из кода у пользователя уже куплен
+	ErrGiftCourseOwned = errors.New("course is already owned")
+	// некорректный запрос на выпуск кодов
+	ErrInvalidGiftCodes = errors.New("invalid gift codes request")
+)
+
+// сервис для работы с подарками
+// отвечает за покупку курса в подарок, выпуск и активацию кодов доступа
+type GiftService struct {
+	config     *config.Config
+	giftRepo   repository.GiftCodeRepository
+	courseRepo repository.CourseRepository
+	payments   *PaymentService
+}
+
+// создаем новый сервис для работы с подарками
+// платежи за подарки создаются и проводятся через сервис платежей
+func NewGiftService(cfg *config.Config, giftRepo repository.GiftCodeRepository, courseRepo repository.CourseRepository, payments *PaymentService) *GiftService {
+	return &GiftService{
+		config:     cfg,
+		giftRepo:   giftRepo,
+		courseRepo: courseRepo,
+		payments:   payments,
+	}
+}
+
+// оформляет покупку курса в подарок и возвращает ссылку на оплату
+// код доступа выпускается после оплаты, сам покупатель на курс не записывается
+func (s *GiftService) Checkout(userID, courseID uuid.UUID, promoCode string) (string, error) {
+	course, payment, err := s.payments.quoteCourse(userID, courseID, promoCode)
+	if err != nil {
+		return "", err
+	}
+	payment.Gift = true
+
+	result, err := s.payments.checkout(payment, course.Name, entity.PaymentSourceCheckout, &gateway.CreatePaymentRequest{
+		Description: "Покупка курса в подарок",
+		ReturnURL:   s.config.Equiring.ReturnURL,
+		Metadata: map[string]string{
+			"user_id":    userID.String(),
+			"course_id":  courseID.String(),
+			"payment_id": payment.PaymentID.String(),
+			"gift":       "true",
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.ConfirmationURL, nil
+}
+
+// активирует код и записывает пользователя на курс
+// код одноразовый, курс который пользователь уже купил повторно активировать нельзя
+func (s *GiftService) Redeem(userID uuid.UUID, code string) (*dto.CourseDto, error) {
+	giftCode, err := s.giftRepo.GetGiftCodeByCode(normalizeGiftCode(code))
+	if err != nil || giftCode == nil {
+		return nil, ErrGiftCodeNotFound
+	}
+	if giftCodeStatus(giftCode, time.Now()) != "active" {
+		return nil, ErrGiftCodeUnavailable
+	}
+
+	// курс из подписки, набора или с приостановленным доступом код делает купленным
+	existing, err := s.courseRepo.GetCourseAssignment(giftCode.CourseID, userID)
+	if err == nil && existing != nil && existing.SubscriptionID == nil && existing.BundleID == nil && !existing.Suspended {
+		return nil, ErrGiftCourseOwned
+	}
+
+	err = s.giftRepo.RedeemGiftCode(giftCode.GiftCodeID, userID, time.Now())
+	if errors.Is(err, repository.ErrGiftCodeUnavailable) {
+		return nil, ErrGiftCodeUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.courseRepo.GetCourse(giftCode.CourseID)
+}
+
+// выпускает пачку кодов на курс без оплаты, например для корпоративного клиента
+// adminID попадает в коды как тот кто их выпустил
+func (s *GiftService) CreateBatch(adminID uuid.UUID, payload *dto.CreateGiftCodesDto) ([]dto.GiftCodeDto, error) {
+	if payload.Count == 0 || payload.Count > MaxGiftCodeBatch {
+		return nil, fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidGiftCodes, MaxGiftCodeBatch)
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expiry date is in the past", ErrInvalidGiftCodes)
+	}
+	course, err := s.courseRepo.GetCourse(payload.CourseID)
+	if err != nil || course == nil {
+		return nil, fmt.Errorf("%w: course %s not found", ErrInvalidGiftCodes, payload.CourseID)
+	}
+
+	batchID := uuid.New()
+	codes := make([]entity.GiftCode, 0, payload.Count)
+	for i := uint(0); i < payload.Count; i++ {
+		code, err := generateGiftCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, entity.GiftCode{
+			GiftCodeID: uuid.New(),
+			Code:       code,
+			CourseID:   payload.CourseID,
+			IssuedByID: adminID,
+			BatchID:    &batchID,
+			Note:       strings.TrimSpace(payload.Note),
+			ExpiresAt:  payload.ExpiresAt,
+		})
+	}
+	if err := s.giftRepo.CreateGiftCodes(codes); err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.GiftCodeDto, 0, len(codes))
+	for i := range codes {
+		codes[i].Course.Title = course.Name
+		result = append(result, giftCodeDto(&codes[i]))
+	}
+	return result, nil
+}
+
+// получает коды с тем кто и когда их активировал
+// если передан batchID или courseID - только коды из этой пачки или на этот курс
+func (s *GiftService) GetGiftCodes(batchID, courseID *uuid.UUID) ([]dto.GiftCodeDto, error) {
+	codes, err := s.giftRepo.GetGiftCodes(batchID, courseID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.GiftCodeDto, 0, len(codes))
+	for i := range codes {
+		result = append(result, giftCodeDto(&codes[i]))
+	}
+	return result, nil
+}
+
+// выгружает коды в csv для передачи клиенту
+func (s *GiftService) ExportGiftCodes(batchID, courseID *uuid.UUID) ([]byte, error) {
+	codes, err := s.GetGiftCodes(batchID, courseID)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	rows := [][]string{{"code", "course_id", "course_title", "batch_id", "note", "status", "expires_at", "redeemed_by_id", "redeemed_by_email", "redeemed_at", "created_at"}}
+	for _, code := range codes {
+		rows = append(rows, []string{
+			code.Code,
+			code.CourseID.String(),
+			code.CourseTitle,
+			optionalUUID(code.BatchID),
+			code.Note,
+			code.Status,
+			optionalTime(code.ExpiresAt),
+			optionalUUID(code.RedeemedByID),
+			code.RedeemedByEmail,
+			optionalTime(code.RedeemedAt),
+			code.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// получает коды из подарков которые купил пользователь
+func (s *GiftService) GetPurchasedGiftCodes(userID uuid.UUID) ([]dto.GiftCodeDto, error) {
+	codes, err := s.giftRepo.GetPurchasedGiftCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.GiftCodeDto, 0, len(codes))
+	for i := range codes {
+		result = append(result, giftCodeDto(&codes[i]))
+	}
+	return result, nil
+}
+
+// выпускает код по оплаченному подарку
+// повторное проведение того же платежа второй код не выпускает
+func issueGiftCode(giftRepo repository.GiftCodeRepository, payment *entity.Payment, ttl time.Duration) error {
+	if existing, err := giftRepo.GetGiftCodeByPaymentID(payment.PaymentID); err == nil && existing != nil {
+		return nil
+	}
+
+	code, err := generateGiftCode()
+	if err != nil {
+		return err
+	}
+	paymentID := payment.PaymentID
+	giftCode := entity.GiftCode{
+		GiftCodeID: uuid.New(),
+		Code:       code,
+		CourseID:   *payment.CourseID,
+		PaymentID:  &paymentID,
+		IssuedByID: payment.UserID,
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		giftCode.ExpiresAt = &expiresAt
+	}
+	return giftRepo.CreateGiftCodes([]entity.GiftCode{giftCode})
+}
+
+// отзывает код после возврата денег за подарок
+// если код уже активировали - доступ к курсу забирается у того кто его активировал
+func revokeGiftCode(giftRepo repository.GiftCodeRepository, courseRepo repository.CourseRepository, paymentID uuid.UUID) error {
+	giftCode, err := giftRepo.GetGiftCodeByPaymentID(paymentID)
+	if err != nil {
+		return err
+	}
+	if err := giftRepo.RevokeGiftCode(giftCode.GiftCodeID, time.Now()); err != nil {
+		return err
+	}
+	if giftCode.RedeemedByID == nil {
+		return nil
+	}
+	return courseRepo.DeleteCourseAssignment(giftCode.CourseID, *giftCode.RedeemedByID)
+}
+
+// генерирует случайный код вида XXXX-XXXX-XXXX
+func generateGiftCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(giftCodeAlphabet)))
+	for i := 0; i < 12; i++ {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(giftCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// приводит введенный пользователем код к виду в котором он хранится
+// регистр, пробелы и дефисы не важны
+func normalizeGiftCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			if b.Len() > 0 && (b.Len()+1)%5 == 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// вычисляет статус кода на момент now
+func giftCodeStatus(code *entity.GiftCode, now time.Time) string {
+	switch {
+	case code.RevokedAt != nil:
+		return "revoked"
+	case code.RedeemedByID != nil:
+		return "redeemed"
+	case code.ExpiresAt != nil && !code.ExpiresAt.After(now):
+		return "expired"
+	default:
+		return "active"
+	}
+}
+
+// преобразует код в формат для response
+func giftCodeDto(code *entity.GiftCode) dto.GiftCodeDto {
+	result := dto.GiftCodeDto{
+		GiftCodeID:   code.GiftCodeID,
+		Code:         code.Code,
+		CourseID:     code.CourseID,
+		CourseTitle:  code.Course.Title,
+		PaymentID:    code.PaymentID,
+		IssuedByID:   code.IssuedByID,
+		BatchID:      code.BatchID,
+		Note:         code.Note,
+		Status:       giftCodeStatus(code, time.Now()),
+		ExpiresAt:    code.ExpiresAt,
+		RevokedAt:    code.RevokedAt,
+		RedeemedByID: code.RedeemedByID,
+		RedeemedAt:   code.RedeemedAt,
+		CreatedAt:    code.CreatedAt,
+	}
+	if code.RedeemedBy != nil && code.RedeemedBy.UserData != nil {
+		result.RedeemedByEmail = code.RedeemedBy.UserData.Email
+	}
+	return result
+}
+
+func optionalUUID(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+func optionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"encoding/csv"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/mocks"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// поднимает сервис подарков поверх тех же моков что и сервис платежей
+func setupGiftService(t *testing.T) (*GiftService, *mocks.FakeYooKassa, *mocks.MockPaymentRepository, *mocks.MockCourseRepository) {
+	paymentService, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	paymentService.config.Equiring.GiftCodeTTL = time.Hour * 24 * 365
+
+	giftService := NewGiftService(paymentService.config, paymentService.giftRepo, courseRepo, paymentService)
+	return giftService, fake, paymentRepo, courseRepo
+}
+
+// покупает курс в подарок, оплачивает его через уведомление и возвращает платеж
+func buyGift(t *testing.T, service *GiftService, fake *mocks.FakeYooKassa, paymentRepo *mocks.MockPaymentRepository, buyerID, courseID uuid.UUID) *entity.Payment {
+	url, err := service.Checkout(buyerID, courseID, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, url)
+
+	for _, payment := range paymentRepo.Payments {
+		if payment.UserID == buyerID && payment.Gift && payment.Status == entity.PaymentProcessing {
+			fake.SetStatus(payment.PaymentRef, "succeeded")
+			require.NoError(t, service.payments.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+			return payment
+		}
+	}
+	t.Fatal("gift payment was not created")
+	return nil
+}
+
+func TestGiftService_PurchaseIssuesCode(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupGiftService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	buyerID, friendID := uuid.New(), uuid.New()
+
+	payment := buyGift(t, service, fake, paymentRepo, buyerID, courseID)
+	assert.Equal(t, entity.PaymentSucceeded, payment.Status)
+
+	// покупатель на курс не записан, зато получил код
+	assignment, _ := courseRepo.GetCourseAssignment(courseID, buyerID)
+	assert.Nil(t, assignment)
+	gifts, err := service.GetPurchasedGiftCodes(buyerID)
+	require.NoError(t, err)
+	require.Len(t, gifts, 1)
+	assert.Equal(t, "active", gifts[0].Status)
+	require.NotNil(t, gifts[0].ExpiresAt)
+
+	// код вводят как удобно: в нижнем регистре и без дефисов
+	code := strings.ToLower(strings.ReplaceAll(gifts[0].Code, "-", ""))
+	course, err := service.Redeem(friendID, code)
+	require.NoError(t, err)
+	assert.Equal(t, courseID, course.CourseID)
+	assignment, _ = courseRepo.GetCourseAssignment(courseID, friendID)
+	assert.NotNil(t, assignment)
+
+	// код одноразовый
+	_, err = service.Redeem(uuid.New(), gifts[0].Code)
+	assert.ErrorIs(t, err, ErrGiftCodeUnavailable)
+
+	gifts, err = service.GetPurchasedGiftCodes(buyerID)
+	require.NoError(t, err)
+	assert.Equal(t, "redeemed", gifts[0].Status)
+	assert.Equal(t, friendID, *gifts[0].RedeemedByID)
+}
+
+func TestGiftService_RefundRevokesCode(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupGiftService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	buyerID, friendID := uuid.New(), uuid.New()
+
+	payment := buyGift(t, service, fake, paymentRepo, buyerID, courseID)
+	gifts, err := service.GetPurchasedGiftCodes(buyerID)
+	require.NoError(t, err)
+	_, err = service.Redeem(friendID, gifts[0].Code)
+	require.NoError(t, err)
+
+	_, err = service.payments.RefundPayment(payment.PaymentID, nil, "")
+	require.NoError(t, err)
+
+	// деньги вернули - доступ забирается у того кто активировал код
+	assignment, _ := courseRepo.GetCourseAssignment(courseID, friendID)
+	assert.Nil(t, assignment)
+	gifts, err = service.GetPurchasedGiftCodes(buyerID)
+	require.NoError(t, err)
+	assert.Equal(t, "revoked", gifts[0].Status)
+}
+
+func TestGiftService_RedeemOwnedCourse(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupGiftService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	buyerID, friendID := uuid.New(), uuid.New()
+	require.NoError(t, courseRepo.CreateCourseAssignment(&entity.CourseAssignment{CaID: uuid.New(), UserID: friendID, CourseID: courseID}))
+
+	buyGift(t, service, fake, paymentRepo, buyerID, courseID)
+	gifts, err := service.GetPurchasedGiftCodes(buyerID)
+	require.NoError(t, err)
+
+	// курс уже куплен, код остается неиспользованным
+	_, err = service.Redeem(friendID, gifts[0].Code)
+	assert.ErrorIs(t, err, ErrGiftCourseOwned)
+	_, err = service.Redeem(friendID, "AAAA-BBBB-CCCC")
+	assert.ErrorIs(t, err, ErrGiftCodeNotFound)
+}
+
+func TestGiftService_AdminBatch(t *testing.T) {
+	service, _, paymentRepo, courseRepo := setupGiftService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	adminID, employeeID := uuid.New(), uuid.New()
+
+	past := time.Now().Add(-time.Hour)
+	_, err := service.CreateBatch(adminID, &dto.CreateGiftCodesDto{CourseID: courseID, Count: 3, ExpiresAt: &past})
+	assert.ErrorIs(t, err, ErrInvalidGiftCodes)
+	_, err = service.CreateBatch(adminID, &dto.CreateGiftCodesDto{CourseID: courseID, Count: MaxGiftCodeBatch + 1})
+	assert.ErrorIs(t, err, ErrInvalidGiftCodes)
+
+	expiresAt := time.Now().AddDate(0, 3, 0)
+	codes, err := service.CreateBatch(adminID, &dto.CreateGiftCodesDto{CourseID: courseID, Count: 3, ExpiresAt: &expiresAt, Note: "ООО Ромашка"})
+	require.NoError(t, err)
+	require.Len(t, codes, 3)
+	batchID := codes[0].BatchID
+	require.NotNil(t, batchID)
+	assert.Nil(t, codes[0].PaymentID)
+
+	_, err = service.Redeem(employeeID, codes[1].Code)
+	require.NoError(t, err)
+
+	data, err := service.ExportGiftCodes(batchID, nil)
+	require.NoError(t, err)
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	assert.Equal(t, "code", rows[0][0])
+
+	redeemed := 0
+	for _, row := range rows[1:] {
+		assert.Equal(t, "ООО Ромашка", row[4])
+		if row[5] == "redeemed" {
+			redeemed++
+			assert.Equal(t, employeeID.String(), row[7])
+		}
+	}
+	assert.Equal(t, 1, redeemed)
+
+	// истекший код активировать нельзя
+	expired := service.giftRepo.(*mocks.MockGiftCodeRepository).Codes[codes[2].GiftCodeID]
+	expired.ExpiresAt = &past
+	_, err = service.Redeem(uuid.New(), codes[2].Code)
+	assert.ErrorIs(t, err, ErrGiftCodeUnavailable)
+}
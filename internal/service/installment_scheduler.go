@@ -0,0 +1,21 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// StartInstallmentScheduler запускает в фоне приостановку доступа по просроченным рассрочкам
+// проверка выполняется сразу и дальше раз в BillingInterval, остановить можно закрыв stop
+func StartInstallmentScheduler(installmentService *InstallmentService, stop <-chan struct{}) {
+	interval := installmentService.config.Equiring.BillingInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	runEvery(interval, stop, func() {
+		if err := installmentService.SuspendOverdue(); err != nil {
+			fmt.Printf("Error suspending overdue installment plans: %v\n", err)
+		}
+	})
+}
@@ -0,0 +1,370 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/gateway"
+	"mzt/internal/money"
+	"mzt/internal/repository"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// на сколько частей можно разбить оплату курса
+const (
+	MinInstallmentParts = 2
+	MaxInstallmentParts = 6
+)
+
+var (
+	// неподходящее число частей или цена слишком мала чтобы ее делить
+	ErrInvalidInstallments = errors.New("invalid number of installments")
+	// рассрочка не найдена или принадлежит другому пользователю
+	ErrInstallmentPlanNotFound = errors.New("installment plan not found")
+	// по этому курсу уже есть незакрытая рассрочка
+	ErrInstallmentPlanExists = errors.New("installment plan already exists for this course")
+	// рассрочка уже оплачена полностью или отменена
+	ErrInstallmentPlanClosed = errors.New("installment plan is closed")
+	// курс у пользователя уже куплен, оформлять на него рассрочку незачем
+	ErrInstallmentCourseOwned = errors.New("course is already owned")
+)
+
+// сервис для работы с рассрочками
+// отвечает за оформление рассрочки, оплату частей и приостановку доступа при просрочке
+type InstallmentService struct {
+	config          *config.Config
+	installmentRepo repository.InstallmentRepository
+	courseRepo      repository.CourseRepository
+	payments        *PaymentService
+}
+
+// создаем новый сервис для работы с рассрочками
+// платежи за части создаются и проводятся через сервис платежей
+func NewInstallmentService(cfg *config.Config, installmentRepo repository.InstallmentRepository, courseRepo repository.CourseRepository, payments *PaymentService) *InstallmentService {
+	return &InstallmentService{
+		config:          cfg,
+		installmentRepo: installmentRepo,
+		courseRepo:      courseRepo,
+		payments:        payments,
+	}
+}
+
+// оформляет рассрочку на курс и возвращает ссылку на оплату первой части
+// цена со скидкой по промокоду делится на parts частей со сроками раз в месяц, доступ открывается после первой части
+func (s *InstallmentService) Checkout(userID, courseID uuid.UUID, promoCode string, parts uint) (string, error) {
+	if parts < MinInstallmentParts || parts > MaxInstallmentParts {
+		return "", ErrInvalidInstallments
+	}
+
+	plans, err := s.installmentRepo.GetInstallmentPlansByUserID(userID)
+	if err != nil {
+		return "", err
+	}
+	var replaced []entity.InstallmentPlan
+	for _, plan := range plans {
+		if plan.CourseID != courseID {
+			continue
+		}
+		switch plan.Status {
+		case entity.InstallmentPlanActive, entity.InstallmentPlanOverdue:
+			return "", ErrInstallmentPlanExists
+		case entity.InstallmentPlanPending:
+			replaced = append(replaced, plan)
+		}
+	}
+
+	// курс доступный только по подписке не куплен, его можно взять в рассрочку
+	existing, err := s.courseRepo.GetCourseAssignment(courseID, userID)
+	if err == nil && existing != nil && existing.SubscriptionID == nil {
+		return "", ErrInstallmentCourseOwned
+	}
+
+	// первую часть старой рассрочки так и не оплатили, заменяем ее новой
+	for i := range replaced {
+		if err := s.cancelPendingPlan(&replaced[i]); err != nil {
+			return "", err
+		}
+	}
+
+	course, payment, err := s.payments.quoteCourse(userID, courseID, promoCode)
+	if err != nil {
+		return "", err
+	}
+	amounts := payment.Amount.Split(int(parts))
+	if !amounts[len(amounts)-1].IsPositive() {
+		return "", ErrInvalidInstallments
+	}
+
+	now := time.Now()
+	plan := &entity.InstallmentPlan{
+		InstallmentPlanID: uuid.New(),
+		UserID:            userID,
+		CourseID:          courseID,
+		Amount:            payment.Amount,
+		Parts:             parts,
+		Status:            entity.InstallmentPlanPending,
+		Installments:      make([]entity.Installment, 0, parts),
+	}
+	for i, amount := range amounts {
+		plan.Installments = append(plan.Installments, entity.Installment{
+			InstallmentID:     uuid.New(),
+			InstallmentPlanID: plan.InstallmentPlanID,
+			Number:            uint(i + 1),
+			Amount:            amount,
+			DueAt:             now.AddDate(0, i, 0),
+		})
+	}
+	if err := s.installmentRepo.CreateInstallmentPlan(plan); err != nil {
+		return "", errors.New("could not create installment plan")
+	}
+
+	// промокод привязан к первому платежу, а цена со скидкой уже поделена поровну между частями
+	payment.Amount = amounts[0]
+	return s.payInstallment(plan, &plan.Installments[0], course.Name, payment)
+}
+
+// отменяет неоплаченную рассрочку вместе с выставленным за первую часть платежом
+// у провайдера платеж не отменить, поэтому помечаем его истекшим: если его все же оплатят,
+// выдача увидит отмененную рассрочку и вернет деньги
+func (s *InstallmentService) cancelPendingPlan(plan *entity.InstallmentPlan) error {
+	if err := s.installmentRepo.UpdateInstallmentPlanStatus(plan.InstallmentPlanID, entity.InstallmentPlanCanceled); err != nil {
+		return err
+	}
+
+	for _, installment := range plan.Installments {
+		if installment.PaymentID == nil {
+			continue
+		}
+		payment, err := s.payments.paymentRepo.GetPaymentByID(*installment.PaymentID)
+		if err != nil || payment == nil {
+			continue
+		}
+		if payment.Status != entity.PaymentPending && payment.Status != entity.PaymentProcessing {
+			continue
+		}
+		if err := s.payments.paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentExpired, entity.PaymentSourceCheckout, "installment plan replaced"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// создает платеж за следующую неоплаченную часть рассрочки и возвращает ссылку на оплату
+// оплатить можно и раньше срока
+func (s *InstallmentService) PayNextInstallment(userID, planID uuid.UUID) (string, error) {
+	plan, err := s.installmentRepo.GetInstallmentPlanByID(planID)
+	if err != nil || plan == nil || plan.UserID != userID {
+		return "", ErrInstallmentPlanNotFound
+	}
+	if plan.Status == entity.InstallmentPlanCompleted || plan.Status == entity.InstallmentPlanCanceled {
+		return "", ErrInstallmentPlanClosed
+	}
+
+	installment := nextInstallment(plan)
+	if installment == nil {
+		return "", ErrInstallmentPlanClosed
+	}
+
+	courseID := plan.CourseID
+	payment := &entity.Payment{
+		PaymentID: uuid.New(),
+		UserID:    userID,
+		CourseID:  &courseID,
+		Amount:    installment.Amount,
+		Status:    entity.PaymentPending,
+	}
+	return s.payInstallment(plan, installment, plan.Course.Title, payment)
+}
+
+// отправляет платеж за часть рассрочки провайдеру и запоминает его в графике
+func (s *InstallmentService) payInstallment(plan *entity.InstallmentPlan, installment *entity.Installment, title string, payment *entity.Payment) (string, error) {
+	payment.InstallmentPlanID = &plan.InstallmentPlanID
+	result, err := s.payments.checkout(payment, title, entity.PaymentSourceCheckout, &gateway.CreatePaymentRequest{
+		Description: fmt.Sprintf("Оплата курса частями: %d из %d", installment.Number, plan.Parts),
+		ReturnURL:   s.config.Equiring.ReturnURL,
+		Metadata: map[string]string{
+			"user_id":             plan.UserID.String(),
+			"course_id":           plan.CourseID.String(),
+			"installment_plan_id": plan.InstallmentPlanID.String(),
+			"payment_id":          payment.PaymentID.String(),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	installment.PaymentID = &payment.PaymentID
+	if err := s.installmentRepo.UpdateInstallment(installment); err != nil {
+		// без ссылки платеж все равно засчитается в первую неоплаченную часть
+		fmt.Printf("Error saving installment payment: %v\n", err)
+	}
+	return result.ConfirmationURL, nil
+}
+
+// получает рассрочки пользователя с графиком оплаты
+func (s *InstallmentService) GetUserInstallmentPlans(userID uuid.UUID) ([]dto.InstallmentPlanDto, error) {
+	plans, err := s.installmentRepo.GetInstallmentPlansByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := make([]dto.InstallmentPlanDto, 0, len(plans))
+	for i := range plans {
+		result = append(result, installmentPlanDto(&plans[i], now))
+	}
+	return result, nil
+}
+
+// получает рассрочки в которых есть неоплаченные в срок части
+// сюда попадают и те, по которым еще идет льготный период
+func (s *InstallmentService) GetOverduePlans() ([]dto.InstallmentPlanDto, error) {
+	now := time.Now()
+	plans, err := s.installmentRepo.GetOverdueInstallmentPlans(now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.InstallmentPlanDto, 0, len(plans))
+	for _, plan := range plans {
+		result = append(result, installmentPlanDto(plan, now))
+	}
+	return result, nil
+}
+
+// приостанавливает доступ к курсу по рассрочкам с частью просроченной дольше льготного периода
+// ошибка по одной рассрочке не останавливает обработку остальных
+func (s *InstallmentService) SuspendOverdue() error {
+	plans, err := s.installmentRepo.GetOverdueInstallmentPlans(time.Now().Add(-s.config.Equiring.InstallmentGracePeriod))
+	if err != nil {
+		return err
+	}
+
+	for _, plan := range plans {
+		if plan.Status == entity.InstallmentPlanOverdue {
+			continue
+		}
+		if err := s.installmentRepo.UpdateInstallmentPlanStatus(plan.InstallmentPlanID, entity.InstallmentPlanOverdue); err != nil {
+			fmt.Printf("Error marking installment plan %s overdue: %v\n", plan.InstallmentPlanID, err)
+			continue
+		}
+		if err := setCourseSuspended(s.courseRepo, plan.CourseID, plan.UserID, true); err != nil {
+			fmt.Printf("Error suspending course access for installment plan %s: %v\n", plan.InstallmentPlanID, err)
+		}
+	}
+	return nil
+}
+
+// засчитывает оплаченный платеж в график рассрочки и пересчитывает ее статус
+// пользователь к этому моменту уже записан на курс, доступ приостанавливается если остались части просроченные дольше grace
+// платежи отмененных рассрочек сюда не попадают, их возвращает выдача
+func settleInstallment(installmentRepo repository.InstallmentRepository, courseRepo repository.CourseRepository, plan *entity.InstallmentPlan, payment *entity.Payment, grace time.Duration) error {
+	// ищем часть за которую выставляли платеж, если ее успели перевыставить - засчитываем в первую неоплаченную
+	// часть уже оплаченная этим платежом значит выдача повторяется и засчитывать ничего не нужно
+	var installment *entity.Installment
+	paid := false
+	for i := range plan.Installments {
+		part := &plan.Installments[i]
+		if part.PaymentID != nil && *part.PaymentID == payment.PaymentID {
+			installment = part
+			paid = part.PaidAt != nil
+			break
+		}
+	}
+	if installment == nil {
+		installment = nextInstallment(plan)
+	}
+	if installment == nil {
+		// все части уже оплачены, лишний платеж админ вернет вручную
+		fmt.Printf("Installment plan %s is already paid, payment %s is extra\n", plan.InstallmentPlanID, payment.PaymentID)
+		return nil
+	}
+
+	now := time.Now()
+	if !paid {
+		installment.PaymentID = &payment.PaymentID
+		installment.PaidAt = &now
+		if err := installmentRepo.UpdateInstallment(installment); err != nil {
+			return err
+		}
+	}
+
+	status := entity.InstallmentPlanActive
+	next := nextInstallment(plan)
+	switch {
+	case next == nil:
+		status = entity.InstallmentPlanCompleted
+	case !next.DueAt.Add(grace).After(now):
+		status = entity.InstallmentPlanOverdue
+	}
+	if err := installmentRepo.UpdateInstallmentPlanStatus(plan.InstallmentPlanID, status); err != nil {
+		return err
+	}
+	if status == entity.InstallmentPlanOverdue {
+		return setCourseSuspended(courseRepo, plan.CourseID, plan.UserID, true)
+	}
+	return nil
+}
+
+// первая неоплаченная часть рассрочки или nil если все оплачено
+func nextInstallment(plan *entity.InstallmentPlan) *entity.Installment {
+	for i := range plan.Installments {
+		if plan.Installments[i].PaidAt == nil {
+			return &plan.Installments[i]
+		}
+	}
+	return nil
+}
+
+// приостанавливает или возвращает доступ к курсу, прогресс при этом сохраняется
+func setCourseSuspended(courseRepo repository.CourseRepository, courseID, userID uuid.UUID, suspended bool) error {
+	assignment, err := courseRepo.GetCourseAssignment(courseID, userID)
+	if err != nil || assignment == nil || assignment.Suspended == suspended {
+		return nil
+	}
+	assignment.Suspended = suspended
+	return courseRepo.UpdateCourseAssignment(assignment)
+}
+
+// преобразует рассрочку в формат для response
+func installmentPlanDto(plan *entity.InstallmentPlan, now time.Time) dto.InstallmentPlanDto {
+	paid := money.New(0, plan.Amount.Currency)
+	installments := make([]dto.InstallmentDto, 0, len(plan.Installments))
+	var nextDueAt *time.Time
+	for _, installment := range plan.Installments {
+		if installment.PaidAt != nil {
+			if sum, err := paid.Add(installment.Amount); err == nil {
+				paid = sum
+			}
+		} else if nextDueAt == nil {
+			dueAt := installment.DueAt
+			nextDueAt = &dueAt
+		}
+		installments = append(installments, dto.InstallmentDto{
+			Number:    installment.Number,
+			Amount:    installment.Amount,
+			DueAt:     installment.DueAt,
+			PaidAt:    installment.PaidAt,
+			PaymentID: installment.PaymentID,
+			Overdue:   installment.PaidAt == nil && installment.DueAt.Before(now),
+		})
+	}
+
+	return dto.InstallmentPlanDto{
+		InstallmentPlanID: plan.InstallmentPlanID,
+		UserID:            plan.UserID,
+		CourseID:          plan.CourseID,
+		CourseTitle:       plan.Course.Title,
+		Amount:            plan.Amount,
+		Paid:              paid,
+		Parts:             plan.Parts,
+		Status:            string(plan.Status),
+		NextDueAt:         nextDueAt,
+		Installments:      installments,
+		CreatedAt:         plan.CreatedAt,
+	}
+}
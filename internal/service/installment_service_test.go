@@ -0,0 +1,197 @@
+package service
+
+import (
+	"mzt/internal/entity"
+	"mzt/internal/mocks"
+	"mzt/internal/money"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// поднимает сервис рассрочек поверх тех же моков что и сервис платежей
+func setupInstallmentService(t *testing.T) (*InstallmentService, *mocks.FakeYooKassa, *mocks.MockPaymentRepository, *mocks.MockCourseRepository, *mocks.MockInstallmentRepository) {
+	paymentService, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	paymentService.config.Equiring.InstallmentGracePeriod = time.Hour * 72
+
+	installmentService := NewInstallmentService(paymentService.config, paymentService.installmentRepo, courseRepo, paymentService)
+	return installmentService, fake, paymentRepo, courseRepo, paymentService.installmentRepo.(*mocks.MockInstallmentRepository)
+}
+
+// оплачивает последний выставленный по рассрочке платеж через уведомление
+func payLastInstallment(t *testing.T, service *InstallmentService, fake *mocks.FakeYooKassa, paymentRepo *mocks.MockPaymentRepository, plan *entity.InstallmentPlan) *entity.Payment {
+	for i := len(plan.Installments) - 1; i >= 0; i-- {
+		if plan.Installments[i].PaymentID == nil || plan.Installments[i].PaidAt != nil {
+			continue
+		}
+		payment := paymentRepo.Payments[*plan.Installments[i].PaymentID]
+		fake.SetStatus(payment.PaymentRef, "succeeded")
+		require.NoError(t, service.payments.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+		return payment
+	}
+	t.Fatal("installment payment was not created")
+	return nil
+}
+
+// рассрочка пользователя по курсу
+func findInstallmentPlan(t *testing.T, installmentRepo *mocks.MockInstallmentRepository, userID uuid.UUID) *entity.InstallmentPlan {
+	for _, plan := range installmentRepo.Plans {
+		if plan.UserID == userID && plan.Status != entity.InstallmentPlanCanceled {
+			return plan
+		}
+	}
+	t.Fatal("installment plan was not created")
+	return nil
+}
+
+func TestInstallmentService_CheckoutSplitsPrice(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, installmentRepo := setupInstallmentService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	userID := uuid.New()
+
+	_, err := service.Checkout(userID, courseID, "", 7)
+	assert.ErrorIs(t, err, ErrInvalidInstallments)
+
+	url, err := service.Checkout(userID, courseID, "", 3)
+	require.NoError(t, err)
+	assert.NotEmpty(t, url)
+
+	plan := findInstallmentPlan(t, installmentRepo, userID)
+	assert.Equal(t, entity.InstallmentPlanPending, plan.Status)
+	require.Len(t, plan.Installments, 3)
+	assert.Equal(t, money.New(996667, "RUB"), plan.Installments[0].Amount)
+	assert.Equal(t, money.New(996666, "RUB"), plan.Installments[2].Amount)
+	assert.WithinDuration(t, time.Now().AddDate(0, 2, 0), plan.Installments[2].DueAt, time.Minute)
+
+	// доступ открывается после первой части
+	payment := payLastInstallment(t, service, fake, paymentRepo, plan)
+	assert.Equal(t, plan.InstallmentPlanID, *payment.InstallmentPlanID)
+	assert.Equal(t, money.New(996667, "RUB"), payment.Amount)
+	assert.Equal(t, entity.InstallmentPlanActive, plan.Status)
+	assignment, _ := courseRepo.GetCourseAssignment(courseID, userID)
+	require.NotNil(t, assignment)
+	assert.False(t, assignment.Suspended)
+
+	// вторую рассрочку на тот же курс не оформить
+	_, err = service.Checkout(userID, courseID, "", 2)
+	assert.ErrorIs(t, err, ErrInstallmentPlanExists)
+
+	plans, err := service.GetUserInstallmentPlans(userID)
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	assert.Equal(t, money.New(996667, "RUB"), plans[0].Paid)
+	assert.Equal(t, plan.Installments[1].DueAt, *plans[0].NextDueAt)
+}
+
+func TestInstallmentService_OverdueSuspendsAccess(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, installmentRepo := setupInstallmentService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	userID := uuid.New()
+
+	_, err := service.Checkout(userID, courseID, "", 2)
+	require.NoError(t, err)
+	plan := findInstallmentPlan(t, installmentRepo, userID)
+	payLastInstallment(t, service, fake, paymentRepo, plan)
+
+	// срок второй части прошел, но льготный период еще идет
+	plan.Installments[1].DueAt = time.Now().Add(-time.Hour)
+	require.NoError(t, service.SuspendOverdue())
+	assert.Equal(t, entity.InstallmentPlanActive, plan.Status)
+
+	overdue, err := service.GetOverduePlans()
+	require.NoError(t, err)
+	require.Len(t, overdue, 1)
+	assert.True(t, overdue[0].Installments[1].Overdue)
+
+	// льготный период закончился - доступ приостанавливается
+	plan.Installments[1].DueAt = time.Now().Add(-time.Hour * 73)
+	require.NoError(t, service.SuspendOverdue())
+	assert.Equal(t, entity.InstallmentPlanOverdue, plan.Status)
+	assignment, _ := courseRepo.GetCourseAssignment(courseID, userID)
+	require.NotNil(t, assignment)
+	assert.True(t, assignment.Suspended)
+
+	// оплата последней части возвращает доступ и закрывает рассрочку
+	_, err = service.PayNextInstallment(userID, plan.InstallmentPlanID)
+	require.NoError(t, err)
+	payLastInstallment(t, service, fake, paymentRepo, plan)
+
+	assert.Equal(t, entity.InstallmentPlanCompleted, plan.Status)
+	assignment, _ = courseRepo.GetCourseAssignment(courseID, userID)
+	assert.False(t, assignment.Suspended)
+
+	_, err = service.PayNextInstallment(userID, plan.InstallmentPlanID)
+	assert.ErrorIs(t, err, ErrInstallmentPlanClosed)
+	_, err = service.PayNextInstallment(uuid.New(), plan.InstallmentPlanID)
+	assert.ErrorIs(t, err, ErrInstallmentPlanNotFound)
+}
+
+func TestInstallmentService_RefundCancelsPlan(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, installmentRepo := setupInstallmentService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	userID := uuid.New()
+
+	_, err := service.Checkout(userID, courseID, "", 2)
+	require.NoError(t, err)
+	plan := findInstallmentPlan(t, installmentRepo, userID)
+	payment := payLastInstallment(t, service, fake, paymentRepo, plan)
+
+	_, err = service.payments.RefundPayment(payment.PaymentID, nil, "")
+	require.NoError(t, err)
+
+	assert.Equal(t, entity.InstallmentPlanCanceled, plan.Status)
+	assignment, _ := courseRepo.GetCourseAssignment(courseID, userID)
+	assert.Nil(t, assignment)
+}
+
+func TestInstallmentService_ReplacedPlanPaymentRefunded(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, installmentRepo := setupInstallmentService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	userID := uuid.New()
+
+	_, err := service.Checkout(userID, courseID, "", 2)
+	require.NoError(t, err)
+	old := findInstallmentPlan(t, installmentRepo, userID)
+	oldPayment := paymentRepo.Payments[*old.Installments[0].PaymentID]
+
+	// первую часть не оплатили и оформили рассрочку заново - старый платеж больше не ждем
+	_, err = service.Checkout(userID, courseID, "", 3)
+	require.NoError(t, err)
+	assert.Equal(t, entity.InstallmentPlanCanceled, old.Status)
+	assert.Equal(t, entity.PaymentExpired, oldPayment.Status)
+	plan := findInstallmentPlan(t, installmentRepo, userID)
+	payLastInstallment(t, service, fake, paymentRepo, plan)
+	assert.Equal(t, entity.InstallmentPlanActive, plan.Status)
+
+	// старый платеж все же оплатили - деньги возвращаются, отмененная рассрочка не оживает
+	fake.SetStatus(oldPayment.PaymentRef, "succeeded")
+	require.NoError(t, service.payments.HandleWebhook(webhookBody(t, fake.Webhook(oldPayment.PaymentRef))))
+	assert.Equal(t, entity.PaymentRefunded, oldPayment.Status)
+	assert.Equal(t, entity.InstallmentPlanCanceled, old.Status)
+	assert.Nil(t, old.Installments[0].PaidAt)
+
+	// доступ по новой рассрочке остается
+	assert.Equal(t, entity.InstallmentPlanActive, plan.Status)
+	assignment, _ := courseRepo.GetCourseAssignment(courseID, userID)
+	require.NotNil(t, assignment)
+	assert.False(t, assignment.Suspended)
+
+	// повторное уведомление второй возврат не создает
+	require.NoError(t, service.payments.HandleWebhook(webhookBody(t, fake.Webhook(oldPayment.PaymentRef))))
+	refunds, err := paymentRepo.GetRefundsByPaymentID(oldPayment.PaymentID)
+	require.NoError(t, err)
+	assert.Len(t, refunds, 1)
+}
+
+func TestInstallmentService_CheckoutOwnedCourse(t *testing.T) {
+	service, _, paymentRepo, courseRepo, _ := setupInstallmentService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	userID := uuid.New()
+	require.NoError(t, service.payments.enrollUser(courseID, userID))
+
+	_, err := service.Checkout(userID, courseID, "", 2)
+	assert.ErrorIs(t, err, ErrInstallmentCourseOwned)
+}
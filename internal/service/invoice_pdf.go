@@ -0,0 +1,118 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"mzt/config"
+	"mzt/internal/entity"
+	"os"
+	"strconv"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// ширина области печати a4 при полях по 15мм
+const invoicePageWidth = 180
+
+// рендерит счет на оплату в pdf
+// шрифт берется из настроек, встроенные шрифты pdf не умеют кириллицу
+func renderInvoicePDF(cfg *config.Invoice, invoice *entity.Invoice) ([]byte, error) {
+	font, err := os.ReadFile(cfg.FontPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not load invoice font: %w", err)
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AddUTF8FontFromBytes("invoice", "", font)
+	pdf.AddPage()
+	pdf.SetFont("invoice", "", 9)
+
+	// банковские реквизиты получателя в виде как на платежном поручении
+	pdf.CellFormat(110, 7, "Банк получателя: "+cfg.BankName, "LTR", 0, "L", false, 0, "")
+	pdf.CellFormat(20, 7, "БИК", "1", 0, "L", false, 0, "")
+	pdf.CellFormat(50, 7, cfg.BIK, "1", 1, "L", false, 0, "")
+	pdf.CellFormat(110, 7, "", "LBR", 0, "L", false, 0, "")
+	pdf.CellFormat(20, 7, "Сч. №", "1", 0, "L", false, 0, "")
+	pdf.CellFormat(50, 7, cfg.CorrAccount, "1", 1, "L", false, 0, "")
+	pdf.CellFormat(55, 7, "ИНН "+cfg.SellerINN, "1", 0, "L", false, 0, "")
+	pdf.CellFormat(55, 7, "КПП "+cfg.SellerKPP, "1", 0, "L", false, 0, "")
+	pdf.CellFormat(20, 7, "Сч. №", "LTR", 0, "L", false, 0, "")
+	pdf.CellFormat(50, 7, cfg.BankAccount, "LTR", 1, "L", false, 0, "")
+	pdf.CellFormat(110, 7, "Получатель: "+cfg.SellerName, "1", 0, "L", false, 0, "")
+	pdf.CellFormat(20, 7, "", "LBR", 0, "L", false, 0, "")
+	pdf.CellFormat(50, 7, "", "LBR", 1, "L", false, 0, "")
+	pdf.Ln(8)
+
+	pdf.SetFont("invoice", "", 14)
+	title := fmt.Sprintf("Счет на оплату № %d от %s", invoice.Number, invoice.CreatedAt.Format("02.01.2006"))
+	pdf.CellFormat(invoicePageWidth, 8, title, "B", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	pdf.SetFont("invoice", "", 9)
+	invoiceParty(pdf, "Поставщик:", cfg.SellerName, cfg.SellerINN, cfg.SellerKPP, cfg.SellerAddress)
+	invoiceParty(pdf, "Покупатель:", invoice.CompanyName, invoice.INN, invoice.KPP, invoice.LegalAddress)
+	pdf.Ln(4)
+
+	// одна позиция: доступ к курсу на количество мест
+	total := invoice.UnitPrice.Mul(int64(invoice.Seats))
+	widths := []float64{10, 90, 20, 15, 22.5, 22.5}
+	for i, header := range []string{"№", "Товары (работы, услуги)", "Кол-во", "Ед.", "Цена", "Сумма"} {
+		pdf.CellFormat(widths[i], 7, header, "1", 0, "C", false, 0, "")
+	}
+	pdf.Ln(-1)
+
+	name := fmt.Sprintf("Доступ к онлайн-курсу «%s»", invoice.Course.Title)
+	height := float64(len(pdf.SplitText(name, widths[1]-2))) * 6
+	x, y := pdf.GetXY()
+	pdf.CellFormat(widths[0], height, "1", "1", 0, "C", false, 0, "")
+	pdf.MultiCell(widths[1], 6, name, "1", "L", false)
+	pdf.SetXY(x+widths[0]+widths[1], y)
+	pdf.CellFormat(widths[2], height, strconv.FormatUint(uint64(invoice.Seats), 10), "1", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[3], height, "шт", "1", 0, "C", false, 0, "")
+	pdf.CellFormat(widths[4], height, invoice.UnitPrice.String(), "1", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[5], height, total.String(), "1", 1, "R", false, 0, "")
+	pdf.Ln(2)
+
+	pdf.CellFormat(invoicePageWidth-widths[5], 6, "Итого:", "", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[5], 6, total.String(), "", 1, "R", false, 0, "")
+	pdf.CellFormat(invoicePageWidth-widths[5], 6, cfg.VatNote+":", "", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[5], 6, "-", "", 1, "R", false, 0, "")
+	pdf.CellFormat(invoicePageWidth-widths[5], 6, "Всего к оплате:", "", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[5], 6, total.String(), "", 1, "R", false, 0, "")
+	pdf.Ln(4)
+
+	pdf.MultiCell(invoicePageWidth, 5, fmt.Sprintf("Всего наименований 1, на сумму %s %s", total.String(), total.Currency), "", "L", false)
+	pdf.MultiCell(invoicePageWidth, 5, fmt.Sprintf(
+		"Оплатить не позднее %s. В назначении платежа укажите «Оплата по счету № %d». "+
+			"После поступления денег сотрудники будут записаны на курс.",
+		invoice.DueAt.Format("02.01.2006"), invoice.Number), "", "L", false)
+	pdf.Ln(12)
+
+	pdf.CellFormat(90, 6, "Руководитель ____________________", "", 0, "L", false, 0, "")
+	pdf.CellFormat(90, 6, "Бухгалтер ____________________", "", 1, "L", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// печатает строку со стороной сделки: название, инн, кпп и адрес
+func invoiceParty(pdf *fpdf.Fpdf, label, name, inn, kpp, address string) {
+	details := name + ", ИНН " + inn
+	if kpp != "" {
+		details += ", КПП " + kpp
+	}
+	if address != "" {
+		details += ", " + address
+	}
+
+	x, y := pdf.GetXY()
+	pdf.CellFormat(25, 5, label, "", 0, "L", false, 0, "")
+	pdf.SetXY(x+25, y)
+	pdf.MultiCell(invoicePageWidth-25, 5, details, "", "L", false)
+	pdf.Ln(1)
+}
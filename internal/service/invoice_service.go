@@ -0,0 +1,314 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/repository"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// сколько мест можно купить по одному счету
+const MaxInvoiceSeats = 1000
+
+var (
+	// счет не найден или принадлежит другому пользователю
+	ErrInvoiceNotFound = errors.New("invoice not found")
+	// некорректные реквизиты, количество мест или список сотрудников
+	ErrInvalidInvoice = errors.New("invalid invoice")
+	// счет уже оплачен или отменен
+	ErrInvoiceClosed = errors.New("invoice is already paid or canceled")
+)
+
+// сервис для работы со счетами юрлицам
+// компания оплачивает курс банковским переводом по счету, а админ после поступления денег записывает сотрудников
+type InvoiceService struct {
+	config      *config.Config
+	invoiceRepo repository.InvoiceRepository
+	courseRepo  repository.CourseRepository
+	paymentRepo repository.PaymentRepository
+	userRepo    repository.UserRepository
+}
+
+// создаем новый сервис для работы со счетами
+func NewInvoiceService(cfg *config.Config, invoiceRepo repository.InvoiceRepository, courseRepo repository.CourseRepository, paymentRepo repository.PaymentRepository, userRepo repository.UserRepository) *InvoiceService {
+	return &InvoiceService{
+		config:      cfg,
+		invoiceRepo: invoiceRepo,
+		courseRepo:  courseRepo,
+		paymentRepo: paymentRepo,
+		userRepo:    userRepo,
+	}
+}
+
+// выставляет счет на курс за seats мест по реквизитам компании
+// платеж создается сразу в статусе awaiting_transfer, провайдер в оплате не участвует
+func (s *InvoiceService) CreateInvoice(userID, courseID uuid.UUID, payload *dto.CreateInvoiceDto) (*dto.InvoiceDto, error) {
+	invoice := &entity.Invoice{
+		InvoiceID:    uuid.New(),
+		UserID:       userID,
+		CourseID:     courseID,
+		Seats:        payload.Seats,
+		CompanyName:  strings.TrimSpace(payload.CompanyName),
+		INN:          strings.TrimSpace(payload.INN),
+		KPP:          strings.TrimSpace(payload.KPP),
+		LegalAddress: strings.TrimSpace(payload.LegalAddress),
+		BankName:     strings.TrimSpace(payload.BankName),
+		BIK:          strings.TrimSpace(payload.BIK),
+		BankAccount:  strings.TrimSpace(payload.BankAccount),
+		CorrAccount:  strings.TrimSpace(payload.CorrAccount),
+		DueAt:        time.Now().Add(s.config.Invoice.DueIn),
+	}
+	if err := validateInvoice(invoice); err != nil {
+		return nil, err
+	}
+
+	course, err := s.courseRepo.GetCourse(courseID)
+	if err != nil || course == nil {
+		return nil, fmt.Errorf("%w: course %s not found", ErrInvalidInvoice, courseID)
+	}
+	coursePrice, err := s.paymentRepo.GetCoursePrice(courseID)
+	if err != nil {
+		return nil, errors.New("could not get course price")
+	}
+	if !coursePrice.Amount.Valid() || !coursePrice.Amount.IsPositive() {
+		return nil, ErrInvalidPrice
+	}
+	invoice.UnitPrice = coursePrice.Amount
+
+	payment := &entity.Payment{
+		PaymentID: uuid.New(),
+		UserID:    userID,
+		CourseID:  &courseID,
+		Amount:    coursePrice.Amount.Mul(int64(invoice.Seats)),
+		Status:    entity.PaymentPending,
+	}
+	if err := s.paymentRepo.CreatePayment(payment); err != nil {
+		return nil, errors.New("could not create payment record")
+	}
+	if err := s.paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentAwaitingTransfer, entity.PaymentSourceCheckout, ""); err != nil {
+		return nil, err
+	}
+
+	invoice.PaymentID = payment.PaymentID
+	if err := s.invoiceRepo.CreateInvoice(invoice); err != nil {
+		return nil, err
+	}
+	invoice.Payment = *payment
+	invoice.Payment.Status = entity.PaymentAwaitingTransfer
+	invoice.Course.Title = course.Name
+
+	response := invoiceDto(invoice)
+	return &response, nil
+}
+
+// получает счета которые оформил пользователь
+func (s *InvoiceService) GetUserInvoices(userID uuid.UUID) ([]dto.InvoiceDto, error) {
+	invoices, err := s.invoiceRepo.GetInvoicesByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return invoiceDtos(invoices), nil
+}
+
+// получает все счета, если передан status - только с платежом в этом статусе
+func (s *InvoiceService) GetInvoices(status string) ([]dto.InvoiceDto, error) {
+	var filter *entity.PaymentStatus
+	if status != "" {
+		paymentStatus := entity.PaymentStatus(status)
+		filter = &paymentStatus
+	}
+
+	invoices, err := s.invoiceRepo.GetInvoices(filter)
+	if err != nil {
+		return nil, err
+	}
+	return invoiceDtos(invoices), nil
+}
+
+// рендерит pdf счета и возвращает его номер для имени файла
+// если передан userID - счет отдается только тому кто его оформил
+func (s *InvoiceService) InvoicePDF(invoiceID uuid.UUID, userID *uuid.UUID) (uint, []byte, error) {
+	invoice, err := s.invoiceRepo.GetInvoiceByID(invoiceID)
+	if err != nil || invoice == nil {
+		return 0, nil, ErrInvoiceNotFound
+	}
+	if userID != nil && invoice.UserID != *userID {
+		return 0, nil, ErrInvoiceNotFound
+	}
+
+	data, err := renderInvoicePDF(&s.config.Invoice, invoice)
+	if err != nil {
+		return 0, nil, err
+	}
+	return invoice.Number, data, nil
+}
+
+// отмечает счет оплаченным после поступления перевода и записывает сотрудников на курс
+// сотрудников не больше чем мест в счете, все записываются в одной транзакции вместе со сменой статуса платежа
+func (s *InvoiceService) MarkPaid(invoiceID uuid.UUID, userIDs []uuid.UUID) (*dto.InvoiceDto, error) {
+	invoice, err := s.invoiceRepo.GetInvoiceByID(invoiceID)
+	if err != nil || invoice == nil {
+		return nil, ErrInvoiceNotFound
+	}
+	if invoice.Payment.Status != entity.PaymentAwaitingTransfer {
+		return nil, ErrInvoiceClosed
+	}
+
+	employees := make([]uuid.UUID, 0, len(userIDs))
+	seen := make(map[uuid.UUID]bool)
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		employees = append(employees, userID)
+	}
+	if len(employees) == 0 || len(employees) > int(invoice.Seats) {
+		return nil, fmt.Errorf("%w: from 1 to %d employees can be enrolled", ErrInvalidInvoice, invoice.Seats)
+	}
+	for _, userID := range employees {
+		if user, err := s.userRepo.GetUserById(userID); err != nil || user == nil {
+			return nil, fmt.Errorf("%w: user %s not found", ErrInvalidInvoice, userID)
+		}
+	}
+
+	err = s.invoiceRepo.MarkInvoicePaid(invoiceID, employees, time.Now())
+	if errors.Is(err, repository.ErrIllegalTransition) {
+		return nil, ErrInvoiceClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	invoice, err = s.invoiceRepo.GetInvoiceByID(invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	response := invoiceDto(invoice)
+	return &response, nil
+}
+
+// отменяет неоплаченный счет, например если компания передумала
+func (s *InvoiceService) CancelInvoice(invoiceID uuid.UUID) error {
+	invoice, err := s.invoiceRepo.GetInvoiceByID(invoiceID)
+	if err != nil || invoice == nil {
+		return ErrInvoiceNotFound
+	}
+
+	err = s.paymentRepo.TransitionPaymentStatus(invoice.PaymentID, entity.PaymentCanceled, entity.PaymentSourceAdmin, "")
+	if errors.Is(err, repository.ErrIllegalTransition) {
+		return ErrInvoiceClosed
+	}
+	return err
+}
+
+// проверяет количество мест и реквизиты покупателя
+// у организации инн из 10 цифр и обязателен кпп, у ИП инн из 12 цифр
+func validateInvoice(invoice *entity.Invoice) error {
+	if invoice.Seats == 0 || invoice.Seats > MaxInvoiceSeats {
+		return fmt.Errorf("%w: seats must be between 1 and %d", ErrInvalidInvoice, MaxInvoiceSeats)
+	}
+	if invoice.CompanyName == "" || invoice.LegalAddress == "" {
+		return fmt.Errorf("%w: company name and legal address are required", ErrInvalidInvoice)
+	}
+	if !validINN(invoice.INN) {
+		return fmt.Errorf("%w: inn is not valid", ErrInvalidInvoice)
+	}
+	if (len(invoice.INN) == 10 || invoice.KPP != "") && !digitsOnly(invoice.KPP, 9) {
+		return fmt.Errorf("%w: kpp must be 9 digits", ErrInvalidInvoice)
+	}
+	if invoice.BIK != "" && !digitsOnly(invoice.BIK, 9) {
+		return fmt.Errorf("%w: bik must be 9 digits", ErrInvalidInvoice)
+	}
+	if invoice.BankAccount != "" && !digitsOnly(invoice.BankAccount, 20) {
+		return fmt.Errorf("%w: bank account must be 20 digits", ErrInvalidInvoice)
+	}
+	if invoice.CorrAccount != "" && !digitsOnly(invoice.CorrAccount, 20) {
+		return fmt.Errorf("%w: correspondent account must be 20 digits", ErrInvalidInvoice)
+	}
+	return nil
+}
+
+// проверяет длину и контрольные цифры инн
+func validINN(inn string) bool {
+	checksum := func(digits string, weights []int) byte {
+		sum := 0
+		for i, w := range weights {
+			sum += int(digits[i]-'0') * w
+		}
+		return byte(sum%11%10) + '0'
+	}
+
+	switch {
+	case digitsOnly(inn, 10):
+		return inn[9] == checksum(inn, []int{2, 4, 10, 3, 5, 9, 4, 6, 8})
+	case digitsOnly(inn, 12):
+		return inn[10] == checksum(inn, []int{7, 2, 4, 10, 3, 5, 9, 4, 6, 8}) &&
+			inn[11] == checksum(inn, []int{3, 7, 2, 4, 10, 3, 5, 9, 4, 6, 8})
+	default:
+		return false
+	}
+}
+
+func digitsOnly(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func invoiceDtos(invoices []entity.Invoice) []dto.InvoiceDto {
+	result := make([]dto.InvoiceDto, 0, len(invoices))
+	for i := range invoices {
+		result = append(result, invoiceDto(&invoices[i]))
+	}
+	return result
+}
+
+// преобразует счет в формат для response
+func invoiceDto(invoice *entity.Invoice) dto.InvoiceDto {
+	employees := make([]dto.InvoiceEmployeeDto, 0, len(invoice.Employees))
+	for _, employee := range invoice.Employees {
+		item := dto.InvoiceEmployeeDto{UserID: employee.UserID}
+		if employee.User.UserData != nil {
+			item.Email = employee.User.UserData.Email
+		}
+		employees = append(employees, item)
+	}
+
+	return dto.InvoiceDto{
+		InvoiceID:    invoice.InvoiceID,
+		Number:       invoice.Number,
+		PaymentID:    invoice.PaymentID,
+		UserID:       invoice.UserID,
+		CourseID:     invoice.CourseID,
+		CourseTitle:  invoice.Course.Title,
+		Seats:        invoice.Seats,
+		UnitPrice:    invoice.UnitPrice,
+		Amount:       invoice.Payment.Amount,
+		Status:       string(invoice.Payment.Status),
+		CompanyName:  invoice.CompanyName,
+		INN:          invoice.INN,
+		KPP:          invoice.KPP,
+		LegalAddress: invoice.LegalAddress,
+		BankName:     invoice.BankName,
+		BIK:          invoice.BIK,
+		BankAccount:  invoice.BankAccount,
+		CorrAccount:  invoice.CorrAccount,
+		DueAt:        invoice.DueAt,
+		PaidAt:       invoice.PaidAt,
+		Employees:    employees,
+		CreatedAt:    invoice.CreatedAt,
+	}
+}
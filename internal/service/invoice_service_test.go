@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bytes"
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/mocks"
+	"mzt/internal/money"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// поднимает сервис счетов на моках, провайдер для оплаты переводом не нужен
+func setupInvoiceService(t *testing.T) (*InvoiceService, *mocks.MockPaymentRepository, *mocks.MockCourseRepository, *mocks.MockUserRepository) {
+	cfg := &config.Config{
+		Invoice: config.Invoice{
+			SellerName:  "ООО Мозг и Тело",
+			SellerINN:   "7707083893",
+			SellerKPP:   "773601001",
+			BankName:    "ПАО Сбербанк",
+			BIK:         "044525225",
+			BankAccount: "40702810938000000001",
+			CorrAccount: "30101810400000000225",
+			VatNote:     "Без НДС",
+			DueIn:       time.Hour * 24 * 5,
+			FontPath:    "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf",
+		},
+	}
+	courseRepo := mocks.NewMockCourseRepository()
+	paymentRepo := mocks.NewMockPaymentRepository()
+	userRepo := mocks.NewMockUserRepository()
+	service := NewInvoiceService(cfg, mocks.NewMockInvoiceRepository(paymentRepo, courseRepo), courseRepo, paymentRepo, userRepo)
+
+	return service, paymentRepo.(*mocks.MockPaymentRepository), courseRepo.(*mocks.MockCourseRepository), userRepo.(*mocks.MockUserRepository)
+}
+
+// реквизиты компании с корректными контрольными цифрами инн
+func testInvoicePayload(seats uint) *dto.CreateInvoiceDto {
+	return &dto.CreateInvoiceDto{
+		Seats:        seats,
+		CompanyName:  "ООО Ромашка",
+		INN:          "7707083893",
+		KPP:          "773601001",
+		LegalAddress: "г. Москва, ул. Ленина, д. 1",
+		BIK:          "044525225",
+		BankAccount:  "40702810938000000002",
+		CorrAccount:  "30101810400000000225",
+	}
+}
+
+func TestInvoiceService_MarkPaidEnrollsEmployees(t *testing.T) {
+	service, paymentRepo, courseRepo, userRepo := setupInvoiceService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	buyerID := uuid.New()
+	employees := []uuid.UUID{uuid.New(), uuid.New()}
+	for _, id := range employees {
+		userRepo.Users[id] = &entity.User{ID: id}
+	}
+
+	invoice, err := service.CreateInvoice(buyerID, courseID, testInvoicePayload(3))
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), invoice.Number)
+	assert.Equal(t, string(entity.PaymentAwaitingTransfer), invoice.Status)
+	assert.Equal(t, money.New(2990000*3, "RUB"), invoice.Amount)
+	assert.Equal(t, money.New(2990000, "RUB"), invoice.UnitPrice)
+	events := paymentRepo.Events[invoice.PaymentID]
+	assert.Equal(t, entity.PaymentAwaitingTransfer, events[len(events)-1].ToStatus)
+
+	// сверка с провайдером такие платежи не трогает
+	payment := paymentRepo.Payments[invoice.PaymentID]
+	assert.Empty(t, payment.PaymentRef)
+
+	// неизвестного пользователя записать нельзя
+	_, err = service.MarkPaid(invoice.InvoiceID, []uuid.UUID{uuid.New()})
+	assert.ErrorIs(t, err, ErrInvalidInvoice)
+	assert.Equal(t, entity.PaymentAwaitingTransfer, payment.Status)
+
+	paid, err := service.MarkPaid(invoice.InvoiceID, append(employees, employees[0]))
+	require.NoError(t, err)
+	assert.Equal(t, string(entity.PaymentSucceeded), paid.Status)
+	assert.NotNil(t, paid.PaidAt)
+	// сотрудников записали вместе с отметкой, сверке доделывать нечего
+	assert.NotNil(t, payment.FulfilledAt)
+	assert.Len(t, paid.Employees, 2)
+	for _, id := range employees {
+		assignment, _ := courseRepo.GetCourseAssignment(courseID, id)
+		assert.NotNil(t, assignment)
+	}
+	// покупатель сам на курс не записывается
+	assignment, _ := courseRepo.GetCourseAssignment(courseID, buyerID)
+	assert.Nil(t, assignment)
+
+	// повторно отметить оплату нельзя
+	_, err = service.MarkPaid(invoice.InvoiceID, employees)
+	assert.ErrorIs(t, err, ErrInvoiceClosed)
+
+	invoices, err := service.GetUserInvoices(buyerID)
+	require.NoError(t, err)
+	require.Len(t, invoices, 1)
+	assert.Equal(t, invoice.InvoiceID, invoices[0].InvoiceID)
+}
+
+func TestInvoiceService_SeatsLimit(t *testing.T) {
+	service, paymentRepo, courseRepo, userRepo := setupInvoiceService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	employees := []uuid.UUID{uuid.New(), uuid.New()}
+	for _, id := range employees {
+		userRepo.Users[id] = &entity.User{ID: id}
+	}
+
+	invoice, err := service.CreateInvoice(uuid.New(), courseID, testInvoicePayload(1))
+	require.NoError(t, err)
+
+	_, err = service.MarkPaid(invoice.InvoiceID, employees)
+	assert.ErrorIs(t, err, ErrInvalidInvoice)
+	_, err = service.MarkPaid(invoice.InvoiceID, nil)
+	assert.ErrorIs(t, err, ErrInvalidInvoice)
+
+	// отмененный счет оплатить уже нельзя
+	require.NoError(t, service.CancelInvoice(invoice.InvoiceID))
+	_, err = service.MarkPaid(invoice.InvoiceID, employees[:1])
+	assert.ErrorIs(t, err, ErrInvoiceClosed)
+	assert.ErrorIs(t, service.CancelInvoice(invoice.InvoiceID), ErrInvoiceClosed)
+
+	pending := string(entity.PaymentAwaitingTransfer)
+	invoices, err := service.GetInvoices(pending)
+	require.NoError(t, err)
+	assert.Empty(t, invoices)
+}
+
+func TestInvoiceService_ValidateDetails(t *testing.T) {
+	service, paymentRepo, courseRepo, _ := setupInvoiceService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+
+	cases := map[string]func(*dto.CreateInvoiceDto){
+		"bad inn checksum": func(p *dto.CreateInvoiceDto) { p.INN = "7707083894" },
+		"inn length":       func(p *dto.CreateInvoiceDto) { p.INN = "77070838" },
+		"missing kpp":      func(p *dto.CreateInvoiceDto) { p.KPP = "" },
+		"bad bik":          func(p *dto.CreateInvoiceDto) { p.BIK = "04452522" },
+		"bad account":      func(p *dto.CreateInvoiceDto) { p.BankAccount = "4070281093800000000A" },
+		"too many seats":   func(p *dto.CreateInvoiceDto) { p.Seats = MaxInvoiceSeats + 1 },
+	}
+	for name, mutate := range cases {
+		t.Run(name, func(t *testing.T) {
+			payload := testInvoicePayload(2)
+			mutate(payload)
+			_, err := service.CreateInvoice(uuid.New(), courseID, payload)
+			assert.ErrorIs(t, err, ErrInvalidInvoice)
+		})
+	}
+
+	// у ИП инн из 12 цифр и нет кпп
+	payload := testInvoicePayload(1)
+	payload.INN = "500100732259"
+	payload.KPP = ""
+	_, err := service.CreateInvoice(uuid.New(), courseID, payload)
+	assert.NoError(t, err)
+
+	_, err = service.CreateInvoice(uuid.New(), uuid.New(), testInvoicePayload(1))
+	assert.ErrorIs(t, err, ErrInvalidInvoice)
+}
+
+func TestInvoiceService_PDF(t *testing.T) {
+	service, paymentRepo, courseRepo, _ := setupInvoiceService(t)
+	if _, err := os.Stat(service.config.Invoice.FontPath); err != nil {
+		t.Skip("invoice font is not installed")
+	}
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	buyerID := uuid.New()
+
+	invoice, err := service.CreateInvoice(buyerID, courseID, testInvoicePayload(5))
+	require.NoError(t, err)
+
+	number, data, err := service.InvoicePDF(invoice.InvoiceID, &buyerID)
+	require.NoError(t, err)
+	assert.Equal(t, invoice.Number, number)
+	assert.True(t, bytes.HasPrefix(data, []byte("%PDF")))
+
+	// чужой счет не отдаем
+	other := uuid.New()
+	_, _, err = service.InvoicePDF(invoice.InvoiceID, &other)
+	assert.ErrorIs(t, err, ErrInvoiceNotFound)
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// StartPaymentReconciler запускает сверку зависших платежей в фоне
+// сверка выполняется сразу и дальше раз в ReconcileInterval, остановить можно закрыв stop
+func StartPaymentReconciler(paymentService *PaymentService, stop <-chan struct{}) {
+	interval := paymentService.config.Equiring.ReconcileInterval
+	if interval <= 0 {
+		interval = time.Minute * 5
+	}
+
+	runEvery(interval, stop, func() {
+		if err := paymentService.ReconcilePayments(); err != nil {
+			fmt.Printf("Error reconciling payments: %v\n", err)
+		}
+	})
+}
@@ -2,40 +2,72 @@
 package service
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"mzt/config"
 	"mzt/internal/dto"
 	"mzt/internal/entity"
+	"mzt/internal/gateway"
+	"mzt/internal/money"
 	"mzt/internal/repository"
-	"net/http"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	// тело уведомления не удалось разобрать
+	ErrInvalidWebhook = errors.New("invalid webhook")
+	// платеж из уведомления не найден у нас или у провайдера
+	ErrPaymentNotFound = errors.New("payment not found")
+	// данные провайдера не совпадают с нашей записью о платеже
+	ErrPaymentMismatch = errors.New("payment does not match provider data")
+	// платеж еще не оплачен или уже возвращен полностью
+	ErrPaymentNotRefundable = errors.New("payment can not be refunded")
+	// сумма возврата меньше нуля или больше того что осталось вернуть
+	ErrInvalidRefundAmount = errors.New("invalid refund amount")
+	// цена отрицательная, нулевая или в неизвестной валюте
+	ErrInvalidPrice = errors.New("invalid price")
+	// для чека нужна почта или телефон покупателя
+	ErrReceiptContact = errors.New("email or phone required for receipt")
+)
+
 // сервис для работы с платежами
-// отвечает за создание платежей и работу с YooKassa
+// отвечает за создание платежей и обработку уведомлений платежного провайдера
 type PaymentService struct {
-	config      *config.Config
-	courseRepo  repository.CourseRepository
-	paymentRepo repository.PaymentRepository
+	config           *config.Config
+	courseRepo       repository.CourseRepository
+	paymentRepo      repository.PaymentRepository
+	promoRepo        repository.PromoCodeRepository
+	userRepo         repository.UserRepository
+	subscriptionRepo repository.SubscriptionRepository
+	installmentRepo  repository.InstallmentRepository
+	bundleRepo       repository.BundleRepository
+	giftRepo         repository.GiftCodeRepository
+	gateway          gateway.PaymentGateway
 }
 
 // создаем новый сервис для работы с платежами
-func NewPaymentService(cfg *config.Config, courseRepo repository.CourseRepository, paymentRepo repository.PaymentRepository) *PaymentService {
+func NewPaymentService(cfg *config.Config, courseRepo repository.CourseRepository, paymentRepo repository.PaymentRepository, promoRepo repository.PromoCodeRepository, userRepo repository.UserRepository, subscriptionRepo repository.SubscriptionRepository, installmentRepo repository.InstallmentRepository, bundleRepo repository.BundleRepository, giftRepo repository.GiftCodeRepository, gw gateway.PaymentGateway) *PaymentService {
 	return &PaymentService{
-		config:      cfg,
-		courseRepo:  courseRepo,
-		paymentRepo: paymentRepo,
+		config:           cfg,
+		courseRepo:       courseRepo,
+		paymentRepo:      paymentRepo,
+		promoRepo:        promoRepo,
+		userRepo:         userRepo,
+		subscriptionRepo: subscriptionRepo,
+		installmentRepo:  installmentRepo,
+		bundleRepo:       bundleRepo,
+		giftRepo:         giftRepo,
+		gateway:          gw,
 	}
 }
 
-// создает платеж в YooKassa
-// создает запись о платеже в базе и отправляет запрос в YooKassa
-func (s *PaymentService) CreateYooKassaPayment(userID string, courseID string, amount string) (string, error) {
+// создает платеж за курс у провайдера
+// создает запись о платеже в базе и возвращает ссылку на оплату
+// если передан промокод - сумма платежа считается со скидкой
+func (s *PaymentService) CreatePayment(userID string, courseID string, promoCode string) (string, error) {
 	// проверяем что id курса валидный
 	courseUUID, err := uuid.Parse(courseID)
 	if err != nil {
@@ -48,101 +80,654 @@ func (s *PaymentService) CreateYooKassaPayment(userID string, courseID string, a
 		return "", errors.New("invalid user ID")
 	}
 
-	// получаем цену курса из базы
-	coursePrice, err := s.paymentRepo.GetCoursePrice(courseUUID)
+	course, payment, err := s.quoteCourse(userUUID, courseUUID, promoCode)
 	if err != nil {
-		return "", errors.New("could not get course price")
+		return "", err
 	}
 
-	// форматируем цену для YooKassa
-	priceStr := fmt.Sprintf("%.2f", coursePrice.Amount)
+	// метаданные вернутся в уведомлении
+	result, err := s.checkout(payment, course.Name, entity.PaymentSourceCheckout, &gateway.CreatePaymentRequest{
+		Description: "Покупка курса",
+		ReturnURL:   s.config.Equiring.ReturnURL,
+		Metadata: map[string]string{
+			"user_id":    userID,
+			"course_id":  courseID,
+			"payment_id": payment.PaymentID.String(),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// возвращаем ссылку для оплаты
+	return result.ConfirmationURL, nil
+}
+
+// считает сколько пользователь заплатит за курс с учетом промокода
+// возвращает курс и еще не сохраненный платеж на эту сумму
+func (s *PaymentService) quoteCourse(userID, courseID uuid.UUID, promoCode string) (*dto.CourseDto, *entity.Payment, error) {
+	// название курса попадет в чек
+	course, err := s.courseRepo.GetCourse(courseID)
+	if err != nil || course == nil {
+		return nil, nil, errors.New("course not found")
+	}
+
+	// получаем цену курса из базы
+	coursePrice, err := s.paymentRepo.GetCoursePrice(courseID)
+	if err != nil {
+		return nil, nil, errors.New("could not get course price")
+	}
 
-	// создаем запись о платеже в базе
 	payment := &entity.Payment{
-		PaymentID:    uuid.New(),
-		UserID:       userUUID,
-		CourseID:     courseUUID,
-		Amount:       coursePrice.Amount,
-		CurrencyCode: coursePrice.CurrencyCode,
-		Status:       "pending",
+		PaymentID: uuid.New(),
+		UserID:    userID,
+		CourseID:  &courseID,
+		Amount:    coursePrice.Amount,
+		Status:    entity.PaymentPending,
+	}
+
+	// применяем промокод, использование засчитается после оплаты
+	if promoCode != "" {
+		promo, discount, err := applyPromoCode(s.promoRepo, promoCode, userID, courseID, coursePrice.Amount)
+		if err != nil {
+			return nil, nil, err
+		}
+		payment.PromoCodeID = &promo.PromoCodeID
+		payment.PromoCode = promo
+		payment.Discount = discount
+		payment.Amount, err = coursePrice.Amount.Sub(discount)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return course, payment, nil
+}
+
+// отправляет новый платеж провайдеру
+// собирает чек, сохраняет платеж и ссылку на него у провайдера и переводит платеж в processing
+// title - название позиции в чеке
+func (s *PaymentService) checkout(payment *entity.Payment, title string, source entity.PaymentEventSource, req *gateway.CreatePaymentRequest) (*gateway.Payment, error) {
+	// чек собираем до записи платежа: без контакта покупателя провайдер платеж не примет
+	if s.config.Equiring.SendReceipts {
+		receipt, err := s.buildReceipt(payment.UserID, title, payment.Amount)
+		if err != nil {
+			return nil, err
+		}
+		req.Receipt = receipt
 	}
 
 	// сохраняем платеж в базе
-	err = s.paymentRepo.CreatePayment(payment)
+	if err := s.paymentRepo.CreatePayment(payment); err != nil {
+		return nil, errors.New("could not create payment record")
+	}
+
+	// id платежа - ключ идемпотентности у провайдера
+	req.PaymentID = payment.PaymentID
+	req.Amount = payment.Amount
+	result, err := s.gateway.CreatePayment(req)
 	if err != nil {
-		return "", errors.New("could not create payment record")
+		return nil, err
 	}
+	s.syncReceiptStatus(payment, result)
 
-	// формируем запрос для YooKassa
-	reqData := &dto.PaymentRequest{}
-	reqData.Amount.Value = priceStr
-	reqData.Amount.Currency = coursePrice.CurrencyCode
-	reqData.Capture = true
-	reqData.Description = "Покупка курса"
+	// сохраняем ссылку на платеж у провайдера, без нее не получится проверить вебхук
+	payment.PaymentRef = result.Ref
+	if err := s.paymentRepo.UpdatePaymentRef(payment.PaymentID, payment.PaymentRef); err != nil {
+		// не отдаем ссылку на оплату: такой платеж потом не сопоставить с нашей записью
+		return nil, errors.New("could not save payment reference")
+	}
 
-	reqData.Confirmation.Type = "redirect"
-	reqData.Confirmation.ReturnURL = "https://mzt-study.ru/"
+	// платеж ушел провайдеру и ждет оплаты
+	err = s.paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentProcessing, source, string(result.Raw))
+	if err != nil {
+		fmt.Printf("Error updating payment status: %v\n", err)
+	} else {
+		payment.Status = entity.PaymentProcessing
+	}
+	return result, nil
+}
 
-	// добавляем метаданные для вебхука
-	reqData.Metadata = map[string]string{
-		"user_id":    userID,
-		"course_id":  courseID,
-		"payment_id": payment.PaymentID.String(),
+// обрабатывает уведомление от платежного провайдера
+// уведомлению не доверяем: перезапрашиваем платеж у провайдера и сверяем с нашей записью
+func (s *PaymentService) HandleWebhook(body []byte) error {
+	event, err := s.gateway.ParseWebhook(body)
+	if err != nil {
+		return ErrInvalidWebhook
 	}
 
-	// генерируем ключ идемпотентности
-	idempotenceKey := uuid.New().String()
+	// интересуют только успешные платежи и возвраты, остальные события просто подтверждаем
+	switch event.Event {
+	case gateway.EventPaymentSucceeded:
+	case gateway.EventRefundSucceeded:
+		return s.handleRefundWebhook(event)
+	default:
+		return nil
+	}
 
-	// отправляем запрос в YooKassa
-	body, _ := json.Marshal(reqData)
-	req, _ := http.NewRequest("POST", "https://api.yookassa.ru/v3/payments", bytes.NewBuffer(body))
+	payment, err := s.findWebhookPayment(event)
+	if err != nil {
+		return err
+	}
 
-	req.SetBasicAuth(s.config.Equiring.StoreCode, s.config.Equiring.StoreSecret)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Idempotence-Key", idempotenceKey)
+	// id из уведомления должен совпадать с сохраненным при создании платежа
+	if payment.PaymentRef == "" || payment.PaymentRef != event.PaymentRef {
+		return ErrPaymentMismatch
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// повторное уведомление по уже обработанному платежу, в том числе успевшему вернуться
+	// если платеж проведен, но выдать оплаченное не удалось - пробуем еще раз
+	if payment.FulfilledAt != nil {
+		return nil
+	}
 
+	// берем актуальное состояние платежа у провайдера
+	remote, err := s.gateway.GetPayment(payment.PaymentRef)
+	if errors.Is(err, gateway.ErrNotFound) {
+		return ErrPaymentNotFound
+	}
 	if err != nil {
-		return "", err
+		return err
+	}
+	s.syncReceiptStatus(payment, remote)
+
+	return s.settlePayment(payment, remote, entity.PaymentSourceWebhook, string(event.Raw))
+}
+
+// ищет наш платеж по уведомлению
+// сначала по id из метаданных, если его нет - по id платежа у провайдера
+func (s *PaymentService) findWebhookPayment(event *gateway.WebhookEvent) (*entity.Payment, error) {
+	var (
+		payment *entity.Payment
+		err     error
+	)
+	if event.PaymentID != "" {
+		paymentID, parseErr := uuid.Parse(event.PaymentID)
+		if parseErr != nil {
+			return nil, ErrPaymentNotFound
+		}
+		payment, err = s.paymentRepo.GetPaymentByID(paymentID)
+	} else {
+		payment, err = s.paymentRepo.GetPaymentByRef(event.PaymentRef)
+	}
+	if err != nil || payment == nil {
+		return nil, ErrPaymentNotFound
+	}
+	return payment, nil
+}
+
+// проводит оплаченный платеж: сверяет данные провайдера с нашей записью,
+// переводит платеж в succeeded, выдает оплаченное и отмечает что выдача прошла
+// уже проведенный, но не выданный платеж только выдается заново, поэтому каждый шаг выдачи можно повторять
+func (s *PaymentService) settlePayment(payment *entity.Payment, remote *gateway.Payment, source entity.PaymentEventSource, payload string) error {
+	// сверяем статус, сумму и валюту с тем что мы выставляли
+	if remote.Ref != payment.PaymentRef || remote.Status != gateway.StatusSucceeded || !remote.Paid {
+		return ErrPaymentMismatch
+	}
+	if remote.Amount != payment.Amount {
+		return ErrPaymentMismatch
 	}
 
-	// проверяем ответ от YooKassa
-	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
+	if payment.Status != entity.PaymentSucceeded {
+		// если при создании не успели перевести платеж в processing - делаем это сейчас
+		if payment.Status == entity.PaymentPending {
+			err := s.paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentProcessing, source, payload)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := s.paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentSucceeded, source, payload)
 		if err != nil {
-			return "", err
+			return err
 		}
-		return "", errors.New(string(body))
 	}
 
-	defer resp.Body.Close()
+	if err := s.fulfillPayment(payment, remote); err != nil {
+		return err
+	}
+	return s.paymentRepo.MarkPaymentFulfilled(payment.PaymentID)
+}
 
-	// парсим ответ от YooKassa
-	var result dto.PaymentResponse
-	json.NewDecoder(resp.Body).Decode(&result)
+// выдает оплаченное: записывает на курс или курсы набора, продлевает подписку или выпускает код подарка
+// повторный вызов по тому же платежу ничего не выдает второй раз
+func (s *PaymentService) fulfillPayment(payment *entity.Payment, remote *gateway.Payment) error {
+	// рассрочку заменили новой пока платеж за нее висел у провайдера - курс не выдаем и возвращаем деньги
+	var plan *entity.InstallmentPlan
+	if payment.InstallmentPlanID != nil {
+		var err error
+		plan, err = s.installmentRepo.GetInstallmentPlanByID(*payment.InstallmentPlanID)
+		if err != nil {
+			return err
+		}
+		if plan.Status == entity.InstallmentPlanCanceled {
+			return s.refundInFull(payment, "Рассрочка заменена новой")
+		}
+	}
 
-	// обновляем статус платежа в базе
-	err = s.paymentRepo.UpdatePaymentStatus(payment.PaymentID, "processing")
+	// промокод считается использованным только после оплаты
+	if payment.PromoCodeID != nil {
+		err := s.promoRepo.RecordPromoCodeUsage(&entity.PromoCodeUsage{
+			PromoCodeID: *payment.PromoCodeID,
+			UserID:      payment.UserID,
+			PaymentID:   payment.PaymentID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// курс или подписку берем из нашей базы, а не из метаданных
+	if payment.SubscriptionID != nil {
+		return activateSubscription(s.subscriptionRepo, s.courseRepo, payment, remote)
+	}
+	if payment.BundleID != nil {
+		return s.enrollInBundle(payment)
+	}
+	// за подарок покупатель получает код, а на курс записывается тот кто его активирует
+	if payment.Gift {
+		return issueGiftCode(s.giftRepo, payment, s.config.Equiring.GiftCodeTTL)
+	}
+	if err := s.enrollUser(*payment.CourseID, payment.UserID); err != nil {
+		return err
+	}
+	// доступ по рассрочке остается приостановленным пока есть просроченные части
+	if plan != nil {
+		return settleInstallment(s.installmentRepo, s.courseRepo, plan, payment, s.config.Equiring.InstallmentGracePeriod)
+	}
+	return nil
+}
+
+// возвращает всю сумму платежа, который нечего выдавать
+// если возврат уже запрошен, повторная выдача второй не создает
+func (s *PaymentService) refundInFull(payment *entity.Payment, reason string) error {
+	refunds, err := s.paymentRepo.GetRefundsByPaymentID(payment.PaymentID)
 	if err != nil {
-		fmt.Printf("Error updating payment status: %v\n", err)
+		return err
 	}
+	for _, refund := range refunds {
+		if refund.Status != entity.RefundCanceled {
+			return nil
+		}
+	}
+	_, err = s.RefundPayment(payment.PaymentID, nil, reason)
+	return err
+}
 
-	// сохраняем ссылку на платеж в YooKassa
-	payment.PaymentRef = result.ID
-	err = s.paymentRepo.UpdatePaymentStatus(payment.PaymentID, "processing")
+// сверяет зависшие платежи с провайдером
+// берет платежи которые дольше StalePaymentAfter висят в pending или processing
+// и по актуальному статусу у провайдера проводит их, отменяет или помечает истекшими
+// заодно повторяет выдачу по оплаченным платежам, где она сорвалась
+func (s *PaymentService) ReconcilePayments() error {
+	before := time.Now().Add(-s.config.Equiring.StalePaymentAfter)
+	payments, err := s.paymentRepo.GetStalePayments([]entity.PaymentStatus{entity.PaymentPending, entity.PaymentProcessing}, before)
 	if err != nil {
-		fmt.Printf("Error setting payment reference: %v\n", err)
+		return err
 	}
+	unfulfilled, err := s.paymentRepo.GetUnfulfilledPayments(before)
+	if err != nil {
+		return err
+	}
+	payments = append(payments, unfulfilled...)
 
-	// возвращаем ссылку для оплаты
-	return result.Confirmation.ConfirmationURL, nil
+	for _, payment := range payments {
+		// ошибка по одному платежу не должна останавливать сверку остальных
+		if err := s.reconcilePayment(payment); err != nil {
+			fmt.Printf("Error reconciling payment %s: %v\n", payment.PaymentID, err)
+		}
+	}
+	return nil
+}
+
+// сверяет один платеж с провайдером
+func (s *PaymentService) reconcilePayment(payment *entity.Payment) error {
+	if payment.PaymentRef == "" {
+		// оплату по счету отметил админ и тогда же выдал курс, у провайдера сверять нечего
+		if payment.Status == entity.PaymentSucceeded {
+			return s.paymentRepo.MarkPaymentFulfilled(payment.PaymentID)
+		}
+		// платеж так и не дошел до провайдера
+		return s.paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentExpired, entity.PaymentSourceReconciler, "")
+	}
+
+	remote, err := s.gateway.GetPayment(payment.PaymentRef)
+	if errors.Is(err, gateway.ErrNotFound) {
+		return s.paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentExpired, entity.PaymentSourceReconciler, "")
+	}
+	if err != nil {
+		return err
+	}
+	s.syncReceiptStatus(payment, remote)
+
+	switch remote.Status {
+	case gateway.StatusSucceeded:
+		return s.settlePayment(payment, remote, entity.PaymentSourceReconciler, string(remote.Raw))
+	case gateway.StatusCanceled:
+		return s.paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentCanceled, entity.PaymentSourceReconciler, string(remote.Raw))
+	default:
+		// пользователь так и не оплатил, если оплата все же придет - вебхук проведет платеж из expired
+		return s.paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentExpired, entity.PaymentSourceReconciler, string(remote.Raw))
+	}
+}
+
+// собирает чек по 54-ФЗ
+// контакты берутся из данных пользователя, единственная позиция - курс или подписка по сумме платежа
+func (s *PaymentService) buildReceipt(userID uuid.UUID, title string, amount money.Money) (*gateway.Receipt, error) {
+	user, err := s.userRepo.GetUserWithDataById(userID)
+	if err != nil || user == nil || user.UserData == nil {
+		return nil, ErrReceiptContact
+	}
+	phone := receiptPhone(user.UserData.PhoneNumber)
+	if user.UserData.Email == "" && phone == "" {
+		return nil, ErrReceiptContact
+	}
+
+	cfg := s.config.Equiring
+	return &gateway.Receipt{
+		Email:         user.UserData.Email,
+		Phone:         phone,
+		TaxSystemCode: cfg.TaxSystemCode,
+		Items: []gateway.ReceiptItem{{
+			Description:    title,
+			Quantity:       1,
+			Amount:         amount,
+			VatCode:        cfg.VatCode,
+			PaymentSubject: cfg.PaymentSubject,
+			PaymentMode:    cfg.PaymentMode,
+		}},
+	}, nil
+}
+
+// приводит телефон к виду который ждет касса: только цифры с кодом страны
+// российские номера с 8 в начале или без кода переводятся на 7
+func receiptPhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+
+	switch {
+	case len(digits) == 11 && digits[0] == '8':
+		digits = "7" + digits[1:]
+	case len(digits) == 10:
+		digits = "7" + digits
+	}
+	if len(digits) < 11 || len(digits) > 15 {
+		return ""
+	}
+	return digits
+}
+
+// сохраняет статус регистрации чека если провайдер сообщил новый
+// ошибка здесь не мешает проводить платеж, поэтому только пишем ее в лог
+func (s *PaymentService) syncReceiptStatus(payment *entity.Payment, remote *gateway.Payment) {
+	status := entity.ReceiptStatus(remote.ReceiptStatus)
+	if status == "" || status == payment.ReceiptStatus {
+		return
+	}
+	if err := s.paymentRepo.UpdateReceiptStatus(payment.PaymentID, status); err != nil {
+		fmt.Printf("Error updating receipt status: %v\n", err)
+		return
+	}
+	payment.ReceiptStatus = status
+}
+
+// возвращает деньги по оплаченному платежу
+// если amount не передан - возвращается весь остаток, при полном возврате пользователь отчисляется с курса
+func (s *PaymentService) RefundPayment(paymentID uuid.UUID, amount *money.Money, reason string) (*dto.RefundDto, error) {
+	payment, err := s.paymentRepo.GetPaymentByID(paymentID)
+	if err != nil || payment == nil {
+		return nil, ErrPaymentNotFound
+	}
+	if payment.Status != entity.PaymentSucceeded && payment.Status != entity.PaymentPartiallyRefunded {
+		return nil, ErrPaymentNotRefundable
+	}
+	// оплату по счету получили переводом мимо провайдера, такие деньги возвращают вручную
+	if payment.PaymentRef == "" {
+		return nil, ErrPaymentNotRefundable
+	}
+
+	// считаем сколько еще можно вернуть, незавершенные возвраты тоже занимают сумму
+	refunds, err := s.paymentRepo.GetRefundsByPaymentID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	remaining := payment.Amount
+	for _, refund := range refunds {
+		if refund.Status != entity.RefundCanceled {
+			if remaining, err = remaining.Sub(refund.Amount); err != nil {
+				return nil, err
+			}
+		}
+	}
+	toRefund := remaining
+	if amount != nil {
+		toRefund = *amount
+	}
+	if !toRefund.SameCurrency(payment.Amount) || !toRefund.IsPositive() || toRefund.Minor > remaining.Minor {
+		return nil, ErrInvalidRefundAmount
+	}
+
+	// запись о возврате создаем до запроса к провайдеру, ее id - ключ идемпотентности
+	refund := &entity.Refund{
+		RefundID:  uuid.New(),
+		PaymentID: payment.PaymentID,
+		Amount:    toRefund,
+		Status:    entity.RefundPending,
+		Reason:    reason,
+	}
+	// остаток перепроверяется под блокировкой платежа: параллельный возврат мог занять его после подсчета выше
+	if err := s.paymentRepo.CreateRefund(refund); err != nil {
+		if errors.Is(err, repository.ErrRefundExceedsPayment) {
+			return nil, ErrInvalidRefundAmount
+		}
+		return nil, errors.New("could not create refund record")
+	}
+
+	result, err := s.gateway.Refund(&gateway.RefundRequest{
+		RefundID:   refund.RefundID,
+		PaymentRef: payment.PaymentRef,
+		Amount:     refund.Amount,
+		Reason:     reason,
+	})
+	if err != nil {
+		// провайдер не принял возврат, сумма снова доступна для возврата
+		refund.Status = entity.RefundCanceled
+		if updateErr := s.paymentRepo.UpdateRefund(refund); updateErr != nil {
+			fmt.Printf("Error canceling refund: %v\n", updateErr)
+		}
+		return nil, err
+	}
+
+	refund.RefundRef = result.Ref
+	switch result.Status {
+	case gateway.StatusSucceeded:
+		err = s.completeRefund(payment, refund, entity.PaymentSourceAdmin, string(result.Raw))
+	case gateway.StatusCanceled:
+		refund.Status = entity.RefundCanceled
+		err = s.paymentRepo.UpdateRefund(refund)
+	default:
+		// возврат еще обрабатывается, закончим по уведомлению refund.succeeded
+		err = s.paymentRepo.UpdateRefund(refund)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response := refundDto(refund)
+	return &response, nil
+}
+
+// обрабатывает уведомление о проведенном возврате
+// так же как с платежом перепроверяем возврат у провайдера
+func (s *PaymentService) handleRefundWebhook(event *gateway.WebhookEvent) error {
+	refund, err := s.paymentRepo.GetRefundByRef(event.RefundRef)
+	if err != nil || refund == nil {
+		return ErrPaymentNotFound
+	}
+
+	// повторное уведомление по уже проведенному возврату
+	if refund.Status == entity.RefundSucceeded {
+		return nil
+	}
+
+	payment, err := s.paymentRepo.GetPaymentByID(refund.PaymentID)
+	if err != nil || payment == nil {
+		return ErrPaymentNotFound
+	}
+	if payment.PaymentRef != event.PaymentRef {
+		return ErrPaymentMismatch
+	}
+
+	remote, err := s.gateway.GetRefund(refund.RefundRef)
+	if errors.Is(err, gateway.ErrNotFound) {
+		return ErrPaymentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if remote.Status != gateway.StatusSucceeded || remote.PaymentRef != payment.PaymentRef {
+		return ErrPaymentMismatch
+	}
+	if remote.Amount != refund.Amount {
+		return ErrPaymentMismatch
+	}
+
+	return s.completeRefund(payment, refund, entity.PaymentSourceWebhook, string(event.Raw))
+}
+
+// отмечает возврат проведенным и переводит платеж в refunded или partially_refunded
+// после полного возврата у пользователя забирается доступ к курсу или курсам набора, подписка завершается, а код подарка отзывается
+func (s *PaymentService) completeRefund(payment *entity.Payment, refund *entity.Refund, source entity.PaymentEventSource, payload string) error {
+	refund.Status = entity.RefundSucceeded
+	if err := s.paymentRepo.UpdateRefund(refund); err != nil {
+		return err
+	}
+
+	refunds, err := s.paymentRepo.GetRefundsByPaymentID(payment.PaymentID)
+	if err != nil {
+		return err
+	}
+	refunded := money.New(0, payment.Amount.Currency)
+	for _, r := range refunds {
+		if r.Status == entity.RefundSucceeded {
+			if refunded, err = refunded.Add(r.Amount); err != nil {
+				return err
+			}
+		}
+	}
+
+	full := refunded.Minor >= payment.Amount.Minor
+	status := entity.PaymentPartiallyRefunded
+	if full {
+		status = entity.PaymentRefunded
+	}
+	if err := s.paymentRepo.TransitionPaymentStatus(payment.PaymentID, status, source, payload); err != nil {
+		return err
+	}
+
+	if !full {
+		return nil
+	}
+	if payment.SubscriptionID != nil {
+		subscription, err := s.subscriptionRepo.GetSubscriptionByID(*payment.SubscriptionID)
+		if err != nil {
+			return err
+		}
+		return expireSubscription(s.subscriptionRepo, s.courseRepo, subscription)
+	}
+	if payment.BundleID != nil {
+		if err := s.courseRepo.DeleteBundleAssignments(*payment.BundleID, payment.UserID); err != nil {
+			return err
+		}
+		// курсы набора которые до покупки были доступны по подписке возвращаются в нее
+		return restoreSubscriptionAccess(s.subscriptionRepo, s.courseRepo, payment.UserID)
+	}
+	if payment.Gift {
+		return revokeGiftCode(s.giftRepo, s.courseRepo, payment.PaymentID)
+	}
+	// вернули деньги за часть рассрочки - рассрочка закрывается вместе с доступом
+	// по уже отмененной рассрочке доступ забрали раньше или этот платеж его не давал
+	if payment.InstallmentPlanID != nil {
+		plan, err := s.installmentRepo.GetInstallmentPlanByID(*payment.InstallmentPlanID)
+		if err != nil {
+			return err
+		}
+		if plan.Status == entity.InstallmentPlanCanceled {
+			return nil
+		}
+		if err := s.installmentRepo.UpdateInstallmentPlanStatus(plan.InstallmentPlanID, entity.InstallmentPlanCanceled); err != nil {
+			return err
+		}
+	}
+	return s.courseRepo.DeleteCourseAssignment(*payment.CourseID, payment.UserID)
+}
+
+// преобразует возврат в формат для response
+func refundDto(refund *entity.Refund) dto.RefundDto {
+	return dto.RefundDto{
+		RefundID:  refund.RefundID,
+		PaymentID: refund.PaymentID,
+		Amount:    refund.Amount,
+		Status:    string(refund.Status),
+		RefundRef: refund.RefundRef,
+		Reason:    refund.Reason,
+		Date:      refund.CreatedAt,
+	}
+}
+
+// записывает пользователя на курс если он еще не записан
+// купленный курс остается у пользователя, даже если раньше он был доступен по подписке, из набора или был приостановлен
+func (s *PaymentService) enrollUser(courseID, userID uuid.UUID) error {
+	existing, err := s.courseRepo.GetCourseAssignment(courseID, userID)
+	if err == nil && existing != nil {
+		if existing.SubscriptionID != nil || existing.BundleID != nil || existing.Suspended {
+			existing.SubscriptionID = nil
+			existing.BundleID = nil
+			existing.Suspended = false
+			return s.courseRepo.UpdateCourseAssignment(existing)
+		}
+		return nil
+	}
+
+	assignment := &entity.CourseAssignment{
+		CaID:     uuid.New(),
+		UserID:   userID,
+		CourseID: courseID,
+		Progress: 0,
+	}
+	return s.courseRepo.CreateCourseAssignment(assignment)
+}
+
+// записывает пользователя на все курсы набора одной транзакцией
+// список курсов берем тот, что записали в платеж при создании, а не текущий состав набора
+func (s *PaymentService) enrollInBundle(payment *entity.Payment) error {
+	courseIDs, err := s.paymentRepo.GetPaymentCourseIDs(payment.PaymentID)
+	if err != nil {
+		return err
+	}
+	// платежи созданные до того как курсы стали записывать в платеж
+	if len(courseIDs) == 0 {
+		bundle, err := s.bundleRepo.GetBundleByID(*payment.BundleID)
+		if err != nil {
+			return err
+		}
+		for _, course := range bundle.Courses {
+			courseIDs = append(courseIDs, course.CourseID)
+		}
+	}
+	return s.courseRepo.EnrollInBundle(payment.UserID, *payment.BundleID, courseIDs)
 }
 
 // устанавливает цену для курса
 // создает или обновляет запись о цене курса в базе
-func (s *PaymentService) SetCoursePrice(courseID uuid.UUID, amount float64, currency string) error {
+// цена может быть в любой поддерживаемой валюте
+func (s *PaymentService) SetCoursePrice(courseID uuid.UUID, price money.Money) error {
+	if !price.Valid() || !price.IsPositive() {
+		return ErrInvalidPrice
+	}
+
 	// проверяем что курс существует
 	_, err := s.courseRepo.GetCourse(courseID)
 	if err != nil {
@@ -153,16 +738,14 @@ func (s *PaymentService) SetCoursePrice(courseID uuid.UUID, amount float64, curr
 	_, err = s.paymentRepo.GetCoursePrice(courseID)
 	if err == nil {
 		// если есть, обновляем
-		return s.paymentRepo.UpdateCoursePrice(courseID, amount)
+		return s.paymentRepo.UpdateCoursePrice(courseID, price)
 	}
 
 	// если нет, создаем новую
-	price := &entity.CoursePrice{
-		CourseID:     courseID,
-		Amount:       amount,
-		CurrencyCode: currency,
-	}
-	return s.paymentRepo.SetCoursePrice(price)
+	return s.paymentRepo.SetCoursePrice(&entity.CoursePrice{
+		CourseID: courseID,
+		Amount:   price,
+	})
 }
 
 // получает цену курса
@@ -171,15 +754,16 @@ func (s *PaymentService) GetCoursePrice(courseID uuid.UUID) (*entity.CoursePrice
 	return s.paymentRepo.GetCoursePrice(courseID)
 }
 
-// обновляет статус платежа
-// просто меняет статус платежа в базе
-func (s *PaymentService) UpdatePaymentStatus(paymentID uuid.UUID, status string) error {
-	return s.paymentRepo.UpdatePaymentStatus(paymentID, status)
+// обновляет статус платежа вручную
+// переход проверяется так же как для уведомлений и попадает в историю с пометкой admin
+func (s *PaymentService) UpdatePaymentStatus(paymentID uuid.UUID, status entity.PaymentStatus) error {
+	return s.paymentRepo.TransitionPaymentStatus(paymentID, status, entity.PaymentSourceAdmin, "")
 }
 
 // получает историю платежей пользователя
 // берет все платежи пользователя из базы и преобразует их в формат для response
-func (s *PaymentService) GetUserTransactions(userID uuid.UUID) ([]dto.PaymentDto, error) {
+// withPayload - отдать сырые ответы провайдера в истории статусов, в них данные карты и метаданные, только для админки
+func (s *PaymentService) GetUserTransactions(userID uuid.UUID, withPayload bool) ([]dto.PaymentDto, error) {
 	// получаем все платежи пользователя
 	payments, err := s.paymentRepo.GetPaymentsByUserID(userID)
 	if err != nil {
@@ -189,15 +773,48 @@ func (s *PaymentService) GetUserTransactions(userID uuid.UUID) ([]dto.PaymentDto
 	// преобразуем каждый платеж в корректный формат для response
 	result := make([]dto.PaymentDto, 0, len(payments))
 	for _, payment := range payments {
+		// история переходов статуса платежа
+		history := make([]dto.PaymentEventDto, 0, len(payment.Events))
+		for _, event := range payment.Events {
+			item := dto.PaymentEventDto{
+				FromStatus: string(event.FromStatus),
+				ToStatus:   string(event.ToStatus),
+				Source:     string(event.Source),
+				Date:       event.CreatedAt,
+			}
+			if withPayload {
+				item.Payload = event.Payload
+			}
+			history = append(history, item)
+		}
+
+		refunds := make([]dto.RefundDto, 0, len(payment.Refunds))
+		for i := range payment.Refunds {
+			refunds = append(refunds, refundDto(&payment.Refunds[i]))
+		}
+
+		promoCode := ""
+		if payment.PromoCode != nil {
+			promoCode = payment.PromoCode.Code
+		}
+
 		result = append(result, dto.PaymentDto{
-			PaymentID:    payment.PaymentID,
-			UserID:       payment.UserID,
-			CourseID:     payment.CourseID,
-			Amount:       payment.Amount,
-			CurrencyCode: payment.CurrencyCode,
-			Date:         payment.CreatedAt,
-			Status:       payment.Status,
-			PaymentRef:   payment.PaymentRef,
+			PaymentID:         payment.PaymentID,
+			UserID:            payment.UserID,
+			CourseID:          payment.CourseID,
+			SubscriptionID:    payment.SubscriptionID,
+			BundleID:          payment.BundleID,
+			InstallmentPlanID: payment.InstallmentPlanID,
+			Amount:            payment.Amount,
+			Date:              payment.CreatedAt,
+			Status:            string(payment.Status),
+			PaymentRef:        payment.PaymentRef,
+			PromoCode:         promoCode,
+			Discount:          payment.Discount,
+			ReceiptStatus:     string(payment.ReceiptStatus),
+			Gift:              payment.Gift,
+			History:           history,
+			Refunds:           refunds,
 		})
 	}
 
@@ -0,0 +1,502 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/gateway"
+	"mzt/internal/mocks"
+	"mzt/internal/money"
+	"mzt/internal/repository"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// поднимает фейковый YooKassa и сервис платежей поверх моков
+func setupPaymentService(t *testing.T) (*PaymentService, *mocks.FakeYooKassa, *mocks.MockPaymentRepository, *mocks.MockCourseRepository) {
+	fake, server := mocks.NewFakeYooKassaServer("shop", "secret")
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{
+		Equiring: config.Equiring{
+			StoreCode:   "shop",
+			StoreSecret: "secret",
+			APIURL:      server.URL + "/v3",
+			// платеж считается зависшим через полчаса
+			StalePaymentAfter: time.Minute * 30,
+		},
+	}
+	courseRepo := mocks.NewMockCourseRepository()
+	paymentRepo := mocks.NewMockPaymentRepository()
+	service := NewPaymentService(cfg, courseRepo, paymentRepo, mocks.NewMockPromoCodeRepository(), mocks.NewMockUserRepository(), mocks.NewMockSubscriptionRepository(), mocks.NewMockInstallmentRepository(), mocks.NewMockBundleRepository(), mocks.NewMockGiftCodeRepository(courseRepo), gateway.NewYooKassa(cfg))
+
+	return service, fake, paymentRepo.(*mocks.MockPaymentRepository), courseRepo.(*mocks.MockCourseRepository)
+}
+
+// сериализует уведомление так как его присылает YooKassa
+func webhookBody(t *testing.T, webhook dto.YooWebhook) []byte {
+	body, err := json.Marshal(webhook)
+	require.NoError(t, err)
+	return body
+}
+
+// создает платеж за курс и возвращает нашу запись о нем
+func createTestPayment(t *testing.T, service *PaymentService, paymentRepo *mocks.MockPaymentRepository) *entity.Payment {
+	courseID := uuid.New()
+	service.courseRepo.(*mocks.MockCourseRepository).Courses[courseID] = &entity.Course{CourseID: courseID, Title: "Test Course"}
+	paymentRepo.Prices[courseID] = &entity.CoursePrice{CourseID: courseID, Amount: money.New(2990000, "RUB")}
+
+	url, err := service.CreatePayment(uuid.New().String(), courseID.String(), "")
+	require.NoError(t, err)
+	require.NotEmpty(t, url)
+
+	for _, payment := range paymentRepo.Payments {
+		if *payment.CourseID == courseID {
+			return payment
+		}
+	}
+	t.Fatal("payment was not created")
+	return nil
+}
+
+func TestPaymentService_WebhookEnrollsVerifiedPayment(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+	require.NotEmpty(t, payment.PaymentRef)
+
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	webhook := fake.Webhook(payment.PaymentRef)
+
+	err := service.HandleWebhook(webhookBody(t, webhook))
+
+	assert.NoError(t, err)
+	assert.Equal(t, entity.PaymentSucceeded, payment.Status)
+	assignment, _ := courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.NotNil(t, assignment)
+
+	// повторное уведомление ничего не ломает
+	assert.NoError(t, service.HandleWebhook(webhookBody(t, webhook)))
+
+	// в истории все переходы платежа с источником
+	events := paymentRepo.Events[payment.PaymentID]
+	require.Len(t, events, 3)
+	assert.Equal(t, entity.PaymentPending, events[0].ToStatus)
+	assert.Equal(t, entity.PaymentProcessing, events[1].ToStatus)
+	assert.Equal(t, entity.PaymentSourceCheckout, events[1].Source)
+	assert.Equal(t, entity.PaymentSucceeded, events[2].ToStatus)
+	assert.Equal(t, entity.PaymentSourceWebhook, events[2].Source)
+	assert.NotEmpty(t, events[2].Payload)
+
+	// ответ провайдера видит только админка, самому пользователю он не отдается
+	transactions, err := service.GetUserTransactions(payment.UserID, true)
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+	assert.NotEmpty(t, transactions[0].History[2].Payload)
+	transactions, err = service.GetUserTransactions(payment.UserID, false)
+	require.NoError(t, err)
+	require.Len(t, transactions[0].History, 3)
+	for _, event := range transactions[0].History {
+		assert.Empty(t, event.Payload)
+	}
+}
+
+func TestPaymentService_WebhookRetriesFailedEnrollment(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	webhook := fake.Webhook(payment.PaymentRef)
+
+	// деньги пришли, а записать на курс не получилось - провайдер повторит уведомление
+	courseRepo.AssignmentErr = errors.New("database is down")
+	require.Error(t, service.HandleWebhook(webhookBody(t, webhook)))
+	assert.Equal(t, entity.PaymentSucceeded, payment.Status)
+	assert.Nil(t, payment.FulfilledAt)
+
+	courseRepo.AssignmentErr = nil
+	require.NoError(t, service.HandleWebhook(webhookBody(t, webhook)))
+	assignment, _ := courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.NotNil(t, assignment)
+	assert.NotNil(t, payment.FulfilledAt)
+	// платеж проведен один раз
+	assert.Len(t, paymentRepo.Events[payment.PaymentID], 3)
+}
+
+func TestPaymentService_ReconcileRetriesFailedEnrollment(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+
+	courseRepo.AssignmentErr = errors.New("database is down")
+	require.Error(t, service.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+
+	// уведомлений больше не будет, выдачу доделывает сверка
+	courseRepo.AssignmentErr = nil
+	payment.CreatedAt = time.Now().Add(-time.Hour)
+	require.NoError(t, service.ReconcilePayments())
+	assignment, _ := courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.NotNil(t, assignment)
+	assert.NotNil(t, payment.FulfilledAt)
+}
+
+func TestPaymentService_RejectsIllegalTransition(t *testing.T) {
+	service, _, paymentRepo, _ := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+
+	// из processing нельзя сразу вернуть деньги
+	err := service.UpdatePaymentStatus(payment.PaymentID, entity.PaymentRefunded)
+	assert.ErrorIs(t, err, repository.ErrIllegalTransition)
+
+	assert.NoError(t, service.UpdatePaymentStatus(payment.PaymentID, entity.PaymentCanceled))
+	assert.Equal(t, entity.PaymentCanceled, payment.Status)
+
+	// отмененный платеж нельзя вернуть в обработку
+	err = service.UpdatePaymentStatus(payment.PaymentID, entity.PaymentProcessing)
+	assert.ErrorIs(t, err, repository.ErrIllegalTransition)
+}
+
+func TestPaymentService_WebhookRejectsUnpaidPayment(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+
+	// уведомление говорит что оплачено, а у провайдера платеж еще ждет оплаты
+	webhook := fake.Webhook(payment.PaymentRef)
+	webhook.Event = "payment.succeeded"
+	webhook.Object.Status = "succeeded"
+
+	err := service.HandleWebhook(webhookBody(t, webhook))
+
+	assert.ErrorIs(t, err, ErrPaymentMismatch)
+	assert.NotEqual(t, entity.PaymentSucceeded, payment.Status)
+	assignment, _ := courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.Nil(t, assignment)
+}
+
+func TestPaymentService_WebhookRejectsAmountMismatch(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	webhook := fake.Webhook(payment.PaymentRef)
+	// у нас записана другая сумма чем та что оплачена у провайдера
+	payment.Amount = money.New(100, "RUB")
+
+	err := service.HandleWebhook(webhookBody(t, webhook))
+
+	assert.ErrorIs(t, err, ErrPaymentMismatch)
+	assignment, _ := courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.Nil(t, assignment)
+}
+
+func TestPaymentService_WebhookRejectsUnknownPayment(t *testing.T) {
+	service, fake, paymentRepo, _ := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	webhook := fake.Webhook(payment.PaymentRef)
+	webhook.Object.Metadata.PaymentID = uuid.New().String()
+
+	err := service.HandleWebhook(webhookBody(t, webhook))
+	assert.ErrorIs(t, err, ErrPaymentNotFound)
+
+	// id провайдера не совпадает с сохраненным у платежа
+	webhook = fake.Webhook(payment.PaymentRef)
+	webhook.Object.ID = uuid.New().String()
+
+	err = service.HandleWebhook(webhookBody(t, webhook))
+	assert.ErrorIs(t, err, ErrPaymentMismatch)
+}
+
+func TestPaymentService_FakeGateway(t *testing.T) {
+	cfg := &config.Config{Equiring: config.Equiring{Gateway: "fake", ReturnURL: "https://mzt-study.ru/"}}
+	gw, err := gateway.New(cfg)
+	require.NoError(t, err)
+	fake := gw.(*gateway.Fake)
+
+	courseRepo := mocks.NewMockCourseRepository()
+	paymentRepo := mocks.NewMockPaymentRepository()
+	service := NewPaymentService(cfg, courseRepo, paymentRepo, mocks.NewMockPromoCodeRepository(), mocks.NewMockUserRepository(), mocks.NewMockSubscriptionRepository(), mocks.NewMockInstallmentRepository(), mocks.NewMockBundleRepository(), mocks.NewMockGiftCodeRepository(courseRepo), gw)
+	payment := createTestPayment(t, service, paymentRepo.(*mocks.MockPaymentRepository))
+
+	// пока платеж не оплачен уведомление отклоняется
+	err = service.HandleWebhook([]byte(`{"event":"payment.succeeded","payment_ref":"` + payment.PaymentRef + `","payment_id":"` + payment.PaymentID.String() + `"}`))
+	assert.ErrorIs(t, err, ErrPaymentMismatch)
+
+	fake.SetStatus(payment.PaymentRef, gateway.StatusSucceeded)
+	err = service.HandleWebhook(fake.Webhook(payment.PaymentRef))
+
+	assert.NoError(t, err)
+	assert.Equal(t, entity.PaymentSucceeded, payment.Status)
+	assignment, _ := courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.NotNil(t, assignment)
+}
+
+func TestPaymentService_CreatePaymentStoresRef(t *testing.T) {
+	service, fake, paymentRepo, _ := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+
+	// ссылка на платеж у провайдера сохранена и по ней находится наша запись
+	require.NotEmpty(t, payment.PaymentRef)
+	_, ok := fake.Payment(payment.PaymentRef)
+	assert.True(t, ok)
+	found, err := paymentRepo.GetPaymentByRef(payment.PaymentRef)
+	require.NoError(t, err)
+	assert.Equal(t, payment.PaymentID, found.PaymentID)
+
+	// уведомление без наших метаданных сопоставляется по id провайдера
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	webhook := fake.Webhook(payment.PaymentRef)
+	webhook.Object.Metadata.PaymentID = ""
+
+	assert.NoError(t, service.HandleWebhook(webhookBody(t, webhook)))
+	assert.Equal(t, entity.PaymentSucceeded, payment.Status)
+}
+
+func TestPaymentService_ReconcileFinishesPaidPayment(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+
+	// пользователь оплатил, но уведомление потерялось
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+
+	// свежие платежи сверка не трогает
+	require.NoError(t, service.ReconcilePayments())
+	assert.Equal(t, entity.PaymentProcessing, payment.Status)
+
+	payment.CreatedAt = time.Now().Add(-time.Hour)
+	require.NoError(t, service.ReconcilePayments())
+
+	assert.Equal(t, entity.PaymentSucceeded, payment.Status)
+	assignment, _ := courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.NotNil(t, assignment)
+	events := paymentRepo.Events[payment.PaymentID]
+	assert.Equal(t, entity.PaymentSourceReconciler, events[len(events)-1].Source)
+}
+
+func TestPaymentService_ReconcileExpiresUnpaidPayment(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+	payment.CreatedAt = time.Now().Add(-time.Hour)
+
+	// платеж без ссылки на провайдера тоже истекает
+	lostCourseID := uuid.New()
+	lost := &entity.Payment{PaymentID: uuid.New(), UserID: uuid.New(), CourseID: &lostCourseID, Amount: money.New(10000, "RUB"), CreatedAt: time.Now().Add(-time.Hour)}
+	require.NoError(t, paymentRepo.CreatePayment(lost))
+
+	require.NoError(t, service.ReconcilePayments())
+
+	assert.Equal(t, entity.PaymentExpired, payment.Status)
+	assert.Equal(t, entity.PaymentExpired, lost.Status)
+	assignment, _ := courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.Nil(t, assignment)
+
+	// оплата пришла уже после сверки - платеж все равно проводится
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	assert.NoError(t, service.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+	assert.Equal(t, entity.PaymentSucceeded, payment.Status)
+}
+
+func TestPaymentService_ReconcileSkipsPaidInvoice(t *testing.T) {
+	service, _, paymentRepo, _ := setupPaymentService(t)
+
+	// оплату по счету отметили, а выдачу нет - так было до того как ее стали отмечать вместе с оплатой
+	courseID := uuid.New()
+	invoice := &entity.Payment{PaymentID: uuid.New(), UserID: uuid.New(), CourseID: &courseID, Amount: money.New(10000, "RUB"), Status: entity.PaymentAwaitingTransfer, CreatedAt: time.Now().Add(-time.Hour)}
+	require.NoError(t, paymentRepo.CreatePayment(invoice))
+	require.NoError(t, paymentRepo.TransitionPaymentStatus(invoice.PaymentID, entity.PaymentSucceeded, entity.PaymentSourceAdmin, ""))
+
+	require.NoError(t, service.ReconcilePayments())
+	assert.Equal(t, entity.PaymentSucceeded, invoice.Status)
+	assert.NotNil(t, invoice.FulfilledAt)
+}
+
+func TestPaymentService_ReconcileCancelsCanceledPayment(t *testing.T) {
+	service, fake, paymentRepo, _ := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+	payment.CreatedAt = time.Now().Add(-time.Hour)
+
+	fake.SetStatus(payment.PaymentRef, "canceled")
+	require.NoError(t, service.ReconcilePayments())
+
+	assert.Equal(t, entity.PaymentCanceled, payment.Status)
+}
+
+// создает платеж и проводит его как оплаченный
+func paidTestPayment(t *testing.T, service *PaymentService, fake *mocks.FakeYooKassa, paymentRepo *mocks.MockPaymentRepository) *entity.Payment {
+	payment := createTestPayment(t, service, paymentRepo)
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	require.NoError(t, service.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+	require.Equal(t, entity.PaymentSucceeded, payment.Status)
+	return payment
+}
+
+func TestPaymentService_FullRefundUnenrolls(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	payment := paidTestPayment(t, service, fake, paymentRepo)
+
+	// без суммы возвращается весь платеж
+	refund, err := service.RefundPayment(payment.PaymentID, nil, "не подошел курс")
+	require.NoError(t, err)
+
+	assert.Equal(t, money.New(2990000, "RUB"), refund.Amount)
+	assert.Equal(t, string(entity.RefundSucceeded), refund.Status)
+	assert.NotEmpty(t, refund.RefundRef)
+	assert.Equal(t, entity.PaymentRefunded, payment.Status)
+	assignment, _ := courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.Nil(t, assignment)
+
+	// вернуть больше нечего
+	_, err = service.RefundPayment(payment.PaymentID, nil, "")
+	assert.ErrorIs(t, err, ErrPaymentNotRefundable)
+}
+
+func TestPaymentService_PartialRefunds(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	payment := paidTestPayment(t, service, fake, paymentRepo)
+
+	_, err := service.RefundPayment(payment.PaymentID, rubles(10000), "")
+	require.NoError(t, err)
+	assert.Equal(t, entity.PaymentPartiallyRefunded, payment.Status)
+
+	// после частичного возврата доступ к курсу остается
+	assignment, _ := courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.NotNil(t, assignment)
+
+	// нельзя вернуть больше чем осталось
+	_, err = service.RefundPayment(payment.PaymentID, rubles(20000), "")
+	assert.ErrorIs(t, err, ErrInvalidRefundAmount)
+	_, err = service.RefundPayment(payment.PaymentID, rubles(-1), "")
+	assert.ErrorIs(t, err, ErrInvalidRefundAmount)
+	// возврат только в валюте платежа
+	usd := money.New(100, "USD")
+	_, err = service.RefundPayment(payment.PaymentID, &usd, "")
+	assert.ErrorIs(t, err, ErrInvalidRefundAmount)
+
+	refund, err := service.RefundPayment(payment.PaymentID, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, money.New(1990000, "RUB"), refund.Amount)
+	assert.Equal(t, entity.PaymentRefunded, payment.Status)
+	assignment, _ = courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.Nil(t, assignment)
+}
+
+func TestPaymentService_RefundWebhook(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	payment := paidTestPayment(t, service, fake, paymentRepo)
+
+	// провайдер принял возврат, но еще не провел его
+	fake.RefundStatus = "pending"
+	refund, err := service.RefundPayment(payment.PaymentID, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, string(entity.RefundPending), refund.Status)
+	assert.Equal(t, entity.PaymentSucceeded, payment.Status)
+
+	// уведомление раньше чем возврат проведен у провайдера не принимается
+	webhook := fake.RefundWebhook(refund.RefundRef)
+	webhook.Event = "refund.succeeded"
+	err = service.HandleWebhook(webhookBody(t, webhook))
+	assert.ErrorIs(t, err, ErrPaymentMismatch)
+
+	// пока возврат в обработке его сумма занята
+	_, err = service.RefundPayment(payment.PaymentID, rubles(1), "")
+	assert.ErrorIs(t, err, ErrInvalidRefundAmount)
+
+	fake.SetRefundStatus(refund.RefundRef, "succeeded")
+	webhook = fake.RefundWebhook(refund.RefundRef)
+	require.NoError(t, service.HandleWebhook(webhookBody(t, webhook)))
+
+	assert.Equal(t, entity.PaymentRefunded, payment.Status)
+	assert.Equal(t, entity.RefundSucceeded, paymentRepo.Refunds[refund.RefundID].Status)
+	assignment, _ := courseRepo.GetCourseAssignment(*payment.CourseID, payment.UserID)
+	assert.Nil(t, assignment)
+	events := paymentRepo.Events[payment.PaymentID]
+	assert.Equal(t, entity.PaymentSourceWebhook, events[len(events)-1].Source)
+
+	// повторное уведомление ничего не ломает
+	assert.NoError(t, service.HandleWebhook(webhookBody(t, webhook)))
+}
+
+func TestPaymentService_RefundUnpaidPayment(t *testing.T) {
+	service, _, paymentRepo, _ := setupPaymentService(t)
+	payment := createTestPayment(t, service, paymentRepo)
+
+	_, err := service.RefundPayment(payment.PaymentID, nil, "")
+	assert.ErrorIs(t, err, ErrPaymentNotRefundable)
+
+	_, err = service.RefundPayment(uuid.New(), nil, "")
+	assert.ErrorIs(t, err, ErrPaymentNotFound)
+}
+
+func TestPaymentService_SendsReceipt(t *testing.T) {
+	service, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	service.config.Equiring.SendReceipts = true
+	service.config.Equiring.VatCode = 1
+	service.config.Equiring.PaymentSubject = "service"
+	service.config.Equiring.PaymentMode = "full_payment"
+
+	userRepo := service.userRepo.(*mocks.MockUserRepository)
+	userID := uuid.New()
+	require.NoError(t, userRepo.CreateUser(&entity.User{ID: userID}, &entity.UserData{UserID: userID, Email: "student@example.com", PhoneNumber: "8 (900) 123-45-67"}, nil))
+
+	courseID := uuid.New()
+	courseRepo.Courses[courseID] = &entity.Course{CourseID: courseID, Title: "Финансовая грамотность"}
+	paymentRepo.Prices[courseID] = &entity.CoursePrice{CourseID: courseID, Amount: money.New(2990000, "RUB")}
+
+	_, err := service.CreatePayment(userID.String(), courseID.String(), "")
+	require.NoError(t, err)
+
+	var payment *entity.Payment
+	for _, p := range paymentRepo.Payments {
+		payment = p
+	}
+	require.NotNil(t, payment)
+	assert.Equal(t, entity.ReceiptPending, payment.ReceiptStatus)
+
+	receipt, ok := fake.Receipt(payment.PaymentRef)
+	require.True(t, ok)
+	assert.Equal(t, "student@example.com", receipt.Customer.Email)
+	assert.Equal(t, "79001234567", receipt.Customer.Phone)
+	require.Len(t, receipt.Items, 1)
+	assert.Equal(t, "Финансовая грамотность", receipt.Items[0].Description)
+	assert.Equal(t, "1.00", receipt.Items[0].Quantity)
+	assert.Equal(t, money.New(2990000, "RUB"), receipt.Items[0].Amount)
+	assert.Equal(t, 1, receipt.Items[0].VatCode)
+	assert.Equal(t, "service", receipt.Items[0].PaymentSubject)
+	assert.Equal(t, "full_payment", receipt.Items[0].PaymentMode)
+
+	// статус чека обновляется вместе с оплатой
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	require.NoError(t, service.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+	assert.Equal(t, entity.ReceiptSucceeded, payment.ReceiptStatus)
+
+	// без почты и телефона чек не собрать, платеж не создается
+	noContactID := uuid.New()
+	require.NoError(t, userRepo.CreateUser(&entity.User{ID: noContactID}, &entity.UserData{UserID: noContactID}, nil))
+	_, err = service.CreatePayment(noContactID.String(), courseID.String(), "")
+	assert.ErrorIs(t, err, ErrReceiptContact)
+	assert.Len(t, paymentRepo.Payments, 1)
+}
+
+func TestReceiptPhone(t *testing.T) {
+	assert.Equal(t, "79001234567", receiptPhone("+7 900 123-45-67"))
+	assert.Equal(t, "79001234567", receiptPhone("89001234567"))
+	assert.Equal(t, "79001234567", receiptPhone("9001234567"))
+	assert.Equal(t, "", receiptPhone("12345"))
+	assert.Equal(t, "", receiptPhone(""))
+}
+
+func TestGateway_UnknownName(t *testing.T) {
+	_, err := gateway.New(&config.Config{Equiring: config.Equiring{Gateway: "cloudpayments"}})
+	assert.Error(t, err)
+}
+
+// сумма в рублях для возврата
+func rubles(amount int64) *money.Money {
+	m := money.New(amount*100, "RUB")
+	return &m
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/money"
+	"mzt/internal/repository"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// промокод не найден
+	ErrPromoCodeNotFound = errors.New("promo code not found")
+	// промокод выключен или вне периода действия
+	ErrPromoCodeExpired = errors.New("promo code is not active")
+	// промокод не действует на этот курс
+	ErrPromoCodeNotApplicable = errors.New("promo code is not applicable")
+	// исчерпан общий лимит или лимит на пользователя
+	ErrPromoCodeLimitReached = errors.New("promo code usage limit reached")
+	// некорректные данные промокода от админа
+	ErrInvalidPromoCode = errors.New("invalid promo code")
+	// промокод с таким кодом уже есть
+	ErrPromoCodeExists = errors.New("promo code already exists")
+)
+
+// сервис для работы с промокодами
+// отвечает за управление промокодами из админки и статистику по ним
+type PromoCodeService struct {
+	config     *config.Config
+	promoRepo  repository.PromoCodeRepository
+	courseRepo repository.CourseRepository
+}
+
+// создаем новый сервис для работы с промокодами
+func NewPromoCodeService(cfg *config.Config, promoRepo repository.PromoCodeRepository, courseRepo repository.CourseRepository) *PromoCodeService {
+	return &PromoCodeService{
+		config:     cfg,
+		promoRepo:  promoRepo,
+		courseRepo: courseRepo,
+	}
+}
+
+// получает список всех промокодов
+func (s *PromoCodeService) GetPromoCodes() ([]dto.PromoCodeDto, error) {
+	promos, err := s.promoRepo.GetPromoCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.PromoCodeDto, 0, len(promos))
+	for i := range promos {
+		result = append(result, promoCodeDto(&promos[i]))
+	}
+	return result, nil
+}
+
+// получает промокод по id
+func (s *PromoCodeService) GetPromoCode(promoCodeID uuid.UUID) (*dto.PromoCodeDto, error) {
+	promo, err := s.promoRepo.GetPromoCodeByID(promoCodeID)
+	if err != nil || promo == nil {
+		return nil, ErrPromoCodeNotFound
+	}
+	result := promoCodeDto(promo)
+	return &result, nil
+}
+
+// создает новый промокод
+// код приводится к верхнему регистру и должен быть уникальным
+func (s *PromoCodeService) CreatePromoCode(payload *dto.CreatePromoCodeDto) (*dto.PromoCodeDto, error) {
+	promo := &entity.PromoCode{PromoCodeID: uuid.New(), Active: true}
+	if err := s.fillPromoCode(promo, payload); err != nil {
+		return nil, err
+	}
+
+	if existing, err := s.promoRepo.GetPromoCodeByCode(promo.Code); err == nil && existing != nil {
+		return nil, ErrPromoCodeExists
+	}
+	if err := s.promoRepo.CreatePromoCode(promo); err != nil {
+		return nil, err
+	}
+
+	result := promoCodeDto(promo)
+	return &result, nil
+}
+
+// обновляет промокод
+// уже совершенные оплаты по нему не меняются
+func (s *PromoCodeService) UpdatePromoCode(promoCodeID uuid.UUID, payload *dto.CreatePromoCodeDto) (*dto.PromoCodeDto, error) {
+	promo, err := s.promoRepo.GetPromoCodeByID(promoCodeID)
+	if err != nil || promo == nil {
+		return nil, ErrPromoCodeNotFound
+	}
+	if err := s.fillPromoCode(promo, payload); err != nil {
+		return nil, err
+	}
+
+	if existing, err := s.promoRepo.GetPromoCodeByCode(promo.Code); err == nil && existing != nil && existing.PromoCodeID != promoCodeID {
+		return nil, ErrPromoCodeExists
+	}
+	if err := s.promoRepo.UpdatePromoCode(promo); err != nil {
+		return nil, err
+	}
+
+	result := promoCodeDto(promo)
+	return &result, nil
+}
+
+// удаляет промокод
+func (s *PromoCodeService) DeletePromoCode(promoCodeID uuid.UUID) error {
+	if _, err := s.promoRepo.GetPromoCodeByID(promoCodeID); err != nil {
+		return ErrPromoCodeNotFound
+	}
+	return s.promoRepo.DeletePromoCode(promoCodeID)
+}
+
+// получает статистику использования промокода
+func (s *PromoCodeService) GetPromoCodeStats(promoCodeID uuid.UUID) (*dto.PromoCodeStatsDto, error) {
+	if _, err := s.promoRepo.GetPromoCodeByID(promoCodeID); err != nil {
+		return nil, ErrPromoCodeNotFound
+	}
+	return s.promoRepo.GetPromoCodeStats(promoCodeID)
+}
+
+// проверяет данные от админа и переносит их в промокод
+func (s *PromoCodeService) fillPromoCode(promo *entity.PromoCode, payload *dto.CreatePromoCodeDto) error {
+	code := strings.ToUpper(strings.TrimSpace(payload.Code))
+	if code == "" {
+		return ErrInvalidPromoCode
+	}
+
+	var percent float64
+	var amount money.Money
+	kind := entity.PromoCodeKind(payload.Kind)
+	switch kind {
+	case entity.PromoCodePercent:
+		if payload.Percent <= 0 || payload.Percent > 100 {
+			return ErrInvalidPromoCode
+		}
+		percent = payload.Percent
+	case entity.PromoCodeFixed:
+		if payload.Amount == nil || !payload.Amount.Valid() || !payload.Amount.IsPositive() {
+			return ErrInvalidPromoCode
+		}
+		amount = *payload.Amount
+	default:
+		return ErrInvalidPromoCode
+	}
+
+	if payload.ValidFrom != nil && payload.ValidUntil != nil && !payload.ValidUntil.After(*payload.ValidFrom) {
+		return ErrInvalidPromoCode
+	}
+
+	// проверяем что все курсы существуют
+	courses := make([]entity.PromoCodeCourse, 0, len(payload.CourseIDs))
+	for _, courseID := range payload.CourseIDs {
+		if course, err := s.courseRepo.GetCourse(courseID); err != nil || course == nil {
+			return fmt.Errorf("%w: course %s not found", ErrInvalidPromoCode, courseID)
+		}
+		courses = append(courses, entity.PromoCodeCourse{PromoCodeID: promo.PromoCodeID, CourseID: courseID})
+	}
+
+	promo.Code = code
+	promo.Kind = kind
+	promo.Percent = percent
+	promo.Amount = amount
+	promo.ValidFrom = payload.ValidFrom
+	promo.ValidUntil = payload.ValidUntil
+	promo.UsageLimit = payload.UsageLimit
+	promo.PerUserLimit = payload.PerUserLimit
+	promo.Courses = courses
+	if payload.Active != nil {
+		promo.Active = *payload.Active
+	}
+	return nil
+}
+
+// проверяет промокод при оплате курса и считает скидку
+// использование здесь не записывается, оно засчитывается только после успешной оплаты
+func applyPromoCode(promoRepo repository.PromoCodeRepository, code string, userID, courseID uuid.UUID, price money.Money) (*entity.PromoCode, money.Money, error) {
+	promo, err := promoRepo.GetPromoCodeByCode(strings.TrimSpace(code))
+	if err != nil || promo == nil {
+		return nil, money.Money{}, ErrPromoCodeNotFound
+	}
+
+	now := time.Now()
+	if !promo.Active || (promo.ValidFrom != nil && now.Before(*promo.ValidFrom)) || (promo.ValidUntil != nil && now.After(*promo.ValidUntil)) {
+		return nil, money.Money{}, ErrPromoCodeExpired
+	}
+
+	if len(promo.Courses) > 0 {
+		applicable := false
+		for _, course := range promo.Courses {
+			if course.CourseID == courseID {
+				applicable = true
+				break
+			}
+		}
+		if !applicable {
+			return nil, money.Money{}, ErrPromoCodeNotApplicable
+		}
+	}
+
+	if promo.UsageLimit > 0 {
+		used, err := promoRepo.CountPromoCodeUsages(promo.PromoCodeID)
+		if err != nil {
+			return nil, money.Money{}, err
+		}
+		if used >= int64(promo.UsageLimit) {
+			return nil, money.Money{}, ErrPromoCodeLimitReached
+		}
+	}
+	if promo.PerUserLimit > 0 {
+		used, err := promoRepo.CountPromoCodeUsagesByUser(promo.PromoCodeID, userID)
+		if err != nil {
+			return nil, money.Money{}, err
+		}
+		if used >= int64(promo.PerUserLimit) {
+			return nil, money.Money{}, ErrPromoCodeLimitReached
+		}
+	}
+
+	discount := promo.Amount
+	if promo.Kind == entity.PromoCodePercent {
+		discount = price.Percent(promo.Percent)
+	}
+	// фиксированная скидка действует только на цену в той же валюте
+	// бесплатно через оплату курс не выдаем
+	if !discount.SameCurrency(price) || discount.Minor >= price.Minor {
+		return nil, money.Money{}, ErrPromoCodeNotApplicable
+	}
+	return promo, discount, nil
+}
+
+// преобразует промокод в формат для response
+func promoCodeDto(promo *entity.PromoCode) dto.PromoCodeDto {
+	courseIDs := make([]uuid.UUID, 0, len(promo.Courses))
+	for _, course := range promo.Courses {
+		courseIDs = append(courseIDs, course.CourseID)
+	}
+	result := dto.PromoCodeDto{
+		PromoCodeID:  promo.PromoCodeID,
+		Code:         promo.Code,
+		Kind:         string(promo.Kind),
+		Percent:      promo.Percent,
+		ValidFrom:    promo.ValidFrom,
+		ValidUntil:   promo.ValidUntil,
+		UsageLimit:   promo.UsageLimit,
+		PerUserLimit: promo.PerUserLimit,
+		Active:       promo.Active,
+		CourseIDs:    courseIDs,
+		CreatedAt:    promo.CreatedAt,
+	}
+	if promo.Kind == entity.PromoCodeFixed {
+		amount := promo.Amount
+		result.Amount = &amount
+	}
+	return result
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/mocks"
+	"mzt/internal/money"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// поднимает сервис промокодов поверх тех же моков что и сервис платежей
+func setupPromoCodeService(t *testing.T) (*PromoCodeService, *PaymentService, *mocks.FakeYooKassa, *mocks.MockPaymentRepository, *mocks.MockCourseRepository) {
+	paymentService, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	promoService := NewPromoCodeService(paymentService.config, paymentService.promoRepo, courseRepo)
+	return promoService, paymentService, fake, paymentRepo, courseRepo
+}
+
+// создает курс с ценой 29900 RUB
+func createPricedCourse(courseRepo *mocks.MockCourseRepository, paymentRepo *mocks.MockPaymentRepository) uuid.UUID {
+	courseID := uuid.New()
+	courseRepo.Courses[courseID] = &entity.Course{CourseID: courseID, Title: "Test Course"}
+	paymentRepo.Prices[courseID] = &entity.CoursePrice{CourseID: courseID, Amount: money.New(2990000, "RUB")}
+	return courseID
+}
+
+// оформляет покупку курса с промокодом и возвращает созданный платеж
+func checkoutWithPromoCode(t *testing.T, service *PaymentService, paymentRepo *mocks.MockPaymentRepository, userID, courseID uuid.UUID, code string) (*entity.Payment, error) {
+	_, err := service.CreatePayment(userID.String(), courseID.String(), code)
+	if err != nil {
+		return nil, err
+	}
+	for _, payment := range paymentRepo.Payments {
+		if payment.UserID == userID && *payment.CourseID == courseID && payment.Status == entity.PaymentProcessing {
+			return payment, nil
+		}
+	}
+	t.Fatal("payment was not created")
+	return nil, nil
+}
+
+func TestPromoCodeService_CreateValidates(t *testing.T) {
+	promoService, _, _, _, _ := setupPromoCodeService(t)
+
+	_, err := promoService.CreatePromoCode(&dto.CreatePromoCodeDto{Code: "SALE", Kind: "bonus", Percent: 10})
+	assert.ErrorIs(t, err, ErrInvalidPromoCode)
+	_, err = promoService.CreatePromoCode(&dto.CreatePromoCodeDto{Code: "SALE", Kind: "percent", Percent: 150})
+	assert.ErrorIs(t, err, ErrInvalidPromoCode)
+	_, err = promoService.CreatePromoCode(&dto.CreatePromoCodeDto{Code: "SALE", Kind: "fixed", Amount: rubles(100), CourseIDs: []uuid.UUID{uuid.New()}})
+	assert.ErrorIs(t, err, ErrInvalidPromoCode)
+
+	promo, err := promoService.CreatePromoCode(&dto.CreatePromoCodeDto{Code: " sale ", Kind: "percent", Percent: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "SALE", promo.Code)
+	assert.True(t, promo.Active)
+
+	// коды не различаются по регистру
+	_, err = promoService.CreatePromoCode(&dto.CreatePromoCodeDto{Code: "Sale", Kind: "fixed", Amount: rubles(100)})
+	assert.ErrorIs(t, err, ErrPromoCodeExists)
+}
+
+func TestPromoCodeService_DiscountCountedAfterPayment(t *testing.T) {
+	promoService, paymentService, fake, paymentRepo, courseRepo := setupPromoCodeService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+
+	promo, err := promoService.CreatePromoCode(&dto.CreatePromoCodeDto{Code: "SALE10", Kind: "percent", Percent: 10, UsageLimit: 1})
+	require.NoError(t, err)
+
+	payment, err := checkoutWithPromoCode(t, paymentService, paymentRepo, uuid.New(), courseID, "sale10")
+	require.NoError(t, err)
+	assert.Equal(t, money.New(2691000, "RUB"), payment.Amount)
+	assert.Equal(t, money.New(299000, "RUB"), payment.Discount)
+	require.NotNil(t, payment.PromoCodeID)
+	assert.Equal(t, promo.PromoCodeID, *payment.PromoCodeID)
+
+	// сумма у провайдера уже со скидкой
+	remote, _ := fake.Payment(payment.PaymentRef)
+	assert.Equal(t, "26910.00", remote.Amount.String())
+
+	// пока платеж не оплачен промокод не считается использованным
+	stats, err := promoService.GetPromoCodeStats(promo.PromoCodeID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Usages)
+
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	require.NoError(t, paymentService.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+
+	stats, err = promoService.GetPromoCodeStats(promo.PromoCodeID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Usages)
+
+	// общий лимит исчерпан
+	_, err = checkoutWithPromoCode(t, paymentService, paymentRepo, uuid.New(), courseID, "SALE10")
+	assert.ErrorIs(t, err, ErrPromoCodeLimitReached)
+}
+
+func TestPromoCodeService_PerUserLimit(t *testing.T) {
+	promoService, paymentService, fake, paymentRepo, courseRepo := setupPromoCodeService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	otherCourseID := createPricedCourse(courseRepo, paymentRepo)
+
+	_, err := promoService.CreatePromoCode(&dto.CreatePromoCodeDto{Code: "ONCE", Kind: "fixed", Amount: rubles(5000), PerUserLimit: 1})
+	require.NoError(t, err)
+
+	userID := uuid.New()
+	payment, err := checkoutWithPromoCode(t, paymentService, paymentRepo, userID, courseID, "ONCE")
+	require.NoError(t, err)
+	assert.Equal(t, money.New(2490000, "RUB"), payment.Amount)
+
+	fake.SetStatus(payment.PaymentRef, "succeeded")
+	require.NoError(t, paymentService.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+
+	_, err = checkoutWithPromoCode(t, paymentService, paymentRepo, userID, otherCourseID, "ONCE")
+	assert.ErrorIs(t, err, ErrPromoCodeLimitReached)
+
+	// другой пользователь все еще может им воспользоваться
+	_, err = checkoutWithPromoCode(t, paymentService, paymentRepo, uuid.New(), otherCourseID, "ONCE")
+	assert.NoError(t, err)
+}
+
+func TestPromoCodeService_Restrictions(t *testing.T) {
+	promoService, paymentService, _, paymentRepo, courseRepo := setupPromoCodeService(t)
+	courseID := createPricedCourse(courseRepo, paymentRepo)
+	otherCourseID := createPricedCourse(courseRepo, paymentRepo)
+
+	// промокод только на один курс
+	_, err := promoService.CreatePromoCode(&dto.CreatePromoCodeDto{Code: "COURSE", Kind: "percent", Percent: 50, CourseIDs: []uuid.UUID{courseID}})
+	require.NoError(t, err)
+	_, err = checkoutWithPromoCode(t, paymentService, paymentRepo, uuid.New(), otherCourseID, "COURSE")
+	assert.ErrorIs(t, err, ErrPromoCodeNotApplicable)
+	_, err = checkoutWithPromoCode(t, paymentService, paymentRepo, uuid.New(), courseID, "COURSE")
+	assert.NoError(t, err)
+
+	// срок действия закончился
+	yesterday := time.Now().Add(-time.Hour * 24)
+	_, err = promoService.CreatePromoCode(&dto.CreatePromoCodeDto{Code: "OLD", Kind: "percent", Percent: 10, ValidUntil: &yesterday})
+	require.NoError(t, err)
+	_, err = checkoutWithPromoCode(t, paymentService, paymentRepo, uuid.New(), courseID, "OLD")
+	assert.ErrorIs(t, err, ErrPromoCodeExpired)
+
+	// скидка больше цены курса
+	_, err = promoService.CreatePromoCode(&dto.CreatePromoCodeDto{Code: "FREE", Kind: "fixed", Amount: rubles(50000)})
+	require.NoError(t, err)
+	_, err = checkoutWithPromoCode(t, paymentService, paymentRepo, uuid.New(), courseID, "FREE")
+	assert.ErrorIs(t, err, ErrPromoCodeNotApplicable)
+
+	// фиксированная скидка в другой валюте к рублевой цене не применяется
+	usd := money.New(1000, "USD")
+	_, err = promoService.CreatePromoCode(&dto.CreatePromoCodeDto{Code: "DOLLAR", Kind: "fixed", Amount: &usd})
+	require.NoError(t, err)
+	_, err = checkoutWithPromoCode(t, paymentService, paymentRepo, uuid.New(), courseID, "DOLLAR")
+	assert.ErrorIs(t, err, ErrPromoCodeNotApplicable)
+
+	_, err = checkoutWithPromoCode(t, paymentService, paymentRepo, uuid.New(), courseID, "UNKNOWN")
+	assert.ErrorIs(t, err, ErrPromoCodeNotFound)
+}
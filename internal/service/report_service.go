@@ -0,0 +1,282 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/money"
+	"mzt/internal/repository"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// как можно сгруппировать отчет по выручке
+const (
+	ReportByCourse   = "course"
+	ReportByDay      = "day"
+	ReportByWeek     = "week"
+	ReportByMonth    = "month"
+	ReportByCurrency = "currency"
+	ReportByStatus   = "status"
+)
+
+// некорректные параметры отчета
+var ErrInvalidReport = errors.New("invalid report request")
+
+// ReportFilter параметры отчета по выручке
+// платежи попадают в отчет по дате создания из промежутка [From, To), пустые поля не фильтруют
+type ReportFilter struct {
+	GroupBy  string
+	From     *time.Time
+	To       *time.Time
+	CourseID *uuid.UUID
+	Currency string
+	Status   string
+	// в каком часовом поясе считать дни, недели и месяцы, по умолчанию UTC
+	Location *time.Location
+}
+
+// сервис отчетов для финансов
+// все считается по нашей истории платежей и возвратов, провайдер не опрашивается
+type ReportService struct {
+	config      *config.Config
+	paymentRepo repository.PaymentRepository
+}
+
+// создаем новый сервис отчетов
+func NewReportService(cfg *config.Config, paymentRepo repository.PaymentRepository) *ReportService {
+	return &ReportService{
+		config:      cfg,
+		paymentRepo: paymentRepo,
+	}
+}
+
+// строит отчет по выручке с конверсией и возвратами
+func (s *ReportService) RevenueReport(filter ReportFilter) (*dto.RevenueReportDto, error) {
+	if filter.GroupBy == "" {
+		filter.GroupBy = ReportByDay
+	}
+	switch filter.GroupBy {
+	case ReportByCourse, ReportByDay, ReportByWeek, ReportByMonth, ReportByCurrency, ReportByStatus:
+	default:
+		return nil, fmt.Errorf("%w: unknown group_by %q", ErrInvalidReport, filter.GroupBy)
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidReport)
+	}
+	if filter.Location == nil {
+		filter.Location = time.UTC
+	}
+
+	payments, err := s.reportPayments(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make(map[string]*dto.RevenueReportRow)
+	totals := make(map[string]*dto.RevenueReportRow)
+	for _, payment := range payments {
+		key, label := reportKey(payment, filter.GroupBy, filter.Location)
+		currency := payment.Amount.Currency
+		row, exists := rows[key+"|"+currency]
+		if !exists {
+			row = newReportRow(key, label, currency)
+			rows[key+"|"+currency] = row
+		}
+		total, exists := totals[currency]
+		if !exists {
+			total = newReportRow("total", "", currency)
+			totals[currency] = total
+		}
+		if err := addToReport(row, payment); err != nil {
+			return nil, err
+		}
+		if err := addToReport(total, payment); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &dto.RevenueReportDto{
+		GroupBy:  filter.GroupBy,
+		From:     filter.From,
+		To:       filter.To,
+		Timezone: filter.Location.String(),
+		Rows:     finishReportRows(rows),
+		Totals:   finishReportRows(totals),
+	}
+	return report, nil
+}
+
+// выгружает отчет по выручке в csv, итоги по валютам идут последними строками
+func (s *ReportService) RevenueReportCSV(filter ReportFilter) ([]byte, error) {
+	report, err := s.RevenueReport(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	rows := [][]string{{"key", "label", "currency", "payments", "amount", "paid", "conversion", "revenue", "refunds", "refunded", "net"}}
+	for _, row := range append(report.Rows, report.Totals...) {
+		rows = append(rows, []string{
+			row.Key,
+			row.Label,
+			row.Currency,
+			strconv.Itoa(row.Payments),
+			row.Amount.String(),
+			strconv.Itoa(row.Paid),
+			strconv.FormatFloat(row.Conversion, 'f', 4, 64),
+			row.Revenue.String(),
+			strconv.Itoa(row.Refunds),
+			row.Refunded.String(),
+			row.Net.String(),
+		})
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// выбирает платежи под фильтр
+// отчет по одному курсу берет платежи этого курса, остальные - все платежи за период
+func (s *ReportService) reportPayments(filter ReportFilter) ([]*entity.Payment, error) {
+	var payments []*entity.Payment
+	var err error
+	if filter.CourseID != nil {
+		payments, err = s.paymentRepo.GetPaymentsByCourseID(*filter.CourseID)
+	} else {
+		payments, err = s.paymentRepo.GetPaymentsCreatedBetween(filter.From, filter.To)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*entity.Payment, 0, len(payments))
+	for _, payment := range payments {
+		if filter.From != nil && payment.CreatedAt.Before(*filter.From) {
+			continue
+		}
+		if filter.To != nil && !payment.CreatedAt.Before(*filter.To) {
+			continue
+		}
+		if filter.Currency != "" && payment.Amount.Currency != filter.Currency {
+			continue
+		}
+		if filter.Status != "" && string(payment.Status) != filter.Status {
+			continue
+		}
+		result = append(result, payment)
+	}
+	return result, nil
+}
+
+// ключ группы для платежа и подпись к нему
+func reportKey(payment *entity.Payment, groupBy string, loc *time.Location) (string, string) {
+	created := payment.CreatedAt.In(loc)
+	switch groupBy {
+	case ReportByDay:
+		return created.Format("2006-01-02"), ""
+	case ReportByWeek:
+		// неделя начинается с понедельника, ключ - ее первый день
+		start := created.AddDate(0, 0, -((int(created.Weekday()) + 6) % 7))
+		year, week := created.ISOWeek()
+		return start.Format("2006-01-02"), fmt.Sprintf("%d-W%02d", year, week)
+	case ReportByMonth:
+		return created.Format("2006-01"), ""
+	case ReportByCurrency:
+		return payment.Amount.Currency, ""
+	case ReportByStatus:
+		return string(payment.Status), ""
+	}
+
+	// за что платили: курс, в том числе рассрочка и подарок, набор или подписка
+	switch {
+	case payment.CourseID != nil:
+		label := ""
+		if payment.Course != nil {
+			label = payment.Course.Title
+		}
+		return payment.CourseID.String(), label
+	case payment.BundleID != nil:
+		label := "bundle"
+		if payment.Bundle != nil {
+			label += ": " + payment.Bundle.Title
+		}
+		return payment.BundleID.String(), label
+	case payment.SubscriptionID != nil && payment.Subscription != nil:
+		return payment.Subscription.PlanID.String(), "plan: " + payment.Subscription.Plan.Title
+	case payment.SubscriptionID != nil:
+		return payment.SubscriptionID.String(), "subscription"
+	default:
+		return "other", ""
+	}
+}
+
+func newReportRow(key, label, currency string) *dto.RevenueReportRow {
+	return &dto.RevenueReportRow{
+		Key:      key,
+		Label:    label,
+		Currency: currency,
+		Amount:   money.New(0, currency),
+		Revenue:  money.New(0, currency),
+		Refunded: money.New(0, currency),
+		Net:      money.New(0, currency),
+	}
+}
+
+// добавляет платеж и его проведенные возвраты в строку отчета
+// выручкой считаются платежи которые были оплачены, даже если деньги потом вернули
+func addToReport(row *dto.RevenueReportRow, payment *entity.Payment) error {
+	var err error
+	row.Payments++
+	if row.Amount, err = row.Amount.Add(payment.Amount); err != nil {
+		return err
+	}
+
+	switch payment.Status {
+	case entity.PaymentSucceeded, entity.PaymentPartiallyRefunded, entity.PaymentRefunded:
+		row.Paid++
+		if row.Revenue, err = row.Revenue.Add(payment.Amount); err != nil {
+			return err
+		}
+	}
+
+	for _, refund := range payment.Refunds {
+		if refund.Status != entity.RefundSucceeded {
+			continue
+		}
+		row.Refunds++
+		if row.Refunded, err = row.Refunded.Add(refund.Amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// считает конверсию и чистую выручку и сортирует строки по ключу
+func finishReportRows(rows map[string]*dto.RevenueReportRow) []dto.RevenueReportRow {
+	result := make([]dto.RevenueReportRow, 0, len(rows))
+	for _, row := range rows {
+		if row.Payments > 0 {
+			row.Conversion = math.Round(float64(row.Paid)/float64(row.Payments)*10000) / 10000
+		}
+		row.Net, _ = row.Revenue.Sub(row.Refunded)
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Key != result[j].Key {
+			return result[i].Key < result[j].Key
+		}
+		return result[i].Currency < result[j].Currency
+	})
+	return result
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"encoding/csv"
+	"mzt/config"
+	"mzt/internal/entity"
+	"mzt/internal/mocks"
+	"mzt/internal/money"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// наполняет историю платежей: два курса, две валюты, возврат и неоплаченный платеж
+func setupReportService(t *testing.T) (*ReportService, uuid.UUID, uuid.UUID) {
+	paymentRepo := mocks.NewMockPaymentRepository().(*mocks.MockPaymentRepository)
+	service := NewReportService(&config.Config{}, paymentRepo)
+
+	courseA, courseB := uuid.New(), uuid.New()
+	add := func(courseID uuid.UUID, amount money.Money, status entity.PaymentStatus, created string) *entity.Payment {
+		createdAt, err := time.Parse(time.RFC3339, created)
+		require.NoError(t, err)
+		payment := &entity.Payment{
+			PaymentID: uuid.New(),
+			UserID:    uuid.New(),
+			CourseID:  &courseID,
+			Amount:    amount,
+			Status:    status,
+			CreatedAt: createdAt,
+		}
+		paymentRepo.Payments[payment.PaymentID] = payment
+		return payment
+	}
+
+	add(courseA, money.New(100000, "RUB"), entity.PaymentSucceeded, "2025-03-03T10:00:00Z")
+	add(courseA, money.New(100000, "RUB"), entity.PaymentCanceled, "2025-03-03T11:00:00Z")
+	refunded := add(courseA, money.New(100000, "RUB"), entity.PaymentPartiallyRefunded, "2025-03-10T09:00:00Z")
+	add(courseB, money.New(5000, "EUR"), entity.PaymentSucceeded, "2025-03-31T23:30:00Z")
+	add(courseB, money.New(5000, "EUR"), entity.PaymentPending, "2025-04-02T12:00:00Z")
+
+	paymentRepo.Refunds[uuid.New()] = &entity.Refund{RefundID: uuid.New(), PaymentID: refunded.PaymentID, Amount: money.New(30000, "RUB"), Status: entity.RefundSucceeded}
+	// незавершенный возврат в отчет не попадает
+	paymentRepo.Refunds[uuid.New()] = &entity.Refund{RefundID: uuid.New(), PaymentID: refunded.PaymentID, Amount: money.New(10000, "RUB"), Status: entity.RefundPending}
+
+	return service, courseA, courseB
+}
+
+func TestReportService_ByCourse(t *testing.T) {
+	service, courseA, courseB := setupReportService(t)
+
+	report, err := service.RevenueReport(ReportFilter{GroupBy: ReportByCourse})
+	require.NoError(t, err)
+	require.Len(t, report.Rows, 2)
+
+	rows := map[string]int{report.Rows[0].Key: 0, report.Rows[1].Key: 1}
+	a := report.Rows[rows[courseA.String()]]
+	assert.Equal(t, "RUB", a.Currency)
+	assert.Equal(t, 3, a.Payments)
+	assert.Equal(t, 2, a.Paid)
+	assert.Equal(t, 0.6667, a.Conversion)
+	assert.Equal(t, money.New(200000, "RUB"), a.Revenue)
+	assert.Equal(t, 1, a.Refunds)
+	assert.Equal(t, money.New(30000, "RUB"), a.Refunded)
+	assert.Equal(t, money.New(170000, "RUB"), a.Net)
+
+	b := report.Rows[rows[courseB.String()]]
+	assert.Equal(t, money.New(5000, "EUR"), b.Revenue)
+	assert.Equal(t, 0.5, b.Conversion)
+
+	// итоги по каждой валюте отдельно
+	require.Len(t, report.Totals, 2)
+	assert.Equal(t, "EUR", report.Totals[0].Currency)
+	assert.Equal(t, "RUB", report.Totals[1].Currency)
+	assert.Equal(t, money.New(170000, "RUB"), report.Totals[1].Net)
+}
+
+func TestReportService_ByPeriod(t *testing.T) {
+	service, _, _ := setupReportService(t)
+
+	report, err := service.RevenueReport(ReportFilter{GroupBy: ReportByMonth})
+	require.NoError(t, err)
+	keys := make([]string, 0)
+	for _, row := range report.Rows {
+		keys = append(keys, row.Key+"/"+row.Currency)
+	}
+	assert.Equal(t, []string{"2025-03/EUR", "2025-03/RUB", "2025-04/EUR"}, keys)
+
+	// в Москве последний платеж марта уже пришелся на апрель
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	report, err = service.RevenueReport(ReportFilter{GroupBy: ReportByMonth, Currency: "EUR", Location: moscow})
+	require.NoError(t, err)
+	require.Len(t, report.Rows, 1)
+	assert.Equal(t, "2025-04", report.Rows[0].Key)
+	assert.Equal(t, 2, report.Rows[0].Payments)
+
+	report, err = service.RevenueReport(ReportFilter{GroupBy: ReportByWeek, Currency: "RUB"})
+	require.NoError(t, err)
+	require.Len(t, report.Rows, 2)
+	assert.Equal(t, "2025-03-03", report.Rows[0].Key)
+	assert.Equal(t, "2025-W10", report.Rows[0].Label)
+	assert.Equal(t, "2025-03-10", report.Rows[1].Key)
+
+	from := time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	report, err = service.RevenueReport(ReportFilter{GroupBy: ReportByDay, From: &from, To: &to})
+	require.NoError(t, err)
+	require.Len(t, report.Rows, 2)
+	assert.Equal(t, "2025-03-10", report.Rows[0].Key)
+	assert.Equal(t, "2025-03-31", report.Rows[1].Key)
+
+	_, err = service.RevenueReport(ReportFilter{GroupBy: "year"})
+	assert.ErrorIs(t, err, ErrInvalidReport)
+	_, err = service.RevenueReport(ReportFilter{From: &to, To: &from})
+	assert.ErrorIs(t, err, ErrInvalidReport)
+}
+
+func TestReportService_ByStatusAndCSV(t *testing.T) {
+	service, courseA, _ := setupReportService(t)
+
+	report, err := service.RevenueReport(ReportFilter{GroupBy: ReportByStatus, CourseID: &courseA})
+	require.NoError(t, err)
+	statuses := make([]string, 0)
+	for _, row := range report.Rows {
+		statuses = append(statuses, row.Key)
+	}
+	assert.Equal(t, []string{"canceled", "partially_refunded", "succeeded"}, statuses)
+
+	data, err := service.RevenueReportCSV(ReportFilter{GroupBy: ReportByCurrency})
+	require.NoError(t, err)
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	require.NoError(t, err)
+	// заголовок, две валюты и два итога
+	require.Len(t, rows, 5)
+	assert.Equal(t, "key", rows[0][0])
+	assert.Equal(t, []string{"RUB", "", "RUB", "3", "3000.00", "2", "0.6667", "2000.00", "1", "300.00", "1700.00"}, rows[2])
+	assert.Equal(t, "total", rows[3][0])
+}
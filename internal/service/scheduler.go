@@ -0,0 +1,22 @@
+package service
+
+import "time"
+
+// runEvery в фоне выполняет fn сразу и дальше раз в interval, пока не закроют stop
+// следующий запуск не начнется пока не закончился предыдущий
+func runEvery(interval time.Duration, stop <-chan struct{}, fn func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			fn()
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// StartSubscriptionScheduler запускает списания за продление подписок в фоне
+// списания выполняются сразу и дальше раз в BillingInterval, остановить можно закрыв stop
+func StartSubscriptionScheduler(subscriptionService *SubscriptionService, stop <-chan struct{}) {
+	interval := subscriptionService.config.Equiring.BillingInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	runEvery(interval, stop, func() {
+		if err := subscriptionService.RunBilling(); err != nil {
+			fmt.Printf("Error running subscription billing: %v\n", err)
+		}
+	})
+}
@@ -0,0 +1,559 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/gateway"
+	"mzt/internal/repository"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// план не найден или снят с продажи
+	ErrPlanNotFound = errors.New("plan not found")
+	// некорректные данные плана от админа
+	ErrInvalidPlan = errors.New("invalid plan")
+	// подписка не найдена или принадлежит другому пользователю
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	// у пользователя уже есть действующая подписка на этот план
+	// отмененную, но еще оплаченную подписку не оформляют заново, а возобновляют
+	ErrAlreadySubscribed = errors.New("already subscribed to this plan")
+	// возобновить можно только отмененную подписку до конца оплаченного периода
+	ErrSubscriptionNotResumable = errors.New("subscription can not be resumed")
+	// подписка уже отменена или истекла
+	ErrSubscriptionNotActive = errors.New("subscription is not active")
+)
+
+// сервис для работы с подписками
+// отвечает за планы, оформление и отмену подписок и списания за продление
+type SubscriptionService struct {
+	config           *config.Config
+	subscriptionRepo repository.SubscriptionRepository
+	courseRepo       repository.CourseRepository
+	paymentRepo      repository.PaymentRepository
+	payments         *PaymentService
+}
+
+// создаем новый сервис для работы с подписками
+// платежи по подпискам создаются и проводятся через сервис платежей
+func NewSubscriptionService(cfg *config.Config, subscriptionRepo repository.SubscriptionRepository, courseRepo repository.CourseRepository, paymentRepo repository.PaymentRepository, payments *PaymentService) *SubscriptionService {
+	return &SubscriptionService{
+		config:           cfg,
+		subscriptionRepo: subscriptionRepo,
+		courseRepo:       courseRepo,
+		paymentRepo:      paymentRepo,
+		payments:         payments,
+	}
+}
+
+// получает список планов
+// если onlyActive - только те на которые можно подписаться
+func (s *SubscriptionService) GetPlans(onlyActive bool) ([]dto.PlanDto, error) {
+	plans, err := s.subscriptionRepo.GetPlans(onlyActive)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.PlanDto, 0, len(plans))
+	for i := range plans {
+		result = append(result, planDto(&plans[i]))
+	}
+	return result, nil
+}
+
+// создает новый план
+func (s *SubscriptionService) CreatePlan(payload *dto.CreatePlanDto) (*dto.PlanDto, error) {
+	plan := &entity.Plan{PlanID: uuid.New(), Active: true}
+	if err := s.fillPlan(plan, payload); err != nil {
+		return nil, err
+	}
+	if err := s.subscriptionRepo.CreatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	result := planDto(plan)
+	return &result, nil
+}
+
+// обновляет план
+// новая цена и период действуют со следующего списания, уже выданный доступ не меняется
+func (s *SubscriptionService) UpdatePlan(planID uuid.UUID, payload *dto.CreatePlanDto) (*dto.PlanDto, error) {
+	plan, err := s.subscriptionRepo.GetPlanByID(planID)
+	if err != nil || plan == nil {
+		return nil, ErrPlanNotFound
+	}
+	if err := s.fillPlan(plan, payload); err != nil {
+		return nil, err
+	}
+	if err := s.subscriptionRepo.UpdatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	result := planDto(plan)
+	return &result, nil
+}
+
+// снимает план с продажи
+// план не удаляется: действующие подписки на него продолжают продлеваться пока их не отменят
+func (s *SubscriptionService) DeactivatePlan(planID uuid.UUID) error {
+	plan, err := s.subscriptionRepo.GetPlanByID(planID)
+	if err != nil || plan == nil {
+		return ErrPlanNotFound
+	}
+	plan.Active = false
+	return s.subscriptionRepo.UpdatePlan(plan)
+}
+
+// проверяет данные от админа и переносит их в план
+func (s *SubscriptionService) fillPlan(plan *entity.Plan, payload *dto.CreatePlanDto) error {
+	title := strings.TrimSpace(payload.Title)
+	if title == "" {
+		return ErrInvalidPlan
+	}
+	if !payload.Price.Valid() || !payload.Price.IsPositive() {
+		return ErrInvalidPrice
+	}
+	periodMonths := payload.PeriodMonths
+	if periodMonths == 0 {
+		periodMonths = 1
+	}
+	if periodMonths > 12 {
+		return fmt.Errorf("%w: period can not be longer than a year", ErrInvalidPlan)
+	}
+
+	// план без курсов ничего не дает, все курсы должны существовать
+	if len(payload.CourseIDs) == 0 {
+		return fmt.Errorf("%w: plan must include at least one course", ErrInvalidPlan)
+	}
+	courses := make([]entity.PlanCourse, 0, len(payload.CourseIDs))
+	seen := make(map[uuid.UUID]bool, len(payload.CourseIDs))
+	for _, courseID := range payload.CourseIDs {
+		if seen[courseID] {
+			continue
+		}
+		seen[courseID] = true
+		if course, err := s.courseRepo.GetCourse(courseID); err != nil || course == nil {
+			return fmt.Errorf("%w: course %s not found", ErrInvalidPlan, courseID)
+		}
+		courses = append(courses, entity.PlanCourse{PlanID: plan.PlanID, CourseID: courseID})
+	}
+
+	plan.Title = title
+	plan.Description = payload.Description
+	plan.Amount = payload.Price
+	plan.PeriodMonths = periodMonths
+	plan.Courses = courses
+	if payload.Active != nil {
+		plan.Active = *payload.Active
+	}
+	return nil
+}
+
+// оформляет подписку на план
+// создает первый платеж с сохранением способа оплаты и возвращает ссылку на оплату
+// доступ к курсам появляется после оплаты
+func (s *SubscriptionService) Subscribe(userID, planID uuid.UUID) (string, error) {
+	plan, err := s.subscriptionRepo.GetPlanByID(planID)
+	if err != nil || plan == nil || !plan.Active {
+		return "", ErrPlanNotFound
+	}
+
+	subscriptions, err := s.subscriptionRepo.GetSubscriptionsByUserID(userID)
+	if err != nil {
+		return "", err
+	}
+	var subscription *entity.Subscription
+	for i := range subscriptions {
+		if subscriptions[i].PlanID != planID {
+			continue
+		}
+		switch subscriptions[i].Status {
+		case entity.SubscriptionActive, entity.SubscriptionPastDue:
+			return "", ErrAlreadySubscribed
+		case entity.SubscriptionCanceled:
+			if subscriptions[i].CurrentPeriodEnd != nil && subscriptions[i].CurrentPeriodEnd.After(time.Now()) {
+				return "", ErrAlreadySubscribed
+			}
+			// оплаченный период закончился, а планировщик еще не забрал доступ - забираем сейчас,
+			// иначе он потом заберет курсы, которые выдаст новая подписка
+			if err := expireSubscription(s.subscriptionRepo, s.courseRepo, &subscriptions[i]); err != nil {
+				return "", err
+			}
+		case entity.SubscriptionPending:
+			// первая оплата не прошла, пробуем еще раз по той же подписке
+			subscription = &subscriptions[i]
+		}
+	}
+
+	if subscription == nil {
+		subscription = &entity.Subscription{
+			SubscriptionID: uuid.New(),
+			UserID:         userID,
+			PlanID:         planID,
+			Status:         entity.SubscriptionPending,
+		}
+		if err := s.subscriptionRepo.CreateSubscription(subscription); err != nil {
+			return "", errors.New("could not create subscription")
+		}
+	}
+
+	payment := &entity.Payment{
+		PaymentID:      uuid.New(),
+		UserID:         userID,
+		SubscriptionID: &subscription.SubscriptionID,
+		Amount:         plan.Amount,
+		Status:         entity.PaymentPending,
+	}
+	result, err := s.payments.checkout(payment, plan.Title, entity.PaymentSourceCheckout, &gateway.CreatePaymentRequest{
+		Description: "Подписка на план",
+		ReturnURL:   s.config.Equiring.ReturnURL,
+		// способ оплаты нужен для следующих списаний без участия пользователя
+		SavePaymentMethod: true,
+		Metadata: map[string]string{
+			"user_id":         userID.String(),
+			"subscription_id": subscription.SubscriptionID.String(),
+			"payment_id":      payment.PaymentID.String(),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.ConfirmationURL, nil
+}
+
+// получает все подписки пользователя
+func (s *SubscriptionService) GetUserSubscriptions(userID uuid.UUID) ([]dto.SubscriptionDto, error) {
+	subscriptions, err := s.subscriptionRepo.GetSubscriptionsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.SubscriptionDto, 0, len(subscriptions))
+	for i := range subscriptions {
+		result = append(result, subscriptionDto(&subscriptions[i]))
+	}
+	return result, nil
+}
+
+// отменяет продление подписки
+// доступ к курсам остается до конца оплаченного периода, неоплаченная подписка завершается сразу
+func (s *SubscriptionService) CancelSubscription(userID, subscriptionID uuid.UUID) (*dto.SubscriptionDto, error) {
+	subscription, err := s.subscriptionRepo.GetSubscriptionByID(subscriptionID)
+	if err != nil || subscription == nil || subscription.UserID != userID {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	now := time.Now()
+	switch subscription.Status {
+	case entity.SubscriptionPending:
+		err = expireSubscription(s.subscriptionRepo, s.courseRepo, subscription)
+	case entity.SubscriptionActive, entity.SubscriptionPastDue:
+		subscription.CanceledAt = &now
+		// продление не прошло и оплаченный период уже закончился - держать доступ больше не за что
+		if subscription.CurrentPeriodEnd == nil || !subscription.CurrentPeriodEnd.After(now) {
+			err = expireSubscription(s.subscriptionRepo, s.courseRepo, subscription)
+			break
+		}
+		subscription.Status = entity.SubscriptionCanceled
+		subscription.NextChargeAt = nil
+		subscription.GraceUntil = nil
+		err = s.subscriptionRepo.UpdateSubscription(subscription)
+	default:
+		return nil, ErrSubscriptionNotActive
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := subscriptionDto(subscription)
+	return &result, nil
+}
+
+// возобновляет отмененную подписку пока не закончился оплаченный период
+// следующее списание будет в конце периода, как будто подписку не отменяли
+func (s *SubscriptionService) ResumeSubscription(userID, subscriptionID uuid.UUID) (*dto.SubscriptionDto, error) {
+	subscription, err := s.subscriptionRepo.GetSubscriptionByID(subscriptionID)
+	if err != nil || subscription == nil || subscription.UserID != userID {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	if subscription.Status != entity.SubscriptionCanceled || subscription.CurrentPeriodEnd == nil || !subscription.CurrentPeriodEnd.After(time.Now()) {
+		return nil, ErrSubscriptionNotResumable
+	}
+	nextChargeAt := *subscription.CurrentPeriodEnd
+	subscription.Status = entity.SubscriptionActive
+	subscription.CanceledAt = nil
+	subscription.NextChargeAt = &nextChargeAt
+	if err := s.subscriptionRepo.UpdateSubscription(subscription); err != nil {
+		return nil, err
+	}
+
+	result := subscriptionDto(subscription)
+	return &result, nil
+}
+
+// списывает деньги за продление подписок и забирает доступ у истекших
+// ошибка по одной подписке не останавливает обработку остальных
+func (s *SubscriptionService) RunBilling() error {
+	now := time.Now()
+	due, err := s.subscriptionRepo.GetDueSubscriptions(now)
+	if err != nil {
+		return err
+	}
+	for _, subscription := range due {
+		if err := s.renewSubscription(subscription, now); err != nil {
+			fmt.Printf("Error renewing subscription %s: %v\n", subscription.SubscriptionID, err)
+		}
+	}
+
+	lapsed, err := s.subscriptionRepo.GetLapsedSubscriptions(now)
+	if err != nil {
+		return err
+	}
+	for _, subscription := range lapsed {
+		if err := expireSubscription(s.subscriptionRepo, s.courseRepo, subscription); err != nil {
+			fmt.Printf("Error expiring subscription %s: %v\n", subscription.SubscriptionID, err)
+		}
+	}
+	return nil
+}
+
+// списывает деньги за следующий период сохраненным способом оплаты
+// если списание не прошло - подписка переходит в past_due и попытка повторяется через RenewalRetryInterval
+func (s *SubscriptionService) renewSubscription(subscription *entity.Subscription, now time.Time) error {
+	// забираем продление себе: если его уже начал другой экземпляр приложения, второй раз не списываем
+	// если упадем посреди списания, следующая попытка будет через RenewalRetryInterval
+	until := now.Add(s.config.Equiring.RenewalRetryInterval)
+	claimed, err := s.subscriptionRepo.ClaimDueSubscription(subscription.SubscriptionID, now, until)
+	if err != nil || !claimed {
+		return err
+	}
+	subscription.NextChargeAt = &until
+
+	// предыдущее списание еще не завершилось, его проведет вебхук или сверка
+	payments, err := s.paymentRepo.GetPaymentsBySubscriptionID(subscription.SubscriptionID)
+	if err != nil {
+		return err
+	}
+	for _, payment := range payments {
+		if payment.Status == entity.PaymentPending || payment.Status == entity.PaymentProcessing {
+			return nil
+		}
+	}
+
+	// провайдер не сохранил способ оплаты - списывать нечем
+	if subscription.PaymentMethod == nil {
+		return s.markPastDue(subscription, now)
+	}
+
+	payment := &entity.Payment{
+		PaymentID:      uuid.New(),
+		UserID:         subscription.UserID,
+		SubscriptionID: &subscription.SubscriptionID,
+		Amount:         subscription.Plan.Amount,
+		Status:         entity.PaymentPending,
+	}
+	result, err := s.payments.checkout(payment, subscription.Plan.Title, entity.PaymentSourceScheduler, &gateway.CreatePaymentRequest{
+		Description:      "Продление подписки",
+		PaymentMethodRef: subscription.PaymentMethod.Ref,
+		Metadata: map[string]string{
+			"user_id":         subscription.UserID.String(),
+			"subscription_id": subscription.SubscriptionID.String(),
+			"payment_id":      payment.PaymentID.String(),
+		},
+	})
+	if err != nil {
+		// провайдер не принял списание, платеж не должен блокировать следующую попытку
+		if payment.PaymentRef == "" {
+			if cancelErr := s.paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentCanceled, entity.PaymentSourceScheduler, err.Error()); cancelErr != nil {
+				fmt.Printf("Error canceling renewal payment: %v\n", cancelErr)
+			}
+		}
+		fmt.Printf("Error charging subscription %s: %v\n", subscription.SubscriptionID, err)
+		return s.markPastDue(subscription, now)
+	}
+
+	switch result.Status {
+	case gateway.StatusSucceeded:
+		return s.payments.settlePayment(payment, result, entity.PaymentSourceScheduler, string(result.Raw))
+	case gateway.StatusCanceled:
+		err := s.paymentRepo.TransitionPaymentStatus(payment.PaymentID, entity.PaymentCanceled, entity.PaymentSourceScheduler, string(result.Raw))
+		if err != nil {
+			return err
+		}
+		return s.markPastDue(subscription, now)
+	default:
+		// списание еще обрабатывается, результат придет в уведомлении
+		return nil
+	}
+}
+
+// переводит подписку в past_due после неудачного списания
+// льготный период отсчитывается от конца оплаченного и не продлевается повторными неудачами
+func (s *SubscriptionService) markPastDue(subscription *entity.Subscription, now time.Time) error {
+	subscription.Status = entity.SubscriptionPastDue
+	if subscription.GraceUntil == nil {
+		graceUntil := now.Add(s.config.Equiring.SubscriptionGracePeriod)
+		if subscription.CurrentPeriodEnd != nil {
+			graceUntil = subscription.CurrentPeriodEnd.Add(s.config.Equiring.SubscriptionGracePeriod)
+		}
+		subscription.GraceUntil = &graceUntil
+	}
+	nextChargeAt := now.Add(s.config.Equiring.RenewalRetryInterval)
+	subscription.NextChargeAt = &nextChargeAt
+	return s.subscriptionRepo.UpdateSubscription(subscription)
+}
+
+// продлевает подписку после оплаты очередного периода
+// сохраняет способ оплаты для следующих списаний и выдает доступ к курсам плана
+func activateSubscription(subscriptionRepo repository.SubscriptionRepository, courseRepo repository.CourseRepository, payment *entity.Payment, remote *gateway.Payment) error {
+	subscription, err := subscriptionRepo.GetSubscriptionByID(*payment.SubscriptionID)
+	if err != nil {
+		return err
+	}
+	// период за этот платеж уже засчитан, осталось только выдать доступ
+	if subscription.LastPaymentID != nil && *subscription.LastPaymentID == payment.PaymentID {
+		return grantSubscriptionAccess(courseRepo, subscription)
+	}
+
+	if remote.PaymentMethod != nil && remote.PaymentMethod.Saved {
+		method, err := subscriptionRepo.GetPaymentMethodByRef(remote.PaymentMethod.Ref)
+		if err != nil || method == nil {
+			method = &entity.PaymentMethod{
+				PaymentMethodID: uuid.New(),
+				UserID:          payment.UserID,
+				Ref:             remote.PaymentMethod.Ref,
+				Title:           remote.PaymentMethod.Title,
+			}
+			if err := subscriptionRepo.CreatePaymentMethod(method); err != nil {
+				return err
+			}
+		}
+		subscription.PaymentMethodID = &method.PaymentMethodID
+	}
+
+	// новый период начинается с конца оплаченного, истекшая подписка начинается заново
+	now := time.Now()
+	months := int(subscription.Plan.PeriodMonths)
+	if months == 0 {
+		months = 1
+	}
+	start := now
+	if subscription.CurrentPeriodEnd != nil && subscription.Status != entity.SubscriptionExpired {
+		start = *subscription.CurrentPeriodEnd
+	}
+	periodEnd := start.AddDate(0, months, 0)
+	if !periodEnd.After(now) {
+		periodEnd = now.AddDate(0, months, 0)
+	}
+	subscription.CurrentPeriodEnd = &periodEnd
+	subscription.GraceUntil = nil
+	subscription.LastPaymentID = &payment.PaymentID
+
+	// отмененная подписка дальше не продлевается, но оплаченный период засчитываем
+	if subscription.Status != entity.SubscriptionCanceled {
+		subscription.Status = entity.SubscriptionActive
+		subscription.NextChargeAt = &periodEnd
+	}
+	if err := subscriptionRepo.UpdateSubscription(subscription); err != nil {
+		return err
+	}
+	return grantSubscriptionAccess(courseRepo, subscription)
+}
+
+// записывает пользователя на курсы плана
+// курсы на которые пользователь уже записан не трогаем, в том числе купленные отдельно
+func grantSubscriptionAccess(courseRepo repository.CourseRepository, subscription *entity.Subscription) error {
+	for _, course := range subscription.Plan.Courses {
+		existing, err := courseRepo.GetCourseAssignment(course.CourseID, subscription.UserID)
+		if err == nil && existing != nil {
+			continue
+		}
+
+		err = courseRepo.CreateCourseAssignment(&entity.CourseAssignment{
+			CaID:           uuid.New(),
+			UserID:         subscription.UserID,
+			CourseID:       course.CourseID,
+			SubscriptionID: &subscription.SubscriptionID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// завершает подписку и забирает доступ к курсам выданным по ней
+// купленные отдельно курсы остаются, курсы из других действующих подписок пользователя выдаются заново
+func expireSubscription(subscriptionRepo repository.SubscriptionRepository, courseRepo repository.CourseRepository, subscription *entity.Subscription) error {
+	subscription.Status = entity.SubscriptionExpired
+	subscription.NextChargeAt = nil
+	subscription.GraceUntil = nil
+	if err := subscriptionRepo.UpdateSubscription(subscription); err != nil {
+		return err
+	}
+	if err := courseRepo.DeleteSubscriptionAssignments(subscription.SubscriptionID); err != nil {
+		return err
+	}
+	return restoreSubscriptionAccess(subscriptionRepo, courseRepo, subscription.UserID)
+}
+
+// заново выдает доступ к курсам всех действующих подписок пользователя
+// нужно после того как у пользователя забрали курсы которые входят и в его подписки
+func restoreSubscriptionAccess(subscriptionRepo repository.SubscriptionRepository, courseRepo repository.CourseRepository, userID uuid.UUID) error {
+	subscriptions, err := subscriptionRepo.GetSubscriptionsByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for i := range subscriptions {
+		switch subscriptions[i].Status {
+		case entity.SubscriptionActive, entity.SubscriptionPastDue, entity.SubscriptionCanceled:
+			if err := grantSubscriptionAccess(courseRepo, &subscriptions[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// преобразует план в формат для response
+func planDto(plan *entity.Plan) dto.PlanDto {
+	courseIDs := make([]uuid.UUID, 0, len(plan.Courses))
+	for _, course := range plan.Courses {
+		courseIDs = append(courseIDs, course.CourseID)
+	}
+	return dto.PlanDto{
+		PlanID:       plan.PlanID,
+		Title:        plan.Title,
+		Description:  plan.Description,
+		Price:        plan.Amount,
+		PeriodMonths: plan.PeriodMonths,
+		Active:       plan.Active,
+		CourseIDs:    courseIDs,
+		CreatedAt:    plan.CreatedAt,
+	}
+}
+
+// преобразует подписку в формат для response
+func subscriptionDto(subscription *entity.Subscription) dto.SubscriptionDto {
+	result := dto.SubscriptionDto{
+		SubscriptionID:   subscription.SubscriptionID,
+		Plan:             planDto(&subscription.Plan),
+		Status:           string(subscription.Status),
+		CurrentPeriodEnd: subscription.CurrentPeriodEnd,
+		NextChargeAt:     subscription.NextChargeAt,
+		GraceUntil:       subscription.GraceUntil,
+		CanceledAt:       subscription.CanceledAt,
+		CreatedAt:        subscription.CreatedAt,
+	}
+	if subscription.PaymentMethod != nil {
+		result.PaymentMethod = subscription.PaymentMethod.Title
+	}
+	return result
+}
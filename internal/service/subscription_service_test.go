@@ -0,0 +1,298 @@
+package service
+
+import (
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/mocks"
+	"mzt/internal/money"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// поднимает сервис подписок поверх тех же моков что и сервис платежей
+func setupSubscriptionService(t *testing.T) (*SubscriptionService, *mocks.FakeYooKassa, *mocks.MockPaymentRepository, *mocks.MockCourseRepository, *mocks.MockSubscriptionRepository) {
+	paymentService, fake, paymentRepo, courseRepo := setupPaymentService(t)
+	paymentService.config.Equiring.RenewalRetryInterval = time.Hour * 24
+	paymentService.config.Equiring.SubscriptionGracePeriod = time.Hour * 72
+
+	subscriptionService := NewSubscriptionService(paymentService.config, paymentService.subscriptionRepo, courseRepo, paymentRepo, paymentService)
+	return subscriptionService, fake, paymentRepo, courseRepo, paymentService.subscriptionRepo.(*mocks.MockSubscriptionRepository)
+}
+
+// создает месячный план на два курса
+func createTestPlan(t *testing.T, service *SubscriptionService, courseRepo *mocks.MockCourseRepository) *dto.PlanDto {
+	courseIDs := []uuid.UUID{uuid.New(), uuid.New()}
+	for _, courseID := range courseIDs {
+		courseRepo.Courses[courseID] = &entity.Course{CourseID: courseID, Title: "Test Course"}
+	}
+
+	plan, err := service.CreatePlan(&dto.CreatePlanDto{Title: "Все курсы", Price: money.New(99000, "RUB"), CourseIDs: courseIDs})
+	require.NoError(t, err)
+	return plan
+}
+
+// оформляет подписку, оплачивает первый платеж через уведомление и возвращает подписку
+func subscribeAndPay(t *testing.T, service *SubscriptionService, fake *mocks.FakeYooKassa, paymentRepo *mocks.MockPaymentRepository, subscriptionRepo *mocks.MockSubscriptionRepository, userID, planID uuid.UUID) *entity.Subscription {
+	url, err := service.Subscribe(userID, planID)
+	require.NoError(t, err)
+	require.NotEmpty(t, url)
+
+	for _, payment := range paymentRepo.Payments {
+		if payment.UserID == userID && payment.SubscriptionID != nil && payment.Status == entity.PaymentProcessing {
+			fake.SetStatus(payment.PaymentRef, "succeeded")
+			require.NoError(t, service.payments.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+			return subscriptionRepo.Subscriptions[*payment.SubscriptionID]
+		}
+	}
+	t.Fatal("subscription payment was not created")
+	return nil
+}
+
+// переносит конец оплаченного периода в прошлое, как будто месяц уже прошел
+func endPeriod(subscription *entity.Subscription, ago time.Duration) {
+	periodEnd := time.Now().Add(-ago)
+	subscription.CurrentPeriodEnd = &periodEnd
+	subscription.NextChargeAt = &periodEnd
+}
+
+func TestSubscriptionService_SubscribeGrantsAccess(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, subscriptionRepo := setupSubscriptionService(t)
+	plan := createTestPlan(t, service, courseRepo)
+	userID := uuid.New()
+
+	subscription := subscribeAndPay(t, service, fake, paymentRepo, subscriptionRepo, userID, plan.PlanID)
+
+	assert.Equal(t, entity.SubscriptionActive, subscription.Status)
+	require.NotNil(t, subscription.PaymentMethodID)
+	require.NotNil(t, subscription.CurrentPeriodEnd)
+	assert.WithinDuration(t, time.Now().AddDate(0, 1, 0), *subscription.CurrentPeriodEnd, time.Minute)
+	assert.Equal(t, subscription.CurrentPeriodEnd, subscription.NextChargeAt)
+
+	for _, courseID := range plan.CourseIDs {
+		assignment, _ := courseRepo.GetCourseAssignment(courseID, userID)
+		require.NotNil(t, assignment)
+		assert.Equal(t, subscription.SubscriptionID, *assignment.SubscriptionID)
+	}
+
+	// второй раз на тот же план подписаться нельзя
+	_, err := service.Subscribe(userID, plan.PlanID)
+	assert.ErrorIs(t, err, ErrAlreadySubscribed)
+
+	subscriptions, err := service.GetUserSubscriptions(userID)
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 1)
+	assert.Equal(t, "Bank card *4444", subscriptions[0].PaymentMethod)
+}
+
+func TestSubscriptionService_InactivePlan(t *testing.T) {
+	service, _, _, courseRepo, _ := setupSubscriptionService(t)
+	plan := createTestPlan(t, service, courseRepo)
+	require.NoError(t, service.DeactivatePlan(plan.PlanID))
+
+	_, err := service.Subscribe(uuid.New(), plan.PlanID)
+	assert.ErrorIs(t, err, ErrPlanNotFound)
+
+	_, err = service.CreatePlan(&dto.CreatePlanDto{Title: "Пустой", Price: money.New(99000, "RUB")})
+	assert.ErrorIs(t, err, ErrInvalidPlan)
+}
+
+func TestSubscriptionService_RenewalExtendsPeriod(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, subscriptionRepo := setupSubscriptionService(t)
+	plan := createTestPlan(t, service, courseRepo)
+	subscription := subscribeAndPay(t, service, fake, paymentRepo, subscriptionRepo, uuid.New(), plan.PlanID)
+	endPeriod(subscription, time.Minute)
+	previousEnd := *subscription.CurrentPeriodEnd
+
+	require.NoError(t, service.RunBilling())
+
+	assert.Equal(t, entity.SubscriptionActive, subscription.Status)
+	assert.Equal(t, previousEnd.AddDate(0, 1, 0), *subscription.CurrentPeriodEnd)
+
+	payments, err := paymentRepo.GetPaymentsBySubscriptionID(subscription.SubscriptionID)
+	require.NoError(t, err)
+	require.Len(t, payments, 2)
+	for _, payment := range payments {
+		assert.Equal(t, entity.PaymentSucceeded, payment.Status)
+	}
+
+	// до следующего периода больше ничего не списывается
+	require.NoError(t, service.RunBilling())
+	payments, _ = paymentRepo.GetPaymentsBySubscriptionID(subscription.SubscriptionID)
+	assert.Len(t, payments, 2)
+}
+
+func TestSubscriptionService_RenewalChargedOnce(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, subscriptionRepo := setupSubscriptionService(t)
+	plan := createTestPlan(t, service, courseRepo)
+	subscription := subscribeAndPay(t, service, fake, paymentRepo, subscriptionRepo, uuid.New(), plan.PlanID)
+	endPeriod(subscription, time.Minute)
+
+	// два экземпляра приложения получили одну и ту же подписку к списанию
+	now := time.Now()
+	due, err := subscriptionRepo.GetDueSubscriptions(now)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	require.NoError(t, service.renewSubscription(due[0], now))
+	require.NoError(t, service.renewSubscription(due[0], now))
+
+	payments, err := paymentRepo.GetPaymentsBySubscriptionID(subscription.SubscriptionID)
+	require.NoError(t, err)
+	assert.Len(t, payments, 2)
+}
+
+func TestSubscriptionService_FailedRenewalExpiresAfterGrace(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, subscriptionRepo := setupSubscriptionService(t)
+	plan := createTestPlan(t, service, courseRepo)
+	userID := uuid.New()
+	subscription := subscribeAndPay(t, service, fake, paymentRepo, subscriptionRepo, userID, plan.PlanID)
+
+	// один из курсов плана пользователь купил отдельно
+	purchased, _ := courseRepo.GetCourseAssignment(plan.CourseIDs[0], userID)
+	purchased.SubscriptionID = nil
+
+	fake.RecurringStatus = "canceled"
+	endPeriod(subscription, time.Minute)
+	require.NoError(t, service.RunBilling())
+
+	// в льготный период доступ остается
+	assert.Equal(t, entity.SubscriptionPastDue, subscription.Status)
+	require.NotNil(t, subscription.GraceUntil)
+	assert.WithinDuration(t, subscription.CurrentPeriodEnd.Add(time.Hour*72), *subscription.GraceUntil, time.Second)
+	assignment, _ := courseRepo.GetCourseAssignment(plan.CourseIDs[1], userID)
+	assert.NotNil(t, assignment)
+
+	// льготный период закончился
+	graceUntil := time.Now().Add(-time.Minute)
+	subscription.GraceUntil = &graceUntil
+	require.NoError(t, service.RunBilling())
+
+	assert.Equal(t, entity.SubscriptionExpired, subscription.Status)
+	assignment, _ = courseRepo.GetCourseAssignment(plan.CourseIDs[1], userID)
+	assert.Nil(t, assignment)
+	assignment, _ = courseRepo.GetCourseAssignment(plan.CourseIDs[0], userID)
+	assert.NotNil(t, assignment)
+}
+
+func TestSubscriptionService_CancelKeepsAccessUntilPeriodEnd(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, subscriptionRepo := setupSubscriptionService(t)
+	plan := createTestPlan(t, service, courseRepo)
+	userID := uuid.New()
+	subscription := subscribeAndPay(t, service, fake, paymentRepo, subscriptionRepo, userID, plan.PlanID)
+
+	// чужую подписку отменить нельзя
+	_, err := service.CancelSubscription(uuid.New(), subscription.SubscriptionID)
+	assert.ErrorIs(t, err, ErrSubscriptionNotFound)
+
+	canceled, err := service.CancelSubscription(userID, subscription.SubscriptionID)
+	require.NoError(t, err)
+	assert.Equal(t, string(entity.SubscriptionCanceled), canceled.Status)
+	assert.Nil(t, canceled.NextChargeAt)
+
+	_, err = service.CancelSubscription(userID, subscription.SubscriptionID)
+	assert.ErrorIs(t, err, ErrSubscriptionNotActive)
+
+	// до конца периода доступ есть и денег не списываем
+	require.NoError(t, service.RunBilling())
+	assignment, _ := courseRepo.GetCourseAssignment(plan.CourseIDs[0], userID)
+	assert.NotNil(t, assignment)
+	payments, _ := paymentRepo.GetPaymentsBySubscriptionID(subscription.SubscriptionID)
+	assert.Len(t, payments, 1)
+
+	periodEnd := time.Now().Add(-time.Minute)
+	subscription.CurrentPeriodEnd = &periodEnd
+	require.NoError(t, service.RunBilling())
+
+	assert.Equal(t, entity.SubscriptionExpired, subscription.Status)
+	assignment, _ = courseRepo.GetCourseAssignment(plan.CourseIDs[0], userID)
+	assert.Nil(t, assignment)
+}
+
+func TestSubscriptionService_ResumeCanceled(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, subscriptionRepo := setupSubscriptionService(t)
+	plan := createTestPlan(t, service, courseRepo)
+	userID := uuid.New()
+	subscription := subscribeAndPay(t, service, fake, paymentRepo, subscriptionRepo, userID, plan.PlanID)
+
+	// действующую подписку возобновлять нечего
+	_, err := service.ResumeSubscription(userID, subscription.SubscriptionID)
+	assert.ErrorIs(t, err, ErrSubscriptionNotResumable)
+
+	_, err = service.CancelSubscription(userID, subscription.SubscriptionID)
+	require.NoError(t, err)
+	// пока период оплачен, оформить заново нельзя - только возобновить
+	_, err = service.Subscribe(userID, plan.PlanID)
+	assert.ErrorIs(t, err, ErrAlreadySubscribed)
+	_, err = service.ResumeSubscription(uuid.New(), subscription.SubscriptionID)
+	assert.ErrorIs(t, err, ErrSubscriptionNotFound)
+
+	resumed, err := service.ResumeSubscription(userID, subscription.SubscriptionID)
+	require.NoError(t, err)
+	assert.Equal(t, string(entity.SubscriptionActive), resumed.Status)
+	assert.Nil(t, subscription.CanceledAt)
+	require.NotNil(t, subscription.NextChargeAt)
+	assert.Equal(t, *subscription.CurrentPeriodEnd, *subscription.NextChargeAt)
+
+	// продление снова списывается в конце периода
+	endPeriod(subscription, time.Minute)
+	require.NoError(t, service.RunBilling())
+	payments, _ := paymentRepo.GetPaymentsBySubscriptionID(subscription.SubscriptionID)
+	assert.Len(t, payments, 2)
+}
+
+func TestSubscriptionService_ResubscribeAfterCanceledPeriod(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, subscriptionRepo := setupSubscriptionService(t)
+	plan := createTestPlan(t, service, courseRepo)
+	userID := uuid.New()
+	old := subscribeAndPay(t, service, fake, paymentRepo, subscriptionRepo, userID, plan.PlanID)
+	_, err := service.CancelSubscription(userID, old.SubscriptionID)
+	require.NoError(t, err)
+
+	// период закончился, планировщик до подписки еще не дошел
+	periodEnd := time.Now().Add(-time.Minute)
+	old.CurrentPeriodEnd = &periodEnd
+	_, err = service.ResumeSubscription(userID, old.SubscriptionID)
+	assert.ErrorIs(t, err, ErrSubscriptionNotResumable)
+
+	subscription := subscribeAndPay(t, service, fake, paymentRepo, subscriptionRepo, userID, plan.PlanID)
+	assert.NotEqual(t, old.SubscriptionID, subscription.SubscriptionID)
+	assert.Equal(t, entity.SubscriptionExpired, subscriptionRepo.Subscriptions[old.SubscriptionID].Status)
+
+	// курсы выданы новой подпиской и планировщик их не заберет
+	require.NoError(t, service.RunBilling())
+	for _, courseID := range plan.CourseIDs {
+		assignment, _ := courseRepo.GetCourseAssignment(courseID, userID)
+		require.NotNil(t, assignment)
+		assert.Equal(t, subscription.SubscriptionID, *assignment.SubscriptionID)
+	}
+}
+
+func TestSubscriptionService_PurchaseOutlivesSubscription(t *testing.T) {
+	service, fake, paymentRepo, courseRepo, subscriptionRepo := setupSubscriptionService(t)
+	plan := createTestPlan(t, service, courseRepo)
+	userID := uuid.New()
+	subscription := subscribeAndPay(t, service, fake, paymentRepo, subscriptionRepo, userID, plan.PlanID)
+
+	// курс из подписки покупают отдельно - доступ становится постоянным
+	courseID := plan.CourseIDs[0]
+	paymentRepo.Prices[courseID] = &entity.CoursePrice{CourseID: courseID, Amount: money.New(2990000, "RUB")}
+	_, err := service.payments.CreatePayment(userID.String(), courseID.String(), "")
+	require.NoError(t, err)
+	for _, payment := range paymentRepo.Payments {
+		if payment.CourseID != nil && *payment.CourseID == courseID {
+			fake.SetStatus(payment.PaymentRef, "succeeded")
+			require.NoError(t, service.payments.HandleWebhook(webhookBody(t, fake.Webhook(payment.PaymentRef))))
+		}
+	}
+
+	require.NoError(t, expireSubscription(subscriptionRepo, courseRepo, subscription))
+
+	assignment, _ := courseRepo.GetCourseAssignment(courseID, userID)
+	require.NotNil(t, assignment)
+	assert.Nil(t, assignment.SubscriptionID)
+	assignment, _ = courseRepo.GetCourseAssignment(plan.CourseIDs[1], userID)
+	assert.Nil(t, assignment)
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/oauth"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// такого сервиса нет или вход через него выключен
+	ErrOAuthProviderUnknown = errors.New("unknown or disabled sign-in provider")
+	// state неверный, истек или уже использован, вход нужно начать заново
+	ErrOAuthStateInvalid = errors.New("sign-in attempt is invalid or expired, start again")
+	// сервис не отдал токен или данные пользователя
+	ErrOAuthFailed = errors.New("sign-in provider rejected the login")
+	// аккаунт с такой почтой уже есть, но связать их автоматически нельзя
+	ErrOAuthEmailTaken = errors.New("account with this email already exists, sign in with password and link the provider in profile")
+)
+
+// OAuthProviders сервисы через которые можно войти
+func (s *UserService) OAuthProviders() []string {
+	return oauth.Names(s.providers)
+}
+
+// StartOAuth начинает вход через внешний сервис, userId - кому привязать аккаунт, nil для входа
+// возвращает адрес страницы сервиса и state, который сервис вернет вместе с кодом
+func (s *UserService) StartOAuth(name string, userId *uuid.UUID) (string, string, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return "", "", ErrOAuthProviderUnknown
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oauth.NewVerifier()
+	if err != nil {
+		return "", "", err
+	}
+	ttl := s.config.OAuth.StateTTL
+	if ttl <= 0 {
+		ttl = time.Minute * 10
+	}
+
+	err = s.repo.CreateOAuthState(&entity.OAuthState{
+		StateHash: hashToken(state),
+		Provider:  name,
+		Verifier:  verifier,
+		UserID:    userId,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return provider.AuthCodeURL(state, verifier), state, nil
+}
+
+// SignInWithOAuth завершает вход через внешний сервис
+// ищет пользователя по привязанному аккаунту, потом по почте, иначе создает новый аккаунт без пароля
+// если у пользователя подключены одноразовые коды, вместо токенов вернется TwoFactorChallenge
+func (s *UserService) SignInWithOAuth(name string, callback *dto.OAuthCallbackDto, device Device) (uuid.UUID, string, string, error) {
+	profile, err := s.oauthProfile(name, callback, nil)
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+
+	identity, err := s.repo.GetIdentity(name, profile.Subject)
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+	if identity != nil {
+		return s.completeIdentitySignIn(identity.UserID, device)
+	}
+
+	if profile.Email != "" {
+		// сервисы могут вернуть почту в другом регистре чем ее ввели у нас
+		users, err := s.repo.GetUsersByEmailFold(profile.Email)
+		if err != nil {
+			return uuid.Nil, "", "", err
+		}
+		// непонятно какой из аккаунтов связывать
+		if len(users) > 1 {
+			return uuid.Nil, "", "", ErrOAuthEmailTaken
+		}
+		if len(users) == 1 {
+			existing := users[0]
+			// связываем только если почту подтвердили и сервис, и мы,
+			// иначе чужой аккаунт сервиса или заранее зарегистрированный на чужую почту аккаунт получат доступ
+			if !profile.EmailVerified || existing.EmailVerifiedAt == nil {
+				return uuid.Nil, "", "", ErrOAuthEmailTaken
+			}
+			linked, err := s.repo.GetUserIdentity(existing.ID, name)
+			if err != nil {
+				return uuid.Nil, "", "", err
+			}
+			if linked != nil {
+				return uuid.Nil, "", "", ErrOAuthEmailTaken
+			}
+			if err := s.linkIdentity(existing.ID, name, profile.Subject, profile.Username, device); err != nil {
+				return uuid.Nil, "", "", err
+			}
+			return s.completeIdentitySignIn(existing.ID, device)
+		}
+	}
+
+	return s.signUpWithOAuth(name, profile, device)
+}
+
+// LinkOAuth привязывает аккаунт внешнего сервиса к пользователю, вход начат через StartOAuth с его id
+func (s *UserService) LinkOAuth(userId uuid.UUID, name string, callback *dto.OAuthCallbackDto, device Device) error {
+	profile, err := s.oauthProfile(name, callback, &userId)
+	if err != nil {
+		return err
+	}
+	return s.attachIdentity(userId, name, profile.Subject, profile.Username, device)
+}
+
+// UnlinkOAuth отвязывает аккаунт внешнего сервиса
+func (s *UserService) UnlinkOAuth(userId uuid.UUID, name string, device Device) error {
+	if _, ok := s.providers[name]; !ok {
+		return ErrOAuthProviderUnknown
+	}
+	return s.unlinkIdentity(userId, name, device)
+}
+
+// ProfileStatus каких полей профиля не хватает по сравнению с обычной регистрацией
+// после входа через внешний сервис фронтенд просит их заполнить через PATCH /users/me
+func (s *UserService) ProfileStatus(userId uuid.UUID) (*dto.ProfileStatusDto, error) {
+	user, err := s.repo.GetUserWithDataById(userId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.UserData == nil {
+		return nil, errors.New("user not found")
+	}
+
+	data := user.UserData
+	fields := []struct {
+		name    string
+		missing bool
+	}{
+		{"name", data.Name == ""},
+		{"email", isPlaceholderEmail(data.Email)},
+		{"birthdate", data.Birthdate.IsZero()},
+		{"phone_number", data.PhoneNumber == ""},
+		{"telegram", data.Telegram == ""},
+		{"city", data.City == ""},
+		{"employment", data.Employment == ""},
+		{"is_business_owner", data.IsBusinessOwner == ""},
+		{"position_at_work", data.PositionAtWork == ""},
+	}
+
+	status := &dto.ProfileStatusDto{MissingFields: make([]string, 0)}
+	for _, field := range fields {
+		if field.missing {
+			status.MissingFields = append(status.MissingFields, field.name)
+		}
+	}
+	status.Complete = len(status.MissingFields) == 0
+	return status, nil
+}
+
+// проверяет state и меняет код на данные пользователя сервиса
+// userId должен совпасть с тем, кто начинал привязку, для входа - nil
+func (s *UserService) oauthProfile(name string, callback *dto.OAuthCallbackDto, userId *uuid.UUID) (*oauth.Profile, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, ErrOAuthProviderUnknown
+	}
+
+	state, err := s.repo.ConsumeOAuthState(hashToken(callback.State))
+	if err != nil {
+		return nil, err
+	}
+	if state == nil || state.Provider != name {
+		return nil, ErrOAuthStateInvalid
+	}
+	// начатый вход не завершить привязкой и наоборот
+	if (state.UserID == nil) != (userId == nil) || (userId != nil && *state.UserID != *userId) {
+		return nil, ErrOAuthStateInvalid
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+	profile, err := provider.Exchange(ctx, &oauth.Callback{
+		Code:     callback.Code,
+		State:    callback.State,
+		DeviceID: callback.DeviceID,
+	}, state.Verifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOAuthFailed, err)
+	}
+	return profile, nil
+}
+
+// создает аккаунт для нового пользователя внешнего сервиса
+// почту из сервиса берем только если сервис ее подтвердил, она сразу считается подтвержденной и у нас
+// без почты или с неподтвержденной (vk почту не подтверждает) - заглушка, настоящую пользователь подтвердит из профиля
+// занятую почту сюда не передают, на нее SignInWithOAuth отвечает ErrOAuthEmailTaken
+func (s *UserService) signUpWithOAuth(name string, profile *oauth.Profile, device Device) (uuid.UUID, string, string, error) {
+	userData := entity.UserData{
+		Email: placeholderEmail(name, profile.Subject),
+		Name:  profile.Name,
+	}
+	var verifiedAt *time.Time
+	if profile.Email != "" && profile.EmailVerified {
+		userData.Email = profile.Email
+		now := time.Now()
+		verifiedAt = &now
+	}
+
+	identity := entity.Identity{Provider: name, Subject: profile.Subject, Username: profile.Username}
+	return s.signUpWithIdentity(identity, userData, verifiedAt, device)
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"mzt/internal/dto"
+	"mzt/internal/mocks"
+	"mzt/internal/oauth"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// включает вход через google, который на самом деле ходит в фейковый провайдер
+func setupOAuthService(t *testing.T) (*UserService, *mocks.MockUserRepository, *mocks.FakeOIDC, uuid.UUID) {
+	service, repo, _, userId := setupProfileService(t)
+	fake, server := mocks.NewFakeOIDCServer("client", "secret")
+	t.Cleanup(server.Close)
+
+	service.config.OAuth.RedirectURL = "https://mzt-study.ru/oauth/callback"
+	service.config.OAuth.Google.ClientID = "client"
+	service.config.OAuth.Google.ClientSecret = "secret"
+	service.config.OAuth.Google.AuthURL = server.URL + "/authorize"
+	service.config.OAuth.Google.TokenURL = server.URL + "/token"
+	service.config.OAuth.Google.UserInfoURL = server.URL + "/userinfo"
+	service.providers = oauth.Providers(service.config)
+	return service, repo, fake, userId
+}
+
+// проходит страницу провайдера и возвращает то, что фронтенд пришлет в callback
+func authorizeOAuth(t *testing.T, service *UserService, fake *mocks.FakeOIDC, userId *uuid.UUID) *dto.OAuthCallbackDto {
+	url, state, err := service.StartOAuth(oauth.Google, userId)
+	require.NoError(t, err)
+	code, returned, err := fake.Authorize(url)
+	require.NoError(t, err)
+	require.Equal(t, state, returned)
+	return &dto.OAuthCallbackDto{Code: code, State: state}
+}
+
+func TestService_SignInWithOAuth(t *testing.T) {
+	service, repo, fake, userId := setupOAuthService(t)
+	assert.Equal(t, []string{oauth.Google}, service.OAuthProviders())
+	_, _, err := service.StartOAuth(oauth.VK, nil)
+	require.ErrorIs(t, err, ErrOAuthProviderUnknown)
+
+	fake.SetUser(mocks.FakeOIDCUser{Subject: "g-1", Email: "new@example.com", EmailVerified: true, Name: "New User"})
+	callback := authorizeOAuth(t, service, fake, nil)
+	id, access, refresh, err := service.SignInWithOAuth(oauth.Google, callback, Device{})
+	require.NoError(t, err)
+	assert.NotEqual(t, userId, id)
+	assert.NotEmpty(t, access)
+	assert.NotEmpty(t, refresh)
+	assert.Equal(t, "new@example.com", repo.UserData[id].Email)
+	assert.Equal(t, "New User", repo.UserData[id].Name)
+	assert.NotNil(t, repo.Users[id].EmailVerifiedAt)
+	assert.Empty(t, repo.Users[id].PasswdHash)
+	assert.Equal(t, id, repo.Identities["google:g-1"].UserID)
+
+	// state одноразовый
+	_, _, _, err = service.SignInWithOAuth(oauth.Google, callback, Device{})
+	require.ErrorIs(t, err, ErrOAuthStateInvalid)
+
+	status, err := service.ProfileStatus(id)
+	require.NoError(t, err)
+	assert.False(t, status.Complete)
+	assert.Contains(t, status.MissingFields, "phone_number")
+	assert.NotContains(t, status.MissingFields, "email")
+	assert.NotContains(t, status.MissingFields, "name")
+
+	// повторный вход находит тот же аккаунт
+	again, _, _, err := service.SignInWithOAuth(oauth.Google, authorizeOAuth(t, service, fake, nil), Device{})
+	require.NoError(t, err)
+	assert.Equal(t, id, again)
+
+	// без почты аккаунт получает заглушку и должен указать почту
+	fake.SetUser(mocks.FakeOIDCUser{Subject: "g-2"})
+	noEmail, _, _, err := service.SignInWithOAuth(oauth.Google, authorizeOAuth(t, service, fake, nil), Device{})
+	require.NoError(t, err)
+	assert.Equal(t, "google-g-2@google.invalid", repo.UserData[noEmail].Email)
+	status, err = service.ProfileStatus(noEmail)
+	require.NoError(t, err)
+	assert.Contains(t, status.MissingFields, "email")
+
+	// неподтвержденную сервисом почту в профиль не берем, иначе можно занять чужой адрес
+	fake.SetUser(mocks.FakeOIDCUser{Subject: "g-3", Email: "someone@example.com"})
+	unverified, _, _, err := service.SignInWithOAuth(oauth.Google, authorizeOAuth(t, service, fake, nil), Device{})
+	require.NoError(t, err)
+	assert.Equal(t, "google-g-3@google.invalid", repo.UserData[unverified].Email)
+	assert.Nil(t, repo.Users[unverified].EmailVerifiedAt)
+	users, err := repo.GetUsersByEmailFold("someone@example.com")
+	require.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func TestService_OAuthEmailLinking(t *testing.T) {
+	service, repo, fake, userId := setupOAuthService(t)
+
+	// почта пользователя у нас не подтверждена - автоматически не связываем
+	fake.SetUser(mocks.FakeOIDCUser{Subject: "g-1", Email: "test@example.com", EmailVerified: true})
+	_, _, _, err := service.SignInWithOAuth(oauth.Google, authorizeOAuth(t, service, fake, nil), Device{})
+	require.ErrorIs(t, err, ErrOAuthEmailTaken)
+
+	now := time.Now()
+	repo.Users[userId].EmailVerifiedAt = &now
+	// провайдер почту не подтвердил - тоже не связываем
+	fake.SetUser(mocks.FakeOIDCUser{Subject: "g-1", Email: "test@example.com"})
+	_, _, _, err = service.SignInWithOAuth(oauth.Google, authorizeOAuth(t, service, fake, nil), Device{})
+	require.ErrorIs(t, err, ErrOAuthEmailTaken)
+
+	// почта совпадает без учета регистра
+	fake.SetUser(mocks.FakeOIDCUser{Subject: "g-1", Email: "Test@Example.com", EmailVerified: true})
+	id, _, _, err := service.SignInWithOAuth(oauth.Google, authorizeOAuth(t, service, fake, nil), Device{})
+	require.NoError(t, err)
+	assert.Equal(t, userId, id)
+	assert.Equal(t, userId, repo.Identities["google:g-1"].UserID)
+
+	// другой google аккаунт с той же почтой к уже связанному аккаунту не пускает
+	fake.SetUser(mocks.FakeOIDCUser{Subject: "g-2", Email: "test@example.com", EmailVerified: true})
+	_, _, _, err = service.SignInWithOAuth(oauth.Google, authorizeOAuth(t, service, fake, nil), Device{})
+	require.ErrorIs(t, err, ErrOAuthEmailTaken)
+}
+
+func TestService_LinkOAuth(t *testing.T) {
+	service, repo, fake, userId := setupOAuthService(t)
+	fake.SetUser(mocks.FakeOIDCUser{Subject: "g-1", Email: "other@example.com", EmailVerified: true})
+
+	// state для входа не подходит для привязки и наоборот
+	require.ErrorIs(t, service.LinkOAuth(userId, oauth.Google, authorizeOAuth(t, service, fake, nil), Device{}), ErrOAuthStateInvalid)
+	_, _, _, err := service.SignInWithOAuth(oauth.Google, authorizeOAuth(t, service, fake, &userId), Device{})
+	require.ErrorIs(t, err, ErrOAuthStateInvalid)
+	stranger := uuid.New()
+	require.ErrorIs(t, service.LinkOAuth(stranger, oauth.Google, authorizeOAuth(t, service, fake, &userId), Device{}), ErrOAuthStateInvalid)
+
+	require.NoError(t, service.LinkOAuth(userId, oauth.Google, authorizeOAuth(t, service, fake, &userId), Device{}))
+	assert.Equal(t, userId, repo.Identities["google:g-1"].UserID)
+
+	// теперь вход через google попадает в аккаунт с паролем, хотя почты разные
+	id, _, _, err := service.SignInWithOAuth(oauth.Google, authorizeOAuth(t, service, fake, nil), Device{})
+	require.NoError(t, err)
+	assert.Equal(t, userId, id)
+
+	require.ErrorIs(t, service.UnlinkOAuth(userId, oauth.VK, Device{}), ErrOAuthProviderUnknown)
+	require.NoError(t, service.UnlinkOAuth(userId, oauth.Google, Device{}))
+	require.ErrorIs(t, service.UnlinkOAuth(userId, oauth.Google, Device{}), ErrIdentityNotLinked)
+}
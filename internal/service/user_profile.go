@@ -0,0 +1,213 @@
+package service
+
+import (
+	"errors"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/validator"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// текущий пароль указан неверно
+	ErrWrongPassword = errors.New("current password is incorrect")
+	// почта уже занята другим аккаунтом или совпадает с текущей
+	ErrEmailTaken = errors.New("email is already in use")
+	// у аккаунта нет пароля: перед сменой пароля или почты нужно заново войти или ввести код из приложения
+	ErrReauthRequired = errors.New("sign in again or enter a two-factor code")
+)
+
+// UpdateProfile меняет данные профиля пользователя, пустые поля запроса не трогает
+// возвращает обновленный профиль
+func (s *UserService) UpdateProfile(userId uuid.UUID, patch *dto.UpdateProfileDto) (*dto.UserInfoDto, error) {
+	user, err := s.repo.GetUserWithDataById(userId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.UserData == nil {
+		return nil, errors.New("user not found")
+	}
+
+	data := *user.UserData
+	if patch.Name != nil {
+		data.Name = *patch.Name
+	}
+	if patch.Birthdate != nil {
+		data.Birthdate = *patch.Birthdate
+		// возраст считается так же как при обновлении админом
+		data.Age = uint(time.Since(data.Birthdate).Hours() / 24 / 365.25)
+	}
+	if patch.PhoneNumber != nil {
+		data.PhoneNumber = *patch.PhoneNumber
+	}
+	if patch.Telegram != nil {
+		data.Telegram = *patch.Telegram
+	}
+	if patch.City != nil {
+		data.City = *patch.City
+	}
+	if patch.Employment != nil {
+		data.Employment = *patch.Employment
+	}
+	if patch.IsBusinessOwner != nil {
+		data.IsBusinessOwner = *patch.IsBusinessOwner
+	}
+	if patch.PositionAtWork != nil {
+		data.PositionAtWork = *patch.PositionAtWork
+	}
+	if patch.MonthIncome != nil {
+		data.MonthIncome = *patch.MonthIncome
+	}
+
+	if err := s.repo.UpdateUser(userId, &data); err != nil {
+		return nil, err
+	}
+
+	return &dto.UserInfoDto{
+		Name:            data.Name,
+		Birthdate:       data.Birthdate,
+		Email:           data.Email,
+		PhoneNumber:     data.PhoneNumber,
+		Telegram:        data.Telegram,
+		City:            data.City,
+		Age:             data.Age,
+		Employment:      data.Employment,
+		IsBusinessOwner: data.IsBusinessOwner,
+		PositionAtWork:  data.PositionAtWork,
+		MonthIncome:     data.MonthIncome,
+		EmailVerified:   user.EmailVerifiedAt != nil,
+	}, nil
+}
+
+// ChangePassword меняет пароль после проверки текущего
+// аккаунт без пароля задает первый пароль по коду из приложения или сразу после входа, см. reauthenticate
+// текущая сессия остается, на остальных устройствах нужно войти с новым паролем
+func (s *UserService) ChangePassword(userId uuid.UUID, sessionId uuid.UUID, current, code, password string, device Device) error {
+	user, err := s.repo.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+	if err := s.reauthenticate(user, sessionId, current, code, device); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	event := &entity.SecurityEvent{
+		UserID:    userId,
+		Type:      entity.SecurityPasswordChanged,
+		SessionID: &sessionId,
+		UserAgent: device.UserAgent,
+		IP:        device.IP,
+	}
+	return s.repo.ChangePassword(userId, string(hashedPassword), sessionId, event)
+}
+
+// RequestEmailChange отправляет на новую почту ссылку для ее подтверждения
+// почта - subject в токенах и адрес для восстановления пароля, поэтому сразу не меняется
+// владельца подтверждаем так же как при смене пароля
+func (s *UserService) RequestEmailChange(userId uuid.UUID, sessionId uuid.UUID, email, password, code string, device Device) error {
+	if !s.validator.IsValidEmail(email) {
+		return ErrInvalidEmail
+	}
+
+	user, err := s.repo.GetUserWithDataById(userId)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.UserData == nil {
+		return errors.New("user not found")
+	}
+	if err := s.reauthenticate(user, sessionId, password, code, device); err != nil {
+		return err
+	}
+	if email == user.UserData.Email {
+		return ErrEmailTaken
+	}
+	if existing, err := s.repo.GetUserByEmail(email); err == nil && existing != nil {
+		return ErrEmailTaken
+	}
+
+	return s.sendEmailLink(userId, email, validator.PurposeChangeEmail)
+}
+
+// ConfirmEmailChange меняет почту по ссылке из письма, отправленного на новую почту
+// после смены все сессии завершаются, потому что в выданных токенах старая почта
+func (s *UserService) ConfirmEmailChange(token string, device Device) error {
+	userId, email, err := s.validator.ValidateEmailToken(token, validator.PurposeChangeEmail, s.config.Auth.EmailVerifyKey)
+	if err != nil {
+		return ErrVerifyTokenInvalid
+	}
+
+	user, err := s.repo.GetUserWithDataById(userId)
+	if err != nil || user == nil || user.UserData == nil {
+		return ErrVerifyTokenInvalid
+	}
+	// по этой ссылке уже перешли
+	if user.UserData.Email == email {
+		return nil
+	}
+	// пока письмо шло, почту занял кто-то другой
+	if existing, err := s.repo.GetUserByEmail(email); err == nil && existing != nil {
+		return ErrEmailTaken
+	}
+
+	event := &entity.SecurityEvent{
+		UserID:    userId,
+		Type:      entity.SecurityEmailChanged,
+		UserAgent: device.UserAgent,
+		IP:        device.IP,
+		Details:   "from " + user.UserData.Email + " to " + email,
+	}
+	return s.repo.ChangeEmail(userId, email, time.Now(), event)
+}
+
+// подтверждает что настройки аккаунта меняет сам владелец
+// с паролем - по текущему паролю, у аккаунтов созданных через телеграм или соцсеть пароля нет:
+// им нужен код из приложения, если коды подключены, или вход через сервис не дольше ReauthWindow назад
+func (s *UserService) reauthenticate(user *entity.User, sessionId uuid.UUID, password, code string, device Device) error {
+	if user.PasswdHash != "" {
+		return checkPassword(user, password)
+	}
+
+	if code != "" {
+		twoFactor, err := s.repo.GetTwoFactor(user.ID)
+		if err != nil {
+			return err
+		}
+		if twoFactor != nil && twoFactor.EnabledAt != nil {
+			return s.checkTwoFactorCode(twoFactor, code, device)
+		}
+	}
+
+	window := s.config.Auth.ReauthWindow
+	if window <= 0 {
+		window = time.Minute * 10
+	}
+	session, err := s.repo.GetSession(sessionId)
+	if err != nil || session == nil || session.UserID != user.ID || time.Since(session.CreatedAt) > window {
+		return ErrReauthRequired
+	}
+	return nil
+}
+
+// сверяет текущий пароль перед изменением настроек аккаунта
+// у аккаунтов созданных входом через телеграм пароля нет, им его не спрашиваем
+func checkPassword(user *entity.User, password string) error {
+	if user.PasswdHash == "" {
+		return nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswdHash), []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
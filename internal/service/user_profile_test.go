@@ -0,0 +1,186 @@
+package service
+
+import (
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/mocks"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// регистрирует пользователя и возвращает сервис, моки и его id
+func setupProfileService(t *testing.T) (*UserService, *mocks.MockUserRepository, *mocks.MockMailSender, uuid.UUID) {
+	mockRepo := mocks.NewMockUserRepository()
+	mail := mocks.NewMockMailSender()
+	cfg := &config.Config{
+		Jwt: config.Jwt{
+			RefreshKey:       "test-refresh-key",
+			AccessExpiresIn:  time.Minute * 30,
+			RefreshExpiresIn: time.Hour * 24 * 14,
+		},
+		Auth: config.Auth{
+			FrontendURL:    "https://mzt-study.ru",
+			EmailVerifyKey: "test-verify-key",
+			EmailVerifyTTL: time.Hour,
+		},
+	}
+	service := NewUserService(cfg, mockRepo, mail, testKeyring(t))
+
+	userDto := &dto.RegistrationDto{
+		Email:       "test@example.com",
+		Password:    "password123",
+		Name:        "Test User",
+		PhoneNumber: "+1234567890",
+		Telegram:    "@testuser",
+		City:        "Test City",
+	}
+	_, _, err := service.SignUp(userDto, Device{UserAgent: "laptop"})
+	require.NoError(t, err)
+	userId, err := service.GetUserId(userDto.Email)
+	require.NoError(t, err)
+
+	return service, mockRepo.(*mocks.MockUserRepository), mail, userId
+}
+
+// последняя открытая сессия пользователя
+func userSession(t *testing.T, repo *mocks.MockUserRepository, userId uuid.UUID) uuid.UUID {
+	var latest *entity.Session
+	for _, session := range repo.Sessions {
+		if session.UserID == userId && (latest == nil || session.CreatedAt.After(latest.CreatedAt)) {
+			latest = session
+		}
+	}
+	require.NotNil(t, latest)
+	return latest.SessionID
+}
+
+func TestService_UpdateProfile(t *testing.T) {
+	service, repo, _, userId := setupProfileService(t)
+
+	name := "New Name"
+	city := ""
+	birthdate := time.Now().AddDate(-30, 0, -1)
+	user, err := service.UpdateProfile(userId, &dto.UpdateProfileDto{Name: &name, City: &city, Birthdate: &birthdate})
+	require.NoError(t, err)
+	assert.Equal(t, "New Name", user.Name)
+	assert.Equal(t, uint(30), user.Age)
+	// пустая строка в запросе очищает поле, а не пропускается
+	assert.Equal(t, "", user.City)
+
+	// поля которых нет в запросе не меняются
+	data := repo.UserData[userId]
+	assert.Equal(t, "+1234567890", data.PhoneNumber)
+	assert.Equal(t, "@testuser", data.Telegram)
+	assert.Equal(t, "test@example.com", data.Email)
+	assert.Equal(t, "New Name", data.Name)
+}
+
+func TestService_ChangePassword(t *testing.T) {
+	service, repo, _, userId := setupProfileService(t)
+	_, _, err := service.SignIn(&dto.LoginDto{Email: "test@example.com", Password: "password123"}, Device{UserAgent: "phone"})
+	require.NoError(t, err)
+
+	var current uuid.UUID
+	for id, session := range repo.Sessions {
+		if session.UserAgent == "laptop" {
+			current = id
+		}
+	}
+	require.Len(t, repo.Sessions, 2)
+
+	err = service.ChangePassword(userId, current, "wrongpassword", "", "newpassword1", Device{})
+	assert.ErrorIs(t, err, ErrWrongPassword)
+	assert.Len(t, repo.Sessions, 2)
+
+	require.NoError(t, service.ChangePassword(userId, current, "password123", "", "newpassword1", Device{UserAgent: "laptop"}))
+	// остается только сессия с которой меняли пароль
+	require.Len(t, repo.Sessions, 1)
+	assert.Contains(t, repo.Sessions, current)
+
+	_, _, err = service.SignIn(&dto.LoginDto{Email: "test@example.com", Password: "password123"}, Device{})
+	assert.Error(t, err)
+	_, _, err = service.SignIn(&dto.LoginDto{Email: "test@example.com", Password: "newpassword1"}, Device{})
+	assert.NoError(t, err)
+
+	events, err := service.GetSecurityEvents(userId)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, string(entity.SecurityPasswordChanged), events[0].Type)
+}
+
+func TestService_ChangeEmail(t *testing.T) {
+	service, repo, mail, userId := setupProfileService(t)
+	_, _, err := service.SignUp(&dto.RegistrationDto{Email: "other@example.com", Password: "password123"}, Device{})
+	require.NoError(t, err)
+	mail.Messages = nil
+
+	assert.ErrorIs(t, service.RequestEmailChange(userId, uuid.Nil, "not-an-email", "password123", "", Device{}), ErrInvalidEmail)
+	assert.ErrorIs(t, service.RequestEmailChange(userId, uuid.Nil, "new@example.com", "wrongpassword", "", Device{}), ErrWrongPassword)
+	assert.ErrorIs(t, service.RequestEmailChange(userId, uuid.Nil, "other@example.com", "password123", "", Device{}), ErrEmailTaken)
+	assert.ErrorIs(t, service.RequestEmailChange(userId, uuid.Nil, "test@example.com", "password123", "", Device{}), ErrEmailTaken)
+	assert.Empty(t, mail.Messages)
+
+	require.NoError(t, service.RequestEmailChange(userId, uuid.Nil, "new@example.com", "password123", "", Device{}))
+	require.Len(t, mail.Messages, 1)
+	// письмо уходит на новую почту, старая пока остается
+	assert.Equal(t, "new@example.com", mail.Messages[0].To)
+	assert.Equal(t, "test@example.com", repo.UserData[userId].Email)
+	text := mail.Messages[0].Text
+	require.Contains(t, text, "https://mzt-study.ru/confirm-email?token=")
+	token := strings.Fields(text[strings.Index(text, "token=")+len("token="):])[0]
+
+	// ссылкой на смену почты нельзя подтвердить регистрацию и наоборот
+	assert.ErrorIs(t, service.VerifyEmail(token), ErrVerifyTokenInvalid)
+	assert.ErrorIs(t, service.ConfirmEmailChange("garbage", Device{}), ErrVerifyTokenInvalid)
+
+	require.NoError(t, service.ConfirmEmailChange(token, Device{}))
+	assert.Equal(t, "new@example.com", repo.UserData[userId].Email)
+	assert.NotNil(t, repo.Users[userId].EmailVerifiedAt)
+	// все сессии завершены, входить нужно с новой почтой
+	sessions, err := repo.GetSessionsByUserID(userId)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+	_, _, err = service.SignIn(&dto.LoginDto{Email: "new@example.com", Password: "password123"}, Device{})
+	assert.NoError(t, err)
+
+	// повторный переход по ссылке не ошибка
+	require.NoError(t, service.ConfirmEmailChange(token, Device{}))
+	events, err := service.GetSecurityEvents(userId)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, string(entity.SecurityEmailChanged), events[0].Type)
+}
+
+func TestService_PasswordlessReauth(t *testing.T) {
+	service, repo, mail, _ := setupProfileService(t)
+	service.config.Auth.TelegramBotToken = testBotToken
+	tgUser, _, _, err := service.SignInWithTelegram(telegramPayload(300, "no_password"), Device{})
+	require.NoError(t, err)
+	session := userSession(t, repo, tgUser)
+	mail.Messages = nil
+
+	// со входа прошло много времени - пароля нет, поэтому без нового входа или кода ничего не поменять
+	repo.Sessions[session].CreatedAt = time.Now().Add(-time.Hour)
+	assert.ErrorIs(t, service.ChangePassword(tgUser, session, "", "", "newpassword1", Device{}), ErrReauthRequired)
+	assert.ErrorIs(t, service.RequestEmailChange(tgUser, session, "new@example.com", "", "", Device{}), ErrReauthRequired)
+	assert.ErrorIs(t, service.ChangePassword(tgUser, uuid.Nil, "", "", "newpassword1", Device{}), ErrReauthRequired)
+	assert.Empty(t, mail.Messages)
+
+	// сразу после входа через телеграм можно
+	_, _, _, err = service.SignInWithTelegram(telegramPayload(300, "no_password"), Device{})
+	require.NoError(t, err)
+	require.NoError(t, service.RequestEmailChange(tgUser, userSession(t, repo, tgUser), "new@example.com", "", "", Device{}))
+	assert.Len(t, mail.Messages, 1)
+
+	// с подключенными кодами вместо нового входа подходит код
+	_, codes := enableTwoFactor(t, service, repo, tgUser)
+	assert.ErrorIs(t, service.ChangePassword(tgUser, session, "", "wrong-code", "newpassword1", Device{}), ErrTwoFactorInvalid)
+	require.NoError(t, service.ChangePassword(tgUser, session, "", codes[0], "newpassword1", Device{}))
+	assert.NotEmpty(t, repo.Users[tgUser].PasswdHash)
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// такой роли нет
+	ErrUnknownRole = errors.New("unknown role")
+	// нельзя снять роль суперадмина с последнего суперадмина
+	ErrLastSuperadmin = errors.New("can't remove the last superadmin")
+)
+
+// GetRoles все роли с их правами
+func (s *UserService) GetRoles() ([]dto.RoleDto, error) {
+	roles, err := s.repo.GetRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.RoleDto, 0, len(roles))
+	for _, role := range roles {
+		permissions := make([]string, 0, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			permissions = append(permissions, permission.Code)
+		}
+		sort.Strings(permissions)
+		result = append(result, dto.RoleDto{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: permissions,
+		})
+	}
+	return result, nil
+}
+
+// Roles роли пользователя и все права которые они дают
+func (s *UserService) Roles(userId uuid.UUID) (*dto.UserRolesDto, error) {
+	roles, err := s.repo.GetUserRoles(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	permissions := make([]string, 0)
+	for _, role := range roles {
+		for _, permission := range role.Permissions {
+			if !seen[permission.Code] {
+				seen[permission.Code] = true
+				permissions = append(permissions, permission.Code)
+			}
+		}
+	}
+	sort.Strings(permissions)
+
+	return &dto.UserRolesDto{
+		Roles:       roleNames(roles),
+		Permissions: permissions,
+	}, nil
+}
+
+// SetUserRoles заменяет роли пользователя, пустой список оставляет пользователя без прав
+// actorId - админ который меняет роли, попадает в событие безопасности
+func (s *UserService) SetUserRoles(actorId, userId uuid.UUID, names []string, device Device) (*dto.UserRolesDto, error) {
+	user, err := s.repo.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	unique := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	roles, err := s.repo.GetRolesByNames(unique)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) != len(unique) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRole, strings.Join(unknownRoles(unique, roles), ", "))
+	}
+
+	current, err := s.repo.GetUserRoles(userId)
+	if err != nil {
+		return nil, err
+	}
+	// без суперадмина роли больше некому назначать
+	if hasRole(current, entity.RoleSuperadmin) && !seen[entity.RoleSuperadmin] {
+		count, err := s.repo.CountUsersWithRole(entity.RoleSuperadmin)
+		if err != nil {
+			return nil, err
+		}
+		if count <= 1 {
+			return nil, ErrLastSuperadmin
+		}
+	}
+
+	event := &entity.SecurityEvent{
+		UserID:    userId,
+		Type:      entity.SecurityRolesChanged,
+		UserAgent: device.UserAgent,
+		IP:        device.IP,
+		Details: fmt.Sprintf("by %s: [%s] -> [%s]", actorId,
+			strings.Join(roleNames(current), ", "), strings.Join(roleNames(roles), ", ")),
+	}
+	if err := s.repo.SetUserRoles(userId, roles, event); err != nil {
+		return nil, err
+	}
+	return s.Roles(userId)
+}
+
+// названия ролей
+func roleNames(roles []entity.Role) []string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+func hasRole(roles []entity.Role, name string) bool {
+	for _, role := range roles {
+		if role.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// какие из запрошенных ролей не нашлись
+func unknownRoles(names []string, found []entity.Role) []string {
+	unknown := make([]string, 0)
+	for _, name := range names {
+		if !hasRole(found, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
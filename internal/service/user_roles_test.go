@@ -0,0 +1,79 @@
+package service
+
+import (
+	"mzt/config"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/mocks"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Roles(t *testing.T) {
+	mockRepo := mocks.NewMockUserRepository()
+	repo := mockRepo.(*mocks.MockUserRepository)
+	cfg := &config.Config{}
+	service := NewUserService(cfg, mockRepo, mocks.NewMockMailSender(), testKeyring(t))
+
+	_, _, err := service.SignUp(&dto.RegistrationDto{Email: "student@example.com", Password: "password123"}, Device{})
+	require.NoError(t, err)
+	userId, err := service.GetUserId("student@example.com")
+	require.NoError(t, err)
+
+	// после регистрации пользователь студент и прав у него нет
+	roles, err := service.Roles(userId)
+	require.NoError(t, err)
+	assert.Equal(t, []string{entity.RoleStudent}, roles.Roles)
+	assert.Empty(t, roles.Permissions)
+
+	adminId := uuid.New()
+	repo.Users[adminId] = &entity.User{ID: adminId, Roles: []entity.Role{repo.Roles[entity.RoleSuperadmin]}}
+
+	_, err = service.SetUserRoles(adminId, userId, []string{entity.RoleFinance, "god"}, Device{})
+	assert.ErrorIs(t, err, ErrUnknownRole)
+
+	roles, err = service.SetUserRoles(adminId, userId, []string{entity.RoleFinance, entity.RoleCurator, entity.RoleFinance}, Device{IP: "127.0.0.1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{entity.RoleCurator, entity.RoleFinance}, roles.Roles)
+	assert.Contains(t, roles.Permissions, entity.PermReportsRead)
+	assert.Contains(t, roles.Permissions, entity.PermEnrollmentsWrite)
+	assert.NotContains(t, roles.Permissions, entity.PermRolesWrite)
+	// права от двух ролей не дублируются
+	assert.Len(t, roles.Permissions, 9)
+
+	events, err := service.GetSecurityEvents(userId)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, string(entity.SecurityRolesChanged), events[0].Type)
+	assert.Contains(t, events[0].Details, adminId.String())
+
+	all, err := service.GetRoles()
+	require.NoError(t, err)
+	assert.Len(t, all, len(entity.BuiltinRoles))
+}
+
+func TestService_LastSuperadmin(t *testing.T) {
+	mockRepo := mocks.NewMockUserRepository()
+	repo := mockRepo.(*mocks.MockUserRepository)
+	service := NewUserService(&config.Config{}, mockRepo, mocks.NewMockMailSender(), testKeyring(t))
+
+	adminId := uuid.New()
+	repo.Users[adminId] = &entity.User{ID: adminId, Roles: []entity.Role{repo.Roles[entity.RoleSuperadmin]}}
+
+	// единственный суперадмин не может снять роль с себя
+	_, err := service.SetUserRoles(adminId, adminId, []string{entity.RoleFinance}, Device{})
+	assert.ErrorIs(t, err, ErrLastSuperadmin)
+
+	otherId := uuid.New()
+	repo.Users[otherId] = &entity.User{ID: otherId}
+	_, err = service.SetUserRoles(adminId, otherId, []string{entity.RoleSuperadmin}, Device{})
+	require.NoError(t, err)
+
+	roles, err := service.SetUserRoles(otherId, adminId, nil, Device{})
+	require.NoError(t, err)
+	assert.Empty(t, roles.Roles)
+	assert.Empty(t, roles.Permissions)
+}
@@ -1,12 +1,21 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"mzt/config"
 	"mzt/internal/dto"
 	"mzt/internal/entity"
+	"mzt/internal/jwtkeys"
+	"mzt/internal/mailer"
+	"mzt/internal/oauth"
 	"mzt/internal/repository"
 	"mzt/internal/validator"
+	"strings"
 
 	"time"
 
@@ -14,31 +23,58 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-type Role int
-
-const (
-	Default Role = iota // обычный пользователь
-	Admin               // администратор
+var (
+	// сессия не найдена, уже завершена или принадлежит другому пользователю
+	ErrSessionNotFound = errors.New("session not found")
+	// предъявили refresh токен который уже обменяли на новый, сессия отозвана
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected, session revoked")
+	// ссылка для сброса пароля неверная, истекла или уже использована
+	ErrResetTokenInvalid = errors.New("password reset link is invalid or expired")
+	// почта в неправильном формате
+	ErrInvalidEmail = errors.New("invalid email")
+	// ссылка для подтверждения почты неверная, истекла или выдана для другой почты
+	ErrVerifyTokenInvalid = errors.New("email verification link is invalid or expired")
+	// почта уже подтверждена
+	ErrEmailAlreadyVerified = errors.New("email is already verified")
+	// письмо для подтверждения отправляли только что
+	ErrVerificationTooSoon = errors.New("verification email was sent recently, try again later")
+	// неверная почта или пароль, что именно не так не уточняем
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// вход временно заблокирован после серии неверных паролей
+	ErrAccountLocked = errors.New("too many attempts, try again later")
 )
 
-// преобразуем роль в строку
-func (r Role) String() string {
-	return [...]string{"Default", "Admin"}[r]
+// хеш с которым сравниваем пароль если почты нет в базе,
+// чтобы ответ по несуществующей почте не приходил заметно быстрее
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// Device устройство с которого пришел запрос, запоминается в сессии
+type Device struct {
+	UserAgent string
+	IP        string
 }
 
 // сервис для работы с пользователями
 type UserService struct {
 	config    *config.Config
 	repo      repository.UserRepository
+	mail      mailer.Sender
 	validator *validator.Validator
+	// ключи для подписи access токенов
+	keys *jwtkeys.Keyring
+	// включенные в конфиге сервисы для входа по OAuth
+	providers map[string]*oauth.Provider
 }
 
 // создаем новый сервис для работы с пользователями(конструктор)
-func NewUserService(cfg *config.Config, repo repository.UserRepository) *UserService {
+func NewUserService(cfg *config.Config, repo repository.UserRepository, mail mailer.Sender, keys *jwtkeys.Keyring) *UserService {
 	return &UserService{
 		config:    cfg,
 		repo:      repo,
+		mail:      mail,
 		validator: validator.NewValidator(),
+		keys:      keys,
+		providers: oauth.Providers(cfg),
 	}
 }
 
@@ -54,8 +90,14 @@ func (s *UserService) GetUserId(email string) (uuid.UUID, error) {
 }
 
 // SignUp регистрирует нового пользователя
-// создает нового пользователя в базе и выдает токены
-func (s *UserService) SignUp(user *dto.RegistrationDto) (string, string, error) {
+// создает нового пользователя в базе, открывает сессию для устройства и выдает токены
+// аккаунт создается с неподтвержденной почтой, на нее уходит письмо со ссылкой
+func (s *UserService) SignUp(user *dto.RegistrationDto, device Device) (string, string, error) {
+	// на эту почту уйдет письмо для подтверждения
+	if !s.validator.IsValidEmail(user.Email) {
+		return "", "", ErrInvalidEmail
+	}
+
 	// хешируем пароль чтобы не хранить его в открытом виде
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -67,6 +109,7 @@ func (s *UserService) SignUp(user *dto.RegistrationDto) (string, string, error)
 	userEntity := entity.User{
 		ID:         userID,
 		PasswdHash: string(hashedPassword),
+		Roles:      []entity.Role{{Name: entity.RoleStudent}},
 	}
 	userData := entity.UserData{
 		UserID:          userID,
@@ -83,38 +126,50 @@ func (s *UserService) SignUp(user *dto.RegistrationDto) (string, string, error)
 		MonthIncome:     user.MonthIncome,
 	}
 
-	// создаем токены для нового пользователя
-	access, refresh, err := s.generateTokens(user.Email)
+	// создаем сессию и токены для нового пользователя
+	session, access, refresh, err := s.openSession(userID, user.Email, device)
 	if err != nil {
 		return "", "", err
 	}
 
-	// сохраняем refresh токен в базе
-	userAuth := entity.Auth{
-		UserID: userID,
-		Key:    refresh,
-	}
-
-	// сохраняем пользователя в базе
-	err = s.repo.CreateUser(&userEntity, &userData, &userAuth)
+	// сохраняем пользователя вместе с сессией в базе
+	err = s.repo.CreateUser(&userEntity, &userData, session)
 	if err != nil {
 		return "", "", err
 	}
 
+	// почта пока не подтверждена, если письмо не ушло - пользователь запросит его еще раз
+	if err := s.sendEmailLink(userID, user.Email, validator.PurposeVerifyEmail); err != nil {
+		fmt.Printf("Error sending verification email: %v\n", err)
+	}
+
 	return access, refresh, nil
 }
 
 // SignIn входит в аккаунт пользователя
-// проверяет пароль и выдает новые токены
-func (s *UserService) SignIn(user *dto.LoginDto) (string, string, error) {
+// проверяет пароль и открывает новую сессию, сессии на других устройствах остаются
+func (s *UserService) SignIn(user *dto.LoginDto, device Device) (string, string, error) {
 	// ищем пользователя по почте
 	userEntity, err := s.repo.GetUserByEmail(user.Email)
-	if err != nil {
-		return "", "", err
+	if err != nil || userEntity == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(user.Password))
+		return "", "", ErrInvalidCredentials
 	}
+
+	// пока вход заблокирован пароль даже не проверяем
+	now := time.Now()
+	if userEntity.IsLocked(now) {
+		return "", "", ErrAccountLocked
+	}
+
 	// проверяем что пароль правильный
 	if err := bcrypt.CompareHashAndPassword([]byte(userEntity.PasswdHash), []byte(user.Password)); err != nil {
-		return "", "", err
+		return "", "", s.loginFailed(userEntity.ID, device)
+	}
+	if userEntity.FailedLogins > 0 || userEntity.LoginLockouts > 0 {
+		if err := s.repo.LoginSucceeded(userEntity.ID); err != nil {
+			return "", "", err
+		}
 	}
 
 	// получаем данные пользователя
@@ -123,14 +178,27 @@ func (s *UserService) SignIn(user *dto.LoginDto) (string, string, error) {
 		return "", "", err
 	}
 
-	// создаем новые токены
-	access, refresh, err := s.generateTokens(userWithData.UserData.Email)
+	return s.completeSignIn(userEntity.ID, userWithData.UserData.Email, device)
+}
+
+// completeSignIn открывает сессию после того как пользователь подтвердил кто он
+// с подключенными кодами токены выдаем только после кода из приложения
+func (s *UserService) completeSignIn(userId uuid.UUID, email string, device Device) (string, string, error) {
+	twoFactor, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return "", "", err
+	}
+	if twoFactor != nil && twoFactor.EnabledAt != nil {
+		return "", "", s.twoFactorChallenge(userId, email)
+	}
+
+	// создаем новую сессию и токены для нее
+	session, access, refresh, err := s.openSession(userId, email, device)
 	if err != nil {
 		return "", "", err
 	}
 
-	// обновляем refresh токен в базе
-	err = s.repo.UpdateToken(userEntity.ID, refresh)
+	err = s.repo.CreateSession(session)
 	if err != nil {
 		return "", "", err
 	}
@@ -138,6 +206,29 @@ func (s *UserService) SignIn(user *dto.LoginDto) (string, string, error) {
 	return access, refresh, nil
 }
 
+// считает неверный пароль, после серии подряд блокирует вход
+func (s *UserService) loginFailed(userId uuid.UUID, device Device) error {
+	maxAttempts := s.config.Auth.MaxLoginAttempts
+	if maxAttempts <= 0 {
+		return ErrInvalidCredentials
+	}
+
+	event := &entity.SecurityEvent{
+		UserID:    userId,
+		Type:      entity.SecurityAccountLocked,
+		UserAgent: device.UserAgent,
+		IP:        device.IP,
+	}
+	lockedUntil, err := s.repo.LoginFailed(userId, maxAttempts, s.config.Auth.LoginLockout, s.config.Auth.MaxLoginLockout, event)
+	if err != nil {
+		return err
+	}
+	if lockedUntil != nil {
+		return ErrAccountLocked
+	}
+	return ErrInvalidCredentials
+}
+
 // GetUsers получает список всех пользователей
 // берет всех пользователей из базы и преобразует их в формат для ответа
 func (s *UserService) GetUsers() ([]dto.UserInfoAdminDto, error) {
@@ -172,6 +263,7 @@ func (s *UserService) GetUsers() ([]dto.UserInfoAdminDto, error) {
 			IsBusinessOwner:   user.UserData.IsBusinessOwner,
 			PositionAtWork:    user.UserData.PositionAtWork,
 			MonthIncome:       user.UserData.MonthIncome,
+			Roles:             roleNames(user.Roles),
 			CourseAssignments: courseAssignments,
 		})
 	}
@@ -185,6 +277,10 @@ func (s *UserService) GetUser(userId uuid.UUID) (*dto.UserInfoAdminDto, error) {
 	if err != nil {
 		return nil, err
 	}
+	roles, err := s.repo.GetUserRoles(userId)
+	if err != nil {
+		return nil, err
+	}
 	userDto := &dto.UserInfoAdminDto{
 		ID:                user.ID,
 		Name:              user.UserData.Name,
@@ -198,6 +294,7 @@ func (s *UserService) GetUser(userId uuid.UUID) (*dto.UserInfoAdminDto, error) {
 		IsBusinessOwner:   user.UserData.IsBusinessOwner,
 		PositionAtWork:    user.UserData.PositionAtWork,
 		MonthIncome:       user.UserData.MonthIncome,
+		Roles:             roleNames(roles),
 		CourseAssignments: nil,
 	}
 
@@ -239,17 +336,6 @@ func (s *UserService) DeleteUser(toDel uuid.UUID) error {
 	return nil
 }
 
-// Role получает роль пользователя
-// просто берет роль пользователя из базы
-func (s *UserService) Role(userId uuid.UUID) (string, error) {
-	user, err := s.repo.GetUserWithDataById(userId)
-	if err != nil {
-		return "", err
-	}
-
-	return Role(user.Role).String(), nil
-}
-
 /**
 общие понятия:
 - Access Token -- краткоживущий токен, используемый для аутентификации и авторизации пользователя
@@ -258,7 +344,10 @@ func (s *UserService) Role(userId uuid.UUID) (string, error) {
 */
 
 // RefreshTokens обновляет пару access/refresh токенов по переданному refresh token (cookie)
-func (s *UserService) RefreshTokens(cookie string) (string, string, error) {
+// каждый refresh токен одноразовый: при обмене выдается новый токен той же сессии (семейства)
+// если уже обмененный токен предъявят еще раз, значит его кто-то украл -
+// отзываем всю сессию, чтобы и вор и владелец вошли заново, и записываем событие безопасности
+func (s *UserService) RefreshTokens(cookie string, device Device) (string, string, error) {
 	// валидирует переданный токен с использованием refresh-ключа из токена
 	token, err := s.validator.ValidateToken(cookie, s.config.Jwt.RefreshKey)
 	if err != nil || !token.Valid {
@@ -271,64 +360,330 @@ func (s *UserService) RefreshTokens(cookie string) (string, string, error) {
 		return "", "", err // ошибка получения subject или subject пустой
 	}
 
-	// получает пользователя по email (subject)
-	user, err := s.repo.GetUserByEmail(sub)
+	// получает id сессии, которой выдан токен
+	sessionId, err := s.validator.SessionID(token)
 	if err != nil {
-		return "", "", err // ошибка получения пользователя из репозитория
+		return "", "", err
 	}
 
-	// получает дополнительные данные(фио, telegram и т д) пользователя по его ID
-	userData, err := s.repo.GetUserWithDataById(user.ID)
+	// получает пользователя по email (subject)
+	user, err := s.repo.GetUserByEmail(sub)
 	if err != nil {
-		return "", "", err // ошибка получения данных
+		return "", "", err // ошибка получения пользователя из репозитория
 	}
 
-	// получает сущность пользователя с сохранённым refresh токеном (user.Auth.Key)
-	userEntity, err := s.repo.GetUserWithRefreshById(user.ID)
-	if err != nil {
-		return "", "", err // ошибка получения записи из бд
+	// сессия могла быть завершена выходом или отозвана с другого устройства
+	session, err := s.repo.GetSession(sessionId)
+	if err != nil || session == nil || session.UserID != user.ID {
+		return "", "", ErrSessionNotFound
 	}
 
-	// проверяет, совпадает ли переданный токен с сохранённым -- защита от повторного использования
-	if userEntity.Auth.Key != cookie {
-		return "", "", errors.New("this refresh token was already refreshed")
+	// токен подписан нами и выдан этой сессии, но он не последний - это повторное использование
+	previousHash := hashToken(cookie)
+	if session.TokenHash != previousHash {
+		return "", "", s.revokeReusedFamily(session, s.validator.TokenID(token), device)
 	}
 
-	// генерирует новую пару access и refresh токенов
-	access, refresh, err := s.generateTokens(userData.UserData.Email)
+	// генерирует новую пару access и refresh токенов для той же сессии
+	access, refresh, refreshId, err := s.generateTokens(sub, session.SessionID)
 	if err != nil {
 		return "", "", err // ошибка генерации токенов
 	}
 
-	// обновляет сохранённый refresh токен в базе
-	if err := s.repo.UpdateToken(userEntity.ID, refresh); err != nil {
-		return "", "", err // ошибка обновления токена в хранилище
+	// запоминает новый токен и продлевает сессию
+	now := time.Now()
+	session.TokenHash = hashToken(refresh)
+	session.TokenID = refreshId
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(s.config.Jwt.RefreshExpiresIn)
+	session.UserAgent = device.UserAgent
+	session.IP = device.IP
+	err = s.repo.RotateSessionToken(session, previousHash)
+	if errors.Is(err, repository.ErrTokenReused) {
+		// тот же токен одновременно обменяли в другом запросе
+		return "", "", s.revokeReusedFamily(session, s.validator.TokenID(token), device)
+	}
+	if err != nil {
+		return "", "", err // ошибка обновления сессии в хранилище
 	}
 
 	// возвращает новую пару access и refresh токенов
 	return access, refresh, nil
 }
 
-// generateTokens создает новые токены для пользователя
-// создает access и refresh токены с указанной почтой
-func (s *UserService) generateTokens(email string) (access string, refresh string, error error) {
-	// создаем access токен
-	access, err := s.validator.GenerateToken(email, s.config.Jwt.AccessKey, s.config.Jwt.AccessExpiresIn)
+// revokeReusedFamily отзывает сессию, в которой повторно предъявили refresh токен
+// возвращает ErrRefreshTokenReused или ошибку базы
+func (s *UserService) revokeReusedFamily(session *entity.Session, tokenId string, device Device) error {
+	sessionId := session.SessionID
+	event := &entity.SecurityEvent{
+		UserID:    session.UserID,
+		Type:      entity.SecurityRefreshTokenReuse,
+		SessionID: &sessionId,
+		UserAgent: device.UserAgent,
+		IP:        device.IP,
+		Details:   fmt.Sprintf("reused token %s, current token %s", tokenId, session.TokenID),
+	}
+	if err := s.repo.RevokeSessionFamily(sessionId, event); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// JWKS открытые ключи, которыми проверяются access токены
+func (s *UserService) JWKS() jwtkeys.JWKS {
+	return s.keys.JWKS()
+}
+
+// generateTokens создает новые токены для сессии пользователя
+// создает access и refresh токены с указанной почтой и id сессии, возвращает и jti refresh токена
+func (s *UserService) generateTokens(email string, sessionId uuid.UUID) (access string, refresh string, refreshId string, error error) {
+	// создаем access токен, подписанный активным ключом
+	access, err := s.validator.GenerateAccessToken(email, sessionId, uuid.NewString(), s.keys, s.config.Jwt.AccessExpiresIn)
 	if err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 
 	// создаем refresh токен
-	refresh, err = s.validator.GenerateToken(email, s.config.Jwt.RefreshKey, s.config.Jwt.RefreshExpiresIn)
+	refreshId = uuid.NewString()
+	refresh, err = s.validator.GenerateToken(email, sessionId, refreshId, s.config.Jwt.RefreshKey, s.config.Jwt.RefreshExpiresIn)
 	if err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 
-	return access, refresh, nil
+	return access, refresh, refreshId, nil
+}
+
+// openSession готовит новую сессию устройства и выдает для нее токены
+// в базе хранится только хеш refresh токена
+func (s *UserService) openSession(userId uuid.UUID, email string, device Device) (*entity.Session, string, string, error) {
+	now := time.Now()
+	session := &entity.Session{
+		SessionID:  uuid.New(),
+		UserID:     userId,
+		UserAgent:  device.UserAgent,
+		IP:         device.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.config.Jwt.RefreshExpiresIn),
+	}
+
+	access, refresh, refreshId, err := s.generateTokens(email, session.SessionID)
+	if err != nil {
+		return nil, "", "", err
+	}
+	session.TokenHash = hashToken(refresh)
+	session.TokenID = refreshId
+
+	return session, access, refresh, nil
 }
 
 // Logout выходит из аккаунта
-// просто удаляет refresh токен из базы
-func (s *UserService) Logout(userId uuid.UUID) error {
-	return s.repo.UpdateToken(userId, "")
+// завершает только текущую сессию, на других устройствах пользователь остается
+func (s *UserService) Logout(userId uuid.UUID, sessionId uuid.UUID) error {
+	return s.repo.DeleteSession(userId, sessionId)
+}
+
+// GetSessions список активных сессий пользователя
+// currentSessionId - сессия из которой пришел запрос, она помечается как текущая
+func (s *UserService) GetSessions(userId uuid.UUID, currentSessionId uuid.UUID) ([]dto.SessionDto, error) {
+	sessions, err := s.repo.GetSessionsByUserID(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.SessionDto, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, dto.SessionDto{
+			SessionID:  session.SessionID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.SessionID == currentSessionId,
+		})
+	}
+	return result, nil
+}
+
+// RevokeSession завершает одну сессию пользователя, например на потерянном телефоне
+func (s *UserService) RevokeSession(userId uuid.UUID, sessionId uuid.UUID) error {
+	session, err := s.repo.GetSession(sessionId)
+	if err != nil || session == nil || session.UserID != userId {
+		return ErrSessionNotFound
+	}
+	return s.repo.DeleteSession(userId, sessionId)
+}
+
+// RevokeOtherSessions завершает все сессии пользователя кроме текущей
+func (s *UserService) RevokeOtherSessions(userId uuid.UUID, currentSessionId uuid.UUID) error {
+	return s.repo.DeleteOtherSessions(userId, currentSessionId)
+}
+
+// GetSecurityEvents события безопасности по аккаунту пользователя
+func (s *UserService) GetSecurityEvents(userId uuid.UUID) ([]dto.SecurityEventDto, error) {
+	events, err := s.repo.GetSecurityEvents(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.SecurityEventDto, 0, len(events))
+	for _, event := range events {
+		result = append(result, dto.SecurityEventDto{
+			Type:      string(event.Type),
+			SessionID: event.SessionID,
+			UserAgent: event.UserAgent,
+			IP:        event.IP,
+			Details:   event.Details,
+			CreatedAt: event.CreatedAt,
+		})
+	}
+	return result, nil
+}
+
+// ForgotPassword отправляет на почту одноразовую ссылку для сброса пароля
+// если такой почты нет - молча ничего не делает, чтобы по ответу нельзя было проверить кто зарегистрирован
+func (s *UserService) ForgotPassword(email string) error {
+	user, err := s.repo.GetUserByEmail(email)
+	if err != nil || user == nil {
+		return nil
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	ttl := s.config.Auth.PasswordResetTTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	err = s.repo.CreatePasswordResetToken(&entity.PasswordResetToken{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(s.config.Auth.FrontendURL, "/") + "/reset-password?token=" + token
+	return s.mail.Send(&mailer.Message{
+		To:      email,
+		Subject: "Восстановление пароля",
+		Text: fmt.Sprintf("Чтобы задать новый пароль, перейдите по ссылке:\n%s\n\n"+
+			"Ссылка действует %d мин. и сработает один раз.\n"+
+			"Если вы не запрашивали сброс пароля, просто проигнорируйте это письмо.", link, int(ttl.Minutes())),
+	})
+}
+
+// ResetPassword задает новый пароль по токену из письма
+// после сброса все сессии пользователя завершаются, войти нужно заново с новым паролем
+func (s *UserService) ResetPassword(token string, password string, device Device) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	event := &entity.SecurityEvent{
+		Type:      entity.SecurityPasswordReset,
+		UserAgent: device.UserAgent,
+		IP:        device.IP,
+	}
+	_, err = s.repo.ResetPassword(hashToken(token), string(hashedPassword), event)
+	if errors.Is(err, repository.ErrResetTokenInvalid) {
+		return ErrResetTokenInvalid
+	}
+	return err
+}
+
+// VerifyEmail подтверждает почту по токену из ссылки в письме
+// повторное подтверждение уже подтвержденной почты не ошибка
+func (s *UserService) VerifyEmail(token string) error {
+	userId, email, err := s.validator.ValidateEmailToken(token, validator.PurposeVerifyEmail, s.config.Auth.EmailVerifyKey)
+	if err != nil {
+		return ErrVerifyTokenInvalid
+	}
+
+	user, err := s.repo.GetUserWithDataById(userId)
+	if err != nil || user == nil || user.UserData == nil {
+		return ErrVerifyTokenInvalid
+	}
+	// ссылка выдана для почты, которую пользователь уже сменил
+	if user.UserData.Email != email {
+		return ErrVerifyTokenInvalid
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	return s.repo.MarkEmailVerified(userId, time.Now())
+}
+
+// ResendVerification отправляет письмо для подтверждения почты еще раз
+// чаще чем раз в config.Auth.VerifyResendInterval отправить нельзя
+func (s *UserService) ResendVerification(userId uuid.UUID) error {
+	user, err := s.repo.GetUserWithDataById(userId)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.UserData == nil {
+		return errors.New("user not found")
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	return s.sendEmailLink(userId, user.UserData.Email, validator.PurposeVerifyEmail)
+}
+
+// sendEmailLink отправляет на почту ссылку с подписанным токеном: подтверждение почты или ее смены
+// сначала отмечает отправку, чтобы повторные запросы в интервале получили ErrVerificationTooSoon
+func (s *UserService) sendEmailLink(userId uuid.UUID, email, purpose string) error {
+	now := time.Now()
+	sent, err := s.repo.MarkVerificationSent(userId, now, now.Add(-s.config.Auth.VerifyResendInterval))
+	if err != nil {
+		return err
+	}
+	if !sent {
+		return ErrVerificationTooSoon
+	}
+
+	ttl := s.config.Auth.EmailVerifyTTL
+	if ttl <= 0 {
+		ttl = time.Hour * 72
+	}
+	token, err := s.validator.GenerateEmailToken(userId, email, purpose, s.config.Auth.EmailVerifyKey, ttl)
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimRight(s.config.Auth.FrontendURL, "/")
+	msg := &mailer.Message{To: email}
+	switch purpose {
+	case validator.PurposeChangeEmail:
+		msg.Subject = "Смена почты"
+		msg.Text = fmt.Sprintf("Чтобы входить в аккаунт с этой почтой, перейдите по ссылке:\n%s\n\n"+
+			"Ссылка действует %d ч. После смены почты нужно будет войти заново на всех устройствах.\n"+
+			"Если вы не меняли почту, просто проигнорируйте это письмо.", base+"/confirm-email?token="+token, int(ttl.Hours()))
+	default:
+		msg.Subject = "Подтверждение почты"
+		msg.Text = fmt.Sprintf("Чтобы подтвердить почту, перейдите по ссылке:\n%s\n\n"+
+			"Ссылка действует %d ч. Пока почта не подтверждена, покупать курсы нельзя.", base+"/verify-email?token="+token, int(ttl.Hours()))
+	}
+	return s.mail.Send(msg)
+}
+
+// randomToken случайная строка для ссылок в письмах, 256 бит
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken sha256 от токена в hex, по нему ищем и сравниваем refresh токены
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
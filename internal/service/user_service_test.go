@@ -3,25 +3,38 @@ package service
 import (
 	"mzt/config"
 	"mzt/internal/dto"
+	"mzt/internal/jwtkeys"
 	"mzt/internal/mocks"
+	"mzt/internal/validator"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// ключи для подписи access токенов в памяти
+func testKeyring(t *testing.T) *jwtkeys.Keyring {
+	key, err := jwtkeys.Generate(jwtkeys.EdDSA)
+	require.NoError(t, err)
+	keys, err := jwtkeys.NewKeyring([]*jwtkeys.Key{key}, key.ID)
+	require.NoError(t, err)
+	return keys
+}
+
 func TestService_SignUp(t *testing.T) {
 	mockRepo := mocks.NewMockUserRepository()
 	cfg := &config.Config{
 		Jwt: config.Jwt{
-			AccessKey:        "test-access-key",
 			RefreshKey:       "test-refresh-key",
 			AccessExpiresIn:  time.Minute * 30,
 			RefreshExpiresIn: time.Hour * 24 * 14,
 		},
 	}
-	service := NewUserService(cfg, mockRepo)
+	service := NewUserService(cfg, mockRepo, mocks.NewMockMailSender(), testKeyring(t))
 	birthdate, _ := time.Parse("2006-01-02", "1990-01-01")
 	userDto := &dto.RegistrationDto{
 		Email:           "test@example.com",
@@ -38,7 +51,7 @@ func TestService_SignUp(t *testing.T) {
 		MonthIncome:     5000,
 	}
 
-	access, refresh, err := service.SignUp(userDto)
+	access, refresh, err := service.SignUp(userDto, Device{UserAgent: "test"})
 
 	assert.NoError(t, err)
 	assert.NotEmpty(t, access)
@@ -52,7 +65,7 @@ func TestService_SignUp(t *testing.T) {
 func TestService_GetUserId(t *testing.T) {
 	mockRepo := mocks.NewMockUserRepository()
 	cfg := &config.Config{}
-	service := NewUserService(cfg, mockRepo)
+	service := NewUserService(cfg, mockRepo, mocks.NewMockMailSender(), testKeyring(t))
 	email := "test@example.com"
 
 	birthdate, _ := time.Parse("2006-01-02", "1990-01-01")
@@ -70,7 +83,7 @@ func TestService_GetUserId(t *testing.T) {
 		PositionAtWork:  "Test Position",
 		MonthIncome:     5000,
 	}
-	_, _, err := service.SignUp(userDto)
+	_, _, err := service.SignUp(userDto, Device{UserAgent: "test"})
 	assert.NoError(t, err)
 
 	userId, err := service.GetUserId(email)
@@ -83,13 +96,12 @@ func TestService_RefreshTokens(t *testing.T) {
 	mockRepo := mocks.NewMockUserRepository()
 	cfg := &config.Config{
 		Jwt: config.Jwt{
-			AccessKey:        "test-access-key",
 			RefreshKey:       "test-refresh-key",
 			AccessExpiresIn:  time.Minute * 30,
 			RefreshExpiresIn: time.Hour * 24 * 14,
 		},
 	}
-	service := NewUserService(cfg, mockRepo)
+	service := NewUserService(cfg, mockRepo, mocks.NewMockMailSender(), testKeyring(t))
 	birthdate, _ := time.Parse("2006-01-02", "1990-01-01")
 	userDto := &dto.RegistrationDto{
 		Email:           "test@example.com",
@@ -106,14 +118,403 @@ func TestService_RefreshTokens(t *testing.T) {
 		MonthIncome:     5000,
 	}
 
-	_, refresh, err := service.SignUp(userDto)
+	_, refresh, err := service.SignUp(userDto, Device{UserAgent: "test"})
 	assert.NoError(t, err)
 
-	time.Sleep(1 * time.Second)
-	newAccess, newRefresh, err := service.RefreshTokens(refresh)
+	newAccess, newRefresh, err := service.RefreshTokens(refresh, Device{UserAgent: "test"})
 
 	assert.NoError(t, err)
 	assert.NotEmpty(t, newAccess)
 	assert.NotEmpty(t, newRefresh)
 	assert.NotEqual(t, refresh, newRefresh)
 }
+
+func TestService_MultipleSessions(t *testing.T) {
+	mockRepo := mocks.NewMockUserRepository()
+	cfg := &config.Config{
+		Jwt: config.Jwt{
+			RefreshKey:       "test-refresh-key",
+			AccessExpiresIn:  time.Minute * 30,
+			RefreshExpiresIn: time.Hour * 24 * 14,
+		},
+	}
+	service := NewUserService(cfg, mockRepo, mocks.NewMockMailSender(), testKeyring(t))
+	birthdate, _ := time.Parse("2006-01-02", "1990-01-01")
+	userDto := &dto.RegistrationDto{
+		Email:       "test@example.com",
+		Password:    "password123",
+		Name:        "Test User",
+		Birthdate:   birthdate,
+		PhoneNumber: "+1234567890",
+		Telegram:    "@testuser",
+	}
+	login := &dto.LoginDto{Email: userDto.Email, Password: userDto.Password}
+
+	_, laptopRefresh, err := service.SignUp(userDto, Device{UserAgent: "laptop", IP: "10.0.0.1"})
+	require.NoError(t, err)
+	phoneAccess, phoneRefresh, err := service.SignIn(login, Device{UserAgent: "phone", IP: "10.0.0.2"})
+	require.NoError(t, err)
+
+	// вход с телефона не ломает сессию на ноутбуке
+	_, laptopRefresh, err = service.RefreshTokens(laptopRefresh, Device{UserAgent: "laptop", IP: "10.0.0.3"})
+	require.NoError(t, err)
+
+	_, _, err = service.RefreshTokens(phoneRefresh, Device{UserAgent: "phone"})
+	require.NoError(t, err)
+
+	userId, err := service.GetUserId(userDto.Email)
+	require.NoError(t, err)
+	token, err := service.validator.ValidateAccessToken(phoneAccess, service.keys)
+	require.NoError(t, err)
+	phoneSession, err := service.validator.SessionID(token)
+	require.NoError(t, err)
+
+	sessions, err := service.GetSessions(userId, phoneSession)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	var laptop dto.SessionDto
+	for _, session := range sessions {
+		if session.SessionID == phoneSession {
+			assert.True(t, session.Current)
+		} else {
+			laptop = session
+			assert.False(t, session.Current)
+		}
+	}
+	assert.Equal(t, "10.0.0.3", laptop.IP)
+
+	// чужую сессию отозвать нельзя
+	assert.ErrorIs(t, service.RevokeSession(uuid.New(), laptop.SessionID), ErrSessionNotFound)
+
+	// выход с телефона оставляет ноутбук в аккаунте
+	require.NoError(t, service.Logout(userId, phoneSession))
+	sessions, err = service.GetSessions(userId, phoneSession)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, laptop.SessionID, sessions[0].SessionID)
+
+	_, laptopRefresh, err = service.RefreshTokens(laptopRefresh, Device{UserAgent: "laptop"})
+	require.NoError(t, err)
+
+	require.NoError(t, service.RevokeSession(userId, laptop.SessionID))
+	_, _, err = service.RefreshTokens(laptopRefresh, Device{UserAgent: "laptop"})
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestService_RevokeOtherSessions(t *testing.T) {
+	mockRepo := mocks.NewMockUserRepository()
+	cfg := &config.Config{
+		Jwt: config.Jwt{
+			RefreshKey:       "test-refresh-key",
+			AccessExpiresIn:  time.Minute * 30,
+			RefreshExpiresIn: time.Hour * 24 * 14,
+		},
+	}
+	service := NewUserService(cfg, mockRepo, mocks.NewMockMailSender(), testKeyring(t))
+	userDto := &dto.RegistrationDto{Email: "test@example.com", Password: "password123", Name: "Test User"}
+	login := &dto.LoginDto{Email: userDto.Email, Password: userDto.Password}
+
+	_, _, err := service.SignUp(userDto, Device{UserAgent: "laptop"})
+	require.NoError(t, err)
+	_, _, err = service.SignIn(login, Device{UserAgent: "tablet"})
+	require.NoError(t, err)
+	_, phoneRefresh, err := service.SignIn(login, Device{UserAgent: "phone"})
+	require.NoError(t, err)
+
+	userId, err := service.GetUserId(userDto.Email)
+	require.NoError(t, err)
+	token, err := service.validator.ValidateToken(phoneRefresh, cfg.Jwt.RefreshKey)
+	require.NoError(t, err)
+	phoneSession, err := service.validator.SessionID(token)
+	require.NoError(t, err)
+
+	require.NoError(t, service.RevokeOtherSessions(userId, phoneSession))
+	sessions, err := service.GetSessions(userId, phoneSession)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "phone", sessions[0].UserAgent)
+	assert.True(t, sessions[0].Current)
+}
+
+func TestService_RefreshTokenReuse(t *testing.T) {
+	mockRepo := mocks.NewMockUserRepository()
+	cfg := &config.Config{
+		Jwt: config.Jwt{
+			RefreshKey:       "test-refresh-key",
+			AccessExpiresIn:  time.Minute * 30,
+			RefreshExpiresIn: time.Hour * 24 * 14,
+		},
+	}
+	service := NewUserService(cfg, mockRepo, mocks.NewMockMailSender(), testKeyring(t))
+	userDto := &dto.RegistrationDto{Email: "test@example.com", Password: "password123", Name: "Test User"}
+	login := &dto.LoginDto{Email: userDto.Email, Password: userDto.Password}
+
+	_, laptopRefresh, err := service.SignUp(userDto, Device{UserAgent: "laptop"})
+	require.NoError(t, err)
+	_, stolen, err := service.SignIn(login, Device{UserAgent: "phone"})
+	require.NoError(t, err)
+
+	// владелец обменял токен, а вор предъявляет старый
+	_, rotated, err := service.RefreshTokens(stolen, Device{UserAgent: "phone"})
+	require.NoError(t, err)
+	_, _, err = service.RefreshTokens(stolen, Device{UserAgent: "attacker", IP: "10.6.6.6"})
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+
+	// все семейство отозвано, свежий токен владельца тоже не работает
+	_, _, err = service.RefreshTokens(rotated, Device{UserAgent: "phone"})
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// другие устройства не затронуты
+	_, _, err = service.RefreshTokens(laptopRefresh, Device{UserAgent: "laptop"})
+	require.NoError(t, err)
+
+	userId, err := service.GetUserId(userDto.Email)
+	require.NoError(t, err)
+	events, err := service.GetSecurityEvents(userId)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "refresh_token_reuse", events[0].Type)
+	assert.Equal(t, "10.6.6.6", events[0].IP)
+	assert.NotNil(t, events[0].SessionID)
+
+	// в базе лежит только хеш токена
+	for _, session := range mockRepo.(*mocks.MockUserRepository).Sessions {
+		assert.NotEqual(t, laptopRefresh, session.TokenHash)
+		assert.Len(t, session.TokenHash, 64)
+	}
+}
+
+func TestService_PasswordReset(t *testing.T) {
+	mockRepo := mocks.NewMockUserRepository()
+	mail := mocks.NewMockMailSender()
+	cfg := &config.Config{
+		Jwt: config.Jwt{
+			RefreshKey:       "test-refresh-key",
+			AccessExpiresIn:  time.Minute * 30,
+			RefreshExpiresIn: time.Hour * 24 * 14,
+		},
+		Auth: config.Auth{
+			FrontendURL:      "https://mzt-study.ru/",
+			PasswordResetTTL: time.Hour,
+		},
+	}
+	service := NewUserService(cfg, mockRepo, mail, testKeyring(t))
+	userDto := &dto.RegistrationDto{Email: "test@example.com", Password: "password123", Name: "Test User"}
+
+	_, refresh, err := service.SignUp(userDto, Device{UserAgent: "laptop"})
+	require.NoError(t, err)
+
+	// про неизвестную почту не сообщаем и письмо не шлем
+	require.NoError(t, service.ForgotPassword("nobody@example.com"))
+	assert.Empty(t, mail.Messages)
+
+	require.NoError(t, service.ForgotPassword(userDto.Email))
+	require.Len(t, mail.Messages, 1)
+	assert.Equal(t, userDto.Email, mail.Messages[0].To)
+	link := mail.Messages[0].Text
+	start := strings.Index(link, "https://mzt-study.ru/reset-password?token=")
+	require.NotEqual(t, -1, start)
+	token := strings.Fields(link[start+len("https://mzt-study.ru/reset-password?token="):])[0]
+
+	// в базе только хеш токена
+	_, stored := mockRepo.(*mocks.MockUserRepository).Resets[token]
+	assert.False(t, stored)
+
+	assert.ErrorIs(t, service.ResetPassword("wrong", "newpassword1", Device{}), ErrResetTokenInvalid)
+	require.NoError(t, service.ResetPassword(token, "newpassword1", Device{IP: "10.0.0.1"}))
+
+	// ссылка одноразовая
+	assert.ErrorIs(t, service.ResetPassword(token, "newpassword2", Device{}), ErrResetTokenInvalid)
+
+	// все сессии завершены
+	_, _, err = service.RefreshTokens(refresh, Device{UserAgent: "laptop"})
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	_, _, err = service.SignIn(&dto.LoginDto{Email: userDto.Email, Password: userDto.Password}, Device{})
+	assert.Error(t, err)
+	_, _, err = service.SignIn(&dto.LoginDto{Email: userDto.Email, Password: "newpassword1"}, Device{})
+	require.NoError(t, err)
+
+	userId, err := service.GetUserId(userDto.Email)
+	require.NoError(t, err)
+	events, err := service.GetSecurityEvents(userId)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "password_reset", events[0].Type)
+}
+
+func TestService_PasswordResetExpired(t *testing.T) {
+	mockRepo := mocks.NewMockUserRepository()
+	mail := mocks.NewMockMailSender()
+	cfg := &config.Config{
+		Jwt: config.Jwt{
+			RefreshKey:       "test-refresh-key",
+			AccessExpiresIn:  time.Minute * 30,
+			RefreshExpiresIn: time.Hour * 24 * 14,
+		},
+	}
+	service := NewUserService(cfg, mockRepo, mail, testKeyring(t))
+	userDto := &dto.RegistrationDto{Email: "test@example.com", Password: "password123", Name: "Test User"}
+	_, _, err := service.SignUp(userDto, Device{})
+	require.NoError(t, err)
+
+	require.NoError(t, service.ForgotPassword(userDto.Email))
+	for _, reset := range mockRepo.(*mocks.MockUserRepository).Resets {
+		reset.ExpiresAt = time.Now().Add(-time.Minute)
+	}
+	text := mail.Messages[0].Text
+	token := text[strings.Index(text, "token=")+len("token="):]
+	token = strings.Fields(token)[0]
+
+	assert.ErrorIs(t, service.ResetPassword(token, "newpassword1", Device{}), ErrResetTokenInvalid)
+}
+
+func TestService_EmailVerification(t *testing.T) {
+	mockRepo := mocks.NewMockUserRepository()
+	mail := mocks.NewMockMailSender()
+	cfg := &config.Config{
+		Jwt: config.Jwt{
+			RefreshKey:       "test-refresh-key",
+			AccessExpiresIn:  time.Minute * 30,
+			RefreshExpiresIn: time.Hour * 24 * 14,
+		},
+		Auth: config.Auth{
+			FrontendURL:          "https://mzt-study.ru",
+			EmailVerifyKey:       "test-verify-key",
+			EmailVerifyTTL:       time.Hour,
+			VerifyResendInterval: time.Minute,
+		},
+	}
+	service := NewUserService(cfg, mockRepo, mail, testKeyring(t))
+
+	// почта проверяется и в сервисе
+	_, _, err := service.SignUp(&dto.RegistrationDto{Email: "not-an-email", Password: "password123"}, Device{})
+	assert.ErrorIs(t, err, ErrInvalidEmail)
+
+	userDto := &dto.RegistrationDto{Email: "test@example.com", Password: "password123", Name: "Test User"}
+	_, _, err = service.SignUp(userDto, Device{})
+	require.NoError(t, err)
+	userId, err := service.GetUserId(userDto.Email)
+	require.NoError(t, err)
+	user := mockRepo.(*mocks.MockUserRepository).Users[userId]
+	assert.Nil(t, user.EmailVerifiedAt)
+
+	require.Len(t, mail.Messages, 1)
+	assert.Equal(t, userDto.Email, mail.Messages[0].To)
+	text := mail.Messages[0].Text
+	require.Contains(t, text, "https://mzt-study.ru/verify-email?token=")
+	token := strings.Fields(text[strings.Index(text, "token=")+len("token="):])[0]
+
+	// повторно сразу отправить нельзя
+	assert.ErrorIs(t, service.ResendVerification(userId), ErrVerificationTooSoon)
+	sentAt := time.Now().Add(-2 * time.Minute)
+	user.VerificationSentAt = &sentAt
+	require.NoError(t, service.ResendVerification(userId))
+	assert.Len(t, mail.Messages, 2)
+
+	assert.ErrorIs(t, service.VerifyEmail("garbage"), ErrVerifyTokenInvalid)
+	// токен подписанный чужим ключом не принимается
+	forged, err := service.validator.GenerateEmailToken(userId, userDto.Email, validator.PurposeVerifyEmail, "other-key", time.Hour)
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.VerifyEmail(forged), ErrVerifyTokenInvalid)
+
+	// без ключа ссылки не принимаются, даже подписанные пустым ключом
+	service.config.Auth.EmailVerifyKey = ""
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": userDto.Email, "uid": userId.String(), "purpose": validator.PurposeVerifyEmail, "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(""))
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.VerifyEmail(unsigned), ErrVerifyTokenInvalid)
+	service.config.Auth.EmailVerifyKey = "test-verify-key"
+
+	require.NoError(t, service.VerifyEmail(token))
+	assert.NotNil(t, user.EmailVerifiedAt)
+	// повторный переход по ссылке не ошибка
+	require.NoError(t, service.VerifyEmail(token))
+	assert.ErrorIs(t, service.ResendVerification(userId), ErrEmailAlreadyVerified)
+}
+
+func TestService_EmailVerificationChangedEmail(t *testing.T) {
+	mockRepo := mocks.NewMockUserRepository()
+	mail := mocks.NewMockMailSender()
+	cfg := &config.Config{
+		Auth: config.Auth{
+			EmailVerifyKey: "test-verify-key",
+			EmailVerifyTTL: time.Hour,
+		},
+		Jwt: config.Jwt{
+			RefreshKey: "test-refresh-key",
+		},
+	}
+	service := NewUserService(cfg, mockRepo, mail, testKeyring(t))
+	userDto := &dto.RegistrationDto{Email: "old@example.com", Password: "password123", Name: "Test User"}
+	_, _, err := service.SignUp(userDto, Device{})
+	require.NoError(t, err)
+	userId, err := service.GetUserId(userDto.Email)
+	require.NoError(t, err)
+
+	token, err := service.validator.GenerateEmailToken(userId, userDto.Email, validator.PurposeVerifyEmail, cfg.Auth.EmailVerifyKey, time.Hour)
+	require.NoError(t, err)
+
+	// почту сменили, ссылка на старую больше не подтверждает аккаунт
+	mockRepo.(*mocks.MockUserRepository).UserData[userId].Email = "new@example.com"
+	assert.ErrorIs(t, service.VerifyEmail(token), ErrVerifyTokenInvalid)
+	assert.Nil(t, mockRepo.(*mocks.MockUserRepository).Users[userId].EmailVerifiedAt)
+}
+
+func TestService_SignInLockout(t *testing.T) {
+	service, repo, _, userId := setupProfileService(t)
+	service.config.Auth.MaxLoginAttempts = 3
+	service.config.Auth.LoginLockout = time.Minute
+	service.config.Auth.MaxLoginLockout = time.Minute * 3
+	wrong := &dto.LoginDto{Email: "test@example.com", Password: "wrongpassword1"}
+	right := &dto.LoginDto{Email: "test@example.com", Password: "password123"}
+
+	// неизвестная почта и неверный пароль неотличимы
+	_, _, err := service.SignIn(&dto.LoginDto{Email: "nobody@example.com", Password: "password123"}, Device{})
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, _, err = service.SignIn(wrong, Device{})
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// удачный вход сбрасывает счетчик
+	_, _, err = service.SignIn(right, Device{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, repo.Users[userId].FailedLogins)
+
+	for i := 0; i < 2; i++ {
+		_, _, err = service.SignIn(wrong, Device{})
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+	_, _, err = service.SignIn(wrong, Device{IP: "10.0.0.1"})
+	assert.ErrorIs(t, err, ErrAccountLocked)
+	lockedUntil := *repo.Users[userId].LockedUntil
+	assert.WithinDuration(t, time.Now().Add(time.Minute), lockedUntil, time.Second*5)
+
+	// пока вход заблокирован не пускаем и с верным паролем
+	_, _, err = service.SignIn(right, Device{})
+	assert.ErrorIs(t, err, ErrAccountLocked)
+
+	events, err := service.GetSecurityEvents(userId)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "account_locked", events[0].Type)
+
+	// следующая блокировка подряд вдвое дольше, но не дольше максимума
+	for _, lockout := range []time.Duration{time.Minute * 2, time.Minute * 3} {
+		past := time.Now().Add(-time.Second)
+		repo.Users[userId].LockedUntil = &past
+		for i := 0; i < 3; i++ {
+			_, _, err = service.SignIn(wrong, Device{})
+		}
+		assert.ErrorIs(t, err, ErrAccountLocked)
+		assert.WithinDuration(t, time.Now().Add(lockout), *repo.Users[userId].LockedUntil, time.Second*5)
+	}
+
+	// после блокировки верный пароль снова пускает и обнуляет счетчики
+	past := time.Now().Add(-time.Second)
+	repo.Users[userId].LockedUntil = &past
+	_, _, err = service.SignIn(right, Device{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, repo.Users[userId].LoginLockouts)
+	assert.Nil(t, repo.Users[userId].LockedUntil)
+}
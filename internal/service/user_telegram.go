@@ -0,0 +1,278 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/telegram"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// вход через телеграм не настроен
+	ErrTelegramDisabled = errors.New("telegram login is not configured")
+	// подпись данных от телеграма не сходится или они устарели
+	ErrTelegramAuthInvalid = errors.New("telegram login data is invalid or expired")
+	// этот аккаунт сервиса уже привязан к другому пользователю
+	ErrIdentityTaken = errors.New("this account is already linked to another user")
+	// к пользователю уже привязан другой аккаунт этого сервиса
+	ErrIdentityAlreadyLinked = errors.New("another account of this service is already linked")
+	// аккаунт сервиса не привязан
+	ErrIdentityNotLinked = errors.New("account is not linked")
+	// отвязать нельзя: других способов входа у пользователя нет
+	ErrLastLoginMethod = errors.New("can't unlink the only sign-in method, set a password first")
+	// этот username указан в профиле другого аккаунта: нужно войти в него и привязать телеграм в профиле
+	ErrTelegramLinkRequired = errors.New("an account with this telegram username exists, sign in and link telegram in the profile")
+)
+
+// SignInWithTelegram входит по данным из Telegram Login Widget
+// ищет пользователя по привязанному телеграму, иначе создает новый аккаунт
+// если у пользователя подключены одноразовые коды, вместо токенов вернется TwoFactorChallenge
+func (s *UserService) SignInWithTelegram(payload *dto.TelegramAuthDto, device Device) (uuid.UUID, string, string, error) {
+	login, err := s.verifyTelegram(payload)
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+	subject := strconv.FormatInt(login.ID, 10)
+
+	identity, err := s.repo.GetIdentity(entity.ProviderTelegram, subject)
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+	if identity != nil {
+		return s.completeIdentitySignIn(identity.UserID, device)
+	}
+
+	// телеграм еще не привязан, но он мог быть указан в профиле
+	// поле в профиле пользователь заполняет сам, поэтому по нему не входим, а просим привязать телеграм через LinkTelegram
+	user, err := s.telegramProfileUser(login)
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+	if user != nil {
+		return uuid.Nil, "", "", ErrTelegramLinkRequired
+	}
+
+	return s.signUpWithTelegram(login, subject, device)
+}
+
+// LinkTelegram привязывает телеграм к аккаунту, после этого через него можно входить
+// username из телеграма записывается в профиль
+func (s *UserService) LinkTelegram(userId uuid.UUID, payload *dto.TelegramAuthDto, device Device) error {
+	login, err := s.verifyTelegram(payload)
+	if err != nil {
+		return err
+	}
+	subject := strconv.FormatInt(login.ID, 10)
+
+	if err := s.attachIdentity(userId, entity.ProviderTelegram, subject, login.Username, device); err != nil {
+		return err
+	}
+
+	if login.Username == "" {
+		return nil
+	}
+	user, err := s.repo.GetUserWithDataById(userId)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.UserData == nil {
+		return errors.New("user not found")
+	}
+	data := *user.UserData
+	data.Telegram = "@" + login.Username
+	return s.repo.UpdateUser(userId, &data)
+}
+
+// UnlinkTelegram отвязывает телеграм от аккаунта
+// нельзя отвязать если это единственный способ входа: пароля нет и других сервисов тоже
+func (s *UserService) UnlinkTelegram(userId uuid.UUID, device Device) error {
+	return s.unlinkIdentity(userId, entity.ProviderTelegram, device)
+}
+
+// проверяет подпись данных виджета токеном бота
+func (s *UserService) verifyTelegram(payload *dto.TelegramAuthDto) (*telegram.Login, error) {
+	if s.config.Auth.TelegramBotToken == "" {
+		return nil, ErrTelegramDisabled
+	}
+	login := &telegram.Login{
+		ID:        payload.ID,
+		FirstName: payload.FirstName,
+		LastName:  payload.LastName,
+		Username:  payload.Username,
+		PhotoURL:  payload.PhotoURL,
+		AuthDate:  payload.AuthDate,
+		Hash:      payload.Hash,
+	}
+	if err := login.Verify(s.config.Auth.TelegramBotToken, s.config.Auth.TelegramAuthTTL, time.Now()); err != nil {
+		return nil, ErrTelegramAuthInvalid
+	}
+	return login, nil
+}
+
+// пользователь у которого этот username указан в профиле и к которому еще не привязан другой телеграм
+// username в телеграме регистр не различает, поэтому и сравниваем без учета регистра
+func (s *UserService) telegramProfileUser(login *telegram.Login) (*entity.User, error) {
+	if login.Username == "" {
+		return nil, nil
+	}
+	user, err := s.repo.GetUserByTelegram("@" + login.Username)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	// username в телеграме можно сменить и его займет кто-то другой,
+	// поэтому к аккаунту с уже привязанным телеграмом по username не пускаем
+	linked, err := s.repo.GetUserIdentity(user.ID, entity.ProviderTelegram)
+	if err != nil {
+		return nil, err
+	}
+	if linked != nil {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// создает аккаунт для нового пользователя из телеграма
+// почты у такого аккаунта нет, вместо нее заглушка, настоящую можно указать в профиле
+func (s *UserService) signUpWithTelegram(login *telegram.Login, subject string, device Device) (uuid.UUID, string, string, error) {
+	userData := entity.UserData{
+		Email: placeholderEmail(entity.ProviderTelegram, subject),
+		Name:  login.Name(),
+	}
+	if login.Username != "" {
+		userData.Telegram = "@" + login.Username
+	}
+	identity := entity.Identity{Provider: entity.ProviderTelegram, Subject: subject, Username: login.Username}
+	return s.signUpWithIdentity(identity, userData, nil, device)
+}
+
+// создает аккаунт без пароля для пользователя внешнего сервиса и открывает ему сессию
+func (s *UserService) signUpWithIdentity(identity entity.Identity, userData entity.UserData, emailVerifiedAt *time.Time, device Device) (uuid.UUID, string, string, error) {
+	userID := uuid.New()
+	userEntity := entity.User{
+		ID:              userID,
+		EmailVerifiedAt: emailVerifiedAt,
+		Roles:           []entity.Role{{Name: entity.RoleStudent}},
+		Identities:      []entity.Identity{identity},
+	}
+	userData.UserID = userID
+
+	session, access, refresh, err := s.openSession(userID, userData.Email, device)
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+	if err := s.repo.CreateUser(&userEntity, &userData, session); err != nil {
+		return uuid.Nil, "", "", err
+	}
+	return userID, access, refresh, nil
+}
+
+// почта-заглушка для аккаунта без почты, в зоне .invalid письма никуда не уходят
+func placeholderEmail(provider, subject string) string {
+	return fmt.Sprintf("%s-%s@%s.invalid", provider, subject, provider)
+}
+
+// аккаунт создан без почты и в профиле заглушка
+func isPlaceholderEmail(email string) bool {
+	return strings.HasSuffix(email, ".invalid")
+}
+
+// привязывает аккаунт сервиса к пользователю по его просьбе из профиля
+// повторная привязка того же аккаунта ничего не меняет
+func (s *UserService) attachIdentity(userId uuid.UUID, provider, subject, username string, device Device) error {
+	identity, err := s.repo.GetIdentity(provider, subject)
+	if err != nil {
+		return err
+	}
+	if identity != nil {
+		if identity.UserID == userId {
+			return nil
+		}
+		return ErrIdentityTaken
+	}
+
+	linked, err := s.repo.GetUserIdentity(userId, provider)
+	if err != nil {
+		return err
+	}
+	if linked != nil {
+		return ErrIdentityAlreadyLinked
+	}
+	return s.linkIdentity(userId, provider, subject, username, device)
+}
+
+// привязывает аккаунт сервиса к пользователю
+func (s *UserService) linkIdentity(userId uuid.UUID, provider, subject, username string, device Device) error {
+	identity := &entity.Identity{
+		Provider: provider,
+		Subject:  subject,
+		UserID:   userId,
+		Username: username,
+	}
+	event := &entity.SecurityEvent{
+		UserID:    userId,
+		Type:      entity.SecurityIdentityLinked,
+		UserAgent: device.UserAgent,
+		IP:        device.IP,
+	}
+	return s.repo.LinkIdentity(identity, event)
+}
+
+// отвязывает аккаунт сервиса, если у пользователя останется другой способ входа
+func (s *UserService) unlinkIdentity(userId uuid.UUID, provider string, device Device) error {
+	identity, err := s.repo.GetUserIdentity(userId, provider)
+	if err != nil {
+		return err
+	}
+	if identity == nil {
+		return ErrIdentityNotLinked
+	}
+
+	user, err := s.repo.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+	if user.PasswdHash == "" {
+		count, err := s.repo.CountUserIdentities(userId)
+		if err != nil {
+			return err
+		}
+		if count <= 1 {
+			return ErrLastLoginMethod
+		}
+	}
+
+	event := &entity.SecurityEvent{
+		UserID:    userId,
+		Type:      entity.SecurityIdentityUnlinked,
+		UserAgent: device.UserAgent,
+		IP:        device.IP,
+	}
+	return s.repo.UnlinkIdentity(userId, provider, event)
+}
+
+// открывает сессию пользователю который вошел через внешний сервис
+func (s *UserService) completeIdentitySignIn(userId uuid.UUID, device Device) (uuid.UUID, string, string, error) {
+	user, err := s.repo.GetUserWithDataById(userId)
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+	if user == nil || user.UserData == nil {
+		return uuid.Nil, "", "", errors.New("user not found")
+	}
+
+	access, refresh, err := s.completeSignIn(userId, user.UserData.Email, device)
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+	return userId, access, refresh, nil
+}
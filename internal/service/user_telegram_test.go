@@ -0,0 +1,143 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBotToken = "123456:test-bot-token"
+
+// данные виджета подписанные так же как это делает телеграм
+func telegramPayload(id int64, username string) *dto.TelegramAuthDto {
+	payload := &dto.TelegramAuthDto{ID: id, FirstName: "Ivan", LastName: "Petrov", Username: username, AuthDate: time.Now().Unix()}
+	signTelegram(payload)
+	return payload
+}
+
+func signTelegram(payload *dto.TelegramAuthDto) {
+	lines := []string{
+		fmt.Sprintf("id=%d", payload.ID),
+		"first_name=" + payload.FirstName,
+		"last_name=" + payload.LastName,
+		fmt.Sprintf("auth_date=%d", payload.AuthDate),
+	}
+	if payload.Username != "" {
+		lines = append(lines, "username="+payload.Username)
+	}
+	sort.Strings(lines)
+	secret := sha256.Sum256([]byte(testBotToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	payload.Hash = hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestService_SignInWithTelegram(t *testing.T) {
+	service, repo, _, userId := setupProfileService(t)
+
+	_, _, _, err := service.SignInWithTelegram(telegramPayload(100, "testuser"), Device{})
+	require.ErrorIs(t, err, ErrTelegramDisabled)
+	service.config.Auth.TelegramBotToken = testBotToken
+	service.config.Auth.TelegramAuthTTL = time.Hour
+
+	forged := telegramPayload(100, "testuser")
+	forged.ID = 101
+	_, _, _, err = service.SignInWithTelegram(forged, Device{})
+	require.ErrorIs(t, err, ErrTelegramAuthInvalid)
+	expired := telegramPayload(100, "testuser")
+	expired.AuthDate = time.Now().Add(-time.Hour * 2).Unix()
+	signTelegram(expired)
+	_, _, _, err = service.SignInWithTelegram(expired, Device{})
+	require.ErrorIs(t, err, ErrTelegramAuthInvalid)
+
+	// username совпал с профилем - поле заполняет сам пользователь, поэтому по нему не входим,
+	// а просим войти с паролем и привязать телеграм
+	_, _, _, err = service.SignInWithTelegram(telegramPayload(100, "TestUser"), Device{UserAgent: "phone"})
+	require.ErrorIs(t, err, ErrTelegramLinkRequired)
+	assert.Empty(t, repo.Identities)
+	assert.Len(t, repo.Sessions, 1)
+
+	require.NoError(t, service.LinkTelegram(userId, telegramPayload(100, "TestUser"), Device{}))
+	id, access, refresh, err := service.SignInWithTelegram(telegramPayload(100, "TestUser"), Device{UserAgent: "phone"})
+	require.NoError(t, err)
+	assert.Equal(t, userId, id)
+	assert.NotEmpty(t, access)
+	assert.NotEmpty(t, refresh)
+	require.Len(t, repo.Identities, 1)
+	assert.Equal(t, userId, repo.Identities["telegram:100"].UserID)
+	events, err := service.GetSecurityEvents(userId)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "identity_linked", events[0].Type)
+
+	// дальше входим по id, username уже не важен
+	id, _, _, err = service.SignInWithTelegram(telegramPayload(100, "renamed"), Device{})
+	require.NoError(t, err)
+	assert.Equal(t, userId, id)
+
+	// другой телеграм с тем же username к аккаунту уже не пускает, создается новый
+	other, _, _, err := service.SignInWithTelegram(telegramPayload(200, "testuser"), Device{})
+	require.NoError(t, err)
+	assert.NotEqual(t, userId, other)
+	data := repo.UserData[other]
+	assert.Equal(t, "telegram-200@telegram.invalid", data.Email)
+	assert.Equal(t, "Ivan Petrov", data.Name)
+	assert.Equal(t, "@testuser", data.Telegram)
+	assert.Empty(t, repo.Users[other].PasswdHash)
+	assert.True(t, repo.Users[other].HasRole(entity.RoleStudent))
+
+	again, _, _, err := service.SignInWithTelegram(telegramPayload(200, "testuser"), Device{})
+	require.NoError(t, err)
+	assert.Equal(t, other, again)
+}
+
+func TestService_TelegramTwoFactor(t *testing.T) {
+	service, repo, _, userId := setupProfileService(t)
+	service.config.Auth.TelegramBotToken = testBotToken
+	enableTwoFactor(t, service, repo, userId)
+
+	// телеграм не заменяет одноразовый код
+	require.NoError(t, service.LinkTelegram(userId, telegramPayload(100, "testuser"), Device{}))
+	_, _, _, err := service.SignInWithTelegram(telegramPayload(100, "testuser"), Device{})
+	require.ErrorIs(t, err, ErrTwoFactorRequired)
+}
+
+func TestService_LinkTelegram(t *testing.T) {
+	service, repo, _, userId := setupProfileService(t)
+	service.config.Auth.TelegramBotToken = testBotToken
+
+	require.ErrorIs(t, service.UnlinkTelegram(userId, Device{}), ErrIdentityNotLinked)
+
+	require.NoError(t, service.LinkTelegram(userId, telegramPayload(100, "new_name"), Device{}))
+	assert.Equal(t, "@new_name", repo.UserData[userId].Telegram)
+	// повторная привязка того же телеграма ничего не меняет
+	require.NoError(t, service.LinkTelegram(userId, telegramPayload(100, "new_name"), Device{}))
+	require.ErrorIs(t, service.LinkTelegram(userId, telegramPayload(200, "other_name"), Device{}), ErrIdentityAlreadyLinked)
+
+	// чужой телеграм не привязать
+	tgUser, _, _, err := service.SignInWithTelegram(telegramPayload(200, "other_name"), Device{})
+	require.NoError(t, err)
+	require.ErrorIs(t, service.LinkTelegram(userId, telegramPayload(200, "other_name"), Device{}), ErrIdentityTaken)
+
+	// у аккаунта из телеграма нет пароля, отвязать единственный способ входа нельзя
+	require.ErrorIs(t, service.UnlinkTelegram(tgUser, Device{}), ErrLastLoginMethod)
+	// пароль такому аккаунту задается без текущего сразу после входа, после этого телеграм можно отвязать
+	session := userSession(t, repo, tgUser)
+	require.NoError(t, service.ChangePassword(tgUser, session, "", "", "newpassword1", Device{}))
+	require.ErrorIs(t, service.ChangePassword(tgUser, session, "", "", "newpassword2", Device{}), ErrWrongPassword)
+	require.NoError(t, service.UnlinkTelegram(tgUser, Device{}))
+
+	require.NoError(t, service.UnlinkTelegram(userId, Device{}))
+	_, exists := repo.Identities["telegram:100"]
+	assert.False(t, exists)
+}
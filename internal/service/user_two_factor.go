@@ -0,0 +1,341 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/totp"
+	"mzt/internal/validator"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// сколько кодов восстановления выдаем
+	recoveryCodesCount = 10
+	// после скольких неверных кодов подряд блокируем проверку
+	maxTwoFactorAttempts = 5
+	// на сколько блокируем проверку кодов
+	twoFactorLockout = time.Minute * 15
+)
+
+var (
+	// пароль верный, для входа нужен одноразовый код
+	ErrTwoFactorRequired = errors.New("two-factor code required")
+	// код неверный, устарел или уже использован
+	ErrTwoFactorInvalid = errors.New("invalid two-factor code")
+	// слишком много неверных кодов подряд
+	ErrTwoFactorLocked = errors.New("too many invalid two-factor codes, try again later")
+	// токен второго шага входа неверный или истек, нужно снова ввести пароль
+	ErrTwoFactorChallengeInvalid = errors.New("two-factor challenge is invalid or expired")
+	// вход по кодам уже подключен
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	// вход по кодам не подключен
+	ErrTwoFactorDisabled = errors.New("two-factor authentication is not enabled")
+)
+
+// TwoFactorChallenge возвращается из SignIn вместо токенов, если у пользователя подключены коды
+// Challenge нужно вернуть в VerifyTwoFactor вместе с кодом
+type TwoFactorChallenge struct {
+	Challenge string
+}
+
+func (e *TwoFactorChallenge) Error() string {
+	return ErrTwoFactorRequired.Error()
+}
+
+func (e *TwoFactorChallenge) Unwrap() error {
+	return ErrTwoFactorRequired
+}
+
+// VerifyTwoFactor второй шаг входа: проверяет код и открывает сессию
+// вместо кода из приложения можно ввести код восстановления, он сгорает
+func (s *UserService) VerifyTwoFactor(challenge, code string, device Device) (uuid.UUID, string, string, error) {
+	userId, email, err := s.validator.ValidateEmailToken(challenge, validator.PurposeTwoFactor, s.config.Auth.TwoFactorKey)
+	if err != nil {
+		return uuid.Nil, "", "", ErrTwoFactorChallengeInvalid
+	}
+
+	user, err := s.repo.GetUserWithDataById(userId)
+	if err != nil || user == nil || user.UserData == nil || user.UserData.Email != email {
+		return uuid.Nil, "", "", ErrTwoFactorChallengeInvalid
+	}
+	twoFactor, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+	// коды отключили пока вводили, пароль придется ввести заново
+	if twoFactor == nil || twoFactor.EnabledAt == nil {
+		return uuid.Nil, "", "", ErrTwoFactorChallengeInvalid
+	}
+
+	if err := s.checkTwoFactorCode(twoFactor, code, device); err != nil {
+		return uuid.Nil, "", "", err
+	}
+
+	session, access, refresh, err := s.openSession(userId, email, device)
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+	if err := s.repo.CreateSession(session); err != nil {
+		return uuid.Nil, "", "", err
+	}
+	return userId, access, refresh, nil
+}
+
+// SetupTwoFactor выдает новый секрет для приложения с кодами
+// вход по кодам включится только после EnableTwoFactor с первым кодом
+func (s *UserService) SetupTwoFactor(userId uuid.UUID) (*dto.TwoFactorSetupDto, error) {
+	user, err := s.repo.GetUserWithDataById(userId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.UserData == nil {
+		return nil, errors.New("user not found")
+	}
+	current, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.EnabledAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := totp.Encrypt(secret, s.config.Auth.TwoFactorKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SaveTwoFactor(&entity.TwoFactor{UserID: userId, Secret: sealed}); err != nil {
+		return nil, err
+	}
+
+	return &dto.TwoFactorSetupDto{
+		Secret: secret,
+		URI:    totp.URI(s.config.Auth.TwoFactorIssuer, user.UserData.Email, secret),
+	}, nil
+}
+
+// EnableTwoFactor включает вход по кодам, если код из приложения подошел
+// возвращает коды восстановления, они показываются один раз
+// сессии на других устройствах завершаются: они открыты без кода
+func (s *UserService) EnableTwoFactor(userId uuid.UUID, sessionId uuid.UUID, code string, device Device) ([]string, error) {
+	twoFactor, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor == nil {
+		return nil, ErrTwoFactorDisabled
+	}
+	if twoFactor.EnabledAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.Decrypt(twoFactor.Secret, s.config.Auth.TwoFactorKey)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	counter, ok := totp.Validate(secret, code, now)
+	if !ok {
+		return nil, ErrTwoFactorInvalid
+	}
+
+	codes, hashed, err := newRecoveryCodes(userId)
+	if err != nil {
+		return nil, err
+	}
+	event := &entity.SecurityEvent{
+		UserID:    userId,
+		Type:      entity.SecurityTwoFactorEnabled,
+		SessionID: &sessionId,
+		UserAgent: device.UserAgent,
+		IP:        device.IP,
+	}
+	if err := s.repo.EnableTwoFactor(userId, now, counter, hashed, sessionId, event); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor отключает вход по кодам, нужен пароль и код
+func (s *UserService) DisableTwoFactor(userId uuid.UUID, password, code string, device Device) error {
+	user, err := s.repo.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+	if err := checkPassword(user, password); err != nil {
+		return err
+	}
+
+	twoFactor, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return err
+	}
+	if twoFactor == nil || twoFactor.EnabledAt == nil {
+		return ErrTwoFactorDisabled
+	}
+	if err := s.checkTwoFactorCode(twoFactor, code, device); err != nil {
+		return err
+	}
+
+	event := &entity.SecurityEvent{
+		UserID:    userId,
+		Type:      entity.SecurityTwoFactorDisabled,
+		UserAgent: device.UserAgent,
+		IP:        device.IP,
+	}
+	return s.repo.DisableTwoFactor(userId, event)
+}
+
+// RegenerateRecoveryCodes выпускает новые коды восстановления взамен старых
+// нужен код из приложения, кодом восстановления новые коды не получить
+func (s *UserService) RegenerateRecoveryCodes(userId uuid.UUID, code string) ([]string, error) {
+	twoFactor, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor == nil || twoFactor.EnabledAt == nil {
+		return nil, ErrTwoFactorDisabled
+	}
+	if err := s.checkAppCode(twoFactor, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashed, err := newRecoveryCodes(userId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceRecoveryCodes(userId, hashed); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// TwoFactorStatus подключен ли вход по кодам и обязателен ли он для пользователя
+func (s *UserService) TwoFactorStatus(userId uuid.UUID) (*dto.TwoFactorStatusDto, error) {
+	status := &dto.TwoFactorStatusDto{}
+	twoFactor, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor != nil && twoFactor.EnabledAt != nil {
+		status.Enabled = true
+		if status.RecoveryCodesLeft, err = s.repo.CountRecoveryCodes(userId); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.config.Auth.RequireAdminTwoFactor {
+		roles, err := s.Roles(userId)
+		if err != nil {
+			return nil, err
+		}
+		status.Required = len(roles.Permissions) > 0
+	}
+	return status, nil
+}
+
+// выдает токен второго шага входа
+func (s *UserService) twoFactorChallenge(userId uuid.UUID, email string) error {
+	ttl := s.config.Auth.TwoFactorChallengeTTL
+	if ttl <= 0 {
+		ttl = time.Minute * 5
+	}
+	challenge, err := s.validator.GenerateEmailToken(userId, email, validator.PurposeTwoFactor, s.config.Auth.TwoFactorKey, ttl)
+	if err != nil {
+		return err
+	}
+	return &TwoFactorChallenge{Challenge: challenge}
+}
+
+// проверяет код из приложения или код восстановления
+func (s *UserService) checkTwoFactorCode(twoFactor *entity.TwoFactor, code string, device Device) error {
+	err := s.checkAppCode(twoFactor, code)
+	if !errors.Is(err, ErrTwoFactorInvalid) {
+		return err
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return err
+	}
+	event := &entity.SecurityEvent{
+		UserID:    twoFactor.UserID,
+		Type:      entity.SecurityRecoveryCodeUsed,
+		UserAgent: device.UserAgent,
+		IP:        device.IP,
+	}
+	used, useErr := s.repo.UseRecoveryCode(twoFactor.UserID, hashToken(normalized), event)
+	if useErr != nil {
+		return useErr
+	}
+	if used {
+		return nil
+	}
+	return err
+}
+
+// проверяет код из приложения, неверные коды считаются и после серии блокируют проверку
+// один и тот же код дважды не принимается, даже если он еще не истек
+func (s *UserService) checkAppCode(twoFactor *entity.TwoFactor, code string) error {
+	now := time.Now()
+	if twoFactor.LockedUntil != nil && twoFactor.LockedUntil.After(now) {
+		return ErrTwoFactorLocked
+	}
+
+	secret, err := totp.Decrypt(twoFactor.Secret, s.config.Auth.TwoFactorKey)
+	if err != nil {
+		return err
+	}
+	if counter, ok := totp.Validate(secret, code, now); ok {
+		fresh, err := s.repo.UseTwoFactorCounter(twoFactor.UserID, counter)
+		if err != nil {
+			return err
+		}
+		if fresh {
+			return nil
+		}
+	}
+
+	if _, err := s.repo.TwoFactorFailed(twoFactor.UserID, maxTwoFactorAttempts, now.Add(twoFactorLockout)); err != nil {
+		return err
+	}
+	return ErrTwoFactorInvalid
+}
+
+// новые коды восстановления вида abcde-fghij и их хеши для базы
+func newRecoveryCodes(userId uuid.UUID) ([]string, []entity.RecoveryCode, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodesCount)
+	hashed := make([]entity.RecoveryCode, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashed = append(hashed, entity.RecoveryCode{CodeHash: hashToken(raw), UserID: userId})
+	}
+	return codes, hashed, nil
+}
+
+// приводит код восстановления к виду в котором считали хеш, пустая строка - это не код восстановления
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 10 {
+		return ""
+	}
+	return code
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"errors"
+	"mzt/internal/dto"
+	"mzt/internal/entity"
+	"mzt/internal/mocks"
+	"mzt/internal/totp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// код из приложения для текущего окна со сдвигом
+func appCode(t *testing.T, secret string, shift int64) string {
+	code, err := totp.Code(secret, totp.Counter(time.Now())+shift)
+	require.NoError(t, err)
+	return code
+}
+
+// подключает пользователю вход по кодам, возвращает секрет и коды восстановления
+func enableTwoFactor(t *testing.T, service *UserService, repo *mocks.MockUserRepository, userId uuid.UUID) (string, []string) {
+	service.config.Auth.TwoFactorKey = "test-two-factor-key"
+	service.config.Auth.TwoFactorIssuer = "MZT"
+
+	setup, err := service.SetupTwoFactor(userId)
+	require.NoError(t, err)
+	assert.Contains(t, setup.URI, "otpauth://totp/")
+	// в базе секрет лежит зашифрованным
+	assert.NotEqual(t, setup.Secret, repo.TwoFactor[userId].Secret)
+
+	var sessionId uuid.UUID
+	for id := range repo.Sessions {
+		sessionId = id
+	}
+
+	_, err = service.EnableTwoFactor(userId, sessionId, "000000x", Device{})
+	require.ErrorIs(t, err, ErrTwoFactorInvalid)
+
+	codes, err := service.EnableTwoFactor(userId, sessionId, appCode(t, setup.Secret, 0), Device{UserAgent: "laptop"})
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodesCount)
+	return setup.Secret, codes
+}
+
+// входит по паролю и достает challenge второго шага
+func twoFactorChallenge(t *testing.T, service *UserService) string {
+	access, refresh, err := service.SignIn(&dto.LoginDto{Email: "test@example.com", Password: "password123"}, Device{UserAgent: "phone"})
+	require.ErrorIs(t, err, ErrTwoFactorRequired)
+	assert.Empty(t, access)
+	assert.Empty(t, refresh)
+
+	var challenge *TwoFactorChallenge
+	require.True(t, errors.As(err, &challenge))
+	require.NotEmpty(t, challenge.Challenge)
+	return challenge.Challenge
+}
+
+func TestService_TwoFactorSignIn(t *testing.T) {
+	service, repo, _, userId := setupProfileService(t)
+	secret, codes := enableTwoFactor(t, service, repo, userId)
+
+	status, err := service.TwoFactorStatus(userId)
+	require.NoError(t, err)
+	assert.True(t, status.Enabled)
+	assert.Equal(t, int64(recoveryCodesCount), status.RecoveryCodesLeft)
+
+	challenge := twoFactorChallenge(t, service)
+
+	// код из окна в котором включали уже использован
+	_, _, _, err = service.VerifyTwoFactor(challenge, appCode(t, secret, 0), Device{})
+	require.ErrorIs(t, err, ErrTwoFactorInvalid)
+
+	// следующий код принимается один раз
+	next := appCode(t, secret, 1)
+	id, access, refresh, err := service.VerifyTwoFactor(challenge, next, Device{UserAgent: "phone"})
+	require.NoError(t, err)
+	assert.Equal(t, userId, id)
+	assert.NotEmpty(t, access)
+	assert.NotEmpty(t, refresh)
+
+	_, _, _, err = service.VerifyTwoFactor(challenge, next, Device{})
+	require.ErrorIs(t, err, ErrTwoFactorInvalid)
+
+	// код восстановления тоже одноразовый
+	_, _, _, err = service.VerifyTwoFactor(challenge, codes[0], Device{UserAgent: "tablet"})
+	require.NoError(t, err)
+	_, _, _, err = service.VerifyTwoFactor(challenge, codes[0], Device{})
+	require.ErrorIs(t, err, ErrTwoFactorInvalid)
+
+	status, err = service.TwoFactorStatus(userId)
+	require.NoError(t, err)
+	assert.Equal(t, int64(recoveryCodesCount-1), status.RecoveryCodesLeft)
+
+	_, _, _, err = service.VerifyTwoFactor("garbage", codes[1], Device{})
+	require.ErrorIs(t, err, ErrTwoFactorChallengeInvalid)
+}
+
+func TestService_TwoFactorLockout(t *testing.T) {
+	service, repo, _, userId := setupProfileService(t)
+	secret, _ := enableTwoFactor(t, service, repo, userId)
+	challenge := twoFactorChallenge(t, service)
+
+	for i := 0; i < maxTwoFactorAttempts; i++ {
+		_, _, _, err := service.VerifyTwoFactor(challenge, "12345x", Device{})
+		require.ErrorIs(t, err, ErrTwoFactorInvalid)
+	}
+
+	// после серии неверных кодов не принимается даже верный
+	_, _, _, err := service.VerifyTwoFactor(challenge, appCode(t, secret, 1), Device{})
+	require.ErrorIs(t, err, ErrTwoFactorLocked)
+}
+
+func TestService_DisableTwoFactor(t *testing.T) {
+	service, repo, _, userId := setupProfileService(t)
+	secret, codes := enableTwoFactor(t, service, repo, userId)
+
+	code := appCode(t, secret, 1)
+	err := service.DisableTwoFactor(userId, "wrong-password", code, Device{})
+	require.ErrorIs(t, err, ErrWrongPassword)
+
+	// новые коды восстановления заменяют старые
+	fresh, err := service.RegenerateRecoveryCodes(userId, code)
+	require.NoError(t, err)
+	require.Len(t, fresh, recoveryCodesCount)
+
+	err = service.DisableTwoFactor(userId, "password123", codes[0], Device{})
+	require.ErrorIs(t, err, ErrTwoFactorInvalid)
+	err = service.DisableTwoFactor(userId, "password123", fresh[0], Device{})
+	require.NoError(t, err)
+
+	status, err := service.TwoFactorStatus(userId)
+	require.NoError(t, err)
+	assert.False(t, status.Enabled)
+
+	var disabled bool
+	for _, event := range repo.Events {
+		if event.UserID == userId && event.Type == entity.SecurityTwoFactorDisabled {
+			disabled = true
+		}
+	}
+	assert.True(t, disabled)
+
+	// без кодов вход снова по паролю
+	_, _, err = service.SignIn(&dto.LoginDto{Email: "test@example.com", Password: "password123"}, Device{})
+	require.NoError(t, err)
+}
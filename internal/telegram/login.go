@@ -0,0 +1,77 @@
+// пакет для входа через Telegram Login Widget
+// https://core.telegram.org/widgets/login#checking-authorization
+package telegram
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// подпись не сходится: данные подделаны или подписаны другим ботом
+	ErrInvalidHash = errors.New("invalid telegram login hash")
+	// данные входа слишком старые, их могли перехватить и повторить
+	ErrExpired = errors.New("telegram login data is expired")
+)
+
+// Login данные которые виджет передает после входа
+type Login struct {
+	ID        int64
+	FirstName string
+	LastName  string
+	Username  string
+	PhotoURL  string
+	AuthDate  int64
+	Hash      string
+}
+
+// Verify проверяет подпись данных токеном бота и что вход был не раньше чем maxAge назад
+func (l *Login) Verify(botToken string, maxAge time.Duration, now time.Time) error {
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(l.checkString()))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(l.Hash))) {
+		return ErrInvalidHash
+	}
+
+	authAt := time.Unix(l.AuthDate, 0)
+	if maxAge > 0 && now.Sub(authAt) > maxAge {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Name имя и фамилия как в профиле телеграма
+func (l *Login) Name() string {
+	return strings.TrimSpace(l.FirstName + " " + l.LastName)
+}
+
+// строка которую подписывает телеграм: все переданные поля кроме hash, отсортированные по имени
+func (l *Login) checkString() string {
+	fields := map[string]string{
+		"id":         strconv.FormatInt(l.ID, 10),
+		"first_name": l.FirstName,
+		"last_name":  l.LastName,
+		"username":   l.Username,
+		"photo_url":  l.PhotoURL,
+		"auth_date":  strconv.FormatInt(l.AuthDate, 10),
+	}
+
+	lines := make([]string, 0, len(fields))
+	for key, value := range fields {
+		// пустые поля виджет не передает, значит они и не подписаны
+		if value == "" {
+			continue
+		}
+		lines = append(lines, key+"="+value)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
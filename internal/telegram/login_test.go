@@ -0,0 +1,46 @@
+package telegram
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const botToken = "123456:test-bot-token"
+
+// подписывает данные так же как это делает телеграм
+func sign(t *testing.T, login *Login) {
+	t.Helper()
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(login.checkString()))
+	login.Hash = hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestLogin_Verify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	login := &Login{ID: 42, FirstName: "Ivan", Username: "ivan_petrov", AuthDate: now.Add(-time.Minute).Unix()}
+	sign(t, login)
+
+	assert.Equal(t, "auth_date=1699999940\nfirst_name=Ivan\nid=42\nusername=ivan_petrov", login.checkString())
+	assert.NoError(t, login.Verify(botToken, time.Hour, now))
+	assert.ErrorIs(t, login.Verify("654321:other-bot", time.Hour, now), ErrInvalidHash)
+	assert.ErrorIs(t, login.Verify(botToken, time.Second*30, now), ErrExpired)
+
+	// подменили любое поле - подпись не сходится
+	forged := *login
+	forged.ID = 43
+	assert.ErrorIs(t, forged.Verify(botToken, time.Hour, now), ErrInvalidHash)
+	forged = *login
+	forged.LastName = "Petrov"
+	assert.ErrorIs(t, forged.Verify(botToken, time.Hour, now), ErrInvalidHash)
+}
+
+func TestLogin_Name(t *testing.T) {
+	assert.Equal(t, "Ivan Petrov", (&Login{FirstName: "Ivan", LastName: "Petrov"}).Name())
+	assert.Equal(t, "Ivan", (&Login{FirstName: "Ivan"}).Name())
+}
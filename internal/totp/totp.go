@@ -0,0 +1,147 @@
+// пакет для одноразовых кодов входа по RFC 6238 (TOTP), их показывают Google Authenticator, Яндекс Ключ и т.п.
+// коды из 6 цифр, шаг 30 секунд, HMAC-SHA1 - другие параметры большинство приложений не понимает
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// сколько цифр в коде
+	Digits = 6
+	// сколько секунд действует код
+	Period = 30
+	// сколько шагов до и после текущего принимаем, чтобы пережить расхождение часов
+	Skew = 1
+)
+
+var (
+	// ErrInvalidSecret секрет не удалось разобрать или расшифровать
+	ErrInvalidSecret = errors.New("invalid totp secret")
+	// ErrEmptyKey ключ шифрования не задан, с пустым ключом секреты в базе по сути открыты
+	ErrEmptyKey = errors.New("empty totp encryption key")
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret новый секрет в base32, 160 бит как советует RFC 4226
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(buf), nil
+}
+
+// Counter номер 30-секундного шага для момента времени
+func Counter(at time.Time) int64 {
+	return at.Unix() / Period
+}
+
+// Code код для номера шага
+func Code(secret string, counter int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(key) == 0 {
+		return "", ErrInvalidSecret
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// динамическое усечение из RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000), nil
+}
+
+// Validate проверяет код на момент at с допуском в Skew шагов
+// возвращает номер шага которому соответствует код, по нему отсекается повторное использование
+func Validate(secret, code string, at time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Counter(at)
+	for counter := current - Skew; counter <= current+Skew; counter++ {
+		expected, err := Code(secret, counter)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// URI ссылка otpauth:// для QR-кода, который сканирует приложение
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Encrypt шифрует секрет для хранения в базе, AES-256-GCM с ключом из sha256 от key
+func Encrypt(secret, key string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt расшифровывает секрет из базы
+func Decrypt(sealed, key string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", ErrInvalidSecret
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", ErrInvalidSecret
+	}
+	return string(plain), nil
+}
+
+func newGCM(key string) (cipher.AEAD, error) {
+	if key == "" {
+		return nil, ErrEmptyKey
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,83 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// секрет из тестовых векторов RFC 6238 для SHA1
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode_RFCVectors(t *testing.T) {
+	// в RFC коды из 8 цифр, у нас последние 6 из них
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range cases {
+		code, err := Code(rfcSecret, Counter(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "time %d", unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	now := time.Now()
+
+	code, err := Code(secret, Counter(now))
+	require.NoError(t, err)
+	counter, ok := Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, Counter(now), counter)
+
+	// код с соседнего шага принимаем, с более дальнего - нет
+	previous, err := Code(secret, Counter(now)-1)
+	require.NoError(t, err)
+	_, ok = Validate(secret, previous, now)
+	assert.True(t, ok)
+	old, err := Code(secret, Counter(now)-3)
+	require.NoError(t, err)
+	_, ok = Validate(secret, old, now)
+	assert.False(t, ok)
+
+	_, ok = Validate(secret, "12345", now)
+	assert.False(t, ok)
+	_, ok = Validate("not base32!", "123456", now)
+	assert.False(t, ok)
+}
+
+func TestEncrypt(t *testing.T) {
+	sealed, err := Encrypt(rfcSecret, "key")
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, rfcSecret)
+
+	plain, err := Decrypt(sealed, "key")
+	require.NoError(t, err)
+	assert.Equal(t, rfcSecret, plain)
+
+	_, err = Decrypt(sealed, "other key")
+	assert.ErrorIs(t, err, ErrInvalidSecret)
+
+	_, err = Encrypt(rfcSecret, "")
+	assert.ErrorIs(t, err, ErrEmptyKey)
+	_, err = Decrypt(sealed, "")
+	assert.ErrorIs(t, err, ErrEmptyKey)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Мозг и Тело", "user@example.com", "ABC")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/"))
+	assert.Contains(t, uri, "secret=ABC")
+	assert.Contains(t, uri, "user@example.com")
+}
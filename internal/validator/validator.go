@@ -3,11 +3,13 @@ package validator
 import (
 	"errors"
 	"fmt"
+	"mzt/internal/jwtkeys"
 	"regexp"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 // Validator проверяет данные(формат тг, емэйла, пароля и т.д.) и создает токены
@@ -61,13 +63,14 @@ func (v *Validator) IsValidName(name string) bool {
 	return validName.MatchString(name)
 }
 
-func (v *Validator) GenerateToken(email, secret string, expirationTimeUnix time.Duration) (string, error) {
-	if email == "" {
-		return "", errors.New("empty email")
-	}
-	claims := jwt.MapClaims{
-		"sub": email,
-		"exp": time.Now().Add(expirationTimeUnix).Unix(),
+// GenerateToken создает токен для сессии пользователя, подписанный общим секретом
+// так подписываются refresh токены: их проверяем только мы
+// sid - id сессии устройства, она же семейство refresh токенов
+// tokenId уходит в jti и делает каждый токен уникальным даже если они выписаны в одну секунду
+func (v *Validator) GenerateToken(email string, sessionId uuid.UUID, tokenId string, secret string, expirationTimeUnix time.Duration) (string, error) {
+	claims, err := sessionClaims(email, sessionId, tokenId, expirationTimeUnix)
+	if err != nil {
+		return "", err
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -81,6 +84,10 @@ func (v *Validator) GenerateToken(email, secret string, expirationTimeUnix time.
 }
 
 func (v *Validator) ValidateToken(tokenString, secret string) (*jwt.Token, error) {
+	// с пустым ключом подпись может сделать кто угодно
+	if secret == "" {
+		return nil, errors.New("empty secret")
+	}
 	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -88,3 +95,102 @@ func (v *Validator) ValidateToken(tokenString, secret string) (*jwt.Token, error
 		return []byte(secret), nil
 	})
 }
+
+// GenerateAccessToken создает access токен, подписанный активным ключом из keys
+// claims такие же как у GenerateToken, другие сервисы проверяют токен по нашему jwks
+func (v *Validator) GenerateAccessToken(email string, sessionId uuid.UUID, tokenId string, keys *jwtkeys.Keyring, expirationTimeUnix time.Duration) (string, error) {
+	claims, err := sessionClaims(email, sessionId, tokenId, expirationTimeUnix)
+	if err != nil {
+		return "", err
+	}
+	return keys.Sign(claims)
+}
+
+// ValidateAccessToken проверяет access токен ключом из его kid
+func (v *Validator) ValidateAccessToken(tokenString string, keys *jwtkeys.Keyring) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, keys.Keyfunc)
+}
+
+func sessionClaims(email string, sessionId uuid.UUID, tokenId string, expirationTimeUnix time.Duration) (jwt.MapClaims, error) {
+	if email == "" {
+		return nil, errors.New("empty email")
+	}
+	return jwt.MapClaims{
+		"sub": email,
+		"sid": sessionId.String(),
+		"jti": tokenId,
+		"exp": time.Now().Add(expirationTimeUnix).Unix(),
+	}, nil
+}
+
+// SessionID достает id сессии из проверенного токена
+func (v *Validator) SessionID(token *jwt.Token) (uuid.UUID, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return uuid.Nil, errors.New("unexpected claims")
+	}
+	sid, ok := claims["sid"].(string)
+	if !ok {
+		return uuid.Nil, errors.New("token has no session")
+	}
+	return uuid.Parse(sid)
+}
+
+// TokenID достает jti из проверенного токена
+func (v *Validator) TokenID(token *jwt.Token) string {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	jti, _ := claims["jti"].(string)
+	return jti
+}
+
+// для чего выдан токен из ссылки в письме, чтобы одним токеном нельзя было подменить другой
+const (
+	// подтверждение почты после регистрации
+	PurposeVerifyEmail = "verify_email"
+	// подтверждение новой почты при ее смене
+	PurposeChangeEmail = "change_email"
+	// второй шаг входа: пароль уже проверен, ждем одноразовый код
+	// в письмо не уходит, но устроен так же и так же привязан к почте
+	PurposeTwoFactor = "two_factor"
+)
+
+// GenerateEmailToken создает подписанный токен для ссылки из письма
+// в токене и id пользователя и сама почта: если почту сменят, старая ссылка перестанет работать
+func (v *Validator) GenerateEmailToken(userId uuid.UUID, email, purpose, secret string, expirationTimeUnix time.Duration) (string, error) {
+	if email == "" {
+		return "", errors.New("empty email")
+	}
+	if secret == "" {
+		return "", errors.New("empty email verification key")
+	}
+	claims := jwt.MapClaims{
+		"sub":     email,
+		"uid":     userId.String(),
+		"purpose": purpose,
+		"exp":     time.Now().Add(expirationTimeUnix).Unix(),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+// ValidateEmailToken проверяет токен из ссылки в письме и возвращает id пользователя и почту
+func (v *Validator) ValidateEmailToken(tokenString, purpose, secret string) (uuid.UUID, string, error) {
+	token, err := v.ValidateToken(tokenString, secret)
+	if err != nil || !token.Valid {
+		return uuid.Nil, "", errors.New("invalid email token")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != purpose {
+		return uuid.Nil, "", errors.New("invalid email token")
+	}
+	email, _ := claims["sub"].(string)
+	uid, _ := claims["uid"].(string)
+	userId, err := uuid.Parse(uid)
+	if err != nil || email == "" {
+		return uuid.Nil, "", errors.New("invalid email token")
+	}
+	return userId, email, nil
+}